@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxDistinctValues bounds the number of distinct values tracked per group by distinct-count().
+// Once exceeded, tracking stops and the computed value is maxDistinctValues, which is logged as a lower bound.
+const maxDistinctValues = 10000
+
+// aggregationPattern matches aggregation specs like `total=sum(amount)`.
+var aggregationPattern = regexp.MustCompile(`^\s*([^=]+?)\s*=\s*([a-z-]+)\(\s*([^)]*?)\s*\)\s*$`)
+
+// aggregateOptions is used to configure grouping and computations when calling aggregate(),
+// in addition to the CSV input source, parsing behaviors, and JSON output destination.
+type aggregateOptions struct {
+	conversionOptions
+	groupBy      []string
+	aggregations []string
+}
+
+// aggregation is a single named computation over the rows of each group, e.g. `total=sum(amount)`.
+type aggregation struct {
+	name, function, column string
+}
+
+// isNumeric reports whether the aggregation requires numeric cell values.
+func (a aggregation) isNumeric() bool {
+	switch a.function {
+	case "sum", "avg", "min", "max":
+		return true
+	}
+	return false
+}
+
+// newAccumulator returns a new, empty accumulator for computing the aggregation.
+func (a aggregation) newAccumulator() accumulator {
+	switch a.function {
+	case "count":
+		return &countAccumulator{}
+	case "sum":
+		return &sumAccumulator{}
+	case "avg":
+		return &avgAccumulator{}
+	case "min":
+		return &extremeAccumulator{keep: func(candidate, current float64) bool { return candidate < current }}
+	case "max":
+		return &extremeAccumulator{keep: func(candidate, current float64) bool { return candidate > current }}
+	default:
+		return &distinctAccumulator{seen: make(map[string]struct{})}
+	}
+}
+
+// accumulator incrementally computes an aggregation from the cell values of a group's rows.
+type accumulator interface {
+	// add incorporates a cell value. Numeric accumulators also receive the value parsed as a number,
+	// and are never given empty cells.
+	add(value string, number float64)
+	// result returns the computed value.
+	result() interface{}
+}
+
+type countAccumulator struct{ n int }
+
+func (acc *countAccumulator) add(string, float64) { acc.n++ }
+func (acc *countAccumulator) result() interface{} { return acc.n }
+
+type sumAccumulator struct{ sum float64 }
+
+func (acc *sumAccumulator) add(_ string, number float64) { acc.sum += number }
+func (acc *sumAccumulator) result() interface{}          { return acc.sum }
+
+type avgAccumulator struct {
+	sum float64
+	n   int
+}
+
+func (acc *avgAccumulator) add(_ string, number float64) {
+	acc.sum += number
+	acc.n++
+}
+
+func (acc *avgAccumulator) result() interface{} {
+	if acc.n == 0 {
+		return nil
+	}
+	return acc.sum / float64(acc.n)
+}
+
+// extremeAccumulator tracks the minimum or maximum value, depending on keep.
+type extremeAccumulator struct {
+	keep  func(candidate, current float64) bool
+	value float64
+	seen  bool
+}
+
+func (acc *extremeAccumulator) add(_ string, number float64) {
+	if !acc.seen || acc.keep(number, acc.value) {
+		acc.value = number
+		acc.seen = true
+	}
+}
+
+func (acc *extremeAccumulator) result() interface{} {
+	if !acc.seen {
+		return nil
+	}
+	return acc.value
+}
+
+type distinctAccumulator struct {
+	seen   map[string]struct{}
+	capped bool
+}
+
+func (acc *distinctAccumulator) add(value string, _ float64) {
+	if acc.capped {
+		return
+	}
+	acc.seen[value] = struct{}{}
+	if len(acc.seen) > maxDistinctValues {
+		acc.capped = true
+		acc.seen = nil
+	}
+}
+
+// result returns the number of distinct values, or maxDistinctValues once capped, so that it is always a number.
+func (acc *distinctAccumulator) result() interface{} {
+	if acc.capped {
+		return maxDistinctValues
+	}
+	return len(acc.seen)
+}
+
+// aggregateGroup holds the grouping values and in-progress computations for a single group.
+type aggregateGroup struct {
+	values       []string
+	accumulators []accumulator
+}
+
+// parseAggregations parses aggregation specs like `total=sum(amount)` and verifies that any columns they reference
+// exist among colNames, and that each is named differently from the groupBy columns and every other aggregation,
+// since the output fields would otherwise replace one another.
+func parseAggregations(specs []string, colNames []string, groupBy []string) ([]aggregation, error) {
+	aggs := make([]aggregation, 0, len(specs))
+	for _, spec := range specs {
+		m := aggregationPattern.FindStringSubmatch(spec)
+		if m == nil {
+			return nil, usageErrorf("invalid aggregation %q (expected name=function(column))", spec)
+		}
+		agg := aggregation{name: m[1], function: m[2], column: m[3]}
+
+		switch agg.function {
+		case "count":
+		case "sum", "avg", "min", "max", "distinct-count":
+			if agg.column == "" {
+				return nil, usageErrorf("aggregation %q requires a column", spec)
+			}
+		default:
+			return nil, usageErrorf("aggregation %q uses unknown function %q", spec, agg.function)
+		}
+		if agg.column != "" && !containsString(colNames, agg.column) {
+			return nil, usageErrorf("aggregation %q references unknown column %q", spec, agg.column)
+		}
+		if containsString(groupBy, agg.name) {
+			return nil, usageErrorf("aggregation %q has the same name as group-by column %q", spec, agg.name)
+		}
+		for _, other := range aggs {
+			if other.name == agg.name {
+				return nil, usageErrorf("aggregation %q has the same name as another aggregation", spec)
+			}
+		}
+
+		aggs = append(aggs, agg)
+	}
+
+	return aggs, nil
+}
+
+// aggregate groups CSV data from io.Reader by the values of `options.groupBy` columns and emits a JSON array
+// containing one object per group, with the grouping values and each computed aggregation, to io.Writer.
+// Groups are ordered as first seen in the input unless `options.sortBy` gives keys to sort by, as by parseSortKeys(),
+// which name output fields. Input without even a header has no groups.
+// Empty cells are ignored by every aggregation except count(); non-numeric values given to numeric aggregations,
+// including infinities and NaN, are treated like parsing errors. Distinct counts which reach maxDistinctValues are
+// logged. Aggregating aborts once there would be more than `options.maxRecords` groups.
+func aggregate(options aggregateOptions) error {
+	if len(options.groupBy) == 0 {
+		return usageErrorf("at least one group-by column is required")
+	}
+	reader, colNames, err := newCsvRowReader(options.conversionOptions)
+	if err != nil {
+		return err
+	} else if len(colNames) == 0 {
+		return encodeAggregates(options, []map[string]interface{}{})
+	}
+	for _, col := range options.groupBy {
+		if !containsString(colNames, col) {
+			return usageErrorf("unknown group-by column %q", col)
+		}
+	}
+	aggs, err := parseAggregations(options.aggregations, colNames, options.groupBy)
+	if err != nil {
+		return err
+	}
+	var sortKeys []sortKey
+	if options.sortBy != "" {
+		if sortKeys, err = parseSortKeys(options.sortBy); err != nil {
+			return err
+		}
+	}
+	for _, key := range sortKeys {
+		if containsString(options.groupBy, key.field) {
+			continue
+		}
+		found := false
+		for _, agg := range aggs {
+			found = found || agg.name == key.field
+		}
+		if !found {
+			return usageErrorf("cannot sort by %q, which is neither a group-by column nor an aggregation", key.field)
+		}
+	}
+
+	groups := make(map[string]*aggregateGroup)
+	groupOrder := make([]*aggregateGroup, 0)
+	numbers := make([]float64, len(aggs))
+	if err := eachRow(reader, options.skipErrors, func(rowNum int, rowFields []string) error {
+		rec := fieldsToRecord(&colNames, &rowFields)
+
+		// Validate every numeric cell before updating any accumulators so that a skipped row contributes nothing
+		for i, agg := range aggs {
+			if !agg.isNumeric() || rec[agg.column] == "" {
+				continue
+			}
+			// Infinities and NaN are rejected, since JSON cannot represent them or any result they reach
+			n, err := strconv.ParseFloat(rec[agg.column], 64)
+			if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+				return &rowError{rowNum, fmt.Errorf("%s() requires a numeric value in column %q but got %q",
+					agg.function, agg.column, rec[agg.column])}
+			}
+			numbers[i] = n
+		}
+
+		values := make([]string, len(options.groupBy))
+		for i, col := range options.groupBy {
+			values[i] = rec[col]
+		}
+		key := strings.Join(values, "\x1f")
+		group, ok := groups[key]
+		if !ok && options.maxRecords > 0 && len(groups) == options.maxRecords {
+			return recordLimitError{options.maxRecords}
+		} else if !ok {
+			group = &aggregateGroup{values: values, accumulators: make([]accumulator, len(aggs))}
+			for i, agg := range aggs {
+				group.accumulators[i] = agg.newAccumulator()
+			}
+			groups[key] = group
+			groupOrder = append(groupOrder, group)
+		}
+
+		for i, agg := range aggs {
+			if agg.column != "" && rec[agg.column] == "" {
+				continue
+			}
+			group.accumulators[i].add(rec[agg.column], numbers[i])
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	results := make([]map[string]interface{}, len(groupOrder))
+	numCapped := make([]int, len(aggs))
+	for i, group := range groupOrder {
+		result := make(map[string]interface{}, len(options.groupBy)+len(aggs))
+		for j, col := range options.groupBy {
+			result[col] = group.values[j]
+		}
+		for j, agg := range aggs {
+			result[agg.name] = group.accumulators[j].result()
+			if acc, ok := group.accumulators[j].(*distinctAccumulator); ok && acc.capped {
+				numCapped[j]++
+			}
+		}
+		results[i] = result
+	}
+	for j, agg := range aggs {
+		if numCapped[j] > 0 {
+			logCappedDistinctCounts(agg.name, numCapped[j])
+		}
+	}
+	if len(sortKeys) > 0 {
+		sorted := make([]map[string]interface{}, len(results))
+		for i, j := range sortOrder(len(results), sortKeys, func(i int, field string) interface{} {
+			return results[i][field]
+		}) {
+			sorted[i] = results[j]
+		}
+		results = sorted
+	}
+
+	return encodeAggregates(options, results)
+}
+
+// encodeAggregates emits the results of aggregate() as a JSON array to `options.jsonOutput`.
+func encodeAggregates(options aggregateOptions, results []map[string]interface{}) error {
+	if err := newJsonEncoder(options.jsonOutput, options.indent).Encode(results); err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
+
+// toFloat converts integer and floating-point values to float64.
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// containsString reports whether s is present in values.
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	// Log errors to nowhere while this test runs
+	discardLogs(t)
+
+	salesCsv := "region,rep,amount\nemea,ann,10\nus,bob,2.5\nemea,cat,\nemea,ann,5\napac,dan,7\n"
+
+	for _, tt := range []struct {
+		testName     string
+		groupBy      []string
+		aggregations []string
+		sortBy       string
+		csv          string
+		skipErrors   bool
+		wantJson     string
+		wantErr      string
+	}{
+		{
+			"Computes every function per group in first-seen order",
+			[]string{"region"},
+			[]string{"n=count()", "total=sum(amount)", "avg=avg(amount)", "lo=min(amount)", "hi=max(amount)",
+				"reps=distinct-count(rep)"},
+			"",
+			salesCsv,
+			false,
+			`[
+				{"region": "emea", "n": 3, "total": 15, "avg": 7.5, "lo": 5, "hi": 10, "reps": 2},
+				{"region": "us", "n": 1, "total": 2.5, "avg": 2.5, "lo": 2.5, "hi": 2.5, "reps": 1},
+				{"region": "apac", "n": 1, "total": 7, "avg": 7, "lo": 7, "hi": 7, "reps": 1}
+			]`,
+			"",
+		},
+		{
+			"Empty cells are ignored except by count()",
+			[]string{"region"},
+			[]string{"rows=count()", "amounts=count(amount)", "avg=avg(amount)", "hi=max(amount)"},
+			"",
+			"region,amount\nemea,\nemea,4\nus,\n",
+			false,
+			`[
+				{"region": "emea", "rows": 2, "amounts": 1, "avg": 4, "hi": 4},
+				{"region": "us", "rows": 1, "amounts": 0, "avg": null, "hi": null}
+			]`,
+			"",
+		},
+		{
+			"Groups by multiple columns",
+			[]string{"region", "rep"},
+			[]string{"total=sum(amount)"},
+			"",
+			salesCsv,
+			false,
+			`[
+				{"region": "emea", "rep": "ann", "total": 15},
+				{"region": "us", "rep": "bob", "total": 2.5},
+				{"region": "emea", "rep": "cat", "total": 0},
+				{"region": "apac", "rep": "dan", "total": 7}
+			]`,
+			"",
+		},
+		{
+			"Sorts groups by aggregation",
+			[]string{"region"},
+			[]string{"total=sum(amount)"},
+			"total",
+			salesCsv,
+			false,
+			`[{"region": "us", "total": 2.5}, {"region": "apac", "total": 7}, {"region": "emea", "total": 15}]`,
+			"",
+		},
+		{
+			"Sorts groups by group-by column",
+			[]string{"region"},
+			[]string{"n=count()"},
+			"region",
+			salesCsv,
+			false,
+			`[{"region": "apac", "n": 1}, {"region": "emea", "n": 3}, {"region": "us", "n": 1}]`,
+			"",
+		},
+		{
+			"Non-numeric values abort by default",
+			[]string{"region"},
+			[]string{"total=sum(amount)"},
+			"",
+			"region,amount\nemea,1\nemea,lots\n",
+			false,
+			"",
+			`row 2: sum() requires a numeric value in column "amount" but got "lots"`,
+		},
+		{
+			"Rows with non-numeric values can be skipped",
+			[]string{"region"},
+			[]string{"n=count()", "total=sum(amount)"},
+			"",
+			"region,amount\nemea,1\nemea,lots\nemea,2\n",
+			true,
+			`[{"region": "emea", "n": 2, "total": 3}]`,
+			"",
+		},
+		{
+			"Infinities and NaN are non-numeric",
+			[]string{"region"},
+			[]string{"n=count()", "total=sum(amount)", "hi=max(amount)"},
+			"",
+			"region,amount\nemea,1\nemea,NaN\nemea,Inf\nemea,-infinity\nemea,2\n",
+			true,
+			`[{"region": "emea", "n": 2, "total": 3, "hi": 2}]`,
+			"",
+		},
+		{
+			"Empty input produces no groups",
+			[]string{"region"},
+			[]string{"n=count()"},
+			"",
+			"region,amount\n",
+			false,
+			`[]`,
+			"",
+		},
+		{
+			"Input without a header produces no groups",
+			[]string{"region"},
+			[]string{"total=sum(amount)"},
+			"",
+			"",
+			false,
+			`[]`,
+			"",
+		},
+		{
+			"Unknown group-by column is an error",
+			[]string{"country"},
+			[]string{"n=count()"},
+			"",
+			salesCsv,
+			false,
+			"",
+			`unknown group-by column "country"`,
+		},
+		{
+			"Unknown sort-by field is an error",
+			[]string{"region"},
+			[]string{"n=count()"},
+			"rep",
+			salesCsv,
+			false,
+			"",
+			`cannot sort by "rep", which is neither a group-by column nor an aggregation`,
+		},
+		{
+			"Sorts by several keys with directions, keeping equal groups in first-seen order",
+			[]string{"region", "rep"},
+			[]string{"n=count()"},
+			"n:desc,region",
+			salesCsv,
+			false,
+			`[{"region": "emea", "rep": "ann", "n": 2}, {"region": "apac", "rep": "dan", "n": 1},
+				{"region": "emea", "rep": "cat", "n": 1}, {"region": "us", "rep": "bob", "n": 1}]`,
+			"",
+		},
+		{
+			"Null values sort last in either direction",
+			[]string{"region"},
+			[]string{"avg=avg(amount)"},
+			"avg:desc",
+			"region,amount\nemea,1\nus,\napac,7\n",
+			false,
+			`[{"region": "apac", "avg": 7}, {"region": "emea", "avg": 1}, {"region": "us", "avg": null}]`,
+			"",
+		},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+			options := aggregateOptions{
+				conversionOptions: conversionOptions{
+					csvInputs:  []io.Reader{bytes.NewReader([]byte(tt.csv))},
+					jsonOutput: jsonStream,
+					skipErrors: tt.skipErrors,
+					sortBy:     tt.sortBy,
+				},
+				groupBy:      tt.groupBy,
+				aggregations: tt.aggregations,
+			}
+
+			err := aggregate(options)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, jsonStream.String())
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAggregateMaxRecords(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		csv      string
+		wantErr  string
+	}{
+		{"Allows exactly the limit", "region\nemea\nus\nemea\n", ""},
+		{"Aborts at one more than the limit", "region\nemea\nus\napac\n",
+			"input exceeds 2 records; rerun with streaming output or raise the limit"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			err := aggregate(aggregateOptions{
+				conversionOptions: conversionOptions{
+					csvInputs:  []io.Reader{strings.NewReader(tt.csv)},
+					jsonOutput: bytes.NewBuffer([]byte{}),
+					maxRecords: 2,
+				},
+				groupBy:      []string{"region"},
+				aggregations: []string{"n=count()"},
+			})
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAggregateIndent(t *testing.T) {
+	jsonStream := bytes.NewBuffer([]byte{})
+
+	err := aggregate(aggregateOptions{
+		conversionOptions: conversionOptions{
+			csvInputs:  []io.Reader{strings.NewReader("region\nemea\nus\nemea\n")},
+			jsonOutput: jsonStream,
+			indent:     2,
+		},
+		groupBy:      []string{"region"},
+		aggregations: []string{"n=count()"},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, `[
+  {
+    "n": 2,
+    "region": "emea"
+  },
+  {
+    "n": 1,
+    "region": "us"
+  }
+]
+`, jsonStream.String())
+}
+
+func TestParseAggregations(t *testing.T) {
+	colNames := []string{"a", "b"}
+
+	for _, tt := range []struct {
+		testName string
+		specs    []string
+		wantAggs []aggregation
+		wantErr  string
+	}{
+		{
+			"Parses specs",
+			[]string{"n=count()", " total = sum( a ) ", "d=distinct-count(b)"},
+			[]aggregation{{"n", "count", ""}, {"total", "sum", "a"}, {"d", "distinct-count", "b"}},
+			"",
+		},
+		{"Malformed spec", []string{"sum(a)"}, nil, `invalid aggregation "sum(a)" (expected name=function(column))`},
+		{"Unknown function", []string{"x=median(a)"}, nil, `aggregation "x=median(a)" uses unknown function "median"`},
+		{"Missing column", []string{"x=sum()"}, nil, `aggregation "x=sum()" requires a column`},
+		{"Unknown column", []string{"x=max(c)"}, nil, `aggregation "x=max(c)" references unknown column "c"`},
+		{"Group-by name", []string{"a=count()"}, nil,
+			`aggregation "a=count()" has the same name as group-by column "a"`},
+		{"Duplicate name", []string{"x=count()", "x=sum(b)"}, nil,
+			`aggregation "x=sum(b)" has the same name as another aggregation`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			aggs, err := parseAggregations(tt.specs, colNames, []string{"a"})
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantAggs, aggs)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDistinctCountIsBounded(t *testing.T) {
+	acc := aggregation{name: "d", function: "distinct-count", column: "a"}.newAccumulator()
+	for i := 0; i <= maxDistinctValues; i++ {
+		acc.add(string(rune(i)), 0)
+	}
+
+	assert.Equal(t, maxDistinctValues, acc.result(), "Capped counts should still be numbers")
+}
+
+func TestAggregateCappedDistinctCount(t *testing.T) {
+	logged := bytes.NewBuffer([]byte{})
+	oldLogOutput, oldLogFlags := log.Writer(), log.Flags()
+	log.SetOutput(logged)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(oldLogOutput)
+		log.SetFlags(oldLogFlags)
+	})
+	csvData := bytes.NewBufferString("region,id\nus,1\n")
+	for i := 0; i <= maxDistinctValues; i++ {
+		fmt.Fprintf(csvData, "emea,%d\n", i)
+	}
+	jsonStream := bytes.NewBuffer([]byte{})
+
+	err := aggregate(aggregateOptions{
+		conversionOptions: conversionOptions{
+			csvInputs:  []io.Reader{csvData},
+			jsonOutput: jsonStream,
+			sortBy:     "ids",
+		},
+		groupBy:      []string{"region"},
+		aggregations: []string{"ids=distinct-count(id)"},
+	})
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"region": "us", "ids": 1}, {"region": "emea", "ids": 10000}]`, jsonStream.String())
+	assert.Equal(t, "Stopped counting distinct values of ids at 10000 in 1 groups, whose counts are lower bounds\n",
+		logged.String())
+}
+
+func TestAggregateNoGroupBy(t *testing.T) {
+	var count int64
+	err := aggregate(aggregateOptions{
+		conversionOptions: conversionOptions{
+			csvInputs:  []io.Reader{readCounter{strings.NewReader("region\nemea\n"), &count}},
+			jsonOutput: bytes.NewBuffer([]byte{}),
+		},
+		aggregations: []string{"n=count()"},
+	})
+
+	assert.EqualError(t, err, "at least one group-by column is required")
+	assert.Equal(t, exitUsage, exitCode(err))
+	assert.Zero(t, count, "Usage errors should be reported before any input is read")
+}
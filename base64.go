@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// base64BinaryPolicies are the policies accepted by --base64-binary for values of --base64-columns which do not
+// decode to UTF-8 text: keeping the encoded value, wrapping it in a base64Binary object, or treating it as an error.
+var base64BinaryPolicies = []string{"keep", "wrap", "error"}
+
+// defaultBase64BinaryPolicy is the policy which applies when none is chosen.
+const defaultBase64BinaryPolicy = "keep"
+
+// base64Binary is emitted in place of a value of --base64-columns which does not decode to UTF-8 text, with the
+// "wrap" policy of base64BinaryPolicies.
+type base64Binary struct {
+	Binary string `json:"$binary"`
+}
+
+// checkBase64Columns returns a *usageError unless policy is empty or one of base64BinaryPolicies, and each of
+// columns is one of colNames.
+func checkBase64Columns(columns []string, policy string, colNames []string) error {
+	if policy != "" && !containsString(base64BinaryPolicies, policy) {
+		return usageErrorf("unknown --base64-binary policy %q (expected one of %s)", policy,
+			strings.Join(base64BinaryPolicies, ", "))
+	}
+	for _, column := range columns {
+		if colNames != nil && !containsString(colNames, column) {
+			return usageErrorf("unknown --base64-columns column %q", column)
+		}
+	}
+	return nil
+}
+
+// decodeBase64 decodes value from either the standard or the URL-safe base64 alphabet, with or without padding,
+// ignoring surrounding whitespace.
+func decodeBase64(value string) ([]byte, error) {
+	value = strings.TrimRight(strings.TrimSpace(value), "=")
+	if strings.ContainsAny(value, "-_") {
+		return base64.RawURLEncoding.DecodeString(value)
+	}
+	return base64.RawStdEncoding.DecodeString(value)
+}
+
+// decodeBase64Fields decodes the base64 values of the data row with the given number in the columns given by
+// `conversionOptions.base64Columns`, in place. Values which do not decode to UTF-8 text are handled according
+// to the reader's base64Binary policy, where those to be wrapped are left as they are for base64Values() to decode.
+// Returns a *rowError for values which are not base64, or which are binary with the "error" policy.
+func (r *csvRowReader) decodeBase64Fields(rowNum int, rowFields []string) error {
+	for i, name := range r.colNames {
+		if !containsString(r.base64Columns, name) || i >= len(rowFields) {
+			continue
+		}
+		decoded, err := decodeBase64(rowFields[i])
+		if err != nil {
+			return &rowError{rowNum, fmt.Errorf("column %q value %q is not valid base64", name,
+				truncateText(rowFields[i], invalidValueLength))}
+		}
+		switch {
+		case utf8.Valid(decoded) && r.base64Binary != "wrap":
+			rowFields[i] = string(decoded)
+		case utf8.Valid(decoded):
+		case r.base64Binary == "error":
+			return &rowError{rowNum, fmt.Errorf("column %q value does not decode to UTF-8 text", name)}
+		case r.base64Binary != "wrap":
+			r.numBinaryKept[name]++
+		}
+	}
+	return nil
+}
+
+// base64Values returns a copy of fields in which the values of columns, which were left encoded by
+// decodeBase64Fields() with the "wrap" policy, are decoded, and wrapped as a base64Binary unless they are UTF-8 text.
+func base64Values(fields map[string]interface{}, columns []string) map[string]interface{} {
+	decoded := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		decoded[k] = v
+	}
+	for _, column := range columns {
+		value, ok := fields[column].(string)
+		if !ok {
+			continue
+		}
+		// Values were checked as they were read, so they decode
+		data, _ := decodeBase64(value)
+		if utf8.Valid(data) {
+			decoded[column] = string(data)
+		} else {
+			decoded[column] = base64Binary{base64.StdEncoding.EncodeToString(data)}
+		}
+	}
+	return decoded
+}
+
+// logBinaryKept logs how many values of each of --base64-columns were kept encoded since they were not UTF-8 text.
+func logBinaryKept(numKept map[string]int) {
+	columns := make([]string, 0, len(numKept))
+	for column := range numKept {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	for _, column := range columns {
+		if logStructured() {
+			logEvent("kept binary values", "column", column, "count", numKept[column])
+		} else {
+			log.Printf("Kept %d values of base64 column %q encoded, since they do not decode to UTF-8 text",
+				numKept[column], column)
+		}
+	}
+}
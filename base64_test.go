@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBase64(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		value    string
+		want     string
+		wantErr  bool
+	}{
+		{"Standard alphabet", "Pz8+aGk=", "??>hi", false},
+		{"URL-safe alphabet", "Pz8-aGk=", "??>hi", false},
+		{"Without padding", "aGVsbG8", "hello", false},
+		{"Surrounding whitespace", " aGVsbG8=\n", "hello", false},
+		{"Empty", "", "", false},
+		{"Invalid characters", "not base64!", "", true},
+		{"Invalid length", "aGVsbG8=a", "", true},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			decoded, err := decodeBase64(tt.value)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, string(decoded))
+			}
+		})
+	}
+}
+
+func TestCsv2JsonBase64Columns(t *testing.T) {
+	discardLogs(t)
+	// The second payload is the bytes ff fe 00, in the URL-safe alphabet
+	csvData := "id,payload\n1,eyJhIjoxfQ==\n2,__4A\n3,Pz8-\n"
+
+	for _, tt := range []struct {
+		testName   string
+		csv        string
+		policy     string
+		skipErrors bool
+		wantJson   string
+		wantErr    string
+	}{
+		{"Keeps binary values encoded by default", csvData, "", false,
+			`[{"id":"1","payload":"{\"a\":1}"},{"id":"2","payload":"__4A"},{"id":"3","payload":"??>"}]`, ""},
+		{"Keeps binary values encoded", csvData, "keep", false,
+			`[{"id":"1","payload":"{\"a\":1}"},{"id":"2","payload":"__4A"},{"id":"3","payload":"??>"}]`, ""},
+		{"Wraps binary values", csvData, "wrap", false,
+			`[{"id":"1","payload":"{\"a\":1}"},{"id":"2","payload":{"$binary":"//4A"}},{"id":"3","payload":"??>"}]`,
+			""},
+		{"Binary values are errors", csvData, "error", false, "",
+			`row 2: column "payload" value does not decode to UTF-8 text`},
+		{"Binary values can be skipped", csvData, "error", true,
+			`[{"id":"1","payload":"{\"a\":1}"},{"id":"3","payload":"??>"}]`, ""},
+		{"Invalid base64 is an error", "id,payload\n1,%%%\n", "", false, "",
+			`row 1: column "payload" value "%%%" is not valid base64`},
+		{"Invalid base64 can be skipped", "id,payload\n1,%%%\n2,aGk\n", "wrap", true,
+			`[{"id":"2","payload":"hi"}]`, ""},
+		{"Unknown column", "id\n1\n", "", false, "", `unknown --base64-columns column "payload"`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+
+			err := csv2Json(conversionOptions{
+				csvInputs:     []io.Reader{strings.NewReader(tt.csv)},
+				jsonOutput:    jsonStream,
+				base64Columns: []string{"payload"},
+				base64Binary:  tt.policy,
+				skipErrors:    tt.skipErrors,
+			})
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, jsonStream.String())
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLogBinaryKept(t *testing.T) {
+	var buf bytes.Buffer
+	oldLogOutput, oldFlags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(oldLogOutput)
+		log.SetFlags(oldFlags)
+	})
+
+	logBinaryKept(map[string]int{"payload": 2, "attachment": 1})
+
+	assert.Equal(t, `Kept 1 values of base64 column "attachment" encoded, since they do not decode to UTF-8 text`+"\n"+
+		`Kept 2 values of base64 column "payload" encoded, since they do not decode to UTF-8 text`+"\n", buf.String())
+}
@@ -0,0 +1,57 @@
+package main
+
+// bundleMember is an option which is set by a bundle flag, unless one of flagNames (keyed by long name) was given
+// explicitly, since explicit flags take precedence over bundles.
+type bundleMember struct {
+	// option is the name of the option as described by --explain
+	option    string
+	flagNames []string
+	apply     func()
+}
+
+// applyBundle applies each of members for which none of its flags appear in given, as returned by givenFlags(),
+// returning the names of the options it applied.
+func applyBundle(members []bundleMember, given map[string]bool) []string {
+	var applied []string
+	for _, m := range members {
+		explicit := false
+		for _, flagName := range m.flagNames {
+			explicit = explicit || given[flagName]
+		}
+		if !explicit {
+			m.apply()
+			applied = append(applied, m.option)
+		}
+	}
+	return applied
+}
+
+// strictBundle is enabled by --strict to reject anything suspicious about the input. RFC 4180 validation also
+// gives way to any dialect setting, since the two cannot be combined.
+func strictBundle(options *conversionOptions) []bundleMember {
+	return []bundleMember{
+		{"strict_headers", []string{"strict-headers"}, func() { options.strictHeaders = true }},
+		{"rfc4180_strict", []string{"rfc4180-strict", "dialect", "delimiter", "lazy-quotes", "trim-leading-space"},
+			func() { options.rfc4180Strict = true }},
+		{"skip_errors", []string{"s", "skip-errors"}, func() { options.skipErrors = false }},
+		{"fail_if_empty", []string{"fail-if-empty"}, func() { options.failIfEmpty = true }},
+		{"reject_invalid_utf8", []string{"reject-invalid-utf8"}, func() { options.rejectInvalidUtf8 = true }},
+	}
+}
+
+// lenientBundle is enabled by --lenient to convert as much of messy input as possible. Its dialect settings give way
+// to RFC 4180 validation, since the two cannot be combined. It also sets trim, as by --trim, which is resolved into
+// options once bundles are applied.
+func lenientBundle(options *conversionOptions, trim *bool) []bundleMember {
+	return []bundleMember{
+		{"skip_errors", []string{"s", "skip-errors"}, func() { options.skipErrors = true }},
+		{"lazy_quotes", []string{"lazy-quotes", "rfc4180-strict"}, func() { options.dialect.lazyQuotes = true }},
+		{"pad_short_rows", []string{"pad-short-rows"}, func() { options.padShortRows = true }},
+		{"keep_extra_fields", []string{"keep-extra-fields", "extra-fields"}, func() { options.keepExtraFields = true }},
+		{"trim_leading_space", []string{"trim-leading-space", "rfc4180-strict"},
+			func() { options.dialect.trimLeadingSpace = true }},
+		{"trim", []string{"trim"}, func() { *trim = true }},
+		{"fill_empty_headers", []string{"fill-empty-headers"}, func() { options.fillEmptyHeaders = true }},
+		{"dedupe_headers", []string{"dedupe-headers"}, func() { options.dedupeHeaders = true }},
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStrictBundle(t *testing.T) {
+	for _, tt := range []struct {
+		testName    string
+		options     conversionOptions
+		given       []string
+		wantApplied []string
+		wantOptions conversionOptions
+	}{
+		{
+			"Applies every member",
+			conversionOptions{skipErrors: false},
+			nil,
+			[]string{"strict_headers", "rfc4180_strict", "skip_errors", "fail_if_empty", "reject_invalid_utf8"},
+			conversionOptions{
+				strictHeaders:     true,
+				rfc4180Strict:     true,
+				failIfEmpty:       true,
+				rejectInvalidUtf8: true,
+			},
+		},
+		{
+			"Explicit flags take precedence",
+			conversionOptions{skipErrors: true},
+			[]string{"s", "fail-if-empty", "delimiter"},
+			[]string{"strict_headers", "reject_invalid_utf8"},
+			conversionOptions{
+				strictHeaders:     true,
+				skipErrors:        true,
+				rejectInvalidUtf8: true,
+			},
+		},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			given := make(map[string]bool)
+			for _, flagName := range tt.given {
+				given[flagName] = true
+			}
+
+			options := tt.options
+			applied := applyBundle(strictBundle(&options), given)
+
+			assert.Equal(t, tt.wantApplied, applied)
+			assert.Equal(t, tt.wantOptions, options)
+		})
+	}
+}
+
+func TestExplainBundle(t *testing.T) {
+	options := conversionOptions{}
+	applied := applyBundle(strictBundle(&options), map[string]bool{})
+	e := explainConversion(options, "", map[string]bool{})
+	for _, option := range applied {
+		assert.Contains(t, e, option, "Every member of a bundle should be explained")
+	}
+}
+
+func TestLenientBundle(t *testing.T) {
+	options := conversionOptions{}
+	trim := false
+	applied := applyBundle(lenientBundle(&options, &trim), map[string]bool{"trim-leading-space": true})
+
+	assert.Equal(t, []string{
+		"skip_errors", "lazy_quotes", "pad_short_rows", "keep_extra_fields", "trim", "fill_empty_headers",
+		"dedupe_headers",
+	}, applied)
+	assert.True(t, trim)
+	assert.Equal(t, conversionOptions{
+		dialect:          csvDialect{lazyQuotes: true},
+		skipErrors:       true,
+		padShortRows:     true,
+		keepExtraFields:  true,
+		fillEmptyHeaders: true,
+		dedupeHeaders:    true,
+	}, options)
+}
+
+func TestLenientConversion(t *testing.T) {
+	logged := bytes.NewBuffer([]byte{})
+	oldLogOutput, oldLogFlags := log.Writer(), log.Flags()
+	log.SetOutput(logged)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(oldLogOutput)
+		log.SetFlags(oldLogFlags)
+	})
+
+	csvData := "id,,id\n1,a\"b,x\n2\n3,c,y,extra\n4,\"d\"\"\",z\n"
+	options := conversionOptions{dialect: defaultDialect}
+	// Explicitly disabling lazy quotes leaves the bare quote to be skipped
+	applyBundle(lenientBundle(&options, new(bool)), map[string]bool{"lazy-quotes": true})
+	jsonStream := bytes.NewBuffer([]byte{})
+	stats := &conversionStats{}
+	options.csvInputs = []io.Reader{strings.NewReader(csvData)}
+	options.jsonOutput = jsonStream
+	options.stats = stats
+
+	err := csv2Json(options)
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"id": "2", "column_2": "", "id_2": ""},
+		{"id": "3", "column_2": "c", "id_2": "y", "_extra_1": "extra"},
+		{"id": "4", "column_2": "d\"", "id_2": "z"}
+	]`, jsonStream.String())
+	assert.Equal(t, 1, stats.rowsPadded)
+	assert.Equal(t, 1, stats.rowsExtended)
+	assert.Equal(t, 2, stats.headersRenamed)
+	assert.Equal(t, 1, stats.rowsSkipped)
+	assert.Contains(t, logged.String(), "Padded 1 short rows, kept extra fields of 1 long rows, and renamed 2 headers\n")
+	assert.Contains(t, logged.String(), "Skipped 1 lines (rows) due to parsing errors\n")
+}
+
+func TestPadShortRowsOnly(t *testing.T) {
+	err := csv2Json(conversionOptions{
+		csvInputs:    []io.Reader{strings.NewReader("a,b\n1\n2,3,4\n")},
+		jsonOutput:   ioutil.Discard,
+		padShortRows: true,
+	})
+	assert.EqualError(t, err, "record on line 3: wrong number of fields", "Long rows should still be rejected")
+}
+
+func TestCliLenientTrims(t *testing.T) {
+	discardLogs(t)
+	csvFileName := filepath.Join(t.TempDir(), "padded.csv")
+	require.NoError(t, ioutil.WriteFile(csvFileName, []byte(" id ,name\n1,  Ann \t\n"), 0600))
+
+	for _, tt := range []struct {
+		testName string
+		cliArgs  []string
+		wantJson string
+	}{
+		{"Trims headers and values", []string{"--lenient"}, `[{"id": "1", "name": "Ann"}]`},
+		{"Explicit --trim=false leaves only leading space trimmed", []string{"--lenient", "--trim=false"},
+			`[{"id ": "1", "name": "Ann \t"}]`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			stdout, err := runTestCli(t, append(tt.cliArgs, csvFileName)...)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.wantJson, stdout)
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// columnTransform rewrites a value of a single column of a data row, returning an error if it cannot, such as when
+// the value is not in the format the transform expects.
+type columnTransform func(value string) (string, error)
+
+// namedTransforms are the transforms accepted by --transform, other than date, which takes a layout.
+var namedTransforms = map[string]columnTransform{
+	"lower": func(value string) (string, error) { return strings.ToLower(value), nil },
+	"upper": func(value string) (string, error) { return strings.ToUpper(value), nil },
+	"trim":  func(value string) (string, error) { return strings.TrimSpace(value), nil },
+	"digits": func(value string) (string, error) {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, value), nil
+	},
+}
+
+// dateTransform returns a columnTransform which rewrites non-empty dates in the Go time layout as RFC 3339.
+func dateTransform(layout string) columnTransform {
+	return func(value string) (string, error) {
+		if value == "" {
+			return value, nil
+		}
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			return "", err
+		}
+		return t.Format(time.RFC3339), nil
+	}
+}
+
+// parseColumnTransforms parses the transforms given by --transform as COLUMN=TRANSFORM, where TRANSFORM is one of
+// namedTransforms, or date:LAYOUT to rewrite dates in a Go time layout as RFC 3339. Specs which were split on commas
+// are rejoined, as by joinRegexSpecs(), since layouts may contain them.
+// Returns a *usageError for invalid specs, unknown transforms, or a column which is given more than once.
+func parseColumnTransforms(specs []string) (map[string]columnTransform, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	transforms := make(map[string]columnTransform, len(specs))
+	for _, spec := range joinRegexSpecs(specs) {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, usageErrorf("invalid --transform %q (expected COLUMN=TRANSFORM)", spec)
+		}
+		column, name := parts[0], parts[1]
+		if _, ok := transforms[column]; ok {
+			return nil, usageErrorf("column %q is given more than once by --transform", column)
+		}
+		if transform, ok := namedTransforms[name]; ok {
+			transforms[column] = transform
+		} else if layout := strings.TrimPrefix(name, "date:"); layout != name && layout != "" {
+			transforms[column] = dateTransform(layout)
+		} else {
+			return nil, usageErrorf("unknown --transform %q for column %q (expected one of digits, lower, trim, "+
+				"upper, or date:LAYOUT)", name, column)
+		}
+	}
+	return transforms, nil
+}
+
+// checkColumnTransforms returns a *usageError unless each column of transforms is one of colNames, or colNames is
+// nil, as when there is no header.
+func checkColumnTransforms(transforms map[string]columnTransform, colNames []string) error {
+	if colNames == nil {
+		return nil
+	}
+	columns := make([]string, 0, len(transforms))
+	for column := range transforms {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	for _, column := range columns {
+		if !containsString(colNames, column) {
+			return usageErrorf("unknown column %q to transform", column)
+		}
+	}
+	return nil
+}
+
+// transformColumns rewrites the values of the data row with the given number, in place, by the transform of each
+// column given by `conversionOptions.columnTransforms`.
+// Returns a *rowError for the first value which a transform fails to rewrite.
+func (r *csvRowReader) transformColumns(rowNum int, rowFields []string) error {
+	for i, name := range r.colNames {
+		transform, ok := r.columnTransforms[name]
+		if !ok || i >= len(rowFields) {
+			continue
+		}
+		transformed, err := transform(rowFields[i])
+		if err != nil {
+			return &rowError{rowNum, fmt.Errorf("column %q value %q could not be transformed: %v", name,
+				truncateText(rowFields[i], invalidValueLength), err)}
+		}
+		rowFields[i] = transformed
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"github.com/integrii/flaggy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseColumnTransforms(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		specs    []string
+		value    string
+		want     map[string]string
+		wantErr  string
+	}{
+		{"None", nil, "", map[string]string{}, ""},
+		{"Named transforms", []string{"a=lower", "b=upper", "c=trim", "d=digits"}, " (555) 123-Ab ",
+			map[string]string{"a": " (555) 123-ab ", "b": " (555) 123-AB ", "c": "(555) 123-Ab", "d": "555123"}, ""},
+		{"Date layouts", []string{"joined=date:Jan 2", " 2006"}, "Mar 14, 2021",
+			map[string]string{"joined": "2021-03-14T00:00:00Z"}, ""},
+		{"Missing transform", []string{"a="}, "", nil, `invalid --transform "a=" (expected COLUMN=TRANSFORM)`},
+		{"Missing column", []string{"=lower"}, "", nil, `invalid --transform "=lower" (expected COLUMN=TRANSFORM)`},
+		{"Missing layout", []string{"a=date:"}, "", nil,
+			`unknown --transform "date:" for column "a" (expected one of digits, lower, trim, upper, or date:LAYOUT)`},
+		{"Unknown transform", []string{"a=title"}, "", nil,
+			`unknown --transform "title" for column "a" (expected one of digits, lower, trim, upper, or date:LAYOUT)`},
+		{"Repeated column", []string{"a=lower", "a=upper"}, "", nil,
+			`column "a" is given more than once by --transform`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			transforms, err := parseColumnTransforms(tt.specs)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, exitUsage, exitCode(err))
+				return
+			}
+			require.NoError(t, err)
+			got := make(map[string]string, len(transforms))
+			for column, transform := range transforms {
+				got[column], err = transform(tt.value)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCliTransform(t *testing.T) {
+	tempDir := t.TempDir()
+	csvFileName := filepath.Join(tempDir, "people.csv")
+	outputFileName := filepath.Join(tempDir, "people.json")
+	require.NoError(t, ioutil.WriteFile(csvFileName,
+		[]byte("email,joined,phone\nAnn@Example.COM,03/14/2021,555-0100\nbob@x.org,,\n"), 0600))
+	os.Args = []string{"csv2json", "--transform", "email=lower", "--transform", "joined=date:01/02/2006",
+		"--transform", "phone=digits", "-o", outputFileName, csvFileName}
+	flaggy.ResetParser()
+
+	require.NoError(t, runCli())
+	jsonData, err := ioutil.ReadFile(outputFileName)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"email": "ann@example.com", "joined": "2021-03-14T00:00:00Z", "phone": "5550100"},
+		{"email": "bob@x.org", "joined": "", "phone": ""}
+	]`, string(jsonData))
+}
+
+func TestCsv2JsonColumnTransforms(t *testing.T) {
+	oldLogOutput := log.Writer()
+	logOutput := bytes.NewBuffer([]byte{})
+	log.SetOutput(logOutput)
+	t.Cleanup(func() {
+		log.SetOutput(oldLogOutput)
+	})
+	transforms := map[string]columnTransform{
+		"joined": func(value string) (string, error) {
+			joined, err := time.Parse("01/02/2006", value)
+			if err != nil {
+				return "", err
+			}
+			return joined.Format(time.RFC3339), nil
+		},
+		"email": func(value string) (string, error) {
+			return strings.ToLower(value), nil
+		},
+	}
+
+	for _, tt := range []struct {
+		testName   string
+		csv        string
+		skipErrors bool
+		wantJson   string
+		wantErr    string
+		wantLog    string
+	}{
+		{"Transforms dates to RFC 3339", "email,joined,n\nAnn@Example.COM,03/14/2021,1\n", false,
+			`[{"email": "ann@example.com", "joined": "2021-03-14T00:00:00Z", "n": "1"}]`, "", ""},
+		{"Failed transforms abort", "email,joined\na@x.org,soon\n", false, "",
+			`row 1: column "joined" value "soon" could not be transformed: parsing time "soon" as "01/02/2006": ` +
+				`cannot parse "soon" as "01"`, ""},
+		{"Failed transforms can be skipped", "email,joined\na@x.org,2021-03-14\nB@x.org,01/02/2020\n", true,
+			`[{"email": "b@x.org", "joined": "2020-01-02T00:00:00Z"}]`, "", `row 1: column "joined" value "2021-03-14"`},
+		{"Unknown columns", "email\na@x.org\n", false, "", `unknown column "joined" to transform`, ""},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+			logOutput.Reset()
+
+			err := csv2Json(conversionOptions{
+				csvInputs:        []io.Reader{strings.NewReader(tt.csv)},
+				jsonOutput:       jsonStream,
+				columnTransforms: transforms,
+				skipErrors:       tt.skipErrors,
+			})
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, jsonStream.String())
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+			assert.Contains(t, logOutput.String(), tt.wantLog)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// compressionModes are the modes accepted by --compression: auto decompresses inputs which begin with gzipMagic,
+// while gzip decompresses every input and none leaves every input as it is.
+var compressionModes = []string{"auto", "gzip", "none"}
+
+// defaultCompressionMode is the mode which applies when none is chosen on the command line. Elsewhere, an empty mode
+// is the same as none.
+const defaultCompressionMode = "auto"
+
+// gzipMagic is the magic number which begins gzip-compressed data.
+const gzipMagic = "\x1f\x8b"
+
+// checkCompressionMode returns a *usageError unless mode is empty or one of compressionModes.
+func checkCompressionMode(mode string) error {
+	if mode == "" || containsString(compressionModes, mode) {
+		return nil
+	}
+	return usageErrorf("unknown --compression mode %q (expected one of %s)", mode, strings.Join(compressionModes, ", "))
+}
+
+// decompressError reports compressed input which could not be decompressed, such as corrupted or truncated data.
+type decompressError struct {
+	name string
+	err  error
+}
+
+func (e *decompressError) Error() string {
+	return fmt.Sprintf("decompressing %s: %v", e.name, e.err)
+}
+
+func (e *decompressError) Unwrap() error {
+	return e.err
+}
+
+// gzipReader decompresses gzip-compressed data read from the input named name, reporting any failure to do so as a
+// *decompressError rather than leaving it to be mistaken for a problem with the CSV data.
+type gzipReader struct {
+	zr   *gzip.Reader
+	name string
+}
+
+// newGzipReader returns a *gzipReader for r, which must begin with a valid gzip header.
+func newGzipReader(r io.Reader, name string) (*gzipReader, error) {
+	zr, err := gzip.NewReader(r)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, &decompressError{name, err}
+	}
+	return &gzipReader{zr, name}, nil
+}
+
+func (g *gzipReader) Read(p []byte) (int, error) {
+	n, err := g.zr.Read(p)
+	if err != nil && err != io.EOF {
+		err = &decompressError{g.name, err}
+	}
+	return n, err
+}
+
+// decompressInput returns a reader of source, read from the input named name and decompressed according to mode.
+// In auto mode, the start of source is peeked to detect gzipMagic without consuming it, which works even for stdin
+// and other inputs which cannot be read at an offset.
+func decompressInput(source io.Reader, name, mode string) (io.Reader, error) {
+	switch mode {
+	case "", "none":
+		return source, nil
+	case "auto":
+		br := bufio.NewReader(source)
+		if magic, _ := br.Peek(len(gzipMagic)); string(magic) != gzipMagic {
+			return br, nil
+		}
+		source = br
+	}
+	return newGzipReader(source, name)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// gzipFixture is a gzip-compressed CSV file whose header begins with a BOM.
+const gzipFixture = "testdata/people.csv.gz"
+
+// gzipFixtureJson is the conversion of gzipFixture.
+const gzipFixtureJson = `[{"id": "1", "name": "ann"}, {"id": "2", "name": "bob"}]`
+
+func TestCheckCompressionMode(t *testing.T) {
+	assert.NoError(t, checkCompressionMode(""))
+	assert.NoError(t, checkCompressionMode("gzip"))
+	assert.EqualError(t, checkCompressionMode("zip"),
+		`unknown --compression mode "zip" (expected one of auto, gzip, none)`)
+}
+
+func TestDecompressInput(t *testing.T) {
+	compressed, err := ioutil.ReadFile(gzipFixture)
+	require.NoError(t, err)
+	plain := "id,name\n1,ann\n"
+
+	for _, tt := range []struct {
+		testName string
+		input    string
+		mode     string
+		want     string
+		wantErr  string
+	}{
+		{"Auto decompresses gzip", string(compressed), "auto", "\uFEFFid,name\n1,ann\n2,bob\n", ""},
+		{"Auto leaves other input", plain, "auto", plain, ""},
+		{"Auto leaves empty input", "", "auto", "", ""},
+		{"Gzip decompresses gzip", string(compressed), "gzip", "\uFEFFid,name\n1,ann\n2,bob\n", ""},
+		{"Gzip rejects other input", plain, "gzip", "", "decompressing a.csv: gzip: invalid header"},
+		{"Gzip rejects empty input", "", "gzip", "", "decompressing a.csv: unexpected EOF"},
+		{"None leaves gzip", string(compressed), "none", string(compressed), ""},
+		{"Empty mode leaves gzip", string(compressed), "", string(compressed), ""},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			r, err := decompressInput(strings.NewReader(tt.input), "a.csv", tt.mode)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			got, err := ioutil.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestCsv2JsonCorruptGzip(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, err := zw.Write([]byte("id,name\n1,ann\n2,bob\n"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	data := compressed.Bytes()
+	badChecksum := append([]byte(nil), data...)
+	badChecksum[len(badChecksum)-8] ^= 0xff
+
+	for _, tt := range []struct {
+		testName string
+		data     []byte
+		wantErr  string
+	}{
+		{"Truncated", data[:len(data)-10], "decompressing input 1: unexpected EOF"},
+		{"Bad checksum", badChecksum, "decompressing input 1: gzip: invalid checksum"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			err := csv2Json(conversionOptions{
+				csvInputs:   []io.Reader{bytes.NewReader(tt.data)},
+				jsonOutput:  ioutil.Discard,
+				compression: "auto",
+				skipErrors:  true,
+			})
+			assert.EqualError(t, err, tt.wantErr)
+			assert.Equal(t, exitInvalidCsv, exitCode(err))
+		})
+	}
+}
+
+func TestCsv2JsonGzipFile(t *testing.T) {
+	for _, mode := range []string{"auto", "gzip"} {
+		t.Run(mode, func(t *testing.T) {
+			files, err := getCsvFiles([]string{gzipFixture}, mode, urlInputOptions{})
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				closeCsvFiles(files)
+			})
+
+			jsonStream := bytes.NewBuffer([]byte{})
+			err = csv2Json(conversionOptions{csvInputs: files, jsonOutput: jsonStream, compression: mode})
+			assert.NoError(t, err)
+			assert.JSONEq(t, gzipFixtureJson, jsonStream.String(), "The BOM should be stripped once decompressed")
+		})
+	}
+
+	_, err := getCsvFiles([]string{"testdata/enrich.sh"}, "gzip", urlInputOptions{})
+	assert.EqualError(t, err, "decompressing testdata/enrich.sh: gzip: invalid header")
+}
+
+func TestCsv2JsonGzipStdin(t *testing.T) {
+	oldStdin := os.Stdin
+	t.Cleanup(func() {
+		os.Stdin = oldStdin
+	})
+
+	for _, mode := range []string{"auto", "gzip"} {
+		t.Run(mode, func(t *testing.T) {
+			f, err := os.Open(gzipFixture)
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				f.Close()
+			})
+			os.Stdin = f
+			files, err := getCsvFiles(nil, mode, urlInputOptions{})
+			require.NoError(t, err)
+
+			jsonStream := bytes.NewBuffer([]byte{})
+			err = csv2Json(conversionOptions{csvInputs: files, jsonOutput: jsonStream, compression: mode})
+			assert.NoError(t, err)
+			assert.JSONEq(t, gzipFixtureJson, jsonStream.String(), "The BOM should be stripped once decompressed")
+		})
+	}
+}
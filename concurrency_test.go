@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentConversions runs many conversions at once from shared template options. Run with -race to detect
+// any conversion which modifies its options or other shared state.
+func TestConcurrentConversions(t *testing.T) {
+	discardLogs(t)
+
+	templates := []conversionOptions{
+		{
+			dialect:    csvDialect{delimiter: ';'},
+			transforms: []valueTransform{defangFormula, stripFormulaEscape},
+			skipErrors: true,
+		},
+		{
+			colNames:            []string{"id", "name"},
+			skippedRowTextLimit: 10,
+			skipErrors:          true,
+			batchSize:           2,
+			maxRecords:          1,
+		},
+	}
+	csvData := []string{
+		"sep=;\nid;name\n1;\"=\"\"ann\"\"\"\n2;'=bob\n3\n",
+		"1,ann\n2,bob\n3\n4,dan\n",
+	}
+	wantJson := []string{
+		`[{"id": "1", "name": "ann"}, {"id": "2", "name": "=bob"}]`,
+		`[{"id": "1", "name": "ann"}, {"id": "2", "name": "bob"}]` + "\n" + `[{"id": "4", "name": "dan"}]`,
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			options := templates[i%len(templates)]
+			options.csvInputs = []io.Reader{strings.NewReader(csvData[i%len(templates)])}
+			jsonStream := bytes.NewBuffer([]byte{})
+			options.jsonOutput = jsonStream
+			options.stats = &conversionStats{maxErrors: 1}
+
+			err := csv2Json(options)
+
+			assert.NoError(t, err, fmt.Sprintf("conversion %d", i))
+			wantLines := strings.Split(wantJson[i%len(templates)], "\n")
+			gotLines := strings.Split(strings.TrimSpace(jsonStream.String()), "\n")
+			if assert.Len(t, gotLines, len(wantLines), fmt.Sprintf("conversion %d", i)) {
+				for j := range wantLines {
+					assert.JSONEq(t, wantLines[j], gotLines[j], fmt.Sprintf("conversion %d", i))
+				}
+			}
+			assert.Equal(t, 1, options.stats.rowsSkipped, fmt.Sprintf("conversion %d", i))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, []string{"id", "name"}, templates[1].colNames, "Template options should not be modified")
+}
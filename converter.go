@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"io"
+)
+
+// converter converts CSV data from a single input to JSON, as csv2Json() does, configured by the converterOptions
+// given to newConverter(), which checks them before any input is read.
+type converter struct {
+	options conversionOptions
+}
+
+// converterOption configures a converter by changing its conversionOptions.
+// Returns a *usageError if the option is given an invalid value.
+type converterOption func(options *conversionOptions) error
+
+// withOptions starts from template rather than the zero conversionOptions, as when converting with the options of
+// the CLI. It should be given before any other converterOptions, which it would otherwise replace. The inputs and
+// output of template are replaced by those given to newConverter().
+func withOptions(template conversionOptions) converterOption {
+	return func(options *conversionOptions) error {
+		*options = template
+		return nil
+	}
+}
+
+// withColumns reads every row of the input as data with these column names, rather than reading them from its
+// header. At least one name must be given, since converting rows without any columns is never intended.
+func withColumns(colNames ...string) converterOption {
+	return func(options *conversionOptions) error {
+		if len(colNames) == 0 {
+			return usageErrorf("withColumns requires at least one column name")
+		}
+		for _, name := range colNames {
+			if name == "" {
+				return usageErrorf("withColumns requires non-empty column names")
+			}
+		}
+		options.colNames = colNames
+		return nil
+	}
+}
+
+// newConverter returns a converter which reads CSV data from input and writes JSON to output, configured by opts in
+// the order given. Without any, column names are read from the header and records are emitted as a JSON array.
+// Returns a *usageError for invalid options or combinations of them, before anything is read from input.
+func newConverter(input io.Reader, output io.Writer, opts ...converterOption) (*converter, error) {
+	options, err := applyConverterOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	options.csvInputs = []io.Reader{input}
+	options.jsonOutput = output
+	return &converter{options: options}, nil
+}
+
+// applyConverterOptions returns the conversionOptions configured by opts, in the order given.
+// Returns a *usageError for invalid options or combinations of them.
+func applyConverterOptions(opts []converterOption) (conversionOptions, error) {
+	var options conversionOptions
+	for _, opt := range opts {
+		if err := opt(&options); err != nil {
+			return options, err
+		}
+	}
+	if options.ndjson && options.yaml {
+		return options, usageErrorf("NDJSON and YAML output cannot be combined")
+	} else if options.yaml && options.batchSize > 0 {
+		return options, usageErrorf("--format yaml cannot be combined with --batch")
+	}
+	return options, checkBatchOptions(options, options.batchSize)
+}
+
+// run converts the input of the converter to its output, stopping with the error of ctx once it is done.
+// Returns any errors from reading CSV or writing JSON, as by csv2Json().
+func (c *converter) run(ctx context.Context) error {
+	options := c.options
+	options.ctx = ctx
+	return csv2Json(options)
+}
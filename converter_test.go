@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestNewConverterDefaults(t *testing.T) {
+	var jsonData bytes.Buffer
+	conv, err := newConverter(strings.NewReader("a,b\n1,x\n2,y\n"), &jsonData)
+	require.NoError(t, err)
+
+	require.NoError(t, conv.run(context.Background()))
+	assert.JSONEq(t, `[{"a": "1", "b": "x"}, {"a": "2", "b": "y"}]`, jsonData.String(),
+		"Column names should be read from the header and records emitted as a JSON array")
+}
+
+func TestNewConverterInvalidOptions(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		opts     []converterOption
+		wantErr  string
+	}{
+		{"No columns", []converterOption{withColumns()}, "withColumns requires at least one column name"},
+		{"Empty column", []converterOption{withColumns("a", "")}, "withColumns requires non-empty column names"},
+		{"Streamed sort", []converterOption{withOptions(conversionOptions{sortBy: "a", batchSize: 2})},
+			"--sort-by cannot be used when records are streamed"},
+		{"Invalid sort", []converterOption{withOptions(conversionOptions{sortBy: "a:sideways"})},
+			`invalid --sort-by key "a:sideways" (expected field[:asc|desc[:mode]])`},
+		{"NDJSON and YAML", []converterOption{withOptions(conversionOptions{yaml: true, ndjson: true})},
+			"NDJSON and YAML output cannot be combined"},
+		{"Batched YAML", []converterOption{withOptions(conversionOptions{yaml: true, batchSize: 2})},
+			"--format yaml cannot be combined with --batch"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var count int64
+			conv, err := newConverter(readCounter{strings.NewReader("a\n1\n"), &count}, ioutil.Discard, tt.opts...)
+			assert.Nil(t, conv)
+			assert.EqualError(t, err, tt.wantErr)
+			assert.Equal(t, exitUsage, exitCode(err))
+			assert.Zero(t, count, "Invalid options should be reported before any input is read")
+		})
+	}
+}
+
+func TestNewConverterMatchesCsv2Json(t *testing.T) {
+	discardLogs(t)
+	csvData := "id,name,score\n3,cy,9.5\n1,ann,7\n2,bob,x,extra\n4,dee,8\n"
+
+	for _, tt := range []struct {
+		testName string
+		opts     []converterOption
+		options  conversionOptions
+	}{
+		{"Defaults", nil, conversionOptions{}},
+		{"Skipped errors", []converterOption{withOptions(conversionOptions{skipErrors: true})},
+			conversionOptions{skipErrors: true}},
+		{"Columns", []converterOption{withOptions(conversionOptions{skipErrors: true}), withColumns("a", "b", "c")},
+			conversionOptions{colNames: []string{"a", "b", "c"}, skipErrors: true}},
+		{"NDJSON with types", []converterOption{withOptions(conversionOptions{ndjson: true, inferTypes: true,
+			skipErrors: true})}, conversionOptions{ndjson: true, inferTypes: true, skipErrors: true}},
+		{"Sorted and limited", []converterOption{withOptions(conversionOptions{sortBy: "id", limit: 2, indent: 2,
+			skipErrors: true})}, conversionOptions{sortBy: "id", limit: 2, indent: 2, skipErrors: true}},
+		{"Batches", []converterOption{withOptions(conversionOptions{batchSize: 2, skipErrors: true})},
+			conversionOptions{batchSize: 2, skipErrors: true}},
+		{"Template replaces columns", []converterOption{withColumns("a", "b"), withOptions(conversionOptions{})},
+			conversionOptions{}},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var want, got bytes.Buffer
+			tt.options.csvInputs = []io.Reader{strings.NewReader(csvData)}
+			tt.options.jsonOutput = &want
+			wantErr := csv2Json(tt.options)
+
+			conv, err := newConverter(strings.NewReader(csvData), &got, tt.opts...)
+			require.NoError(t, err)
+			assert.Equal(t, wantErr, conv.run(context.Background()))
+			assert.Equal(t, want.String(), got.String())
+		})
+	}
+}
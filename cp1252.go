@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"unicode/utf8"
+)
+
+// cp1252Punctuation maps the Windows-1252 bytes for common punctuation to the characters they encode.
+// Each of these bytes is invalid UTF-8 when it does not continue a multibyte sequence.
+var cp1252Punctuation = map[byte]rune{
+	0x82: '‚',
+	0x84: '„',
+	0x85: '…',
+	0x8B: '‹',
+	0x91: '‘',
+	0x92: '’',
+	0x93: '“',
+	0x94: '”',
+	0x95: '•',
+	0x96: '–',
+	0x97: '—',
+	0x9B: '›',
+}
+
+// cp1252Reader passes UTF-8 text through from an io.Reader, translating any stray byte of cp1252Punctuation
+// into the UTF-8 encoding of its character. Valid UTF-8, including multibyte sequences which are split across
+// reads, and any other invalid bytes are passed through unchanged.
+type cp1252Reader struct {
+	br *bufio.Reader
+	// pending holds the rest of an encoded character which did not fit in the caller's buffer
+	pending []byte
+	err     error
+}
+
+func newCp1252Reader(r io.Reader) *cp1252Reader {
+	return &cp1252Reader{br: bufio.NewReader(r)}
+}
+
+func (c *cp1252Reader) Read(p []byte) (int, error) {
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+
+	// Stop once nothing more is buffered, rather than blocking for input which may not yet be available
+	for n < len(p) && (n == 0 || c.br.Buffered() > 0) {
+		if c.err != nil {
+			return n, c.err
+		}
+		r, size, err := c.br.ReadRune()
+		if err != nil {
+			c.err = err
+			continue
+		}
+
+		var encoded [utf8.UTFMax]byte
+		if r == utf8.RuneError && size == 1 {
+			c.br.UnreadRune()
+			b, _ := c.br.ReadByte()
+			if fixed, ok := cp1252Punctuation[b]; ok {
+				size = utf8.EncodeRune(encoded[:], fixed)
+			} else {
+				encoded[0] = b
+			}
+		} else {
+			utf8.EncodeRune(encoded[:], r)
+		}
+
+		copied := copy(p[n:], encoded[:size])
+		c.pending = append(c.pending, encoded[copied:size]...)
+		n += copied
+	}
+	return n, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestCp1252Reader(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		input    string
+		want     string
+	}{
+		{"Valid UTF-8 is unchanged", "café, naïve “quoted” – ok…�", "café, naïve “quoted” – ok…�"},
+		{"Translates punctuation", "\x93hi\x94 \x91a\x92 \x96 \x97 \x85 \x95", "“hi” ‘a’ – — … •"},
+		{"Passes through other invalid bytes", "\x81\x9d\xff", "\x81\x9d\xff"},
+		{"Translates bytes after a truncated sequence", "caf\xe2\x93", "caf\xe2“"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			got, err := ioutil.ReadAll(newCp1252Reader(strings.NewReader(tt.input)))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+
+			got, err = ioutil.ReadAll(iotest.OneByteReader(newCp1252Reader(iotest.OneByteReader(
+				strings.NewReader(tt.input)))))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(got), "Should translate the same when reading one byte at a time")
+		})
+	}
+}
+
+func TestCsv2JsonFixCp1252(t *testing.T) {
+	csvData := "name,quote\nJosé,\x93Voilà\x94 \x96 naïve\x85\n"
+
+	jsonStream := bytes.NewBuffer([]byte{})
+	err := csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader(csvData)},
+		jsonOutput: jsonStream,
+		fixCp1252:  true,
+	})
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"name": "José", "quote": "“Voilà” – naïve…"}]`, jsonStream.String())
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// csvDialect bundles the settings for parsing a particular flavor of CSV data.
+type csvDialect struct {
+	delimiter        rune
+	lazyQuotes       bool
+	trimLeadingSpace bool
+	// comment, when set, begins lines which are skipped as comments
+	comment rune
+	// keepDelimiter prevents a "sep=" preamble line from overriding delimiter, as when it was given explicitly
+	keepDelimiter bool
+	// crlf ends lines with \r\n rather than \n, and quoteAll quotes every field rather than only those which need
+	// it, when CSV is written in the dialect. encoding/csv reads either line ending, and reads quotes as they are.
+	crlf     bool
+	quoteAll bool
+}
+
+// defaultDialect matches the defaults of encoding/csv.
+var defaultDialect = csvDialect{delimiter: ','}
+
+// dialects are the named presets which may be selected with --dialect.
+// Their line endings and quoting only apply when writing CSV, as by the from-json subcommand, since encoding/csv reads
+// both \n and \r\n, and quoted fields, whichever the preset.
+var dialects = map[string]csvDialect{
+	// excel matches CSV files saved by Excel, with \r\n line endings
+	"excel": {delimiter: ',', crlf: true},
+	// excel-tab matches "Text (Tab delimited)" files saved by Excel
+	"excel-tab": {delimiter: '\t', crlf: true},
+	// unix matches CSV files written with \n line endings and every field quoted, as by Python's unix_dialect
+	"unix": {delimiter: ',', quoteAll: true},
+	// postgres matches the default text format of COPY ... TO, which delimits with tabs and never quotes fields
+	"postgres": {delimiter: '\t', lazyQuotes: true},
+}
+
+// configure applies the dialect's settings to reader. The zero csvDialect leaves reader with the defaults of
+// encoding/csv.
+func (d csvDialect) configure(reader *csv.Reader) {
+	if d.delimiter != 0 {
+		reader.Comma = d.delimiter
+	}
+	reader.LazyQuotes = d.lazyQuotes
+	reader.TrimLeadingSpace = d.trimLeadingSpace
+	reader.Comment = d.comment
+}
+
+// readSettings returns the dialect without the settings which only apply when writing CSV.
+func (d csvDialect) readSettings() csvDialect {
+	d.crlf, d.quoteAll = false, false
+	return d
+}
+
+// dialectOverrides are individually-configured dialect settings, which take precedence over a dialect preset.
+// Empty or false values are only applied when the corresponding flag was explicitly given.
+type dialectOverrides struct {
+	delimiter        string
+	lazyQuotes       bool
+	trimLeadingSpace bool
+	comment          string
+}
+
+// resolveDialect returns the named dialect preset (or the default dialect, when name is empty), with any overrides
+// applied for which given has an entry keyed by the corresponding flag's long name.
+func resolveDialect(name string, overrides dialectOverrides, given map[string]bool) (csvDialect, error) {
+	d := defaultDialect
+	if name != "" {
+		var ok bool
+		if d, ok = dialects[name]; !ok {
+			return d, fmt.Errorf("unknown dialect %q (expected one of %s)", name, strings.Join(dialectNames(), ", "))
+		}
+	}
+
+	if given["delimiter"] {
+		delimiter, err := parseDelimiter(overrides.delimiter)
+		if err != nil {
+			return d, err
+		}
+		d.delimiter = delimiter
+		d.keepDelimiter = true
+	}
+	if given["lazy-quotes"] {
+		d.lazyQuotes = overrides.lazyQuotes
+	}
+	if given["trim-leading-space"] {
+		d.trimLeadingSpace = overrides.trimLeadingSpace
+	}
+	if given["comment"] {
+		comment, err := parseComment(overrides.comment, d.delimiter)
+		if err != nil {
+			return d, err
+		}
+		d.comment = comment
+	}
+
+	return d, nil
+}
+
+// delimiterEscapes are the escape sequences which parseDelimiter accepts for characters awkward to type in a shell.
+var delimiterEscapes = map[string]rune{`\t`: '\t'}
+
+// parseDelimiter parses a delimiter given as a single character, or one of delimiterEscapes, which cannot be a quote
+// or line break.
+func parseDelimiter(s string) (rune, error) {
+	if r, ok := delimiterEscapes[s]; ok {
+		return r, nil
+	} else if len(s) > 1 && s[0] == '\\' {
+		return 0, fmt.Errorf("invalid delimiter %q (the only supported escape sequence is \\t)", s)
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 || size != len(s) || r == utf8.RuneError {
+		return 0, fmt.Errorf("invalid delimiter %q (expected a single character)", s)
+	}
+	if r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("invalid delimiter %q (cannot be a quote or line break)", s)
+	}
+	return r, nil
+}
+
+// parseComment parses a comment character given as a single character, which cannot be delimiter, a quote, or a
+// line break, as encoding/csv requires.
+func parseComment(s string, delimiter rune) (rune, error) {
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 || size != len(s) || r == utf8.RuneError {
+		return 0, fmt.Errorf("invalid comment character %q (expected a single character)", s)
+	}
+	if r == delimiter || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("invalid comment character %q (cannot be the delimiter, a quote, or a line break)", s)
+	}
+	return r, nil
+}
+
+// readSepLine consumes the "sep=" preamble line which Excel may write before the header to declare the delimiter
+// (matched case-insensitively), returning the delimiter it declares. Returns false, and consumes nothing, when br
+// does not begin with such a line.
+func readSepLine(br *bufio.Reader) (rune, bool) {
+	const prefix = "sep="
+	// A sep= line is at most the prefix, one UTF-8 encoded rune, and CRLF
+	peeked, _ := br.Peek(len(prefix) + utf8.UTFMax + 2)
+	if len(peeked) <= len(prefix) || !strings.EqualFold(string(peeked[:len(prefix)]), prefix) {
+		return 0, false
+	}
+
+	sep, size := utf8.DecodeRune(peeked[len(prefix):])
+	n := len(prefix) + size
+	rest := peeked[n:]
+	if bytes.HasPrefix(rest, []byte("\r\n")) {
+		n += 2
+	} else if bytes.HasPrefix(rest, []byte("\n")) {
+		n++
+	} else if len(rest) > 0 {
+		return 0, false
+	}
+	if sep == utf8.RuneError || sep == '\r' || sep == '\n' || sep == '"' {
+		return 0, false
+	}
+
+	br.Discard(n)
+	return sep, true
+}
+
+// delimiterCandidates are the delimiters which sniffDelimiter() chooses between, in order of preference.
+var delimiterCandidates = []rune{',', '\t', ';', '|'}
+
+// delimiterSniffLength is the number of bytes at the start of each input which are inspected by sniffDelimiter().
+const delimiterSniffLength = 4096
+
+// sniffDelimiter returns whichever of delimiterCandidates splits the most lines at the start of br, as parsed
+// according to dialect, into the same number of fields as the header, as long as that is more than one. br is not
+// consumed. A delimiter declared by a sep= line is returned as it is. Returns false when no candidate splits more
+// lines than every other.
+func sniffDelimiter(br *bufio.Reader, dialect csvDialect) (rune, bool) {
+	peeked, err := br.Peek(delimiterSniffLength)
+	sample := bytes.TrimPrefix(peeked, utf8BOM)
+	if err == nil {
+		// The sample ends partway through the input, so its last line may be incomplete
+		if end := bytes.LastIndexByte(sample, '\n'); end >= 0 {
+			sample = sample[:end+1]
+		}
+	}
+	if sep, ok := readSepLine(bufio.NewReader(bytes.NewReader(sample))); ok {
+		return sep, true
+	}
+
+	best, bestLines, tied := rune(0), 0, false
+	for _, candidate := range delimiterCandidates {
+		lines := consistentLines(sample, dialect, candidate)
+		if lines > bestLines {
+			best, bestLines, tied = candidate, lines, false
+		} else if lines == bestLines && lines > 0 {
+			tied = true
+		}
+	}
+	return best, bestLines > 0 && !tied
+}
+
+// consistentLines returns how many records at the start of sample, delimited by delimiter, have the same number of
+// fields as the first, or zero when it has only one.
+func consistentLines(sample []byte, dialect csvDialect, delimiter rune) int {
+	reader := csv.NewReader(bytes.NewReader(sample))
+	dialect.configure(reader)
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	lines, numFields := 0, 0
+	for {
+		fields, err := reader.Read()
+		if err != nil || (lines > 0 && len(fields) != numFields) {
+			break
+		}
+		if lines == 0 {
+			numFields = len(fields)
+		}
+		lines++
+	}
+	if numFields < 2 {
+		return 0
+	}
+	return lines
+}
+
+// logSniffedDelimiter logs the delimiter used for the input named name, which was detected by sniffDelimiter(), or
+// else which it fell back to since none was.
+func logSniffedDelimiter(name string, delimiter rune, detected bool) {
+	decision := "detected"
+	if !detected {
+		decision = "ambiguous, using"
+	}
+	if logStructured() {
+		logEvent("sniffed delimiter", "input", name, "delimiter", string(delimiter), "detected", detected)
+	} else {
+		log.Printf("Delimiter of %s %s %s", name, decision, strconv.QuoteRune(delimiter))
+	}
+}
+
+// dialectNames returns the names of every dialect preset, sorted.
+func dialectNames() []string {
+	names := make([]string, 0, len(dialects))
+	for name := range dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// listDialects writes a table of every dialect preset and its settings.
+func listDialects(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DIALECT\tDELIMITER\tLAZY QUOTES\tTRIM LEADING SPACE\tLINE ENDING\tQUOTE ALL")
+	for _, name := range dialectNames() {
+		d := dialects[name]
+		lineEnding := `\n`
+		if d.crlf {
+			lineEnding = `\r\n`
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%t\t%t\t%s\t%t\n", name, strconv.QuoteRune(d.delimiter), d.lazyQuotes,
+			d.trimLeadingSpace, lineEnding, d.quoteAll)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestResolveDialect(t *testing.T) {
+	for _, tt := range []struct {
+		testName    string
+		name        string
+		overrides   dialectOverrides
+		given       []string
+		wantDialect csvDialect
+		wantErr     string
+	}{
+		{"Defaults without a preset", "", dialectOverrides{}, nil, csvDialect{delimiter: ','}, ""},
+		{"Uses excel preset", "excel", dialectOverrides{}, nil, csvDialect{delimiter: ',', crlf: true}, ""},
+		{"Uses excel-tab preset", "excel-tab", dialectOverrides{}, nil, csvDialect{delimiter: '\t', crlf: true},
+			""},
+		{"Uses unix preset", "unix", dialectOverrides{}, nil, csvDialect{delimiter: ',', quoteAll: true}, ""},
+		{"Uses postgres preset", "postgres", dialectOverrides{}, nil, csvDialect{delimiter: '\t', lazyQuotes: true}, ""},
+		{
+			"Given flags override preset",
+			"postgres",
+			dialectOverrides{delimiter: "|", lazyQuotes: false, trimLeadingSpace: true},
+			[]string{"delimiter", "lazy-quotes", "trim-leading-space"},
+			csvDialect{delimiter: '|', trimLeadingSpace: true, keepDelimiter: true},
+			"",
+		},
+		{
+			"Flags which were not given do not override preset",
+			"postgres",
+			dialectOverrides{delimiter: "|", lazyQuotes: false},
+			[]string{"delimiter"},
+			csvDialect{delimiter: '|', lazyQuotes: true, keepDelimiter: true},
+			"",
+		},
+		{
+			"Unknown preset",
+			"sqlite",
+			dialectOverrides{},
+			nil,
+			csvDialect{},
+			`unknown dialect "sqlite" (expected one of excel, excel-tab, postgres, unix)`,
+		},
+		{
+			"Invalid delimiter",
+			"",
+			dialectOverrides{delimiter: "||"},
+			[]string{"delimiter"},
+			csvDialect{},
+			`invalid delimiter "||" (expected a single character)`,
+		},
+		{
+			"Comment character",
+			"excel-tab",
+			dialectOverrides{comment: "#"},
+			[]string{"comment"},
+			csvDialect{delimiter: '\t', comment: '#', crlf: true},
+			"",
+		},
+		{
+			"Comment character cannot be the delimiter",
+			"",
+			dialectOverrides{delimiter: ";", comment: ";"},
+			[]string{"delimiter", "comment"},
+			csvDialect{},
+			`invalid comment character ";" (cannot be the delimiter, a quote, or a line break)`,
+		},
+		{
+			"Invalid comment character",
+			"",
+			dialectOverrides{comment: "//"},
+			[]string{"comment"},
+			csvDialect{},
+			`invalid comment character "//" (expected a single character)`,
+		},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			given := make(map[string]bool)
+			for _, name := range tt.given {
+				given[name] = true
+			}
+
+			d, err := resolveDialect(tt.name, tt.overrides, given)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantDialect, d)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseDelimiter(t *testing.T) {
+	for _, tt := range []struct {
+		testName      string
+		delimiter     string
+		wantDelimiter rune
+		wantErr       string
+	}{
+		{"Semicolon", ";", ';', ""},
+		{"Tab", "\t", '\t', ""},
+		{"Escaped tab", `\t`, '\t', ""},
+		{"Backslash", `\`, '\\', ""},
+		{"Unsupported escape sequence", `\0`, 0, `invalid delimiter "\\0" (the only supported escape sequence is \t)`},
+		{"Multibyte character", "§", '§', ""},
+		{"Empty", "", 0, `invalid delimiter "" (expected a single character)`},
+		{"Multiple characters", ";;", 0, `invalid delimiter ";;" (expected a single character)`},
+		{"Quote", `"`, 0, `invalid delimiter "\"" (cannot be a quote or line break)`},
+		{"Newline", "\n", 0, `invalid delimiter "\n" (cannot be a quote or line break)`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			delimiter, err := parseDelimiter(tt.delimiter)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantDelimiter, delimiter)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSepLine(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		dialect  csvDialect
+		csv      string
+		wantJson string
+	}{
+		{"Uses declared delimiter", defaultDialect, "sep=;\na;b\n1;2\n", `[{"a": "1", "b": "2"}]`},
+		{"Matches case-insensitively after BOM", defaultDialect, "\uFEFFSEP=|\r\na|b\r\n1|2\r\n", `[{"a": "1", "b": "2"}]`},
+		{"Skips redundant declaration", defaultDialect, "sep=,\na,b\n1,2\n", `[{"a": "1", "b": "2"}]`},
+		{"Overrides preset delimiter", dialects["excel-tab"], "sep=;\na;b\n1;2\n", `[{"a": "1", "b": "2"}]`},
+		{
+			"Explicit delimiter takes precedence",
+			csvDialect{delimiter: ',', keepDelimiter: true},
+			"sep=;\na,b\n1,2\n",
+			`[{"a": "1", "b": "2"}]`,
+		},
+		{
+			"Quoted sep= cell is data",
+			defaultDialect,
+			"\"sep=;\",b\n1,2\n",
+			`[{"sep=;": "1", "b": "2"}]`,
+		},
+		{
+			"Unquoted sep= cell followed by other fields is data",
+			defaultDialect,
+			"sep=;,b\n1,2\n",
+			`[{"sep=;": "1", "b": "2"}]`,
+		},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+
+			err := csv2Json(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(tt.csv)},
+				dialect:    tt.dialect,
+				jsonOutput: jsonStream,
+			})
+
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.wantJson, jsonStream.String())
+		})
+	}
+}
+
+func TestCsv2JsonDelimiter(t *testing.T) {
+	for _, tt := range []struct {
+		testName  string
+		delimiter string
+		csv       string
+		colNames  []string
+		wantJson  string
+	}{
+		{"Semicolon", ";", "\uFEFFa;b\n1;2\n3\n4;5\n", nil, `[{"a": "1", "b": "2"}, {"a": "4", "b": "5"}]`},
+		{"Pipe", "|", "\uFEFFa|b\n1|2\n3\n4|5\n", nil, `[{"a": "1", "b": "2"}, {"a": "4", "b": "5"}]`},
+		{"Tab", `\t`, "\uFEFFa\tb\n1\t2\n3\n4\t5\n", nil, `[{"a": "1", "b": "2"}, {"a": "4", "b": "5"}]`},
+		{"Forced columns", ";", "\uFEFF1;2\n3\n4;5\n", []string{"x", "y"},
+			`[{"x": "1", "y": "2"}, {"x": "4", "y": "5"}]`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+			dialect, err := resolveDialect("", dialectOverrides{delimiter: tt.delimiter}, map[string]bool{"delimiter": true})
+			assert.NoError(t, err)
+
+			err = csv2Json(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(tt.csv)},
+				dialect:    dialect,
+				colNames:   tt.colNames,
+				skipErrors: true,
+				jsonOutput: jsonStream,
+			})
+
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.wantJson, jsonStream.String())
+		})
+	}
+}
+
+func TestSniffDelimiter(t *testing.T) {
+	for _, tt := range []struct {
+		testName      string
+		csv           string
+		wantDelimiter rune
+		wantOk        bool
+	}{
+		{"Comma", "a,b,c\n1,2,3\n", ',', true},
+		{"Tab", "a\tb\n1\t2\n", '\t', true},
+		{"Semicolon", "a;b\n1;2\n", ';', true},
+		{"Pipe", "a|b\n1|2\n", '|', true},
+		{"Header only", "a;b;c\n", ';', true},
+		{"After BOM", "\uFEFFa|b\n1|2\n", '|', true},
+		{"Quoted semicolons", "\"a;b\",\"c;d\"\n\"1;2\",\"3;4\"\n\"5;6\",\"7;8\"\n", ',', true},
+		{"Quoted commas", "name;amount\n\"Smith, John\";\"1,5\"\n\"Doe, Jane\";\"2,75\"\n", ';', true},
+		{"Quoted line breaks", "a|b\n\"1,\n2,3\"|4\n5|6\n", '|', true},
+		{"Most consistent delimiter", "a,b;c\n1,2;3\n4,5,6;7\n", ';', true},
+		{"Declared by sep= line", "sep=|\na,b\n1,2\n", '|', true},
+		{"Single column is ambiguous", "name\nAlice\n", 0, false},
+		{"Equally consistent delimiters are ambiguous", "a;b,c\n1;2,3\n", 0, false},
+		{"Empty input is ambiguous", "", 0, false},
+		{"Ignores incomplete last line of sample", "a;b\n" + strings.Repeat("1;2\n", 1500), ';', true},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			br := bufio.NewReader(strings.NewReader(tt.csv))
+
+			delimiter, ok := sniffDelimiter(br, defaultDialect)
+
+			assert.Equal(t, tt.wantOk, ok)
+			if tt.wantOk {
+				assert.Equal(t, string(tt.wantDelimiter), string(delimiter))
+			}
+			rest, _ := ioutil.ReadAll(br)
+			assert.Equal(t, tt.csv, string(rest), "Sniffing should not consume any input")
+		})
+	}
+}
+
+func TestCsv2JsonSniffDelimiter(t *testing.T) {
+	var logs bytes.Buffer
+	oldLogOutput, oldFlags := log.Writer(), log.Flags()
+	log.SetOutput(&logs)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(oldLogOutput)
+		log.SetFlags(oldFlags)
+	})
+
+	for _, tt := range []struct {
+		testName string
+		csv      []string
+		dialect  csvDialect
+		wantJson string
+		wantLogs string
+	}{
+		{"Detects delimiter of each input", []string{"a;b\n1;2\n", "a\tb\n3\t4\n"}, defaultDialect,
+			`[{"a": "1", "b": "2"}, {"a": "3", "b": "4"}]`,
+			"Delimiter of input 1 detected ';'\nDelimiter of input 2 detected '\\t'\n"},
+		{"Falls back to preset delimiter", []string{"a b\n1 2\n"}, dialects["excel-tab"], `[{"a b": "1 2"}]`,
+			"Delimiter of input 1 ambiguous, using '\\t'\n"},
+		{"Explicit delimiter takes precedence", []string{"a;b\n1;2\n"}, csvDialect{delimiter: ',', keepDelimiter: true},
+			`[{"a;b": "1;2"}]`, ""},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			logs.Reset()
+			jsonStream := bytes.NewBuffer([]byte{})
+			var inputs []io.Reader
+			for _, csv := range tt.csv {
+				inputs = append(inputs, strings.NewReader(csv))
+			}
+
+			err := csv2Json(conversionOptions{
+				csvInputs:            inputs,
+				dialect:              tt.dialect,
+				sniffDelimiter:       true,
+				logSniffedDelimiters: true,
+				jsonOutput:           jsonStream,
+			})
+
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.wantJson, jsonStream.String())
+			assert.Equal(t, tt.wantLogs, logs.String())
+		})
+	}
+}
+
+func TestDialectConfigure(t *testing.T) {
+	reader := csv.NewReader(strings.NewReader(""))
+	csvDialect{}.configure(reader)
+	assert.Equal(t, ',', reader.Comma, "Zero dialect should keep the default delimiter")
+
+	csvDialect{delimiter: ';', lazyQuotes: true, trimLeadingSpace: true, comment: '#'}.configure(reader)
+	assert.Equal(t, ';', reader.Comma)
+	assert.True(t, reader.LazyQuotes)
+	assert.True(t, reader.TrimLeadingSpace)
+	assert.Equal(t, '#', reader.Comment)
+}
+
+func TestCsv2JsonComment(t *testing.T) {
+	discardLogs(t)
+	csvData := "# exported 2024-05-01\nbanner\nid,note\n# instrument: A\n1,\"# not a comment\"\n#2,x\n" +
+		"3,c,extra\n4,d\n5,e\n"
+
+	jsonStream := bytes.NewBuffer([]byte{})
+	stats := &conversionStats{}
+	err := csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader(csvData)},
+		jsonOutput: jsonStream,
+		dialect:    csvDialect{comment: '#'},
+		skipRows:   1,
+		limit:      2,
+		skipErrors: true,
+		stats:      stats,
+	})
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"id": "1", "note": "# not a comment"}, {"id": "4", "note": "d"}]`, jsonStream.String())
+	assert.Equal(t, 1, stats.rowsSkipped, "Only the long row should be skipped")
+}
+
+func TestListDialects(t *testing.T) {
+	out := bytes.NewBuffer([]byte{})
+
+	assert.NoError(t, listDialects(out))
+	assert.Equal(t, `DIALECT    DELIMITER  LAZY QUOTES  TRIM LEADING SPACE  LINE ENDING  QUOTE ALL
+excel      ','        false        false               \r\n         false
+excel-tab  '\t'       false        false               \r\n         false
+postgres   '\t'       true         false               \n           false
+unix       ','        false        false               \n           true
+`, out.String())
+}
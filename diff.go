@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// diffOptions is used to configure comparing two CSV inputs when calling diffCsv().
+type diffOptions struct {
+	// key identifies each record, as for csv2KeyedJson(), and records are compared without ignoreColumns
+	key           keyOptions
+	ignoreColumns []string
+}
+
+// diffReport describes the differences between the records of an old and a new CSV input, by key.
+type diffReport struct {
+	Added   []string        `json:"added"`
+	Removed []string        `json:"removed"`
+	Changed []changedRecord `json:"changed"`
+}
+
+// changedRecord describes the fields of the record with a key which differ between the old and new inputs.
+type changedRecord struct {
+	Key    string                 `json:"key"`
+	Fields map[string]fieldChange `json:"fields"`
+}
+
+// fieldChange holds the old and new values of a field, which are nil where the record has no such field.
+type fieldChange struct {
+	Old *string `json:"old"`
+	New *string `json:"new"`
+}
+
+// differencesError reports that the inputs compared by the diff subcommand differ, so that it exits accordingly.
+type differencesError struct {
+	numDifferences int
+}
+
+func (e differencesError) Error() string {
+	return fmt.Sprintf("found %d differences", e.numDifferences)
+}
+
+// diffCsv compares the records of `oldOptions.csvInputs` and `newOptions.csvInputs` by their keys, formed from
+// `diffOpts.key` like the keys of csv2KeyedJson(). Only the records of the smaller input are held in memory, and the
+// other is compared as it is read, where inputs which cannot be measured count as large. Each list in the report is
+// ordered by key. Keys which are duplicated within an input are an error.
+// Returns any errors from reading CSV or forming keys.
+func diffCsv(oldOptions, newOptions conversionOptions, diffOpts diffOptions) (diffReport, error) {
+	keyOpts := diffOpts.key
+	if len(keyOpts.columns) > 1 && keyOpts.sep == "" {
+		return diffReport{}, usageErrorf("--key-sep cannot be empty when --key names more than one column")
+	}
+	ignored := make(map[string]bool, len(diffOpts.ignoreColumns))
+	for _, column := range diffOpts.ignoreColumns {
+		ignored[column] = true
+	}
+
+	heldOptions, streamedOptions := oldOptions, newOptions
+	newHeld := inputSize(newOptions.csvInputs) < inputSize(oldOptions.csvInputs)
+	if newHeld {
+		heldOptions, streamedOptions = newOptions, oldOptions
+	}
+	held := make(map[string]record)
+	if err := eachBatch(heldOptions, 1, func(batch []record) error {
+		key, err := recordKey(batch[0], keyOpts)
+		if err != nil {
+			return err
+		} else if _, ok := held[key]; ok {
+			return &keyError{key, "duplicates the key of an earlier record"}
+		}
+		held[key] = batch[0]
+		return nil
+	}); err != nil {
+		return diffReport{}, err
+	}
+
+	report := diffReport{Added: []string{}, Removed: []string{}, Changed: []changedRecord{}}
+	seen := make(map[string]bool)
+	err := eachBatch(streamedOptions, 1, func(batch []record) error {
+		rec := batch[0]
+		key, err := recordKey(rec, keyOpts)
+		if err != nil {
+			return err
+		} else if seen[key] {
+			return &keyError{key, "duplicates the key of an earlier record"}
+		}
+		seen[key] = true
+		heldRec, ok := held[key]
+		switch {
+		case !ok && newHeld:
+			report.Removed = append(report.Removed, key)
+		case !ok:
+			report.Added = append(report.Added, key)
+		case newHeld:
+			report.addChanges(key, rec, heldRec, ignored)
+		default:
+			report.addChanges(key, heldRec, rec, ignored)
+		}
+		return nil
+	})
+	if err != nil {
+		return diffReport{}, err
+	}
+	for key := range held {
+		if seen[key] {
+			continue
+		} else if newHeld {
+			report.Added = append(report.Added, key)
+		} else {
+			report.Removed = append(report.Removed, key)
+		}
+	}
+
+	sort.Strings(report.Added)
+	sort.Strings(report.Removed)
+	sort.Slice(report.Changed, func(i, j int) bool { return report.Changed[i].Key < report.Changed[j].Key })
+	return report, nil
+}
+
+// addChanges adds the key to the report's changed records if any field of oldRec and newRec other than those which
+// are ignored differs.
+func (r *diffReport) addChanges(key string, oldRec, newRec record, ignored map[string]bool) {
+	fields := make(map[string]fieldChange)
+	value := func(rec record, name string) *string {
+		if v, ok := rec[name]; ok {
+			return &v
+		}
+		return nil
+	}
+	for _, recs := range [][2]record{{oldRec, newRec}, {newRec, oldRec}} {
+		for name, v := range recs[0] {
+			if other, ok := recs[1][name]; ignored[name] || (ok && other == v) {
+				continue
+			}
+			fields[name] = fieldChange{value(oldRec, name), value(newRec, name)}
+		}
+	}
+	if len(fields) > 0 {
+		r.Changed = append(r.Changed, changedRecord{key, fields})
+	}
+}
+
+// numDifferences counts the keys reported as added, removed, or changed.
+func (r diffReport) numDifferences() int {
+	return len(r.Added) + len(r.Removed) + len(r.Changed)
+}
+
+// inputSize returns the total size of inputs, which is the largest possible size if any cannot be measured.
+func inputSize(inputs []io.Reader) int64 {
+	var size int64
+	for _, input := range inputs {
+		f, ok := input.(interface{ Stat() (os.FileInfo, error) })
+		if !ok {
+			return 1<<63 - 1
+		}
+		info, err := f.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return 1<<63 - 1
+		}
+		size += info.Size()
+	}
+	return size
+}
+
+// writeDiffReport writes report to w as indented JSON. Returns a differencesError if the report has any
+// differences.
+func writeDiffReport(w io.Writer, report diffReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return &outputError{err}
+	}
+	if n := report.numDifferences(); n > 0 {
+		return differencesError{n}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffCsv(t *testing.T) {
+	oldCsv := "id,name,updated_at\n1,Alice,2024-01-01\n2,Bob,2024-01-01\n3,Carol,2024-01-01\n"
+	newCsv := "id,name,updated_at\n3,Caroline,2024-02-01\n1,Alice,2024-02-01\n4,Dave,2024-02-01\n"
+	str := func(s string) *string { return &s }
+
+	for _, tt := range []struct {
+		testName   string
+		oldCsv     string
+		newCsv     string
+		ignore     []string
+		wantReport diffReport
+	}{
+		{"Adds, removes, and changes", oldCsv, newCsv, []string{"updated_at"}, diffReport{
+			Added:   []string{"4"},
+			Removed: []string{"2"},
+			Changed: []changedRecord{{"3", map[string]fieldChange{"name": {str("Carol"), str("Caroline")}}}},
+		}},
+		{"Without ignored columns", oldCsv, newCsv, nil, diffReport{
+			Added:   []string{"4"},
+			Removed: []string{"2"},
+			Changed: []changedRecord{
+				{"1", map[string]fieldChange{"updated_at": {str("2024-01-01"), str("2024-02-01")}}},
+				{"3", map[string]fieldChange{
+					"name":       {str("Carol"), str("Caroline")},
+					"updated_at": {str("2024-01-01"), str("2024-02-01")},
+				}},
+			},
+		}},
+		{"Added and removed columns", "id,a\n1,x\n", "id,b\n1,x\n", nil, diffReport{
+			Added:   []string{},
+			Removed: []string{},
+			Changed: []changedRecord{{"1", map[string]fieldChange{"a": {str("x"), nil}, "b": {nil, str("x")}}}},
+		}},
+		{"Same", oldCsv, oldCsv, nil, diffReport{Added: []string{}, Removed: []string{}, Changed: []changedRecord{}}},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			report, err := diffCsv(
+				conversionOptions{csvInputs: []io.Reader{strings.NewReader(tt.oldCsv)}},
+				conversionOptions{csvInputs: []io.Reader{strings.NewReader(tt.newCsv)}},
+				diffOptions{keyOptions{columns: []string{"id"}, sep: ":"}, tt.ignore})
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantReport, report)
+		})
+	}
+}
+
+func TestDiffCsvHoldsSmallerFile(t *testing.T) {
+	dir := t.TempDir()
+	oldName, newName := filepath.Join(dir, "old.csv"), filepath.Join(dir, "new.csv")
+	require.NoError(t, ioutil.WriteFile(oldName, []byte("id,n\n1,a\n2,b\n3,c\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(newName, []byte("id,n\n2,B\n4,d\n"), 0644))
+	oldFile, err := os.Open(oldName)
+	require.NoError(t, err)
+	defer oldFile.Close()
+	newFile, err := os.Open(newName)
+	require.NoError(t, err)
+	defer newFile.Close()
+	require.Less(t, inputSize([]io.Reader{newFile}), inputSize([]io.Reader{oldFile}))
+
+	report, err := diffCsv(conversionOptions{csvInputs: []io.Reader{oldFile}},
+		conversionOptions{csvInputs: []io.Reader{newFile}}, diffOptions{key: keyOptions{columns: []string{"id"}}})
+	assert.NoError(t, err)
+	b, old := "B", "b"
+	assert.Equal(t, diffReport{
+		Added:   []string{"4"},
+		Removed: []string{"1", "3"},
+		Changed: []changedRecord{{"2", map[string]fieldChange{"n": {&old, &b}}}},
+	}, report, "Holding the new file should not change the report")
+}
+
+func TestDiffCsvErrors(t *testing.T) {
+	_, err := diffCsv(conversionOptions{csvInputs: []io.Reader{strings.NewReader("id\n1\n1\n")}},
+		conversionOptions{csvInputs: []io.Reader{strings.NewReader("id\n1\n")}},
+		diffOptions{key: keyOptions{columns: []string{"id"}}})
+	assert.EqualError(t, err, `key "1": duplicates the key of an earlier record`)
+
+	_, err = diffCsv(conversionOptions{csvInputs: []io.Reader{strings.NewReader("id\n1\n")}},
+		conversionOptions{csvInputs: []io.Reader{strings.NewReader("id\n1\n")}},
+		diffOptions{key: keyOptions{columns: []string{"key"}}})
+	assert.Equal(t, exitUsage, exitCode(err))
+}
+
+func TestWriteDiffReport(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeDiffReport(&buf, diffReport{Added: []string{}, Removed: []string{}, Changed: []changedRecord{}})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"added": [], "removed": [], "changed": []}`, buf.String())
+
+	err = writeDiffReport(&buf, diffReport{Added: []string{"1"}, Removed: []string{"2"}})
+	assert.Equal(t, differencesError{2}, err)
+	assert.Equal(t, exitDifferences, exitCode(err))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// driftReport describes how the header rows of several CSV inputs differ, as reported by the drift subcommand.
+type driftReport struct {
+	Signatures []headerSignature `json:"signatures"`
+	Files      []fileDrift       `json:"files"`
+}
+
+// headerSignature is a distinct header row, along with the inputs which have it, in order.
+type headerSignature struct {
+	Columns []string `json:"columns"`
+	Files   []string `json:"files"`
+}
+
+// fileDrift describes how the header row of an input differs from that of the first input. Signature is the index of
+// its header row among the report's signatures, and Reordered is set when the columns it shares with the first input
+// are in a different order. Types, when sniffed, holds the type of each value of its first data row, as by
+// sniffType().
+type fileDrift struct {
+	File      string            `json:"file"`
+	Signature int               `json:"signature"`
+	Added     []string          `json:"added"`
+	Removed   []string          `json:"removed"`
+	Reordered bool              `json:"reordered"`
+	Types     map[string]string `json:"types,omitempty"`
+}
+
+// csvDrift reads the header row of each of `options.csvInputs` separately, along with its first data row when
+// sniffTypes is set, and reports how they differ, without reading any further. Inputs without a header row have no
+// columns.
+// Returns any errors from reading CSV.
+func csvDrift(options conversionOptions, sniffTypes bool) (driftReport, error) {
+	report := driftReport{Signatures: []headerSignature{}, Files: []fileDrift{}}
+	var first []string
+	for i, input := range options.csvInputs {
+		inputOptions := options
+		inputOptions.csvInputs = []io.Reader{input}
+		reader, colNames, err := newCsvRowReader(inputOptions)
+		if err != nil {
+			return driftReport{}, err
+		}
+		if colNames == nil {
+			colNames = []string{}
+		}
+		if i == 0 {
+			first = colNames
+		}
+
+		drift := fileDrift{File: inputName(input, i+1), Signature: -1,
+			Added: missingStrings(colNames, first), Removed: missingStrings(first, colNames)}
+		drift.Reordered = !equalStrings(commonStrings(colNames, first), commonStrings(first, colNames))
+		for j, signature := range report.Signatures {
+			if equalStrings(signature.Columns, colNames) {
+				drift.Signature = j
+				break
+			}
+		}
+		if drift.Signature < 0 {
+			drift.Signature = len(report.Signatures)
+			report.Signatures = append(report.Signatures, headerSignature{Columns: colNames})
+		}
+		report.Signatures[drift.Signature].Files = append(report.Signatures[drift.Signature].Files, drift.File)
+
+		if sniffTypes && len(colNames) > 0 {
+			rowFields, err := reader.Read()
+			if err != nil && err != io.EOF {
+				return driftReport{}, err
+			}
+			if err == nil {
+				drift.Types = make(map[string]string, len(colNames))
+				for j, name := range colNames {
+					if j < len(rowFields) {
+						drift.Types[name] = sniffType(rowFields[j])
+					}
+				}
+			}
+		}
+		report.Files = append(report.Files, drift)
+	}
+	return report, nil
+}
+
+// missingStrings returns the values which are in values but not in other, in order.
+func missingStrings(values, other []string) []string {
+	missing := []string{}
+	for _, v := range values {
+		if !containsString(other, v) {
+			missing = append(missing, v)
+		}
+	}
+	return missing
+}
+
+// commonStrings returns the values which are in both values and other, in the order of values.
+func commonStrings(values, other []string) []string {
+	var common []string
+	for _, v := range values {
+		if containsString(other, v) {
+			common = append(common, v)
+		}
+	}
+	return common
+}
+
+// sniffType describes the type of a CSV value as empty, integer, number, boolean, or string.
+func sniffType(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "empty"
+	} else if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return "integer"
+	} else if n, err := strconv.ParseFloat(value, 64); err == nil && !math.IsInf(n, 0) && !math.IsNaN(n) {
+		return "number"
+	} else if lower := strings.ToLower(value); lower == "true" || lower == "false" {
+		return "boolean"
+	}
+	return "string"
+}
+
+// writeDriftReport writes report to w as indented JSON.
+func writeDriftReport(w io.Writer, report driftReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
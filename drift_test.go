@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCsvDrift(t *testing.T) {
+	for _, tt := range []struct {
+		testName   string
+		csvs       []string
+		sniffTypes bool
+		wantReport driftReport
+	}{
+		{"Identical files", []string{"a,b\n1,2\n", "a,b\n3,4\n"}, false, driftReport{
+			Signatures: []headerSignature{{[]string{"a", "b"}, []string{"input 1", "input 2"}}},
+			Files: []fileDrift{
+				{"input 1", 0, []string{}, []string{}, false, nil},
+				{"input 2", 0, []string{}, []string{}, false, nil},
+			},
+		}},
+		{"An added and a removed column", []string{"a,b\n", "a,b,c\n", "b\n"}, false, driftReport{
+			Signatures: []headerSignature{
+				{[]string{"a", "b"}, []string{"input 1"}},
+				{[]string{"a", "b", "c"}, []string{"input 2"}},
+				{[]string{"b"}, []string{"input 3"}},
+			},
+			Files: []fileDrift{
+				{"input 1", 0, []string{}, []string{}, false, nil},
+				{"input 2", 1, []string{"c"}, []string{}, false, nil},
+				{"input 3", 2, []string{}, []string{"a"}, false, nil},
+			},
+		}},
+		{"A reordered file", []string{"a,b,c\n", "c,a,b\n", "a,b,c\n"}, false, driftReport{
+			Signatures: []headerSignature{
+				{[]string{"a", "b", "c"}, []string{"input 1", "input 3"}},
+				{[]string{"c", "a", "b"}, []string{"input 2"}},
+			},
+			Files: []fileDrift{
+				{"input 1", 0, []string{}, []string{}, false, nil},
+				{"input 2", 1, []string{}, []string{}, true, nil},
+				{"input 3", 0, []string{}, []string{}, false, nil},
+			},
+		}},
+		{"Sniffs the types of the first data row", []string{"a,b,c\n1,x,true\n2,y,false\n", "a,b,c\n1.5,,3\n", "a,b,c\n"},
+			true, driftReport{
+				Signatures: []headerSignature{{[]string{"a", "b", "c"}, []string{"input 1", "input 2", "input 3"}}},
+				Files: []fileDrift{
+					{"input 1", 0, []string{}, []string{}, false,
+						map[string]string{"a": "integer", "b": "string", "c": "boolean"}},
+					{"input 2", 0, []string{}, []string{}, false,
+						map[string]string{"a": "number", "b": "empty", "c": "integer"}},
+					{"input 3", 0, []string{}, []string{}, false, nil},
+				},
+			}},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			inputs := make([]io.Reader, len(tt.csvs))
+			for i, csv := range tt.csvs {
+				inputs[i] = strings.NewReader(csv)
+			}
+
+			report, err := csvDrift(conversionOptions{csvInputs: inputs}, tt.sniffTypes)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantReport, report)
+		})
+	}
+}
+
+func TestCsvDriftReadsOnlyHeader(t *testing.T) {
+	csvData := "a,b\n" + strings.Repeat("1,2\n", 1<<20)
+	var count int64
+
+	_, err := csvDrift(conversionOptions{csvInputs: []io.Reader{readCounter{strings.NewReader(csvData), &count}}}, true)
+
+	assert.NoError(t, err)
+	assert.Less(t, count, int64(64*1024), "Only the start of the input should be read")
+}
+
+func TestSniffType(t *testing.T) {
+	for value, want := range map[string]string{
+		"": "empty", " ": "empty", "42": "integer", "-7": "integer", "1.5": "number", "1e3": "number",
+		"True": "boolean", "false": "boolean", "NaN": "string", "t": "string", "abc": "string",
+	} {
+		assert.Equal(t, want, sniffType(value), "Type of %q", value)
+	}
+}
+
+func TestWriteDriftReport(t *testing.T) {
+	out := bytes.NewBuffer([]byte{})
+
+	err := writeDriftReport(out, driftReport{
+		Signatures: []headerSignature{{[]string{"a"}, []string{"a.csv"}}},
+		Files:      []fileDrift{{"a.csv", 0, []string{}, []string{}, false, nil}},
+	})
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"signatures": [{"columns": ["a"], "files": ["a.csv"]}],
+		"files": [{"file": "a.csv", "signature": 0, "added": [], "removed": [], "reordered": false}]}`, out.String())
+}
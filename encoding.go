@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// inputEncodings are the encodings accepted by --encoding, other than the aliases of encodingAliases.
+var inputEncodings = []string{"utf-8", "utf-16le", "utf-16be", "windows-1252", "iso-8859-1"}
+
+// encodingAliases maps other common names of inputEncodings to their names.
+var encodingAliases = map[string]string{
+	"utf8":    "utf-8",
+	"cp1252":  "windows-1252",
+	"latin1":  "iso-8859-1",
+	"latin-1": "iso-8859-1",
+}
+
+// utf16Boms are the byte order marks which identify UTF-16 text, by the encoding they identify.
+var utf16Boms = map[string]string{
+	"\xff\xfe": "utf-16le",
+	"\xfe\xff": "utf-16be",
+}
+
+// cp1252High maps the bytes 0x80 to 0x9F of Windows-1252 to the characters they encode, where the five bytes
+// without a character are zero. Every other byte encodes the same code point as in ISO-8859-1.
+var cp1252High = [32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+// normalizeEncoding returns the name among inputEncodings of the encoding named name, ignoring case and resolving
+// encodingAliases. Returns a *usageError for any other name.
+func normalizeEncoding(name string) (string, error) {
+	normalized := strings.ToLower(name)
+	if alias, ok := encodingAliases[normalized]; ok {
+		normalized = alias
+	}
+	if normalized == "" || containsString(inputEncodings, normalized) {
+		return normalized, nil
+	}
+	return "", usageErrorf("unknown --encoding %q (expected one of %s)", name, strings.Join(inputEncodings, ", "))
+}
+
+// decodeError reports a byte sequence which is not valid in the encoding an input was decoded from.
+type decodeError struct {
+	name     string
+	encoding string
+	// offset is the number of bytes of the input which preceded the sequence
+	offset int64
+	reason string
+}
+
+func (e *decodeError) Error() string {
+	return fmt.Sprintf("decoding %s as %s: %s at byte %d", e.name, e.encoding, e.reason, e.offset)
+}
+
+// errInvalidSequence is returned by the decoders of runeDecoders for byte sequences without a character.
+var errInvalidSequence = errors.New("invalid byte sequence")
+
+// runeDecoders decode a single character from br for each encoding but utf-8, returning the character along with the
+// number of bytes it was encoded with, or an errInvalidSequence wrapping the reason it could not be decoded.
+var runeDecoders = map[string]func(br *bufio.Reader) (rune, int, error){
+	"utf-16le":     func(br *bufio.Reader) (rune, int, error) { return decodeUtf16Rune(br, false) },
+	"utf-16be":     func(br *bufio.Reader) (rune, int, error) { return decodeUtf16Rune(br, true) },
+	"windows-1252": decodeCp1252Rune,
+	"iso-8859-1": func(br *bufio.Reader) (rune, int, error) {
+		b, err := br.ReadByte()
+		return rune(b), 1, err
+	},
+}
+
+// decodeUtf16Rune decodes a character of UTF-16 text from br, which is big-endian when bigEndian is set.
+func decodeUtf16Rune(br *bufio.Reader, bigEndian bool) (rune, int, error) {
+	readUnit := func() (rune, error) {
+		var unit [2]byte
+		if _, err := io.ReadFull(br, unit[:]); err == io.ErrUnexpectedEOF {
+			return 0, fmt.Errorf("%w (truncated code unit)", errInvalidSequence)
+		} else if err != nil {
+			return 0, err
+		}
+		if bigEndian {
+			return rune(unit[0])<<8 | rune(unit[1]), nil
+		}
+		return rune(unit[1])<<8 | rune(unit[0]), nil
+	}
+
+	r, err := readUnit()
+	if err != nil {
+		return 0, 0, err
+	}
+	if r < 0xD800 || r > 0xDFFF {
+		return r, 2, nil
+	} else if r > 0xDBFF {
+		return 0, 0, fmt.Errorf("%w (unpaired low surrogate)", errInvalidSequence)
+	}
+	low, err := readUnit()
+	if err == io.EOF {
+		err = fmt.Errorf("%w (truncated surrogate pair)", errInvalidSequence)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if decoded := utf16.DecodeRune(r, low); decoded != utf8.RuneError {
+		return decoded, 4, nil
+	}
+	return 0, 0, fmt.Errorf("%w (unpaired high surrogate)", errInvalidSequence)
+}
+
+// decodeCp1252Rune decodes a character of Windows-1252 text from br.
+func decodeCp1252Rune(br *bufio.Reader) (rune, int, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	if b < 0x80 || b > 0x9F {
+		return rune(b), 1, nil
+	} else if r := cp1252High[b-0x80]; r != 0 {
+		return r, 1, nil
+	}
+	return 0, 0, fmt.Errorf("%w (0x%02X is undefined)", errInvalidSequence, b)
+}
+
+// decodeInput returns a reader of source, which is read from the input named name, transcoded to UTF-8 from
+// encoding. Inputs which begin with a UTF-16 BOM are decoded as UTF-16 unless another encoding is given, so that
+// Excel's "Unicode Text" exports need no flag. The BOM itself is transcoded, to be discarded like a UTF-8 BOM.
+// UTF-8 inputs are read unchanged without being validated.
+func decodeInput(source io.Reader, name, encoding string) io.Reader {
+	br := bufio.NewReader(source)
+	if encoding == "" || encoding == "utf-8" {
+		bom, _ := br.Peek(2)
+		if encoding = utf16Boms[string(bom)]; encoding == "" {
+			return br
+		}
+	}
+	return &decodingReader{br: br, name: name, encoding: encoding, decode: runeDecoders[encoding]}
+}
+
+// decodingReader transcodes text read from br to UTF-8, one character at a time as by decode. Once any byte
+// sequence cannot be decoded, every read returns a *decodeError.
+type decodingReader struct {
+	br       *bufio.Reader
+	name     string
+	encoding string
+	decode   func(br *bufio.Reader) (rune, int, error)
+	// offset is the number of bytes decoded from br
+	offset int64
+	// pending holds the rest of an encoded character which did not fit in the caller's buffer
+	pending []byte
+	err     error
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+
+	// Stop once nothing more is buffered, rather than blocking for input which may not yet be available
+	for n < len(p) && (n == 0 || d.br.Buffered() > 0) {
+		if d.err != nil {
+			return n, d.err
+		}
+		r, size, err := d.decode(d.br)
+		if errors.Is(err, errInvalidSequence) {
+			d.err = &decodeError{d.name, d.encoding, d.offset, err.Error()}
+			continue
+		} else if err != nil {
+			d.err = err
+			continue
+		}
+		d.offset += int64(size)
+
+		var encoded [utf8.UTFMax]byte
+		size = utf8.EncodeRune(encoded[:], r)
+		copied := copy(p[n:], encoded[:size])
+		d.pending = append(d.pending, encoded[copied:size]...)
+		n += copied
+	}
+	return n, nil
+}
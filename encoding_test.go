@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestNormalizeEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		want    string
+		wantErr string
+	}{
+		{"", "", ""},
+		{"UTF-16LE", "utf-16le", ""},
+		{"cp1252", "windows-1252", ""},
+		{"Latin1", "iso-8859-1", ""},
+		{"ebcdic", "", `unknown --encoding "ebcdic" (expected one of utf-8, utf-16le, utf-16be, windows-1252, ` +
+			`iso-8859-1)`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeEncoding(tt.name)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, exitUsage, exitCode(err))
+			}
+		})
+	}
+}
+
+func TestDecodeInput(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		input    string
+		encoding string
+		want     string
+		wantErr  string
+	}{
+		{"UTF-8 is unchanged", "caf\xc3\xa9\xff", "", "caf\xc3\xa9\xff", ""},
+		{"Detects a UTF-16LE BOM", "\xff\xfea\x00\xe9\x00", "", "\uFEFFaé", ""},
+		{"Detects a UTF-16BE BOM", "\xfe\xff\x00a\x00\xe9", "utf-8", "\uFEFFaé", ""},
+		{"Decodes UTF-16BE without a BOM", "\x00a\xd8\x3d\xde\x00", "utf-16be", "a😀", ""},
+		{"Decodes UTF-16LE without a BOM", "a\x00\x3d\xd8\x00\xde", "utf-16le", "a😀", ""},
+		{"Decodes Windows-1252", "\x80 \x93na\xefve\x94 \xff", "windows-1252", "€ “naïve” ÿ", ""},
+		{"Decodes ISO-8859-1", "\x80 na\xefve \xff", "iso-8859-1", "\u0080 naïve ÿ", ""},
+		{"An encoding overrides a UTF-16 BOM", "\xff\xfe", "iso-8859-1", "ÿþ", ""},
+		{"Rejects a truncated code unit", "a\x00b", "utf-16le", "a",
+			"decoding a.csv as utf-16le: invalid byte sequence (truncated code unit) at byte 2"},
+		{"Rejects an unpaired high surrogate", "\x00a\x00b\xd8\x3d\x00c", "utf-16be", "ab",
+			"decoding a.csv as utf-16be: invalid byte sequence (unpaired high surrogate) at byte 4"},
+		{"Rejects an unpaired low surrogate", "\x00a\xde\x00", "utf-16be", "a",
+			"decoding a.csv as utf-16be: invalid byte sequence (unpaired low surrogate) at byte 2"},
+		{"Rejects a truncated surrogate pair", "\x00a\xd8\x3d", "utf-16be", "a",
+			"decoding a.csv as utf-16be: invalid byte sequence (truncated surrogate pair) at byte 2"},
+		{"Rejects undefined Windows-1252 bytes", "ok\x81", "windows-1252", "ok",
+			"decoding a.csv as windows-1252: invalid byte sequence (0x81 is undefined) at byte 2"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			got, err := ioutil.ReadAll(decodeInput(strings.NewReader(tt.input), "a.csv", tt.encoding))
+			assertDecoded(t, tt.want, tt.wantErr, got, err)
+
+			got, err = ioutil.ReadAll(iotest.OneByteReader(decodeInput(iotest.OneByteReader(
+				strings.NewReader(tt.input)), "a.csv", tt.encoding)))
+			assertDecoded(t, tt.want, tt.wantErr, got, err)
+		})
+	}
+}
+
+// assertDecoded asserts that the text read by decodeInput() was want, followed by an error of wantErr, if any.
+func assertDecoded(t *testing.T, want, wantErr string, got []byte, err error) {
+	t.Helper()
+	if wantErr == "" {
+		assert.NoError(t, err)
+	} else {
+		assert.EqualError(t, err, wantErr)
+	}
+	assert.Equal(t, want, string(got))
+}
+
+func TestCsv2JsonEncodings(t *testing.T) {
+	for _, tt := range []struct {
+		fileName string
+		encoding string
+		wantJson string
+	}{
+		{"testdata/utf16le-bom.csv", "", `[{"name": "José", "city": "Zürich"}, {"name": "Renée", "city": "Besançon"}]`},
+		{"testdata/utf16be.csv", "utf-16be",
+			`[{"name": "José", "city": "Zürich"}, {"name": "Renée", "city": "Besançon"}]`},
+		{"testdata/cp1252.csv", "windows-1252", `[{"name": "José", "quote": "“Voilà” – naïve…"}]`},
+	} {
+		t.Run(tt.fileName, func(t *testing.T) {
+			f, err := os.Open(tt.fileName)
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				f.Close()
+			})
+
+			jsonStream := bytes.NewBuffer([]byte{})
+			err = csv2Json(conversionOptions{
+				csvInputs:  []io.Reader{f},
+				jsonOutput: jsonStream,
+				encoding:   tt.encoding,
+			})
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.wantJson, jsonStream.String())
+		})
+	}
+}
+
+func TestCsv2JsonInvalidEncoding(t *testing.T) {
+	err := csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader("a,b\n1,2\n3,\x9d\n")},
+		jsonOutput: ioutil.Discard,
+		encoding:   "windows-1252",
+		skipErrors: true,
+	})
+	assert.EqualError(t, err, "decoding input 1 as windows-1252: invalid byte sequence (0x9D is undefined) at byte 10")
+	assert.Equal(t, exitInvalidCsv, exitCode(err))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// rawFieldName is the only field of the record emitted for a row which failed when its error is handled by
+// errorRaw, holding the row's raw text.
+const rawFieldName = "_raw"
+
+// errorAction is what to do with a data row which failed to parse or convert, as decided by an errorHandler.
+type errorAction int
+
+const (
+	// errorAbort stops reading, returning the row's error
+	errorAbort errorAction = iota
+	// errorSkip skips the row, as --skip-errors does
+	errorSkip
+	// errorRaw emits a record with only a rawFieldName field, holding the row's raw text
+	errorRaw
+)
+
+// errorActionNames maps the names accepted by --on-error to each errorAction.
+var errorActionNames = map[string]errorAction{"abort": errorAbort, "skip": errorSkip, "raw": errorRaw}
+
+// failedRow describes a data row which failed to parse or convert, for an errorHandler.
+type failedRow struct {
+	// line is the line on which the row starts, and text is its raw text
+	line int
+	text string
+	// rowNum is the 1-based number of the row among all data rows
+	rowNum int
+	err    error
+}
+
+// errorHandler decides what to do with each data row which fails to parse or convert.
+type errorHandler func(row failedRow) errorAction
+
+// errTooManyErrors is matched by errors.Is() for the *tooManyErrorsError which aborts conversion once more rows have
+// been skipped than `conversionOptions.maxSkippedRows` allows.
+var errTooManyErrors = errors.New("too many rows were skipped")
+
+// tooManyErrorsError reports that more rows were skipped than limit allows, wrapping the error of the last of them.
+type tooManyErrorsError struct {
+	limit      int
+	numSkipped int
+	last       error
+}
+
+func (e *tooManyErrorsError) Error() string {
+	return fmt.Sprintf("skipped %d rows, more than the %d allowed by --max-errors; last error: %v",
+		e.numSkipped, e.limit, e.last)
+}
+
+func (e *tooManyErrorsError) Is(target error) bool {
+	return target == errTooManyErrors
+}
+
+func (e *tooManyErrorsError) Unwrap() error {
+	return e.last
+}
+
+// parseErrorPolicy returns an errorHandler which applies the actions given to --on-error as KIND=ACTION, where
+// KIND is one of skipErrorKinds and ACTION is a key of errorActionNames. Rows with errors of any other kind are
+// skipped when skipErrors is set, and otherwise abort. Returns nil when policies is empty.
+func parseErrorPolicy(policies []string, skipErrors bool) (errorHandler, error) {
+	if len(policies) == 0 {
+		return nil, nil
+	}
+	actions := make(map[string]errorAction, len(policies))
+	for _, policy := range policies {
+		parts := strings.SplitN(policy, "=", 2)
+		if len(parts) != 2 {
+			return nil, usageErrorf("invalid --on-error %q (expected KIND=ACTION)", policy)
+		}
+		kind, actionName := parts[0], parts[1]
+		if !isSkipErrorKind(kind) {
+			return nil, usageErrorf("unknown --on-error error kind %q (expected one of %s)",
+				kind, strings.Join(skipErrorKinds, ", "))
+		}
+		action, ok := errorActionNames[actionName]
+		if !ok {
+			return nil, usageErrorf("unknown --on-error action %q (expected abort, skip, or raw)", actionName)
+		}
+		actions[kind] = action
+	}
+
+	defaultAction := errorAbort
+	if skipErrors {
+		defaultAction = errorSkip
+	}
+	return func(row failedRow) errorAction {
+		if action, ok := actions[skipErrorKind(row.err)]; ok {
+			return action
+		}
+		return defaultAction
+	}, nil
+}
+
+// emitsRawRows reports whether any of the --on-error policies, as accepted by parseErrorPolicy(), emits rows as raw
+// text.
+func emitsRawRows(policies []string) bool {
+	for _, policy := range policies {
+		if strings.HasSuffix(policy, "=raw") {
+			return true
+		}
+	}
+	return false
+}
+
+// isSkipErrorKind reports whether kind is one of skipErrorKinds.
+func isSkipErrorKind(kind string) bool {
+	for _, k := range skipErrorKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
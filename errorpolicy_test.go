@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCsv2JsonErrorHandler(t *testing.T) {
+	discardLogs(t)
+	csvData := "a,b\n1,2\n3,4,5\n6,7\n8,\"9\"x\n"
+
+	for _, tt := range []struct {
+		testName      string
+		fieldCount    errorAction
+		quote         errorAction
+		wantJson      string
+		wantErr       error
+		wantNumFailed int
+	}{
+		{"Skips", errorSkip, errorSkip, `[{"a": "1", "b": "2"}, {"a": "6", "b": "7"}]`, nil, 2},
+		{"Emits raw text", errorRaw, errorRaw,
+			`[{"a": "1", "b": "2"}, {"_raw": "3,4,5"}, {"a": "6", "b": "7"}, {"_raw": "8,\"9\"x"}]`, nil, 2},
+		{"Aborts on quote errors only", errorSkip, errorAbort, "", csv.ErrQuote, 2},
+		{"Aborts at the first error", errorAbort, errorSkip, "", csv.ErrFieldCount, 1},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var failed []failedRow
+			var buf bytes.Buffer
+			err := csv2Json(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(csvData)},
+				jsonOutput: &buf,
+				errorHandler: func(row failedRow) errorAction {
+					failed = append(failed, row)
+					if errors.Is(row.err, csv.ErrFieldCount) {
+						return tt.fieldCount
+					}
+					return tt.quote
+				},
+			})
+
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "Unexpected error %v", err)
+			} else {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, buf.String())
+			}
+			require.Len(t, failed, tt.wantNumFailed)
+			assert.Equal(t, failedRow{line: 3, text: "3,4,5", rowNum: 2, err: failed[0].err}, failed[0])
+			if len(failed) > 1 {
+				assert.Equal(t, failedRow{line: 5, text: "8,\"9\"x", rowNum: 4, err: failed[1].err}, failed[1])
+			}
+		})
+	}
+}
+
+func TestParseErrorPolicy(t *testing.T) {
+	fieldCountRow := failedRow{err: &csv.ParseError{Err: csv.ErrFieldCount}}
+	quoteRow := failedRow{err: &csv.ParseError{Err: csv.ErrQuote}}
+
+	handler, err := parseErrorPolicy([]string{"field_count=skip", "quote=raw"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, errorSkip, handler(fieldCountRow))
+	assert.Equal(t, errorRaw, handler(quoteRow))
+	assert.Equal(t, errorAbort, handler(failedRow{err: &rowError{1, errors.New("other")}}),
+		"Other kinds should abort without --skip-errors")
+
+	handler, err = parseErrorPolicy([]string{"field_count=abort"}, true)
+	require.NoError(t, err)
+	assert.Equal(t, errorAbort, handler(fieldCountRow))
+	assert.Equal(t, errorSkip, handler(quoteRow), "Other kinds should be skipped with --skip-errors")
+
+	handler, err = parseErrorPolicy(nil, true)
+	assert.NoError(t, err)
+	assert.Nil(t, handler, "Without policies, --skip-errors applies as usual")
+
+	for _, policies := range [][]string{{"field_count"}, {"quotes=skip"}, {"quote=retry"}} {
+		_, err := parseErrorPolicy(policies, false)
+		assert.Equal(t, exitUsage, exitCode(err), "Policies %v should be invalid", policies)
+	}
+}
+
+func TestCsv2JsonMaxSkippedRows(t *testing.T) {
+	discardLogs(t)
+
+	err := csv2Json(conversionOptions{
+		csvInputs:      []io.Reader{strings.NewReader("a,b\n1,2\n3\n4,5\n6\n")},
+		jsonOutput:     ioutil.Discard,
+		skipErrors:     true,
+		maxSkippedRows: 2,
+	})
+	assert.NoError(t, err, "Skipping up to the limit should not abort")
+
+	csvData := bytes.NewBufferString("a,b\n1,2\n")
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(csvData, "%d\n", i)
+	}
+	var count int64
+	err = csv2Json(conversionOptions{
+		csvInputs:      []io.Reader{readCounter{csvData, &count}},
+		jsonOutput:     ioutil.Discard,
+		skipErrors:     true,
+		maxSkippedRows: 2,
+	})
+	assert.EqualError(t, err, "skipped 3 rows, more than the 2 allowed by --max-errors; last error: record on line 5: "+
+		"wrong number of fields")
+	assert.True(t, errors.Is(err, errTooManyErrors))
+	assert.True(t, errors.Is(err, csv.ErrFieldCount), "The last error should be wrapped")
+	assert.Equal(t, exitInvalidCsv, exitCode(err))
+	assert.Less(t, count, int64(64*1024), "Only the start of the input should be read")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// esBulkOptions is used to configure emitting records as Elasticsearch bulk API requests when calling csv2EsBulk().
+type esBulkOptions struct {
+	index string
+	// idColumn names the column whose values are the document ids. Without it, Elasticsearch generates ids.
+	idColumn string
+}
+
+// esBulkAction is the action line which precedes each document in a bulk API request.
+type esBulkAction struct {
+	Index esBulkIndex `json:"index"`
+}
+
+type esBulkIndex struct {
+	Index string `json:"_index"`
+	ID    string `json:"_id,omitempty"`
+}
+
+// csv2EsBulk converts CSV data from `options.csvInputs` to the newline-delimited body of an Elasticsearch bulk API
+// request, emitted to `options.jsonOutput`, which indexes each record as a document in `esOpts.index`.
+// Each record is preceded by an action line, and every line ends with a newline, as the bulk API requires.
+// When `options.batchSize` is set, records are instead emitted as they are converted, as separate bodies of up to
+// `options.batchSize` records which are separated by a blank line, and `options.flushEvery` applies.
+// Returns any errors from reading CSV, forming document ids, or encoding JSON.
+func csv2EsBulk(options conversionOptions, esOpts esBulkOptions) error {
+	if esOpts.index == "" {
+		return usageErrorf("--format es-bulk requires --es-index")
+	}
+
+	numBatches, unflushed := 0, 0
+	return eachBatch(options, options.batchSize, func(batch []record) error {
+		numBatches++
+		if numBatches > 1 {
+			if _, err := io.WriteString(options.jsonOutput, "\n"); err != nil {
+				return &outputError{err}
+			}
+		}
+		if err := writeEsBulk(options.jsonOutput, batch, esOpts, options.fileMeta); err != nil {
+			return err
+		}
+
+		unflushed += len(batch)
+		if f, ok := options.jsonOutput.(interface{ Flush() error }); ok && options.batchSize > 0 &&
+			options.flushEvery > 0 && unflushed >= options.flushEvery {
+			unflushed = 0
+			if err := f.Flush(); err != nil {
+				return &outputError{err}
+			}
+		}
+		return nil
+	})
+}
+
+// writeEsBulk writes an action line and a source line for each of records to w, with the fields added for the
+// fileMeta attributes written as by fileMetaRecord().
+func writeEsBulk(w io.Writer, records []record, esOpts esBulkOptions, fileMeta []string) error {
+	enc := json.NewEncoder(w)
+	for _, rec := range records {
+		action := esBulkAction{esBulkIndex{Index: esOpts.index}}
+		if esOpts.idColumn != "" {
+			id, ok := rec[esOpts.idColumn]
+			if !ok {
+				return usageErrorf("unknown --es-id-column %q", esOpts.idColumn)
+			} else if id == "" {
+				// Elasticsearch rejects empty ids rather than generating one
+				return &keyError{id, fmt.Sprintf("value of --es-id-column %q is empty", esOpts.idColumn)}
+			}
+			action.Index.ID = id
+		}
+		if err := enc.Encode(action); err != nil {
+			return &outputError{err}
+		}
+		if err := enc.Encode(fileMetaRecord(rec, fileMeta)); err != nil {
+			return &outputError{err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCsv2EsBulk(t *testing.T) {
+	csvData := "id,name\n1,Alice\n2,Bob\n3,Carol\n"
+
+	for _, tt := range []struct {
+		testName   string
+		idColumn   string
+		batchSize  int
+		csvData    string
+		wantOutput string
+		wantErr    string
+	}{
+		{"Ids from column", "id", 0, csvData, `{"index":{"_index":"people","_id":"1"}}
+{"id":"1","name":"Alice"}
+{"index":{"_index":"people","_id":"2"}}
+{"id":"2","name":"Bob"}
+{"index":{"_index":"people","_id":"3"}}
+{"id":"3","name":"Carol"}
+`, ""},
+		{"Generated ids", "", 0, "id,name\n1,Alice\n", `{"index":{"_index":"people"}}
+{"id":"1","name":"Alice"}
+`, ""},
+		{"Batches are separate bodies", "id", 2, csvData, `{"index":{"_index":"people","_id":"1"}}
+{"id":"1","name":"Alice"}
+{"index":{"_index":"people","_id":"2"}}
+{"id":"2","name":"Bob"}
+
+{"index":{"_index":"people","_id":"3"}}
+{"id":"3","name":"Carol"}
+`, ""},
+		{"Empty id", "id", 0, "id,name\n,Alice\n", "", `key "": value of --es-id-column "id" is empty`},
+		{"Unknown id column", "key", 0, csvData, "", `unknown --es-id-column "key"`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := csv2EsBulk(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(tt.csvData)},
+				jsonOutput: &buf,
+				batchSize:  tt.batchSize,
+			}, esBulkOptions{index: "people", idColumn: tt.idColumn})
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantOutput, buf.String())
+			assert.True(t, strings.HasSuffix(buf.String(), "}\n"), "Bulk bodies must end with a newline")
+		})
+	}
+
+	err := csv2EsBulk(conversionOptions{csvInputs: []io.Reader{strings.NewReader(csvData)}}, esBulkOptions{})
+	assert.Equal(t, exitUsage, exitCode(err), "An index should be required")
+}
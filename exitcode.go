@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Exit codes for each class of failure, as documented in README.md. flaggy also exits with exitUsage when
+// arguments cannot be parsed.
+const (
+	exitOK             = 0
+	exitFailure        = 1
+	exitUsage          = 2
+	exitInputNotFound  = 3
+	exitInvalidCsv     = 4
+	exitOutputFailure  = 5
+	exitTimeout        = 6
+	exitPartialSuccess = 7
+	exitDifferences    = 8
+)
+
+// usageError reports options which are invalid or cannot be used together.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *usageError) Unwrap() error {
+	return e.err
+}
+
+// usageErrorf formats a *usageError like fmt.Errorf.
+func usageErrorf(format string, a ...interface{}) error {
+	return &usageError{fmt.Errorf(format, a...)}
+}
+
+// outputError reports a failure to write or deliver converted records.
+type outputError struct {
+	err error
+}
+
+func (e *outputError) Error() string {
+	return e.err.Error()
+}
+
+func (e *outputError) Unwrap() error {
+	return e.err
+}
+
+// skippedRowsError reports that conversion otherwise succeeded, but some rows were skipped due to errors.
+// Unlike other errors, it is returned by value so that errors.Is() matches equal counts.
+type skippedRowsError struct {
+	numSkipped int
+}
+
+func (e skippedRowsError) Error() string {
+	return fmt.Sprintf("%d rows were skipped", e.numSkipped)
+}
+
+// exitCode returns the exit code for the class of err, which is exitOK when err is nil.
+func exitCode(err error) int {
+	var (
+		skipped   skippedRowsError
+		differ    differencesError
+		usage     *usageError
+		timeout   interface{ Timeout() bool }
+		output    *outputError
+		parse     *csv.ParseError
+		strict    *rfc4180Error
+		mismatch  *headerMismatchError
+		notCsv    *notCsvError
+		notJson   *invalidJsonError
+		corrupt   *decompressError
+		undecoded *decodeError
+		badHeader *invalidHeaderError
+		noName    *emptyHeadersError
+		badRowErr *rowError
+		badKey    *keyError
+		invalid   *invalidRowError
+	)
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &skipped):
+		return exitPartialSuccess
+	case errors.As(err, &differ):
+		return exitDifferences
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.Is(err, os.ErrNotExist):
+		return exitInputNotFound
+	case errors.As(err, &timeout) && timeout.Timeout():
+		return exitTimeout
+	case errors.As(err, &output):
+		return exitOutputFailure
+	case errors.As(err, &parse), errors.As(err, &strict), errors.As(err, &mismatch), errors.As(err, &notCsv),
+		errors.As(err, &badHeader), errors.Is(err, errNoRows), errors.As(err, &badRowErr),
+		errors.As(err, &badKey), errors.As(err, &invalid), errors.As(err, &notJson),
+		errors.As(err, &corrupt), errors.As(err, &undecoded), errors.As(err, &noName):
+		return exitInvalidCsv
+	}
+	return exitFailure
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	_, notFoundErr := os.Open("does-not-exist.csv")
+
+	for _, tt := range []struct {
+		testName string
+		err      error
+		wantCode int
+	}{
+		{"Success", nil, exitOK},
+		{"Rows skipped", skippedRowsError{2}, exitPartialSuccess},
+		{"Differences found", differencesError{3}, exitDifferences},
+		{"Invalid option", usageErrorf("unknown group-by column %q", "x"), exitUsage},
+		{"Missing input file", notFoundErr, exitInputNotFound},
+		{"CSV parse error", &csv.ParseError{Line: 2, Column: 1, Err: csv.ErrFieldCount}, exitInvalidCsv},
+		{"RFC 4180 violation", &rfc4180Error{2, 1, "bare \" in unquoted field"}, exitInvalidCsv},
+		{"Header mismatch", &headerMismatchError{"b.csv", []string{"a"}, []string{"b"}}, exitInvalidCsv},
+		{"Invalid header", &invalidHeaderError{"a.csv", 2, "empty column name"}, exitInvalidCsv},
+		{"No data rows", errNoRows, exitInvalidCsv},
+		{"Not CSV", &notCsvError{"a.pdf", "a PDF document", "extract its tables as CSV first"}, exitInvalidCsv},
+		{"Not JSON objects", &invalidJsonError{"a.json", errors.New("value 1 is not a JSON object")}, exitInvalidCsv},
+		{"Corrupt gzip data", &decompressError{"a.csv.gz", gzip.ErrChecksum}, exitInvalidCsv},
+		{"Unprocessable row", &rowError{3, errors.New("sum() requires a numeric value")}, exitInvalidCsv},
+		{"Too many skipped rows", &tooManyErrorsError{1, 2, &csv.ParseError{Line: 3, Column: 1, Err: csv.ErrFieldCount}},
+			exitInvalidCsv},
+		{"Output failure", &outputError{os.ErrClosed}, exitOutputFailure},
+		{
+			"Timeout",
+			&outputError{fmt.Errorf("batch 1 could not be sent: %w", &url.Error{Op: "Post", URL: "http://example.com",
+				Err: context.DeadlineExceeded})},
+			exitTimeout,
+		},
+		{"Other failure", errors.New("something else"), exitFailure},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			assert.Equal(t, tt.wantCode, exitCode(tt.err))
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// Sources from which the effective value of an option can come, as reported by --explain.
+const (
+	sourceDefault = "default"
+	sourceFlag    = "flag"
+	sourceDialect = "dialect"
+	sourceHeader  = "header"
+	sourceBundle  = "bundle"
+	sourceProfile = "profile"
+)
+
+// explainedOption is the effective value of an option along with where it came from.
+type explainedOption struct {
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+// explanation describes the effective options for a conversion, keyed by option name.
+type explanation map[string]explainedOption
+
+// explainFlag records value as the effective value of the option named name, which came from a flag if any of
+// flagNames were given explicitly, from a profile if any were set by one, or is otherwise the default.
+func (e explanation) explainFlag(name string, value interface{}, given map[string]bool, flagNames ...string) {
+	source := sourceDefault
+	for _, flagName := range flagNames {
+		if given[flagName] && !given[profileFlagKey(flagName)] {
+			source = sourceFlag
+		} else if given[flagName] && source == sourceDefault {
+			source = sourceProfile
+		}
+	}
+	e[name] = explainedOption{value, source}
+}
+
+// explainConversion describes the effective settings of options, which were resolved from the given flags
+// and the dialect preset named by dialectName. Policies which were not given are described by those which apply
+// instead, as by withDefaultPolicies().
+func explainConversion(options conversionOptions, dialectName string, given map[string]bool) explanation {
+	options = withDefaultPolicies(options)
+	e := make(explanation)
+
+	if len(options.colNames) > 0 {
+		e["columns"] = explainedOption{options.colNames, sourceFlag}
+	} else {
+		e["columns"] = explainedOption{"first line of CSV data", sourceHeader}
+	}
+	e.explainFlag("rename", formatRenames(options.renames), given, "rename")
+	e.explainFlag("select", strings.Join(options.selectColumns, ","), given, "select")
+	e.explainFlag("exclude", strings.Join(options.excludeColumns, ","), given, "exclude")
+	e.explainFlag("skip_errors", options.skipErrors, given, "s", "skip-errors")
+	e.explainFlag("batch", options.batchSize, given, "batch")
+	e.explainFlag("exec_filter", options.execFilter, given, "exec-filter")
+	e.explainFlag("file_meta", strings.Join(options.fileMeta, ","), given, "file-meta")
+	e.explainFlag("row_number_field", options.rowNumberField, given, "row-number", "row-number-field")
+	e.explainFlag("line_number", options.lineNumber, given, "line-number")
+	e.explainFlag("pluck", options.pluck, given, "pluck")
+	e.explainFlag("indent", options.indent, given, "pretty", "indent")
+	e.explainFlag("infer_types", options.inferTypes, given, "infer-types")
+	e.explainFlag("types", formatColumnTypes(options.columnTypes), given, "types")
+	e.explainFlag("null_values", append([]string{}, options.nullValues...), given, "empty-as-null", "null-value")
+	e.explainFlag("nest_separator", options.nestSeparator, given, "nested", "nest-separator")
+	e.explainFlag("max_cell_length", options.maxCellLength, given, "max-cell-length")
+	e.explainFlag("rfc4180_strict", options.rfc4180Strict, given, "rfc4180-strict")
+	e.explainFlag("compression", options.compression, given, "compression")
+	e.explainFlag("encoding", options.encoding, given, "encoding")
+	e.explainFlag("trim_headers", options.trimHeaders, given, "trim", "trim-headers")
+	e.explainFlag("key_case", options.keyCase, given, "key-case")
+	e.explainFlag("strict_headers", options.strictHeaders, given, "strict-headers")
+	e.explainFlag("fail_if_empty", options.failIfEmpty, given, "fail-if-empty")
+	e.explainFlag("progress", options.progress, given, "progress", "vv", "debug")
+	e.explainFlag("progress_rows", options.progressRows, given, "progress-rows")
+	e.explainFlag("progress_interval", options.progressInterval.String(), given, "progress-interval")
+	e.explainFlag("offset", options.offset, given, "offset")
+	e.explainFlag("limit", options.limit, given, "limit")
+	e.explainFlag("reject_invalid_utf8", options.rejectInvalidUtf8, given, "reject-invalid-utf8")
+	e.explainFlag("fill_empty_headers", options.fillEmptyHeaders, given, "fill-empty-headers")
+	e.explainFlag("dedupe_headers", options.dedupeHeaders, given, "dedupe-headers")
+	e.explainFlag("dup_headers", options.dupHeaders, given, "dup-headers", "dedupe-headers", "strict-headers")
+	e.explainFlag("header_mismatch", options.headerMismatch, given, "header-mismatch")
+	e.explainFlag("skip_rows", options.skipRows, given, "skip-rows")
+	e.explainFlag("header_rows", options.headerRows, given, "header-rows")
+	e.explainFlag("header_join", options.headerJoin, given, "header-join")
+	e.explainFlag("pad_short_rows", options.padShortRows, given, "pad-short-rows")
+	e.explainFlag("keep_extra_fields", options.keepExtraFields, given, "keep-extra-fields")
+	extraFields := options.extraFields
+	if extraFields == "" && !options.keepExtraFields {
+		// Rows with extra fields are errors unless kept, as by --keep-extra-fields
+		extraFields = "error"
+	}
+	e.explainFlag("extra_fields", extraFields, given, "extra-fields")
+	e.explainFlag("extra_field_name", options.extraFieldName, given, "extra-field-name")
+	e.explainFlag("keep_empty_rows", options.emptyRows, given, "keep-empty-rows", "skip-blank-records")
+	e.explainFlag("skip_blank_records", options.skipBlankRecords, given, "skip-blank-records")
+	e.explainFlag("base64_columns", strings.Join(options.base64Columns, ","), given, "base64-columns")
+	e.explainFlag("base64_binary", options.base64Binary, given, "base64-binary")
+	e.explainFlag("url_decode_columns", strings.Join(options.urlDecodeColumns, ","), given, "url-decode-columns")
+	e.explainFlag("url_decode_mode", options.urlDecodeMode, given, "url-decode-mode")
+	e.explainFlag("log_format", logFormat, given, "log-format")
+
+	e.explainFlag("dialect", dialectName, given, "dialect")
+	e.explainFlag("sniff_delimiter", options.sniffDelimiter, given, "sniff-delimiter")
+	comment := ""
+	if options.dialect.comment != 0 {
+		comment = string(options.dialect.comment)
+	}
+	for _, setting := range []struct {
+		name     string
+		flagName string
+		value    interface{}
+	}{
+		{"delimiter", "delimiter", string(options.dialect.delimiter)},
+		{"lazy_quotes", "lazy-quotes", options.dialect.lazyQuotes},
+		{"trim_leading_space", "trim-leading-space", options.dialect.trimLeadingSpace},
+		{"comment", "comment", comment},
+	} {
+		e.explainFlag(setting.name, setting.value, given, setting.flagName)
+		if dialectName != "" && !given[setting.flagName] {
+			e[setting.name] = explainedOption{setting.value, sourceDialect}
+		}
+	}
+
+	return e
+}
+
+// writeExplanation writes e as an indented JSON object.
+func writeExplanation(w io.Writer, e explanation) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(e)
+}
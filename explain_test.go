@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/integrii/flaggy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestExplainConversion(t *testing.T) {
+	for _, tt := range []struct {
+		testName    string
+		options     conversionOptions
+		dialectName string
+		given       []string
+		option      string
+		want        explainedOption
+	}{
+		{"Default value", conversionOptions{dialect: defaultDialect}, "", nil, "delimiter", explainedOption{",", sourceDefault}},
+		{
+			"Value from dialect preset",
+			conversionOptions{dialect: dialects["postgres"]},
+			"postgres",
+			[]string{"dialect"},
+			"lazy_quotes",
+			explainedOption{true, sourceDialect},
+		},
+		{
+			"Flag takes precedence over dialect preset",
+			conversionOptions{dialect: csvDialect{delimiter: ';', lazyQuotes: true}},
+			"postgres",
+			[]string{"dialect", "delimiter"},
+			"delimiter",
+			explainedOption{";", sourceFlag},
+		},
+		{
+			"Flag given by short name",
+			conversionOptions{skipErrors: true},
+			"",
+			[]string{"s"},
+			"skip_errors",
+			explainedOption{true, sourceFlag},
+		},
+		{
+			"Implied policy",
+			conversionOptions{strictHeaders: true},
+			"",
+			[]string{"strict-headers"},
+			"dup_headers",
+			explainedOption{"error", sourceFlag},
+		},
+		{
+			"Default policy",
+			conversionOptions{},
+			"",
+			nil,
+			"keep_empty_rows",
+			explainedOption{defaultEmptyRowPolicy, sourceDefault},
+		},
+		{
+			"Columns from header",
+			conversionOptions{},
+			"",
+			nil,
+			"columns",
+			explainedOption{"first line of CSV data", sourceHeader},
+		},
+		{
+			"Forced columns",
+			conversionOptions{colNames: []string{"a", "b"}},
+			"",
+			[]string{"c"},
+			"columns",
+			explainedOption{[]string{"a", "b"}, sourceFlag},
+		},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			given := make(map[string]bool)
+			for _, name := range tt.given {
+				given[name] = true
+			}
+
+			e := explainConversion(tt.options, tt.dialectName, given)
+
+			assert.Equal(t, tt.want, e[tt.option])
+		})
+	}
+}
+
+func TestCliExplainResolvedOptions(t *testing.T) {
+	os.Args = []string{"csv2json", "--explain-only", "--pretty", "--rename", "a=b", "--types", "n:int",
+		"--empty-as-null", "--nested", os.DevNull}
+	flaggy.ResetParser()
+	oldStderr := os.Stderr
+	stderrReader, stderrWriter, _ := os.Pipe()
+	os.Stderr = stderrWriter
+	err := runCli()
+	stderrWriter.Close()
+	os.Stderr = oldStderr
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	io.Copy(&buf, stderrReader)
+	var e map[string]explainedOption
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &e))
+	for option, want := range map[string]explainedOption{
+		"indent":           {float64(2), sourceFlag},
+		"rename":           {"a=b", sourceFlag},
+		"types":            {"n:int", sourceFlag},
+		"null_values":      {[]interface{}{""}, sourceFlag},
+		"nest_separator":   {".", sourceFlag},
+		"debug_row_length": {float64(200), sourceDefault},
+		"dup_headers":      {defaultDupHeaderPolicy, sourceDefault},
+	} {
+		assert.Equal(t, want, e[option], "Unexpected %s", option)
+	}
+}
+
+func TestWriteExplanation(t *testing.T) {
+	out := bytes.NewBuffer([]byte{})
+
+	err := writeExplanation(out, explanation{"delimiter": {"\t", sourceDialect}, "batch": {0, sourceDefault}})
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"batch": {"value": 0, "source": "default"},
+		"delimiter": {"value": "\t", "source": "dialect"}
+	}`, out.String())
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileMetaFields maps each name accepted by --file-meta to the function describing that attribute of an input file.
+var fileMetaFields = map[string]func(info os.FileInfo) string{
+	"mtime": func(info os.FileInfo) string { return info.ModTime().UTC().Format(time.RFC3339) },
+	"size":  func(info os.FileInfo) string { return strconv.FormatInt(info.Size(), 10) },
+}
+
+// fileMetaFieldName returns the name of the field to which --file-meta adds the named attribute of each input file.
+func fileMetaFieldName(name string) string {
+	return "_file_" + name
+}
+
+// checkFileMeta returns a *usageError when any of names is not a key of fileMetaFields, or when the field it adds
+// would have the same name as one of colNames.
+func checkFileMeta(names, colNames []string) error {
+	for _, name := range names {
+		if _, ok := fileMetaFields[name]; !ok {
+			return usageErrorf("unknown --file-meta attribute %q (expected %s)", name, strings.Join(fileMetaNames(), ", "))
+		}
+		for _, colName := range colNames {
+			if colName == fileMetaFieldName(name) {
+				return usageErrorf("--file-meta %s cannot add %s, which is already a column", name, colName)
+			}
+		}
+	}
+	return nil
+}
+
+// fileMetaNames returns the keys of fileMetaFields, sorted.
+func fileMetaNames() []string {
+	names := make([]string, 0, len(fileMetaFields))
+	for name := range fileMetaFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fileMetaValue returns the value of the named attribute as it is emitted in JSON: null when it is empty, as it is
+// for stdin and inputs which are not files, a number for sizes, and otherwise the value itself.
+func fileMetaValue(name, value string) interface{} {
+	if value == "" {
+		return nil
+	} else if name == "size" {
+		return json.Number(value)
+	}
+	return value
+}
+
+// typedFileMeta returns fields with the values of the fields added for the named attributes replaced as by
+// fileMetaValue(), modifying fields in place.
+func typedFileMeta(fields map[string]interface{}, names []string) map[string]interface{} {
+	for _, name := range names {
+		field := fileMetaFieldName(name)
+		if value, ok := fields[field].(string); ok {
+			fields[field] = fileMetaValue(name, value)
+		}
+	}
+	return fields
+}
+
+// fileMetaRecord returns rec as it is emitted in JSON, with the values of the fields added for the named attributes as
+// by fileMetaValue(), or rec itself when there are none.
+func fileMetaRecord(rec record, names []string) interface{} {
+	if len(names) == 0 {
+		return rec
+	}
+	fields := make(map[string]interface{}, len(rec))
+	for k, v := range rec {
+		fields[k] = v
+	}
+	return typedFileMeta(fields, names)
+}
+
+// fileMetaRecords returns records as they are emitted in JSON, as by fileMetaRecord().
+func fileMetaRecords(records []record, names []string) interface{} {
+	if len(names) == 0 {
+		return records
+	}
+	values := make([]interface{}, len(records))
+	for i, rec := range records {
+		values[i] = fileMetaRecord(rec, names)
+	}
+	return values
+}
+
+// fileMeta returns the named attributes of input, which are read with a single Stat() call. Every attribute is empty
+// for stdin and for inputs which are not files.
+func fileMeta(input io.Reader, names []string) ([]string, error) {
+	values := make([]string, len(names))
+	f, ok := input.(interface {
+		Name() string
+		Stat() (os.FileInfo, error)
+	})
+	if !ok || input == io.Reader(os.Stdin) {
+		return values, nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("reading file attributes of %s: %w", f.Name(), err)
+	}
+	for i, name := range names {
+		values[i] = fileMetaFields[name](info)
+	}
+	return values, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCsv2JsonFileMeta(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "people.csv")
+	require.NoError(t, ioutil.WriteFile(fileName, []byte("name\nAlice\n"), 0644))
+	mtime := time.Date(2024, 6, 1, 12, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	require.NoError(t, os.Chtimes(fileName, mtime, mtime))
+	f, err := os.Open(fileName)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	var buf bytes.Buffer
+	err = csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{f, strings.NewReader("name\nBob\n")},
+		jsonOutput: &buf,
+		fileMeta:   []string{"mtime", "size"},
+	})
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"name": "Alice", "_file_mtime": "2024-06-01T10:30:00Z", "_file_size": 11},
+		{"name": "Bob", "_file_mtime": null, "_file_size": null}
+	]`, buf.String(), "Attributes of inputs which are not files should be null")
+}
+
+func TestFileMetaRecord(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		rec      record
+		names    []string
+		want     string
+	}{
+		{"File", record{"a": "1", "_file_mtime": "2024-06-01T10:30:00Z", "_file_size": "11"},
+			[]string{"mtime", "size"}, `{"a": "1", "_file_mtime": "2024-06-01T10:30:00Z", "_file_size": 11}`},
+		{"Stdin", record{"a": "", "_file_mtime": "", "_file_size": ""}, []string{"mtime", "size"},
+			`{"a": "", "_file_mtime": null, "_file_size": null}`},
+		{"Only the named attributes", record{"a": "", "_file_size": ""}, []string{"mtime"},
+			`{"a": "", "_file_size": ""}`},
+		{"No attributes", record{"a": "1"}, nil, `{"a": "1"}`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			data, err := json.Marshal(fileMetaRecord(tt.rec, tt.names))
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(data))
+		})
+	}
+}
+
+func TestCsv2JsonFileMetaOfStdin(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		options  conversionOptions
+		wantJson string
+	}{
+		{"NDJSON", conversionOptions{ndjson: true}, `{"name":"Bob","_file_mtime":null,"_file_size":null}` + "\n"},
+		{"Plucked", conversionOptions{pluck: "_file_size"}, "[null]"},
+		{"Typed", conversionOptions{inferTypes: true}, `[{"name":"Bob","_file_mtime":null,"_file_size":null}]`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.options.jsonOutput = &buf
+			tt.options.fileMeta = []string{"mtime", "size"}
+			oldStdin := os.Stdin
+			stdinReader, stdinWriter, err := os.Pipe()
+			require.NoError(t, err)
+			os.Stdin = stdinReader
+			t.Cleanup(func() { os.Stdin = oldStdin })
+			tt.options.csvInputs = []io.Reader{os.Stdin}
+			stdinWriter.Write([]byte("name\nBob\n"))
+			stdinWriter.Close()
+
+			require.NoError(t, csv2Json(tt.options))
+			assert.JSONEq(t, tt.wantJson, strings.TrimSpace(buf.String()))
+		})
+	}
+}
+
+func TestCheckFileMeta(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		names    []string
+		colNames []string
+		wantErr  string
+	}{
+		{"Known attributes", []string{"size", "mtime"}, []string{"name"}, ""},
+		{"Unknown attribute", []string{"owner"}, []string{"name"},
+			`unknown --file-meta attribute "owner" (expected mtime, size)`},
+		{"Collision", []string{"size"}, []string{"name", "_file_size"},
+			"--file-meta size cannot add _file_size, which is already a column"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			err := checkFileMeta(tt.names, tt.colNames)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, exitUsage, exitCode(err))
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// filterSeqField is added to each record written to an exec filter, numbering records from 1. The filter must keep
+// it in each record it writes back, so that records it drops can be detected without losing their order.
+const filterSeqField = "_seq"
+
+// filterError reports that an exec filter failed or wrote output which is not a valid record.
+type filterError struct {
+	command string
+	err     error
+}
+
+func (e *filterError) Error() string {
+	return fmt.Sprintf("exec filter %q: %v", e.command, e.err)
+}
+
+func (e *filterError) Unwrap() error {
+	return e.err
+}
+
+// filterRecords runs command with sh once, calling produce with a function that writes each record it is given to
+// the command's stdin as a line of JSON, numbered by filterSeqField. Each line the command writes to stdout is decoded
+// as a record, which is passed to emit after removing filterSeqField. Records which the command does not write back
+// are dropped, but those it does write back must still be in order. The command's stderr is passed through.
+// The command is killed if produce or emit fails, and otherwise must exit successfully after stdin is closed.
+func filterRecords(command string, produce func(send func(rec record) error) error,
+	emit func(rec record) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return &filterError{command, err}
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return &filterError{command, err}
+	}
+	if err := cmd.Start(); err != nil {
+		return &filterError{command, err}
+	}
+
+	// Records are produced concurrently with reading them back, since the command may not read all of its input
+	// before writing output
+	produced := make(chan error, 1)
+	go func() {
+		w := bufio.NewWriter(stdin)
+		enc := json.NewEncoder(w)
+		seq := 0
+		err := produce(func(rec record) error {
+			seq++
+			if _, ok := rec[filterSeqField]; ok {
+				return usageErrorf("records cannot be filtered when they have a %s field", filterSeqField)
+			}
+			numbered := make(map[string]interface{}, len(rec)+1)
+			for k, v := range rec {
+				numbered[k] = v
+			}
+			numbered[filterSeqField] = seq
+			if err := enc.Encode(numbered); err != nil {
+				return &filterError{command, err}
+			}
+			return nil
+		})
+		if flushErr := w.Flush(); err == nil && flushErr != nil {
+			err = &filterError{command, flushErr}
+		}
+		stdin.Close()
+		produced <- err
+	}()
+
+	readErr := readFilterOutput(command, stdout, emit)
+	if readErr != nil {
+		// Killing the command also stops producing records, since they can no longer be written
+		cancel()
+	}
+	produceErr := <-produced
+	_, writeFailed := produceErr.(*filterError)
+	if produceErr != nil && !writeFailed {
+		cancel()
+	}
+	waitErr := cmd.Wait()
+
+	switch {
+	case readErr != nil:
+		return readErr
+	case produceErr != nil && !writeFailed:
+		return produceErr
+	case waitErr != nil:
+		// Writing records fails whenever the command exits early, which is better explained by how it exited
+		return &filterError{command, waitErr}
+	}
+	return produceErr
+}
+
+// readFilterOutput decodes each line of JSON read from r as a record written back by the exec filter command, calling
+// emit with each one after removing its filterSeqField. Returns any error from emit, or else a *filterError
+// describing the first line which is not an object of strings numbered after the previous line.
+func readFilterOutput(command string, r io.Reader, emit func(rec record) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	lastSeq := 0
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			return &filterError{command, fmt.Errorf("output line %d is not a JSON object: %v", lineNum, err)}
+		}
+
+		var seq int
+		if err := json.Unmarshal(fields[filterSeqField], &seq); err != nil || seq <= lastSeq {
+			return &filterError{command, fmt.Errorf("output line %d must keep a %s field numbered after %d",
+				lineNum, filterSeqField, lastSeq)}
+		}
+		lastSeq = seq
+		delete(fields, filterSeqField)
+
+		rec := make(record, len(fields))
+		for k, raw := range fields {
+			var v string
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return &filterError{command,
+					fmt.Errorf("output line %d has a value for %q which is not a string", lineNum, k)}
+			}
+			rec[k] = v
+		}
+		if err := emit(rec); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return &filterError{command, err}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFilterRecords(t *testing.T) {
+	csvData := "id,name\n1,ann\n2,bob\n3,cy\n"
+
+	for _, tt := range []struct {
+		testName string
+		command  string
+		wantJson string
+		wantErr  string
+	}{
+		{"Passes records through", "cat", `[{"id": "1", "name": "ann"}, {"id": "2", "name": "bob"}, {"id": "3", "name": "cy"}]`, ""},
+		{"Transforms and drops records", "./testdata/enrich.sh", `[{"id": "1", "name": "ann!"}, {"id": "3", "name": "cy!"}]`, ""},
+		{"Drops every record", "cat >/dev/null", `[]`, ""},
+		{
+			"Fails when the command exits unsuccessfully",
+			"cat >/dev/null; exit 3",
+			"",
+			`exec filter "cat >/dev/null; exit 3": exit status 3`,
+		},
+		{
+			"Fails when the command exits without reading records",
+			"exit 4",
+			"",
+			`exec filter "exit 4": exit status 4`,
+		},
+		{
+			"Fails on output which is not JSON",
+			"cat >/dev/null; echo nope",
+			"",
+			`exec filter "cat >/dev/null; echo nope": output line 1 is not a JSON object: invalid character 'o' in literal null (expecting 'u')`,
+		},
+		{
+			"Fails on output without sequence numbers",
+			"cat >/dev/null; echo '{\"id\": \"1\"}'",
+			"",
+			`exec filter "cat >/dev/null; echo '{\"id\": \"1\"}'": output line 1 must keep a _seq field numbered after 0`,
+		},
+		{
+			"Fails on output out of order",
+			"cat >/dev/null; echo '{\"_seq\": 2}'; echo '{\"_seq\": 1}'",
+			"",
+			`exec filter "cat >/dev/null; echo '{\"_seq\": 2}'; echo '{\"_seq\": 1}'": output line 2 must keep a _seq field numbered after 2`,
+		},
+		{
+			"Fails on values which are not strings",
+			"cat >/dev/null; echo '{\"_seq\": 1, \"id\": 1}'",
+			"",
+			`exec filter "cat >/dev/null; echo '{\"_seq\": 1, \"id\": 1}'": output line 1 has a value for "id" which is not a string`,
+		},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+			err := csv2Json(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(csvData)},
+				execFilter: tt.command,
+				jsonOutput: jsonStream,
+			})
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, jsonStream.String())
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterRecordsKilledOnError(t *testing.T) {
+	// The filter never exits by itself, so converting only finishes if it is killed. Its sleep outlives it, so
+	// it must not inherit stderr, which the test binary's output is read from until every writer closes it.
+	oldStderr := os.Stderr
+	os.Stderr, _ = os.Open(os.DevNull)
+	t.Cleanup(func() {
+		os.Stderr.Close()
+		os.Stderr = oldStderr
+	})
+
+	err := csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader("id\n1\n2\n3\n")},
+		execFilter: "cat; sleep 60",
+		jsonOutput: bytes.NewBuffer([]byte{}),
+		maxRecords: 1,
+		reverse:    true,
+	})
+	assert.Equal(t, recordLimitError{1}, err)
+}
+
+func TestFilterRecordsCsvError(t *testing.T) {
+	err := csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader("id,name\n1,ann\n2\n")},
+		execFilter: "cat",
+		jsonOutput: bytes.NewBuffer([]byte{}),
+	})
+	assert.EqualError(t, err, "record on line 3: wrong number of fields")
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// valueTransform rewrites a single field value of a data row. Transforms are never applied to header rows.
+type valueTransform func(value string) string
+
+// defangFormula recovers the literal value from an Excel formula of the form ="...", which Excel writers use to keep
+// values such as "00123" from being reinterpreted as numbers. Doubled quotes within the literal are unescaped.
+// Any other value, including formulas which do more than wrap a single literal, is returned unchanged.
+func defangFormula(value string) string {
+	if len(value) < 3 || !strings.HasPrefix(value, `="`) || !strings.HasSuffix(value, `"`) {
+		return value
+	}
+	literal := value[2 : len(value)-1]
+	if strings.Contains(strings.ReplaceAll(literal, `""`, ""), `"`) {
+		// An unescaped quote means the formula continues after the first literal, as in ="a"&"b"
+		return value
+	}
+	return strings.ReplaceAll(literal, `""`, `"`)
+}
+
+// stripFormulaEscape removes the leading apostrophe or tab which guards a value that would otherwise be interpreted
+// as a formula, i.e. one beginning with =, +, -, or @. Any other value is returned unchanged.
+func stripFormulaEscape(value string) string {
+	if len(value) < 2 || (value[0] != '\'' && value[0] != '\t') || !strings.ContainsAny(value[1:2], "=+-@") {
+		return value
+	}
+	return value[1:]
+}
+
+// formulaTriggers are the characters which make spreadsheet applications interpret a cell beginning with one of them
+// as a formula, following the OWASP guidance on CSV injection.
+const formulaTriggers = "=+-@\t\r"
+
+// guardFormula prefixes value with an apostrophe when it begins with one of formulaTriggers, so that it is shown as
+// text rather than evaluated when CSV output is opened in a spreadsheet. This is the inverse of stripFormulaEscape.
+// When numeric is set, meaning the value came from a column of numbers, values such as -5 which parse as numbers
+// are returned unchanged, since they cannot be formulas.
+func guardFormula(value string, numeric bool) string {
+	if value == "" || !strings.ContainsAny(value[:1], formulaTriggers) {
+		return value
+	}
+	if numeric {
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return value
+		}
+	}
+	return "'" + value
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDefangFormula(t *testing.T) {
+	for _, tt := range []struct {
+		testName  string
+		value     string
+		wantValue string
+	}{
+		{"Recovers literal with leading zeros", `="00123"`, "00123"},
+		{"Recovers empty literal", `=""`, ""},
+		{"Unescapes doubled quotes", `="say ""hi"""`, `say "hi"`},
+		{"Leaves formula which does more than wrap a literal", `="a"&"b"`, `="a"&"b"`},
+		{"Leaves other formula", "=SUM(A1:A3)", "=SUM(A1:A3)"},
+		{"Leaves negative number", "-5", "-5"},
+		{"Leaves plain text", "hello", "hello"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			assert.Equal(t, tt.wantValue, defangFormula(tt.value))
+		})
+	}
+}
+
+func TestStripFormulaEscape(t *testing.T) {
+	for _, tt := range []struct {
+		testName  string
+		value     string
+		wantValue string
+	}{
+		{"Strips apostrophe before formula", "'=1+2", "=1+2"},
+		{"Strips tab before formula", "\t@SUM(A1)", "@SUM(A1)"},
+		{"Strips apostrophe before sign", "'-5", "-5"},
+		{"Leaves negative number", "-5", "-5"},
+		{"Leaves apostrophe in plain text", "'90s", "'90s"},
+		{"Leaves lone apostrophe", "'", "'"},
+		{"Leaves plain text", "hello", "hello"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			assert.Equal(t, tt.wantValue, stripFormulaEscape(tt.value))
+		})
+	}
+}
+
+func TestGuardFormula(t *testing.T) {
+	for _, tt := range []struct {
+		testName  string
+		value     string
+		numeric   bool
+		wantValue string
+	}{
+		{"Guards equals sign", "=1+2", false, "'=1+2"},
+		{"Guards plus sign", "+1+2", false, "'+1+2"},
+		{"Guards minus sign", "-1+2", false, "'-1+2"},
+		{"Guards at sign", "@SUM(A1)", false, "'@SUM(A1)"},
+		{"Guards tab", "\t=1", false, "'\t=1"},
+		{"Guards carriage return", "\r=1", false, "'\r=1"},
+		{"Guards negative number in text column", "-5", false, "'-5"},
+		{"Leaves negative number in numeric column", "-5", true, "-5"},
+		{"Guards formula in numeric column", "-5+A1", true, "'-5+A1"},
+		{"Leaves trigger after first character", "a=b", false, "a=b"},
+		{"Leaves empty value", "", false, ""},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			assert.Equal(t, tt.wantValue, guardFormula(tt.value, tt.numeric))
+		})
+	}
+}
+
+func TestJson2CsvSanitize(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		json     string
+		sanitize bool
+		wantCsv  string
+	}{
+		{"Guards equals sign", `[{"a": "=1+2"}]`, true, "a\n'=1+2\n"},
+		{"Guards plus sign", `[{"a": "+1+2"}]`, true, "a\n'+1+2\n"},
+		{"Guards minus sign", `[{"a": "-1+2"}]`, true, "a\n'-1+2\n"},
+		{"Guards at sign", `[{"a": "@SUM(A1)"}]`, true, "a\n'@SUM(A1)\n"},
+		{"Guards tab", `[{"a": "\t=1"}]`, true, "a\n'\t=1\n"},
+		{"Guards carriage return", `[{"a": "\r=1"}]`, true, "a\n\"'\r=1\"\n"},
+		{"Leaves negative JSON number", `[{"a": -5}]`, true, "a\n-5\n"},
+		{"Guards negative number in a string", `[{"a": "-5"}]`, true, "a\n'-5\n"},
+		{"Guards header names", `[{"=a": "1"}]`, true, "'=a\n1\n"},
+		{"Leaves values unsanitized", `[{"=a": "=1+2"}]`, false, "=a\n=1+2\n"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var csvData bytes.Buffer
+			err := json2Csv(fromJsonOptions{
+				jsonInputs: []io.Reader{strings.NewReader(tt.json)},
+				csvOutput:  &csvData,
+				sanitize:   tt.sanitize,
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantCsv, csvData.String())
+		})
+	}
+}
+
+func TestTransformsSkipHeader(t *testing.T) {
+	jsonStream := bytes.NewBuffer([]byte{})
+
+	err := csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader("\"=\"\"id\"\"\",'=n\n\"=\"\"007\"\"\",'=1+2\n")},
+		transforms: []valueTransform{defangFormula, stripFormulaEscape},
+		jsonOutput: jsonStream,
+	})
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"=\"id\"": "007", "'=n": "=1+2"}]`, jsonStream.String())
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// fromJsonOptions is used to configure the conversion of JSON back to CSV by the from-json subcommand.
+type fromJsonOptions struct {
+	jsonInputs []io.Reader
+	csvOutput  io.Writer
+	// colNames are the columns to write, in order. When empty, every key of every object is written.
+	colNames  []string
+	delimiter rune
+	// crlf and quoteAll are as given by the csvDialect to write
+	crlf     bool
+	quoteAll bool
+	// sanitize guards values which would be evaluated as formulas by spreadsheets, as by guardFormula()
+	sanitize bool
+}
+
+// invalidJsonError reports input to the from-json subcommand which is not JSON objects.
+type invalidJsonError struct {
+	name string
+	err  error
+}
+
+func (e *invalidJsonError) Error() string {
+	return fmt.Sprintf("%s: %v", e.name, e.err)
+}
+
+func (e *invalidJsonError) Unwrap() error {
+	return e.err
+}
+
+// jsonObject is an object read by readJsonObjects(), with its keys in order and its values as CSV fields.
+// numbers holds the keys whose values are JSON numbers.
+type jsonObject struct {
+	keys    []string
+	fields  map[string]string
+	numbers map[string]bool
+}
+
+// json2Csv converts JSON arrays of flat objects, or NDJSON, as emitted by csv2Json(), from each of
+// `options.jsonInputs` in turn to CSV written to `options.csvOutput`. The header lists `options.colNames` when they
+// are given, and other keys are omitted. Otherwise it lists every key of every object, in the order in which each
+// first appears, so all objects are read before any CSV is written. Keys which an object does not have are empty
+// fields, and values are written as by csvField(), with line endings and quoting given by `options.crlf` and
+// `options.quoteAll`. When `options.sanitize` is set, header names and values are guarded against being evaluated as
+// formulas, as by guardFormula(), except for JSON numbers such as -5.
+// Returns an *invalidJsonError for input which is not JSON objects, or an *outputError when CSV cannot be written.
+func json2Csv(options fromJsonOptions) error {
+	w := csv.NewWriter(options.csvOutput)
+	if options.delimiter != 0 {
+		w.Comma = options.delimiter
+	}
+	w.UseCRLF = options.crlf
+	colNames := options.colNames
+	forced := len(colNames) > 0
+	writeRow := func(row []string, numbers map[string]bool) error {
+		if options.sanitize {
+			for i, field := range row {
+				row[i] = guardFormula(field, numbers[colNames[i]])
+			}
+		}
+		var err error
+		if options.quoteAll {
+			// encoding/csv only quotes fields which need it, and writes nothing itself before it is flushed
+			_, err = io.WriteString(options.csvOutput, quotedCsvRow(row, w.Comma, options.crlf))
+		} else {
+			err = w.Write(row)
+		}
+		if err != nil {
+			return &outputError{err}
+		}
+		return nil
+	}
+	writeHeader := func() error {
+		return writeRow(append([]string(nil), colNames...), nil)
+	}
+	writeObject := func(obj jsonObject) error {
+		row := make([]string, len(colNames))
+		for i, name := range colNames {
+			row[i] = obj.fields[name]
+		}
+		return writeRow(row, obj.numbers)
+	}
+
+	if forced {
+		if err := writeHeader(); err != nil {
+			return err
+		}
+	}
+	var objects []jsonObject
+	seen := make(map[string]bool)
+	for i, input := range options.jsonInputs {
+		err := readJsonObjects(input, func(obj jsonObject) error {
+			if forced {
+				return writeObject(obj)
+			}
+			for _, key := range obj.keys {
+				if !seen[key] {
+					seen[key] = true
+					colNames = append(colNames, key)
+				}
+			}
+			objects = append(objects, obj)
+			return nil
+		})
+		var outputErr *outputError
+		if errors.As(err, &outputErr) {
+			return err
+		} else if err != nil {
+			return &invalidJsonError{inputName(input, i+1), err}
+		}
+	}
+	// Without forced columns, the header is only known once every object is read
+	if !forced && len(colNames) > 0 {
+		if err := writeHeader(); err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			if err := writeObject(obj); err != nil {
+				return err
+			}
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
+
+// quotedCsvRow returns fields as a line of CSV delimited by delimiter in which every field is quoted, ending with
+// \r\n when crlf is set, or \n otherwise.
+func quotedCsvRow(fields []string, delimiter rune, crlf bool) string {
+	var b strings.Builder
+	for i, field := range fields {
+		if i > 0 {
+			b.WriteRune(delimiter)
+		}
+		b.WriteString(`"` + strings.ReplaceAll(field, `"`, `""`) + `"`)
+	}
+	if crlf {
+		b.WriteString("\r\n")
+	} else {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// readJsonObjects reads a JSON array of objects, or a stream of objects such as NDJSON, from r, calling fn with each
+// object, with its keys in the order they are given. Later values of a key which is given more than once replace
+// earlier ones.
+// Returns an error for input which is not valid JSON, or holds values other than objects, or the first error from fn.
+func readJsonObjects(r io.Reader, fn func(obj jsonObject) error) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return nil
+	}
+	inArray := tok == json.Delim('[')
+	if inArray {
+		tok, err = dec.Token()
+	}
+	for n := 1; ; n++ {
+		if err == io.EOF && !inArray {
+			return nil
+		} else if err != nil {
+			return err
+		} else if inArray && tok == json.Delim(']') {
+			if _, err := dec.Token(); err != io.EOF {
+				return fmt.Errorf("unexpected JSON after the array of objects")
+			}
+			return nil
+		} else if tok != json.Delim('{') {
+			return fmt.Errorf("value %d is not a JSON object", n)
+		}
+
+		obj := jsonObject{fields: make(map[string]string), numbers: make(map[string]bool)}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key := keyTok.(string)
+			var value interface{}
+			if err := dec.Decode(&value); err != nil {
+				return err
+			}
+			if _, ok := obj.fields[key]; !ok {
+				obj.keys = append(obj.keys, key)
+			}
+			if obj.fields[key], err = csvField(value); err != nil {
+				return err
+			}
+			_, obj.numbers[key] = value.(json.Number)
+		}
+		// The closing brace
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
+		tok, err = dec.Token()
+	}
+}
+
+// csvField returns the CSV field for a JSON value decoded with json.Decoder.UseNumber(). Strings are written as they
+// are, and numbers as their JSON text, while nulls are empty. Nested objects and arrays are written as compact JSON.
+func csvField(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))), nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/integrii/flaggy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJson2Csv(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		json     string
+		colNames []string
+		wantCsv  string
+		wantErr  string
+	}{
+		{"Converts an array", `[{"a": "1", "b": "2"}, {"a": "3", "b": "4"}]`, nil, "a,b\n1,2\n3,4\n", ""},
+		{"Converts NDJSON", "{\"a\": \"1\", \"b\": \"2\"}\n{\"a\": \"3\", \"b\": \"4\"}\n", nil, "a,b\n1,2\n3,4\n", ""},
+		{"Converts an empty array", `[]`, nil, "", ""},
+		{"Converts empty input", "", nil, "", ""},
+		{"Writes the union of keys in order of appearance", `[{"b": "1"}, {"a": "2", "b": "3"}, {"c": "4"}]`, nil,
+			"b,a,c\n1,,\n3,2,\n,,4\n", ""},
+		{"Stringifies other values", `[{"n": 1.50, "big": 12345678901234567890, "t": true, "z": null}]`, nil,
+			"n,big,t,z\n1.50,12345678901234567890,true,\n", ""},
+		{"Writes nested values as JSON", `[{"o": {"k": "<v>"}, "a": [1, "x"]}]`, nil,
+			"o,a\n\"{\"\"k\"\":\"\"<v>\"\"}\",\"[1,\"\"x\"\"]\"\n", ""},
+		{"Keeps the position of repeated keys", `[{"a": "1", "b": "2", "a": "3"}]`, nil, "a,b\n3,2\n", ""},
+		{"Orders forced columns", `[{"a": "1", "b": "2", "c": "3"}]`, []string{"c", "a", "d"}, "c,a,d\n3,1,\n", ""},
+		{"Rejects other values", `[{"a": "1"}, ["a"]]`, nil, "", "input 1: value 2 is not a JSON object"},
+		{"Rejects trailing data", `[{"a": "1"}] {}`, nil, "", "input 1: unexpected JSON after the array of objects"},
+		{"Rejects invalid JSON", `[{"a": "1"`, nil, "", "input 1: unexpected end of JSON input"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var csvData bytes.Buffer
+			err := json2Csv(fromJsonOptions{
+				jsonInputs: []io.Reader{strings.NewReader(tt.json)},
+				csvOutput:  &csvData,
+				colNames:   tt.colNames,
+			})
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantCsv, csvData.String())
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, exitInvalidCsv, exitCode(err))
+			}
+		})
+	}
+}
+
+func TestCliFromJsonSanitize(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		cliArgs  []string
+		wantCsv  string
+		wantErr  string
+	}{
+		{"Sanitizes by default", nil, "a,n\n'=1,-5\n'-5,'@x\n", ""},
+		{"Sanitizes when asked", []string{"--sanitize-csv"}, "a,n\n'=1,-5\n'-5,'@x\n", ""},
+		{"Disabled", []string{"--no-sanitize-csv"}, "a,n\n=1,-5\n-5,@x\n", ""},
+		{"Disabled as false", []string{"--sanitize-csv=false"}, "a,n\n=1,-5\n-5,@x\n", ""},
+		{"Conflicting flags", []string{"--sanitize-csv", "--no-sanitize-csv"}, "",
+			"--sanitize-csv and --no-sanitize-csv cannot be combined"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			dir := t.TempDir()
+			jsonFileName := filepath.Join(dir, "in.json")
+			require.NoError(t, ioutil.WriteFile(jsonFileName, []byte(`[{"a": "=1", "n": -5}, {"a": "-5", "n": "@x"}]`),
+				0600))
+			outputFileName := filepath.Join(dir, "out.csv")
+			os.Args = append([]string{"csv2json", "from-json", "-o", outputFileName, jsonFileName}, tt.cliArgs...)
+			flaggy.ResetParser()
+
+			err := runCli()
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, exitUsage, exitCode(err))
+				return
+			}
+			require.NoError(t, err)
+			data, err := ioutil.ReadFile(outputFileName)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCsv, string(data))
+		})
+	}
+}
+
+func TestJson2CsvMultipleInputs(t *testing.T) {
+	var csvData bytes.Buffer
+	err := json2Csv(fromJsonOptions{
+		jsonInputs: []io.Reader{strings.NewReader(`[{"a": "1"}]`), strings.NewReader(`{"b": "2"}`)},
+		csvOutput:  &csvData,
+		delimiter:  ';',
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "a;b\n1;\n;2\n", csvData.String())
+}
+
+func TestCliFromJsonDialect(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		cliArgs  []string
+		wantCsv  string
+	}{
+		{"Default", nil, "a,b\n1,\"x\"\"y\"\n"},
+		{"Excel", []string{"--dialect", "excel"}, "a,b\r\n1,\"x\"\"y\"\r\n"},
+		{"Excel tab", []string{"--dialect", "excel-tab"}, "a\tb\r\n1\t\"x\"\"y\"\r\n"},
+		{"Unix", []string{"--dialect", "unix"}, "\"a\",\"b\"\n\"1\",\"x\"\"y\"\n"},
+		{"Unix with delimiter", []string{"--dialect", "unix", "--delimiter", ";"}, "\"a\";\"b\"\n\"1\";\"x\"\"y\"\n"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			dir := t.TempDir()
+			jsonFileName := filepath.Join(dir, "in.json")
+			require.NoError(t, ioutil.WriteFile(jsonFileName, []byte(`[{"a": 1, "b": "x\"y"}]`), 0600))
+			outputFileName := filepath.Join(dir, "out.csv")
+			os.Args = append([]string{"csv2json", "from-json", "-o", outputFileName, jsonFileName}, tt.cliArgs...)
+			flaggy.ResetParser()
+
+			require.NoError(t, runCli())
+			data, err := ioutil.ReadFile(outputFileName)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCsv, string(data))
+		})
+	}
+}
+
+func TestJson2CsvOutputError(t *testing.T) {
+	errFull := errors.New("disk full")
+	err := json2Csv(fromJsonOptions{
+		jsonInputs: []io.Reader{strings.NewReader(`[{"a": "1"}]`)},
+		csvOutput:  &failingWriter{n: 0, err: errFull},
+		colNames:   []string{"a"},
+	})
+
+	assert.True(t, errors.Is(err, errFull), "Unexpected error %v", err)
+	assert.Equal(t, exitOutputFailure, exitCode(err))
+}
+
+func TestJson2CsvRoundTrip(t *testing.T) {
+	csvData := "id,name,note,amount\n" +
+		"1,ann,\"commas, and \"\"quotes\"\"\",1.50\n" +
+		"2,bob,\"line one\nline two\",\n" +
+		"3,cy,ünïcödé ✓,-7\n"
+
+	for _, tt := range []struct {
+		testName string
+		options  conversionOptions
+		sanitize bool
+	}{
+		{"JSON array", conversionOptions{}, false},
+		{"NDJSON", conversionOptions{ndjson: true}, false},
+		{"Inferred types", conversionOptions{inferTypes: true}, false},
+		{"Inferred numbers are not sanitized", conversionOptions{inferTypes: true}, true},
+		{"Indented", conversionOptions{indent: 2}, false},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var jsonData, roundTripped bytes.Buffer
+			tt.options.csvInputs = []io.Reader{strings.NewReader(csvData)}
+			tt.options.jsonOutput = &jsonData
+			require.NoError(t, csv2Json(tt.options))
+
+			err := json2Csv(fromJsonOptions{jsonInputs: []io.Reader{&jsonData}, csvOutput: &roundTripped,
+				sanitize: tt.sanitize})
+
+			// Records are objects, so their keys are not kept in the order of the CSV columns
+			assert.NoError(t, err)
+			assert.Equal(t, csv2JsonString(t, csvData), csv2JsonString(t, roundTripped.String()))
+		})
+	}
+}
+
+// csv2JsonString returns the JSON array converted from csvData by csv2Json().
+func csv2JsonString(t *testing.T, csvData string) string {
+	var jsonData bytes.Buffer
+	require.NoError(t, csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader(csvData)},
+		jsonOutput: &jsonData,
+	}))
+	return jsonData.String()
+}
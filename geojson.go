@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// geoJsonOptions is used to configure emitting records as a GeoJSON FeatureCollection when calling csv2GeoJson().
+type geoJsonOptions struct {
+	latColumn string
+	lonColumn string
+	// bbox adds the bounding box of every feature to the collection
+	bbox bool
+}
+
+// geoJsonFeature is a GeoJSON Feature with a Point geometry, whose properties are the rest of a record.
+type geoJsonFeature struct {
+	Type       string       `json:"type"`
+	Geometry   geoJsonPoint `json:"geometry"`
+	Properties record       `json:"properties"`
+}
+
+type geoJsonPoint struct {
+	Type string `json:"type"`
+	// Coordinates are the longitude and latitude, in that order
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// csv2GeoJson converts CSV data from `options.csvInputs` to a GeoJSON FeatureCollection, emitted to
+// `options.jsonOutput`, in which each record is a Feature whose Point geometry is located by its values of
+// `geoOpts.latColumn` and `geoOpts.lonColumn`, and whose properties are its other fields. Features are emitted as
+// they are converted. Records whose coordinates are missing, are not numbers, or are out of range are invalid rows,
+// as by `options.validate`, so they abort unless skipped, but coordinate columns which the header does not have are
+// rejected before any row is read. Nothing is emitted unless the header is read, and an
+// error after that leaves the FeatureCollection unterminated.
+// Returns any errors from reading CSV or encoding JSON.
+func csv2GeoJson(options conversionOptions, geoOpts geoJsonOptions) error {
+	options.validate = combineValidators(options.validate, validateCoordinates(geoOpts))
+	if err := checkBatchOptions(options, 1); err != nil {
+		return err
+	}
+	reader, colNames, err := newCsvRowReader(options)
+	if err != nil {
+		return err
+	}
+	// Coordinates are validated before records are passed to --exec-filter, so are always read from the input
+	if err := checkCoordinateColumns(geoOpts, reader.fieldNames(colNames)); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(options.jsonOutput, `{"type":"FeatureCollection","features":[`); err != nil {
+		return &outputError{err}
+	}
+	bbox := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	numFeatures := 0
+	err = eachReaderBatch(options, reader, colNames, 1, func(batch []record) error {
+		for _, rec := range batch {
+			// Records were validated, so their coordinates parse
+			lat, _ := coordinate(rec[geoOpts.latColumn])
+			lon, _ := coordinate(rec[geoOpts.lonColumn])
+			delete(rec, geoOpts.latColumn)
+			delete(rec, geoOpts.lonColumn)
+			bbox = [4]float64{math.Min(bbox[0], lon), math.Min(bbox[1], lat),
+				math.Max(bbox[2], lon), math.Max(bbox[3], lat)}
+
+			feature, err := json.Marshal(geoJsonFeature{"Feature", geoJsonPoint{"Point", [2]float64{lon, lat}}, rec})
+			if err != nil {
+				return &outputError{err}
+			}
+			if numFeatures > 0 {
+				feature = append([]byte{','}, feature...)
+			}
+			numFeatures++
+			if _, err := options.jsonOutput.Write(feature); err != nil {
+				return &outputError{err}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	end := "]}\n"
+	if geoOpts.bbox && numFeatures > 0 {
+		encodedBbox, _ := json.Marshal(bbox)
+		end = `],"bbox":` + string(encodedBbox) + "}\n"
+	}
+	if _, err := io.WriteString(options.jsonOutput, end); err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
+
+// validateCoordinates returns a function for `conversionOptions.validate` which rejects records whose values of
+// `geoOpts.latColumn` and `geoOpts.lonColumn` are not a latitude and longitude in degrees, as parsed by coordinate().
+// A record without one of the columns causes a *usageError.
+func validateCoordinates(geoOpts geoJsonOptions) func(rec record) error {
+	return func(rec record) error {
+		for _, c := range []struct {
+			column string
+			flag   string
+			name   string
+			limit  float64
+		}{
+			{geoOpts.latColumn, "--lat-column", "latitude", 90},
+			{geoOpts.lonColumn, "--lon-column", "longitude", 180},
+		} {
+			value, ok := rec[c.column]
+			if !ok {
+				return usageErrorf("unknown %s %q", c.flag, c.column)
+			}
+			if n, err := coordinate(value); err != nil || math.Abs(n) > c.limit {
+				return &constraintViolation{"geojson " + c.name, fmt.Sprintf("column %q value %q is not a %s",
+					c.column, truncateText(value, invalidValueLength), c.name)}
+			}
+		}
+		return nil
+	}
+}
+
+// checkCoordinateColumns returns a *usageError unless `geoOpts.latColumn` and `geoOpts.lonColumn` are both among
+// fields, so that they are rejected before any row is read rather than by validateCoordinates().
+func checkCoordinateColumns(geoOpts geoJsonOptions, fields []string) error {
+	if !containsString(fields, geoOpts.latColumn) {
+		return usageErrorf("unknown --lat-column %q", geoOpts.latColumn)
+	} else if !containsString(fields, geoOpts.lonColumn) {
+		return usageErrorf("unknown --lon-column %q", geoOpts.lonColumn)
+	}
+	return nil
+}
+
+// coordinate parses value as a number of degrees, as by strconv.ParseFloat(), ignoring surrounding whitespace.
+// Infinities and NaN are rejected, since JSON cannot represent them.
+func coordinate(value string) (float64, error) {
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err == nil && (math.IsInf(n, 0) || math.IsNaN(n)) {
+		err = strconv.ErrSyntax
+	}
+	return n, err
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+// decodedFeatureCollection is a GeoJSON FeatureCollection of Point features, as decoded from JSON.
+type decodedFeatureCollection struct {
+	Type     string `json:"type"`
+	Features []struct {
+		Type     string `json:"type"`
+		Geometry struct {
+			Type        string    `json:"type"`
+			Coordinates []float64 `json:"coordinates"`
+		} `json:"geometry"`
+		Properties map[string]interface{} `json:"properties"`
+	} `json:"features"`
+	Bbox []float64 `json:"bbox"`
+}
+
+func TestCsv2GeoJson(t *testing.T) {
+	discardLogs(t)
+	csvData := "name,lat,lon\nLondon,51.5,-0.12\nNowhere,north,1\nNew York, 40.7 ,-74\nSpace,91,0\n"
+
+	var buf bytes.Buffer
+	stats := &conversionStats{}
+	err := csv2GeoJson(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader(csvData)},
+		jsonOutput: &buf,
+		skipErrors: true,
+		stats:      stats,
+	}, geoJsonOptions{latColumn: "lat", lonColumn: "lon", bbox: true})
+	require.NoError(t, err)
+
+	var collection decodedFeatureCollection
+	decoder := json.NewDecoder(&buf)
+	decoder.DisallowUnknownFields()
+	require.NoError(t, decoder.Decode(&collection))
+	assert.Equal(t, "FeatureCollection", collection.Type)
+	require.Len(t, collection.Features, 2, "Rows with bad coordinates should be skipped")
+	for i, want := range []struct {
+		name        string
+		coordinates []float64
+	}{{"London", []float64{-0.12, 51.5}}, {"New York", []float64{-74, 40.7}}} {
+		feature := collection.Features[i]
+		assert.Equal(t, "Feature", feature.Type)
+		assert.Equal(t, "Point", feature.Geometry.Type)
+		assert.Equal(t, want.coordinates, feature.Geometry.Coordinates, "Coordinates should be longitude first")
+		assert.Equal(t, map[string]interface{}{"name": want.name}, feature.Properties)
+	}
+	assert.Equal(t, []float64{-74, 40.7, -0.12, 51.5}, collection.Bbox)
+	assert.Equal(t, 2, stats.rowsInvalid, "Rows with bad coordinates should be counted")
+
+	buf.Reset()
+	err = csv2GeoJson(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader(csvData)},
+		jsonOutput: &buf,
+	}, geoJsonOptions{latColumn: "lat", lonColumn: "lon"})
+	assert.EqualError(t, err, `row 2 is invalid: column "lat" value "north" is not a latitude`)
+	assert.Equal(t, `{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Point",`+
+		`"coordinates":[-0.12,51.5]},"properties":{"name":"London"}}`, buf.String(),
+		"An error should leave the FeatureCollection unterminated")
+}
+
+func TestCsv2GeoJsonHeaderError(t *testing.T) {
+	var buf bytes.Buffer
+	err := csv2GeoJson(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader("lat,\"lon\n")},
+		jsonOutput: &buf,
+	}, geoJsonOptions{latColumn: "lat", lonColumn: "lon"})
+	assert.EqualError(t, err, `parse error on line 1, column 10: extraneous or missing " in quoted-field`)
+	assert.Empty(t, buf.String(), "Nothing should be emitted unless the header is read")
+}
+
+func TestCsv2GeoJsonUnknownColumn(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		geoOpts  geoJsonOptions
+		wantErr  string
+	}{
+		{"Latitude", geoJsonOptions{latColumn: "latitude", lonColumn: "lon"}, `unknown --lat-column "latitude"`},
+		{"Longitude", geoJsonOptions{latColumn: "lat", lonColumn: "lng"}, `unknown --lon-column "lng"`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := csv2GeoJson(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader("name,lat,lon\n")},
+				jsonOutput: &buf,
+			}, tt.geoOpts)
+			assert.EqualError(t, err, tt.wantErr)
+			assert.Equal(t, exitUsage, exitCode(err))
+			assert.Empty(t, buf.String())
+		})
+	}
+}
+
+func TestCsv2GeoJsonEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	err := csv2GeoJson(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader("name,lat,lon\n")},
+		jsonOutput: &buf,
+	}, geoJsonOptions{latColumn: "lat", lonColumn: "lon", bbox: true})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"type":"FeatureCollection","features":[]}`+"\n", buf.String())
+}
+
+func TestValidateCoordinates(t *testing.T) {
+	validate := validateCoordinates(geoJsonOptions{latColumn: "y", lonColumn: "x"})
+	assert.NoError(t, validate(record{"y": "-90", "x": "180"}))
+	assert.EqualError(t, validate(record{"y": "0", "x": "180.5"}), `column "x" value "180.5" is not a longitude`)
+	assert.EqualError(t, validate(record{"y": "NaN", "x": "0"}), `column "y" value "NaN" is not a latitude`)
+	assert.EqualError(t, validate(record{"y": "", "x": "0"}), `column "y" value "" is not a latitude`)
+	assert.Equal(t, exitUsage, exitCode(validate(record{"y": "0"})), "Unknown columns should be a usage error")
+}
@@ -3,6 +3,11 @@ module github.com/TylerHendrickson/csv2json
 go 1.15
 
 require (
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/integrii/flaggy v1.4.4
+	github.com/klauspost/compress v1.14.4 // indirect
+	github.com/segmentio/kafka-go v0.4.30
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/term v0.0.0-20210220032956-6a3ed077a48d
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// isInvisibleHeaderRune reports whether r is a C0 or C1 control character or a zero-width code point,
+// which cannot be seen in a header name but would prevent it from matching.
+func isInvisibleHeaderRune(r rune) bool {
+	switch r {
+	case '\u200B', '\u200C', '\u200D', '\u2060', '\uFEFF':
+		return true
+	}
+	return unicode.IsControl(r)
+}
+
+// sanitizeHeader removes invisible runes from a header name, returning the sanitized name along with the bytes
+// which were removed.
+func sanitizeHeader(name string) (string, []byte) {
+	var removed []byte
+	sanitized := strings.Map(func(r rune) rune {
+		if isInvisibleHeaderRune(r) {
+			removed = append(removed, string(r)...)
+			return -1
+		}
+		return r
+	}, name)
+	return sanitized, removed
+}
+
+// invalidHeaderError reports a header name which is rejected by `conversionOptions.strictHeaders`.
+type invalidHeaderError struct {
+	name    string
+	column  int
+	problem string
+}
+
+func (e *invalidHeaderError) Error() string {
+	return fmt.Sprintf("header of %s, column %d: %s", e.name, e.column, e.problem)
+}
+
+// checkHeaders returns an *invalidHeaderError for the first of the header names read from the named input which is
+// empty or the same as an earlier name.
+func checkHeaders(inputName string, header []string) error {
+	seen := make(map[string]bool, len(header))
+	for i, name := range header {
+		if strings.TrimSpace(name) == "" {
+			return &invalidHeaderError{inputName, i + 1, "empty column name"}
+		} else if seen[name] {
+			return &invalidHeaderError{inputName, i + 1, fmt.Sprintf("duplicate column name %q", name)}
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// emptyHeadersError reports the header names read from an input which are empty or only whitespace, which would
+// otherwise all be converted to "" keys.
+type emptyHeadersError struct {
+	name string
+	// columns are the positions of the empty names, counting from 1
+	columns []int
+}
+
+func (e *emptyHeadersError) Error() string {
+	return fmt.Sprintf("header of %s has empty column names at columns %s; name them with --fill-empty-headers",
+		e.name, joinInts(e.columns, ", "))
+}
+
+// emptyHeaderColumns returns the positions, counting from 1, of the header names which are empty or only whitespace.
+func emptyHeaderColumns(header []string) []int {
+	var columns []int
+	for i, name := range header {
+		if strings.TrimSpace(name) == "" {
+			columns = append(columns, i+1)
+		}
+	}
+	return columns
+}
+
+// joinInts joins the decimal representations of values with sep.
+func joinInts(values []int, sep string) string {
+	formatted := make([]string, len(values))
+	for i, v := range values {
+		formatted[i] = strconv.Itoa(v)
+	}
+	return strings.Join(formatted, sep)
+}
+
+// fillEmptyHeaders names each empty header name read from the named input in place after its column, as column_1,
+// column_2, and so on, returning how many were renamed. A name which is already taken by another column is given
+// the lowest suffix of _2, _3, and so on which makes it unique, as column_3_2.
+func fillEmptyHeaders(inputName string, header []string) int {
+	taken := make(map[string]bool, len(header))
+	for _, name := range header {
+		taken[name] = true
+	}
+	numRenamed := 0
+	for _, column := range emptyHeaderColumns(header) {
+		i := column - 1
+		filled := fmt.Sprintf("column_%d", column)
+		for n := 2; taken[filled]; n++ {
+			filled = fmt.Sprintf("column_%d_%d", column, n)
+		}
+		taken[filled] = true
+		logRenamedHeader(inputName, i, header[i], filled)
+		header[i] = filled
+		numRenamed++
+	}
+	return numRenamed
+}
+
+// mergeHeaderRows merges several header rows, which have the same number of names, into a single name for each
+// column, by joining its names from top to bottom with sep. Blank names in every row but the last are first filled
+// with the nearest name to their left, since a merged cell spanning several columns only has its name in the first.
+// Names are trimmed of surrounding whitespace, and blank names, which are those left in the last row and at the start
+// of the other rows, are omitted.
+func mergeHeaderRows(rows [][]string, sep string) []string {
+	merged := make([]string, len(rows[0]))
+	for i := range merged {
+		var names []string
+		for j, row := range rows {
+			name := ""
+			if i < len(row) {
+				name = strings.TrimSpace(row[i])
+			}
+			for k := i - 1; name == "" && j < len(rows)-1 && k >= 0 && k < len(row); k-- {
+				name = strings.TrimSpace(row[k])
+			}
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+		merged[i] = strings.Join(names, sep)
+	}
+	return merged
+}
+
+// dedupeHeaders renames each of the header names read from the named input in place which is the same as an earlier
+// name, as by suffixDuplicateHeaders(), logging each one. Returns how many were renamed.
+func dedupeHeaders(inputName string, header []string) int {
+	original := append([]string(nil), header...)
+	renamed := suffixDuplicateHeaders(header)
+	for _, i := range renamed {
+		logRenamedHeader(inputName, i, original[i], header[i])
+	}
+	return len(renamed)
+}
+
+// suffixDuplicateHeaders renames each of header names in place which is the same as an earlier name, by appending the
+// lowest suffix of _2, _3, and so on which makes it unique. Returns the indexes of the renamed names.
+func suffixDuplicateHeaders(header []string) []int {
+	taken := make(map[string]bool, len(header))
+	for _, name := range header {
+		taken[name] = true
+	}
+
+	var renamed []int
+	seen := make(map[string]bool, len(header))
+	for i, name := range header {
+		if !seen[name] {
+			seen[name] = true
+			continue
+		}
+		unique := name
+		for n := 2; taken[unique]; n++ {
+			unique = fmt.Sprintf("%s_%d", name, n)
+		}
+		header[i] = unique
+		taken[unique], seen[unique] = true, true
+		renamed = append(renamed, i)
+	}
+	return renamed
+}
+
+// dupHeaderPolicies are the policies accepted by --dup-headers for resolving header names which repeat an earlier
+// name: failing, keeping the values of the first or last of the columns, renaming with suffixes as by dedupeHeaders(),
+// or collapsing their values into an array, as by collapseDuplicateFields().
+var dupHeaderPolicies = []string{"error", "first", "last", "suffix", "array"}
+
+// defaultDupHeaderPolicy is the policy which applies when none is chosen. Columns are renamed, so that the values of
+// every column are kept, rather than the values of one replacing the others in a record.
+const defaultDupHeaderPolicy = "suffix"
+
+// checkDupHeaderPolicy returns a *usageError unless policy is empty or one of dupHeaderPolicies.
+func checkDupHeaderPolicy(policy string) error {
+	if policy == "" {
+		return nil
+	}
+	for _, p := range dupHeaderPolicies {
+		if p == policy {
+			return nil
+		}
+	}
+	return usageErrorf("unknown --dup-headers policy %q (expected one of %s)", policy,
+		strings.Join(dupHeaderPolicies, ", "))
+}
+
+// headerMismatchPolicies are the policies accepted by --header-mismatch for inputs whose header differs from the
+// column names of the first input: failing with a *headerMismatchError, or logging a warning and reading the rows of
+// the input by position as if its header matched.
+var headerMismatchPolicies = []string{"error", "warn"}
+
+// checkHeaderMismatchPolicy returns a *usageError unless policy is empty or one of headerMismatchPolicies.
+func checkHeaderMismatchPolicy(policy string) error {
+	if policy == "" || containsString(headerMismatchPolicies, policy) {
+		return nil
+	}
+	return usageErrorf("unknown --header-mismatch policy %q (expected one of %s)", policy,
+		strings.Join(headerMismatchPolicies, ", "))
+}
+
+// logHeaderMismatch logs that the header of the input named inputName differs from colNames, but is read anyway.
+func logHeaderMismatch(inputName string, header, colNames []string) {
+	if logStructured() {
+		logEvent("header mismatch", "file", inputName, "header", strings.Join(header, ","),
+			"columns", strings.Join(colNames, ","))
+	} else {
+		log.Printf("Header of %s %q does not match columns %q; reading its rows as those columns anyway",
+			inputName, header, colNames)
+	}
+}
+
+// duplicateHeaders returns the header names which occur more than once, in the order of their second occurrence,
+// along with the index of the first name which repeats an earlier one, or -1 if none do.
+func duplicateHeaders(header []string) ([]string, int) {
+	first := -1
+	var dups []string
+	counts := make(map[string]int, len(header))
+	for i, name := range header {
+		counts[name]++
+		if counts[name] == 2 {
+			dups = append(dups, name)
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+	return dups, first
+}
+
+// arrayFields maps each of dups, as returned by duplicateHeaders(), to the names of its columns among renamed, which
+// has the names of header after renaming by suffixDuplicateHeaders(), in column order.
+func arrayFields(header, renamed, dups []string) map[string][]string {
+	fields := make(map[string][]string, len(dups))
+	for _, name := range dups {
+		fields[name] = nil
+	}
+	for i, name := range header {
+		if _, ok := fields[name]; ok {
+			fields[name] = append(fields[name], renamed[i])
+		}
+	}
+	return fields
+}
+
+// collapseDuplicateFields returns a copy of rec in which the fields named by each value of fields, as returned by
+// arrayFields(), are replaced by a single field holding an array of their values, named by its key.
+func collapseDuplicateFields(rec record, fields map[string][]string) map[string]interface{} {
+	collapsed := make(map[string]interface{}, len(rec))
+	for k, v := range rec {
+		collapsed[k] = v
+	}
+	for name, names := range fields {
+		values := make([]string, len(names))
+		for i, fieldName := range names {
+			values[i] = rec[fieldName]
+			delete(collapsed, fieldName)
+		}
+		collapsed[name] = values
+	}
+	return collapsed
+}
+
+// logDuplicateHeaders logs that the duplicate header names of the named input were resolved by policy.
+func logDuplicateHeaders(inputName string, dups []string, policy string) {
+	if logStructured() {
+		logEvent("duplicate headers", "file", inputName, "headers", strings.Join(dups, ","), "policy", policy)
+	} else {
+		log.Printf("Resolved duplicate header names %s of %s with --dup-headers=%s",
+			strings.Join(quoteAll(dups), ", "), inputName, policy)
+	}
+}
+
+// headerStats describes the column names printed by printHeaders().
+type headerStats struct {
+	Columns    []string `json:"columns"`
+	FieldCount int      `json:"field_count"`
+	Duplicates bool     `json:"duplicates"`
+	Empty      bool     `json:"empty"`
+}
+
+// columns returns the column names of `options.csvInputs`, as records converted with options would have them, reading
+// no more of the inputs than their header rows, or the names of `options.colNames` without reading anything.
+// Duplicate and empty names are returned as they are, unless a policy to resolve them is chosen, and inputs without
+// a header have no columns.
+// Returns any errors from reading the header.
+func columns(options conversionOptions) ([]string, error) {
+	if options.dupHeaders == "" && !options.dedupeHeaders {
+		options.dupHeaders = "last"
+	}
+	options.keepEmptyHeaders = true
+	_, colNames, err := newCsvRowReader(options)
+	if err != nil {
+		return nil, err
+	}
+	if colNames == nil {
+		colNames = []string{}
+	}
+	return colNames, nil
+}
+
+// printHeaders writes the column names of `options.csvInputs` to w, as returned by columns(), as the headers
+// subcommand and --headers-only do. Column names are written as a JSON array, or one per line when plain is set.
+// When stats is set, they are written along with the number of fields and whether any names are duplicates or
+// empty, as a JSON object, or after the names as "key: value" lines when plain is also set.
+// Returns any errors from reading the header or writing its names.
+func printHeaders(w io.Writer, options conversionOptions, plain, stats bool) error {
+	colNames, err := columns(options)
+	if err != nil {
+		return err
+	}
+	dups, _ := duplicateHeaders(colNames)
+	s := headerStats{Columns: colNames, FieldCount: len(colNames), Duplicates: len(dups) > 0}
+	for _, name := range colNames {
+		s.Empty = s.Empty || strings.TrimSpace(name) == ""
+	}
+
+	var out []byte
+	switch {
+	case plain:
+		for _, name := range colNames {
+			out = append(append(out, name...), '\n')
+		}
+		if stats {
+			out = append(out, fmt.Sprintf("field_count: %d\nduplicates: %t\nempty: %t\n",
+				s.FieldCount, s.Duplicates, s.Empty)...)
+		}
+	case stats:
+		out, _ = json.Marshal(s)
+		out = append(out, '\n')
+	default:
+		out, _ = json.Marshal(colNames)
+		out = append(out, '\n')
+	}
+	if _, err := w.Write(out); err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
+
+// logRenamedHeader logs that the header name of the ith column of the named input was renamed.
+func logRenamedHeader(inputName string, i int, name, renamed string) {
+	if logStructured() {
+		logEvent("renamed header", "file", inputName, "column", i+1, "header", name, "renamed", renamed)
+	} else {
+		log.Printf("Renamed header %q of %s, column %d, to %q", name, inputName, i+1, renamed)
+	}
+}
+
+// sanitizeHeaders sanitizes each of the header names read from the named input in place, logging any which change.
+func sanitizeHeaders(inputName string, header []string) {
+	for i, name := range header {
+		sanitized, removed := sanitizeHeader(name)
+		if len(removed) == 0 {
+			continue
+		}
+		header[i] = sanitized
+		if logStructured() {
+			logEvent("sanitized header", "file", inputName, "column", i+1, "header", name, "sanitized", sanitized,
+				"removed", fmt.Sprintf("% x", removed))
+		} else {
+			log.Printf("Sanitized header %q of %s to %q by removing bytes % x", name, inputName, sanitized, removed)
+		}
+	}
+}
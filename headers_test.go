@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHeader(t *testing.T) {
+	for _, tt := range []struct {
+		testName    string
+		name        string
+		wantName    string
+		wantRemoved []byte
+	}{
+		{"Leaves clean header", "amount", "amount", nil},
+		{"Removes embedded NUL", "am\x00ount", "amount", []byte{0x00}},
+		{"Removes zero-width space", "\u200Bamount", "amount", []byte{0xe2, 0x80, 0x8b}},
+		{"Removes C1 control and vertical tab", "amount\u0085\v", "amount", []byte{0xc2, 0x85, 0x0b}},
+		{"Keeps visible non-ASCII", "montant_€", "montant_€", nil},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			name, removed := sanitizeHeader(tt.name)
+
+			assert.Equal(t, tt.wantName, name)
+			assert.Equal(t, tt.wantRemoved, removed)
+		})
+	}
+}
+
+func TestCheckHeaders(t *testing.T) {
+	assert.NoError(t, checkHeaders("input 1", []string{"id", "name"}))
+	assert.EqualError(t, checkHeaders("input 1", []string{"id", " ", "name"}),
+		"header of input 1, column 2: empty column name")
+	assert.EqualError(t, checkHeaders("a.csv", []string{"id", "name", "id"}),
+		`header of a.csv, column 3: duplicate column name "id"`)
+}
+
+func TestRenameHeaders(t *testing.T) {
+	logged := bytes.NewBuffer([]byte{})
+	oldLogOutput, oldLogFlags := log.Writer(), log.Flags()
+	log.SetOutput(logged)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(oldLogOutput)
+		log.SetFlags(oldLogFlags)
+	})
+
+	header := []string{"id", "", "name", "id", "id_2", "id"}
+	assert.Equal(t, 1, fillEmptyHeaders("input 1", header))
+	assert.Equal(t, 2, dedupeHeaders("input 1", header))
+	assert.Equal(t, []string{"id", "column_2", "name", "id_3", "id_2", "id_4"}, header)
+	assert.Equal(t, `Renamed header "" of input 1, column 2, to "column_2"
+Renamed header "id" of input 1, column 4, to "id_3"
+Renamed header "id" of input 1, column 6, to "id_4"
+`, logged.String())
+}
+
+func TestCsv2JsonEmptyHeaders(t *testing.T) {
+	discardLogs(t)
+
+	for _, tt := range []struct {
+		testName   string
+		csvData    string
+		colNames   []string
+		fill       bool
+		wantOutput string
+		wantErr    string
+	}{
+		{"Trailing comma", "id,name,\n1,a,\n", nil, false, "",
+			"header of input 1 has empty column names at columns 3; name them with --fill-empty-headers"},
+		{"Entirely empty", ", ,\t\n1,2,3\n", nil, false, "",
+			"header of input 1 has empty column names at columns 1, 2, 3; name them with --fill-empty-headers"},
+		{"Entirely empty filled", ", ,\t\n1,2,3\n", nil, true,
+			`[{"column_1":"1","column_2":"2","column_3":"3"}]` + "\n", ""},
+		{"Filled names do not collide", "column_3,,\n1,2,3\n", nil, true,
+			`[{"column_2":"2","column_3":"1","column_3_2":"3"}]` + "\n", ""},
+		{"Forced columns", "1,2,3\n", []string{"a", "", "c"}, false, "",
+			"--force-columns has empty column names at positions 2"},
+		{"Forced columns are not filled", "1,2,3\n", []string{"a", " ", "c"}, true, "",
+			"--force-columns has empty column names at positions 2"},
+		{"Forced columns ignore the first row", ",,\n", []string{"a", "b", "c"}, false,
+			`[{"a":"","b":"","c":""}]` + "\n", ""},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := csv2Json(conversionOptions{
+				csvInputs:        []io.Reader{strings.NewReader(tt.csvData)},
+				jsonOutput:       &buf,
+				colNames:         tt.colNames,
+				fillEmptyHeaders: tt.fill,
+			})
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.NotEqual(t, exitOK, exitCode(err))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantOutput, buf.String())
+			}
+		})
+	}
+}
+
+func TestSanitizeHeaders(t *testing.T) {
+	logged := bytes.NewBuffer([]byte{})
+	oldLogOutput, oldLogFlags := log.Writer(), log.Flags()
+	log.SetOutput(logged)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(oldLogOutput)
+		log.SetFlags(oldLogFlags)
+	})
+	jsonStream := bytes.NewBuffer([]byte{})
+
+	err := csv2Json(conversionOptions{
+		csvInputs: []io.Reader{
+			strings.NewReader("id\x00,\u200Bname\n1,ann\n"),
+			strings.NewReader("id,name\n2,bob\u200B\n"),
+		},
+		sanitizeHeaders: true,
+		// The NUL byte would otherwise be sniffed as binary data
+		noSniff:    true,
+		jsonOutput: jsonStream,
+	})
+
+	assert.NoError(t, err, "Sanitized headers of each input should match")
+	assert.JSONEq(t, `[{"id": "1", "name": "ann"}, {"id": "2", "name": "bob\u200B"}]`, jsonStream.String(),
+		"Only headers should be sanitized")
+	assert.Equal(t, `Sanitized header "id\x00" of input 1 to "id" by removing bytes 00
+Sanitized header "\u200bname" of input 1 to "name" by removing bytes e2 80 8b
+`, logged.String())
+}
+
+func TestCsv2JsonDupHeaders(t *testing.T) {
+	logged := bytes.NewBuffer([]byte{})
+	oldLogOutput, oldLogFlags := log.Writer(), log.Flags()
+	log.SetOutput(logged)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(oldLogOutput)
+		log.SetFlags(oldLogFlags)
+	})
+	csvData := "id,phone,phone\n1,555-0100,555-0199\n"
+
+	for _, tt := range []struct {
+		policy     string
+		batchSize  int
+		wantOutput string
+		wantErr    string
+		wantPolicy string
+	}{
+		{"", 0, `[{"id":"1","phone":"555-0100","phone_2":"555-0199"}]` + "\n", "", "suffix"},
+		{"error", 0, "", `header of input 1, column 3: duplicate column name "phone"`, "error"},
+		{"first", 0, `[{"id":"1","phone":"555-0100"}]` + "\n", "", "first"},
+		{"last", 0, `[{"id":"1","phone":"555-0199"}]` + "\n", "", "last"},
+		{"suffix", 0, `[{"id":"1","phone":"555-0100","phone_2":"555-0199"}]` + "\n", "", "suffix"},
+		{"array", 0, `[{"id":"1","phone":["555-0100","555-0199"]}]` + "\n", "", "array"},
+		{"array", 1, `[{"id":"1","phone":["555-0100","555-0199"]}]` + "\n", "", "array"},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			logged.Reset()
+			var buf bytes.Buffer
+			err := csv2Json(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(csvData)},
+				jsonOutput: &buf,
+				dupHeaders: tt.policy,
+				batchSize:  tt.batchSize,
+			})
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantOutput, buf.String())
+			}
+			assert.Contains(t, logged.String(),
+				`Resolved duplicate header names "phone" of input 1 with --dup-headers=`+tt.wantPolicy+"\n")
+		})
+	}
+
+	logged.Reset()
+	var buf bytes.Buffer
+	assert.NoError(t, csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader("id,phone\n1,555-0100\n")},
+		jsonOutput: &buf,
+		dupHeaders: "error",
+	}))
+	assert.Empty(t, logged.String(), "The policy should only be logged when there are duplicates")
+}
+
+func TestCsv2JsonThreeWayDupHeaders(t *testing.T) {
+	discardLogs(t)
+
+	for _, tt := range []struct {
+		testName string
+		csvData  string
+		policy   string
+		renames  map[string]string
+		wantJson string
+		wantErr  string
+	}{
+		{"Suffixes by default", "a,a,b,a\n1,2,3,4\n", "", nil, `[{"a": "1", "a_2": "2", "b": "3", "a_3": "4"}]`, ""},
+		{"Collects arrays", "a,a,b,a\n1,2,3,4\n", "array", nil, `[{"a": ["1", "2", "4"], "b": "3"}]`, ""},
+		{"Keeps the first", "a,a,b,a\n1,2,3,4\n", "first", nil, `[{"a": "1", "b": "3"}]`, ""},
+		{"Keeps the last", "a,a,b,a\n1,2,3,4\n", "last", nil, `[{"a": "4", "b": "3"}]`, ""},
+		{"Lists every duplicate", "a,b,a,b,a\n1,2,3,4,5\n", "error", nil, "",
+			`header of input 1, column 3: duplicate column names "a", "b"`},
+		{"Resolves renamed duplicates", "a,a,b\n1,2,3\n", "", map[string]string{"a": "x"},
+			`[{"x": "1", "x_2": "2", "b": "3"}]`, ""},
+		{"Rejects duplicates created by renaming", "a,b\n1,2\n", "suffix", map[string]string{"b": "a"}, "",
+			`--rename gives columns "a" and "b" the same name "a"`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := csv2Json(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(tt.csvData)},
+				jsonOutput: &buf,
+				dupHeaders: tt.policy,
+				renames:    tt.renames,
+			})
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, buf.String())
+			}
+		})
+	}
+}
+
+func TestCheckDupHeaderPolicy(t *testing.T) {
+	for _, policy := range append([]string{""}, dupHeaderPolicies...) {
+		assert.NoError(t, checkDupHeaderPolicy(policy))
+	}
+	assert.Equal(t, exitUsage, exitCode(checkDupHeaderPolicy("merge")))
+}
+
+func TestCheckHeaderMismatchPolicy(t *testing.T) {
+	for _, policy := range append([]string{""}, headerMismatchPolicies...) {
+		assert.NoError(t, checkHeaderMismatchPolicy(policy))
+	}
+	assert.EqualError(t, checkHeaderMismatchPolicy("ignore"),
+		`unknown --header-mismatch policy "ignore" (expected one of error, warn)`)
+}
+
+func TestPrintHeaders(t *testing.T) {
+	discardLogs(t)
+
+	for _, tt := range []struct {
+		testName   string
+		csvData    string
+		options    conversionOptions
+		plain      bool
+		stats      bool
+		wantOutput string
+	}{
+		{"JSON array", "\uFEFFid,name\n1,Alice\n", conversionOptions{}, false, false, `["id","name"]` + "\n"},
+		{"Plain", "id,name\n1,Alice\n", conversionOptions{}, true, false, "id\nname\n"},
+		{"Delimiter", "id;name\n1;Alice\n", conversionOptions{dialect: csvDialect{delimiter: ';'}}, false, false,
+			`["id","name"]` + "\n"},
+		{"Forced columns", "1,Alice\n", conversionOptions{colNames: []string{"n", "who"}}, false, false,
+			`["n","who"]` + "\n"},
+		{"Skipped rows and key case", "Exported today\nFirst Name,ID\nann,1\n",
+			conversionOptions{skipRows: 1, keyCase: "snake"}, false, false, `["first_name","id"]` + "\n"},
+		{"Stats", "id,phone,phone,\n", conversionOptions{}, false, true,
+			`{"columns":["id","phone","phone",""],"field_count":4,"duplicates":true,"empty":true}` + "\n"},
+		{"Plain stats", "id,name\n", conversionOptions{}, true, true,
+			"id\nname\nfield_count: 2\nduplicates: false\nempty: false\n"},
+		{"Empty input", "", conversionOptions{}, false, false, "[]\n"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.options.csvInputs = []io.Reader{strings.NewReader(tt.csvData)}
+
+			assert.NoError(t, printHeaders(&buf, tt.options, tt.plain, tt.stats))
+			assert.Equal(t, tt.wantOutput, buf.String())
+		})
+	}
+}
+
+func TestColumns(t *testing.T) {
+	// Data rows which would fail to parse, so that reading any of them is an error
+	badRows := strings.Repeat("1,\"unterminated\n", 1<<16)
+	for _, tt := range []struct {
+		testName string
+		csvData  string
+		options  conversionOptions
+		want     []string
+	}{
+		{"Header", "\uFEFFid,name\n" + badRows, conversionOptions{}, []string{"id", "name"}},
+		{"Delimiter, skipped rows, and key case", "Exported today\nFirst Name;ID\n" + badRows,
+			conversionOptions{dialect: csvDialect{delimiter: ';'}, skipRows: 1, keyCase: "snake"},
+			[]string{"first_name", "id"}},
+		{"Forced columns", badRows, conversionOptions{colNames: []string{"n", "who"}}, []string{"n", "who"}},
+		{"Duplicates and empties", "id,id,\n" + badRows, conversionOptions{}, []string{"id", "id", ""}},
+		{"Empty input", "", conversionOptions{}, []string{}},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var count int64
+			tt.options.csvInputs = []io.Reader{readCounter{strings.NewReader(tt.csvData), &count}}
+			tt.options.stats = &conversionStats{}
+
+			colNames, err := columns(tt.options)
+			assert.NoError(t, err, "No data rows should be parsed")
+			assert.Equal(t, tt.want, colNames)
+			assert.Zero(t, tt.options.stats.rowsRead, "No data rows should be read")
+			assert.Less(t, count, int64(64*1024), "Only the start of the input should be read")
+		})
+	}
+}
+
+func TestPrintHeadersReadsOnlyHeader(t *testing.T) {
+	csvData := "id,name\n" + strings.Repeat("1,Alice\n", 1<<20)
+	var count int64
+	var buf bytes.Buffer
+
+	err := printHeaders(&buf, conversionOptions{
+		csvInputs: []io.Reader{readCounter{strings.NewReader(csvData), &count}},
+	}, false, false)
+	assert.NoError(t, err)
+	assert.Equal(t, `["id","name"]`+"\n", buf.String())
+	assert.Less(t, count, int64(64*1024), "Only the start of the input should be read")
+}
+
+func TestMergeHeaderRows(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		rows     [][]string
+		sep      string
+		want     []string
+	}{
+		{
+			"Fills blank upper names from the left, including trailing blanks",
+			[][]string{{"Customer", "", "Order", ""}, {"Name", "Email", "ID", "Date"}},
+			" ",
+			[]string{"Customer Name", "Customer Email", "Order ID", "Order Date"},
+		},
+		{
+			"A blank upper name at the start is omitted",
+			[][]string{{"", "Order", ""}, {"Row", "ID", "Date"}},
+			" ",
+			[]string{"Row", "Order ID", "Order Date"},
+		},
+		{
+			"Blank lower names are not filled",
+			[][]string{{"Customer", "", "Notes"}, {"Name", "Email", ""}},
+			"_",
+			[]string{"Customer_Name", "Customer_Email", "Notes"},
+		},
+		{
+			"Joins more than two rows",
+			[][]string{{"2024", "", ""}, {"Q1", "", "Q2"}, {"Sales", "Costs", "Sales"}},
+			".",
+			[]string{"2024.Q1.Sales", "2024.Q1.Costs", "2024.Q2.Sales"},
+		},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			assert.Equal(t, tt.want, mergeHeaderRows(tt.rows, tt.sep))
+		})
+	}
+}
+
+func TestCsv2JsonHeaderRows(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		csvs     []string
+		wantJson string
+		wantErr  string
+	}{
+		{
+			"Merges the header rows of each input",
+			[]string{"Customer,,Order,\nName,Email,ID,Date\nann,a@x,1,2024-01-01\n",
+				"Customer,,Order,\nName,Email,ID,Date\nbob,b@x,2,2024-01-02\n"},
+			`[{"Customer Name":"ann","Customer Email":"a@x","Order ID":"1","Order Date":"2024-01-01"},
+				{"Customer Name":"bob","Customer Email":"b@x","Order ID":"2","Order Date":"2024-01-02"}]`,
+			"",
+		},
+		{
+			"Header rows must match across inputs",
+			[]string{"Customer,\nName,Email\n", "Customer,\nName,Phone\n"},
+			"",
+			`header of input 2 ["Customer Name" "Customer Phone"] does not match columns ["Customer Name" "Customer Email"]`,
+		},
+		{"Input must have every header row", []string{"Customer,\n"}, "", "input 1 ends within its 2 header rows"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			inputs := make([]io.Reader, len(tt.csvs))
+			for i, csv := range tt.csvs {
+				inputs[i] = strings.NewReader(csv)
+			}
+			jsonStream := bytes.NewBuffer([]byte{})
+
+			err := csv2Json(conversionOptions{csvInputs: inputs, jsonOutput: jsonStream, headerRows: 2, headerJoin: " "})
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, jsonStream.String())
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
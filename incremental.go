@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// conversionState is the structure of the file named by --state-file, which records how much of each input file was
+// converted by previous runs.
+type conversionState struct {
+	// Inputs maps the absolute path of each input file to its state
+	Inputs map[string]inputState `json:"inputs"`
+}
+
+// inputState records how much of an input file has been converted, and the header it had at the time.
+type inputState struct {
+	// Offset is the number of bytes of the file which have been converted, which end with a line break
+	Offset int64 `json:"offset"`
+	// HeaderLength is the number of bytes from the start of the file which make up its header lines, as returned by
+	// readHeaderLines(), and HeaderChecksum is their SHA-256 digest
+	HeaderLength   int64  `json:"header_length"`
+	HeaderChecksum string `json:"header_sha256"`
+}
+
+// readStateFile reads the state file named fileName. A file which does not exist is read as an empty state.
+func readStateFile(fileName string) (conversionState, error) {
+	state := conversionState{Inputs: make(map[string]inputState)}
+	data, err := ioutil.ReadFile(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, usageErrorf("invalid state file %s: %v", fileName, err)
+	}
+	if state.Inputs == nil {
+		state.Inputs = make(map[string]inputState)
+	}
+	return state, nil
+}
+
+// writeStateFile replaces the state file named fileName with state. The file is written in full before being
+// renamed into place, as by writeFileAtomically(), so that an interrupted run cannot leave a state file which is only
+// partly written.
+func writeStateFile(fileName string, state conversionState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(fileName, append(data, '\n'))
+}
+
+// headerLines describes the lines at the start of an input file which every run reads again, rather than resuming
+// after them: the `skipRows` lines discarded by --skip-rows, any "sep=" preamble line, and the `headerRows` rows of its
+// header. Lines which begin with comment, when it is set, are read along with them without being counted, as they
+// are when skipped or parsed.
+type headerLines struct {
+	skipRows   int
+	headerRows int
+	comment    rune
+}
+
+// newHeaderLines returns the headerLines of inputs read according to options, which have no header rows when
+// `options.colNames` is given.
+func newHeaderLines(options conversionOptions) headerLines {
+	lines := headerLines{skipRows: options.skipRows, comment: options.dialect.comment}
+	if len(options.colNames) == 0 {
+		lines.headerRows = 1
+		if options.headerRows > 1 {
+			lines.headerRows = options.headerRows
+		}
+	}
+	return lines
+}
+
+// withStateFile calls convert with inputs, replacing each input file named by fileNames with a resumedInput that
+// skips the data rows converted by previous runs, according to the state file named stateFileName. The header lines
+// of each file are still read, and a file whose header lines have changed is converted in full, as is a file which is
+// smaller than when it was last converted. Stdin and other inputs which are not files are always converted in full.
+// Once convert succeeds, the state file is updated to record that every input file was converted.
+func withStateFile(stateFileName string, fileNames []string, inputs []io.Reader, lines headerLines,
+	convert func(inputs []io.Reader) error) error {
+	state, err := readStateFile(stateFileName)
+	if err != nil {
+		return err
+	}
+
+	resumed := make(map[string]*resumedInput)
+	inputs = append([]io.Reader(nil), inputs...)
+	for i, input := range inputs {
+		f, ok := input.(*os.File)
+		if !ok || input == io.Reader(os.Stdin) || i >= len(fileNames) {
+			continue
+		}
+		key, err := filepath.Abs(fileNames[i])
+		if err != nil {
+			return err
+		}
+		prev, ok := state.Inputs[key]
+		r, err := resumeInput(f, prev, ok, lines)
+		if err != nil {
+			return err
+		}
+		resumed[key] = r
+		inputs[i] = r
+	}
+
+	if err := convert(inputs); err != nil {
+		return err
+	}
+	for key, r := range resumed {
+		state.Inputs[key] = r.state()
+	}
+	if err := writeStateFile(stateFileName, state); err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
+
+// resumedInput reads the header lines of an input file followed by the rest of the file from an offset beyond them,
+// up to the end of its last complete line.
+type resumedInput struct {
+	io.Reader
+	file   *os.File
+	header inputState
+	start  int64
+	end    int64
+}
+
+// resumeInput prepares to read f from the offset recorded by prev, when it has a previous state, unless its header
+// lines no longer match or it is now smaller than the offset. Otherwise, f is read in full. Either way, the header
+// lines described by lines are read first, and reading stops after the last line break, so that a line which is
+// still being written is left for a later run.
+func resumeInput(f *os.File, prev inputState, hasPrev bool, lines headerLines) (*resumedInput, error) {
+	r := &resumedInput{file: f}
+	var err error
+	if r.header, err = readHeaderLines(f, lines); err != nil {
+		return nil, err
+	}
+	r.start = r.header.HeaderLength
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if hasPrev {
+		switch {
+		case prev.HeaderLength != r.header.HeaderLength || prev.HeaderChecksum != r.header.HeaderChecksum:
+			logFullConversion(f.Name(), "its header changed")
+		case info.Size() < prev.Offset:
+			logFullConversion(f.Name(), "it is smaller than when it was last converted")
+		default:
+			r.start = prev.Offset
+		}
+	}
+	if r.end, err = lastLineEnd(f, r.start, info.Size()); err != nil {
+		return nil, err
+	}
+
+	r.Reader = io.MultiReader(io.NewSectionReader(f, 0, r.header.HeaderLength),
+		io.NewSectionReader(f, r.start, r.end-r.start))
+	return r, nil
+}
+
+// Name returns the name of the input file, for inputName().
+func (r *resumedInput) Name() string {
+	return r.file.Name()
+}
+
+// Stat describes the input file, for fileMeta().
+func (r *resumedInput) Stat() (os.FileInfo, error) {
+	return r.file.Stat()
+}
+
+// state returns the state of the input file once everything read from it has been converted.
+func (r *resumedInput) state() inputState {
+	s := r.header
+	s.Offset = r.end
+	return s
+}
+
+// lastLineEnd returns the offset just past the last line break of f between start and end, or start if there is none.
+func lastLineEnd(f *os.File, start, end int64) (int64, error) {
+	buf := make([]byte, 4096)
+	for end > start {
+		n := int64(len(buf))
+		if end-start < n {
+			n = end - start
+		}
+		if _, err := f.ReadAt(buf[:n], end-n); err != nil {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return end - n + int64(i) + 1, nil
+		}
+		end -= n
+	}
+	return start, nil
+}
+
+// readHeaderLines returns the length and checksum of the header lines described by lines at the start of f. Header
+// rows which span several lines because of quoted line breaks are therefore only partly checked, and blank lines,
+// which are not parsed as rows, are read without being counted as header rows.
+func readHeaderLines(f *os.File, lines headerLines) (inputState, error) {
+	br := bufio.NewReader(io.NewSectionReader(f, 0, 1<<62))
+	var header []byte
+	hasPreamble := false
+	for numSkipped, numRows := 0, 0; numSkipped < lines.skipRows || numRows < lines.headerRows; {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return inputState{}, err
+		}
+		isFirst := len(header) == 0
+		header = append(header, line...)
+		if err == io.EOF {
+			break
+		}
+		text := string(line)
+		if isFirst {
+			text = strings.TrimPrefix(text, "\uFEFF")
+		}
+		switch {
+		case lines.comment != 0 && strings.HasPrefix(text, string(lines.comment)):
+		case numSkipped < lines.skipRows:
+			numSkipped++
+		case numRows == 0 && !hasPreamble && strings.HasPrefix(text, "sep="):
+			// A preamble line follows any skipped lines, since they are skipped before anything else reads the input
+			hasPreamble = true
+		case strings.TrimRight(text, "\r\n") != "":
+			numRows++
+		}
+	}
+	sum := sha256.Sum256(header)
+	return inputState{HeaderLength: int64(len(header)), HeaderChecksum: hex.EncodeToString(sum[:])}, nil
+}
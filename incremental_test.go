@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"github.com/integrii/flaggy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readCounter adds the number of bytes read from an io.Reader to a running count, so tests can check how much of an
+// input was read.
+type readCounter struct {
+	r     io.Reader
+	count *int64
+}
+
+func (c readCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	*c.count += int64(n)
+	return n, err
+}
+
+// convertWithState converts the named file to JSON according to options as a run with the given state file would,
+// returning the JSON.
+func convertWithState(t *testing.T, stateFileName, fileName string, options conversionOptions) string {
+	f, err := os.Open(fileName)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var buf bytes.Buffer
+	err = withStateFile(stateFileName, []string{fileName}, []io.Reader{f}, newHeaderLines(options),
+		func(inputs []io.Reader) error {
+			options.csvInputs, options.jsonOutput, options.batchSize = inputs, &buf, 1
+			return csv2Json(options)
+		})
+	require.NoError(t, err)
+	return buf.String()
+}
+
+// appendToFile appends data to the named file, creating it if need be.
+func appendToFile(t *testing.T, fileName, data string) {
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func TestWithStateFile(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+
+	dir := t.TempDir()
+	stateFileName := filepath.Join(dir, ".csv2json.state")
+	fileName := filepath.Join(dir, "events.csv")
+	convert := func() string {
+		return convertWithState(t, stateFileName, fileName, conversionOptions{})
+	}
+
+	appendToFile(t, fileName, "sep=;\nid;n\n1;a\n2;b\n")
+	assert.Equal(t, "[{\"id\":\"1\",\"n\":\"a\"}]\n[{\"id\":\"2\",\"n\":\"b\"}]\n",
+		convert(), "First run should convert every row")
+
+	appendToFile(t, fileName, "3;c\n")
+	assert.Equal(t, "[{\"id\":\"3\",\"n\":\"c\"}]\n", convert(),
+		"Second run should convert only the appended row")
+	assert.Empty(t, convert(), "Unchanged file should have no rows to convert")
+	assert.Empty(t, logs.String())
+
+	require.NoError(t, ioutil.WriteFile(fileName, []byte("id,name,n\n4,d,e\n5,f,g\n6,h,i\n"), 0644))
+	assert.Equal(t, "[{\"id\":\"4\",\"n\":\"e\",\"name\":\"d\"}]\n[{\"id\":\"5\",\"n\":\"g\",\"name\":\"f\"}]\n"+
+		"[{\"id\":\"6\",\"n\":\"i\",\"name\":\"h\"}]\n", convert(),
+		"Rewritten file with a new header should be converted in full")
+	assert.Equal(t, "Converting "+fileName+" in full because its header changed\n", logs.String())
+
+	logs.Reset()
+	require.NoError(t, ioutil.WriteFile(fileName, []byte("id,name,n\n7,j,k\n"), 0644))
+	assert.Equal(t, "[{\"id\":\"7\",\"n\":\"k\",\"name\":\"j\"}]\n", convert(),
+		"Rewritten file which shrank should be converted in full")
+	assert.Equal(t, "Converting "+fileName+" in full because it is smaller than when it was last converted\n",
+		logs.String())
+}
+
+func TestWithStateFileHeaderLines(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		options  conversionOptions
+		csvData  string
+		appended string
+		wantJson string
+	}{
+		{
+			"Skipped rows",
+			conversionOptions{skipRows: 2},
+			"exported today\n\nid,n\n1,a\n",
+			"2,b\n",
+			"[{\"id\":\"2\",\"n\":\"b\"}]\n",
+		},
+		{
+			"Skipped rows with a preamble",
+			conversionOptions{skipRows: 1},
+			"exported today\nsep=;\nid;n\n1;a\n",
+			"2;b\n",
+			"[{\"id\":\"2\",\"n\":\"b\"}]\n",
+		},
+		{
+			"Skipped rows and comments",
+			conversionOptions{skipRows: 1, dialect: csvDialect{comment: '#'}},
+			"# exported\nexported today\n# columns\nid,n\n1,a\n",
+			"2,b\n",
+			"[{\"id\":\"2\",\"n\":\"b\"}]\n",
+		},
+		{
+			"Several header rows",
+			conversionOptions{headerRows: 2, headerJoin: "_"},
+			"id,n\n,x\n1,a\n",
+			"2,b\n",
+			"[{\"id\":\"2\",\"n_x\":\"b\"}]\n",
+		},
+		{
+			"Skipped rows without a header",
+			conversionOptions{skipRows: 1, colNames: []string{"id", "n"}},
+			"exported today\n1,a\n",
+			"2,b\n",
+			"[{\"id\":\"2\",\"n\":\"b\"}]\n",
+		},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			dir := t.TempDir()
+			stateFileName := filepath.Join(dir, "state.json")
+			fileName := filepath.Join(dir, "events.csv")
+			appendToFile(t, fileName, tt.csvData)
+			convertWithState(t, stateFileName, fileName, tt.options)
+
+			appendToFile(t, fileName, tt.appended)
+			assert.Equal(t, tt.wantJson, convertWithState(t, stateFileName, fileName, tt.options))
+		})
+	}
+}
+
+func TestWithStateFilePartialLine(t *testing.T) {
+	dir := t.TempDir()
+	stateFileName := filepath.Join(dir, "state.json")
+	fileName := filepath.Join(dir, "events.csv")
+	convert := func() string {
+		return convertWithState(t, stateFileName, fileName, conversionOptions{})
+	}
+
+	appendToFile(t, fileName, "id,n\n1,a\n2,")
+	assert.Equal(t, "[{\"id\":\"1\",\"n\":\"a\"}]\n", convert(),
+		"A line still being written should be left for a later run")
+	assert.Empty(t, convert())
+
+	appendToFile(t, fileName, "b\n3,c")
+	assert.Equal(t, "[{\"id\":\"2\",\"n\":\"b\"}]\n", convert(),
+		"The completed line should be converted in full")
+
+	appendToFile(t, fileName, "\n")
+	assert.Equal(t, "[{\"id\":\"3\",\"n\":\"c\"}]\n", convert())
+}
+
+func TestCliStateFileWithLimit(t *testing.T) {
+	for _, flag := range []string{"--limit", "--offset"} {
+		t.Run(flag, func(t *testing.T) {
+			os.Args = []string{"csv2json", "--state-file", filepath.Join(t.TempDir(), "state.json"), flag, "1",
+				os.DevNull}
+			flaggy.ResetParser()
+
+			err := runCli()
+			assert.EqualError(t, err, "--state-file cannot be combined with --limit or --offset")
+			assert.Equal(t, exitUsage, exitCode(err))
+		})
+	}
+}
+
+func TestWithStateFileFailure(t *testing.T) {
+	dir := t.TempDir()
+	stateFileName := filepath.Join(dir, "state.json")
+	fileName := filepath.Join(dir, "events.csv")
+	require.NoError(t, ioutil.WriteFile(fileName, []byte("id\n1\n"), 0644))
+	f, err := os.Open(fileName)
+	require.NoError(t, err)
+	defer f.Close()
+
+	err = withStateFile(stateFileName, []string{fileName}, []io.Reader{f}, headerLines{headerRows: 1},
+		func(inputs []io.Reader) error {
+			return errNoRows
+		})
+
+	assert.Equal(t, errNoRows, err)
+	_, statErr := os.Stat(stateFileName)
+	assert.True(t, os.IsNotExist(statErr), "State should not be recorded when conversion fails")
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// maxSafeInteger is the largest magnitude of integer which every JSON parser can represent exactly, since many
+// parse numbers as float64. Larger integers are inferred as strings, so that they do not lose precision.
+const maxSafeInteger = 1<<53 - 1
+
+// inferValue returns value as the JSON value it looks like: nil when it is empty, a bool when it is true or false
+// (in any case), a json.Number when it is already written as a JSON number, or else value itself. Numbers are kept
+// exactly as written, while those which would lose information, such as integers with leading zeros or beyond
+// maxSafeInteger, and numbers out of the range of float64, are kept as strings.
+func inferValue(value string) interface{} {
+	switch {
+	case value == "":
+		return nil
+	case strings.EqualFold(value, "true"):
+		return true
+	case strings.EqualFold(value, "false"):
+		return false
+	case isJsonNumber(value):
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			if n > maxSafeInteger || n < -maxSafeInteger {
+				return value
+			}
+		} else if strings.IndexAny(value, ".eE") < 0 {
+			// Too large for int64
+			return value
+		} else if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return value
+		}
+		return json.Number(value)
+	}
+	return value
+}
+
+// isJsonNumber reports whether s is written as a number by the JSON grammar, which has no leading zeros, leading
+// plus sign, surrounding whitespace, or missing digits around a decimal point.
+func isJsonNumber(s string) bool {
+	return s != "" && (s[0] == '-' || isDigit(s[0])) && isDigit(s[len(s)-1]) && json.Valid([]byte(s))
+}
+
+// inferFieldValue returns the value inferred by inferValue() for v when it is a string, or for each of its
+// elements when it is a []string, or else v itself.
+func inferFieldValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return inferValue(v)
+	case []string:
+		values := make([]interface{}, len(v))
+		for i, s := range v {
+			values[i] = inferValue(s)
+		}
+		return values
+	}
+	return v
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestInferValue(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		want  interface{}
+	}{
+		{"", nil},
+		{"true", true},
+		{"FALSE", false},
+		{"42", json.Number("42")},
+		{"-7", json.Number("-7")},
+		{"0", json.Number("0")},
+		{"3.14", json.Number("3.14")},
+		{"0.50", json.Number("0.50")},
+		{"6.02e23", json.Number("6.02e23")},
+		{"1E-9", json.Number("1E-9")},
+		{"9007199254740991", json.Number("9007199254740991")},
+		{"9007199254740992", "9007199254740992"},
+		{"-9007199254740992", "-9007199254740992"},
+		{"123456789012345678901234567890", "123456789012345678901234567890"},
+		{"1e400", "1e400"},
+		{"007", "007"},
+		{"+1", "+1"},
+		{".5", ".5"},
+		{"1.", "1."},
+		{" 1", " 1"},
+		{"NaN", "NaN"},
+		{"Inf", "Inf"},
+		{"0x1F", "0x1F"},
+		{"yes", "yes"},
+		{"truthy", "truthy"},
+	} {
+		t.Run(tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.want, inferValue(tt.value))
+		})
+	}
+}
+
+func TestCsv2JsonInferTypes(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		csv      string
+		options  conversionOptions
+		wantJson string
+	}{
+		{"Strings by default", "id,n,ok\n007,1.5,true\n", conversionOptions{},
+			`[{"id":"007","n":"1.5","ok":"true"}]` + "\n"},
+		{"Mixed-type columns", "id,v\n1,42\n2,abc\n3,\n4,TRUE\n5,-1.5e3\n", conversionOptions{inferTypes: true},
+			`[{"id":1,"v":42},{"id":2,"v":"abc"},{"id":3,"v":null},{"id":4,"v":true},{"id":5,"v":-1.5e3}]` + "\n"},
+		{"Keeps values which would lose information", "zip,big\n02134,12345678901234567890\n",
+			conversionOptions{inferTypes: true}, `[{"big":"12345678901234567890","zip":"02134"}]` + "\n"},
+		{"Plucked values", "id\n1\nx\n", conversionOptions{inferTypes: true, pluck: "id"}, `[1,"x"]` + "\n"},
+		{"Collapsed duplicate headers", "n,n\n1,\n", conversionOptions{inferTypes: true, dupHeaders: "array"},
+			`[{"n":[1,null]}]` + "\n"},
+		{"NDJSON", "n\n1\n2.5\n", conversionOptions{inferTypes: true, ndjson: true}, "{\"n\":1}\n{\"n\":2.5}\n"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+			options := tt.options
+			options.csvInputs = []io.Reader{strings.NewReader(tt.csv)}
+			options.jsonOutput = jsonStream
+
+			err := csv2Json(options)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantJson, jsonStream.String())
+		})
+	}
+}
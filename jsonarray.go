@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// jsonArrayWriter writes values to w as the elements of a JSON array one at a time, so that the array is never
+// held in memory. The array is written exactly as json.Encoder would encode it, including when indented.
+type jsonArrayWriter struct {
+	w           io.Writer
+	indent      string
+	numElements int
+}
+
+// newJsonArrayWriter returns a jsonArrayWriter which writes to w, indenting by indent spaces per level when it is
+// set, as by newJsonEncoder().
+func newJsonArrayWriter(w io.Writer, indent int) *jsonArrayWriter {
+	return &jsonArrayWriter{w: w, indent: strings.Repeat(" ", indent)}
+}
+
+// write writes value as the next element of the array.
+// Returns an *outputError for any error encoding or writing it.
+func (a *jsonArrayWriter) write(value interface{}) error {
+	var data []byte
+	var err error
+	if a.indent == "" {
+		data, err = json.Marshal(value)
+	} else {
+		data, err = json.MarshalIndent(value, a.indent, a.indent)
+	}
+	if err != nil {
+		return &outputError{err}
+	}
+
+	sep := ","
+	if a.numElements == 0 {
+		sep = "["
+	}
+	if a.indent != "" {
+		sep += "\n" + a.indent
+	}
+	a.numElements++
+	if _, err := a.w.Write(append([]byte(sep), data...)); err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
+
+// close ends the array, followed by a newline, which is empty when no elements were written.
+// Returns an *outputError for any error writing it.
+func (a *jsonArrayWriter) close() error {
+	end := "]\n"
+	if a.numElements == 0 {
+		end = "[]\n"
+	} else if a.indent != "" {
+		end = "\n]\n"
+	}
+	if _, err := io.WriteString(a.w, end); err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
+
+// batchElements returns each of the values in a batch which csv2Json() emits (records, records with collapsed
+// duplicate fields, or plucked values), so that each can be emitted by itself, as a line of NDJSON or an element
+// written by a jsonArrayWriter.
+func batchElements(values interface{}) []interface{} {
+	switch values := values.(type) {
+	case []record:
+		lines := make([]interface{}, len(values))
+		for i, rec := range values {
+			lines[i] = rec
+		}
+		return lines
+	case []map[string]interface{}:
+		lines := make([]interface{}, len(values))
+		for i, rec := range values {
+			lines[i] = rec
+		}
+		return lines
+	case []interface{}:
+		return values
+	}
+	return []interface{}{values}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestJsonArrayWriter(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		values   []interface{}
+		indent   int
+	}{
+		{"Empty", []interface{}{}, 0},
+		{"Empty indented", []interface{}{}, 2},
+		{"Records", []interface{}{record{"a": "1", "b": "<2>"}, record{"a": "3"}}, 0},
+		{"Indented records", []interface{}{record{"a": "1", "b": "<2>"}, record{"a": "3"}}, 2},
+		{"Nested values", []interface{}{map[string]interface{}{"a": []string{"1", "2"}}, nil, "x"}, 4},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var want, got bytes.Buffer
+			require.NoError(t, newJsonEncoder(&want, tt.indent).Encode(tt.values))
+			array := newJsonArrayWriter(&got, tt.indent)
+
+			for _, value := range tt.values {
+				assert.NoError(t, array.write(value))
+			}
+			assert.NoError(t, array.close())
+
+			assert.Equal(t, want.String(), got.String(), "Should be written as json.Encoder encodes the array")
+		})
+	}
+}
+
+func TestJsonArrayWriterEncodingError(t *testing.T) {
+	err := newJsonArrayWriter(ioutil.Discard, 0).write(func() {})
+
+	var outErr *outputError
+	assert.ErrorAs(t, err, &outErr)
+}
+
+// readerFunc is an io.Reader which reads by calling itself.
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestCsv2JsonStreamsArray(t *testing.T) {
+	jsonStream := bytes.NewBuffer([]byte{})
+	var writtenBeforeEnd string
+	input := io.MultiReader(strings.NewReader("a\n1\n2\n3\n4\n5\n"), readerFunc(func(p []byte) (int, error) {
+		writtenBeforeEnd = jsonStream.String()
+		return copy(p, "6\n"), io.EOF
+	}))
+
+	err := csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{input},
+		jsonOutput: jsonStream,
+		// Sniffing the format would read ahead of the first rows
+		noSniff: true,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, `[{"a":"1"},{"a":"2"},{"a":"3"},{"a":"4"},{"a":"5"}`, writtenBeforeEnd,
+		"Records should be written as they are read")
+	assert.Equal(t, `[{"a":"1"},{"a":"2"},{"a":"3"},{"a":"4"},{"a":"5"},{"a":"6"}]`+"\n", jsonStream.String())
+}
+
+func TestCsv2JsonStreamedArrayErrors(t *testing.T) {
+	discardLogs(t)
+
+	for _, tt := range []struct {
+		testName   string
+		csv        string
+		skipErrors bool
+		wantJson   string
+		wantErr    string
+	}{
+		{"Skipped rows are omitted", "a\n1\n2,3\n4\n", true, `[{"a":"1"},{"a":"4"}]` + "\n", ""},
+		{"Skipping every row leaves an empty array", "a\n1,2\n3,4\n", true, "[]\n", ""},
+		{"Errors leave the array unterminated", "a\n1\n2,3\n4\n", false, `[{"a":"1"}`,
+			"record on line 3: wrong number of fields"},
+		{"Header errors emit nothing", "a,\"b\n", false, "",
+			`parse error on line 1, column 6: extraneous or missing " in quoted-field`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+
+			err := csv2Json(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(tt.csv)},
+				jsonOutput: jsonStream,
+				skipErrors: tt.skipErrors,
+			})
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+			assert.Equal(t, tt.wantJson, jsonStream.String())
+		})
+	}
+}
+
+// generatedCsv is an io.Reader of a header followed by `rows` data rows, each generated as it is read, so that the
+// input is never held in memory.
+type generatedCsv struct {
+	rows    int
+	row     int
+	pending []byte
+}
+
+func (g *generatedCsv) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(g.pending) == 0 {
+			if g.row > g.rows {
+				break
+			} else if g.row == 0 {
+				g.pending = []byte("id,name,amount\n")
+			} else {
+				g.pending = []byte(fmt.Sprintf("%d,name %d,%d.%02d\n", g.row, g.row, g.row%1000, g.row%100))
+			}
+			g.row++
+		}
+		copied := copy(p[n:], g.pending)
+		n += copied
+		g.pending = g.pending[copied:]
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// heapSampler is an io.Writer which discards what is written to it, recording the most heap memory in use after
+// every `every` writes.
+type heapSampler struct {
+	every  int
+	writes int
+	peak   uint64
+}
+
+func (s *heapSampler) Write(p []byte) (int, error) {
+	s.writes++
+	if s.writes%s.every == 0 {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		if stats.HeapInuse > s.peak {
+			s.peak = stats.HeapInuse
+		}
+	}
+	return len(p), nil
+}
+
+// BenchmarkCsv2Json reports the most heap memory in use while converting generated inputs of increasing size,
+// which stays roughly constant, since records are streamed rather than held in memory.
+func BenchmarkCsv2Json(b *testing.B) {
+	for _, rows := range []int{10000, 100000, 1000000, 3000000} {
+		b.Run(fmt.Sprintf("%d rows", rows), func(b *testing.B) {
+			b.ReportAllocs()
+			var peak uint64
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+				output := &heapSampler{every: 1000}
+
+				err := csv2Json(conversionOptions{
+					csvInputs:  []io.Reader{&generatedCsv{rows: rows}},
+					jsonOutput: output,
+				})
+
+				require.NoError(b, err)
+				if output.peak > peak {
+					peak = output.peak
+				}
+			}
+			b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MiB")
+		})
+	}
+}
+
+func TestCsv2JsonNdjson(t *testing.T) {
+	discardLogs(t)
+
+	for _, tt := range []struct {
+		testName string
+		csv      string
+		options  conversionOptions
+		wantJson string
+		wantErr  string
+	}{
+		{"One record per line", "a,b\n1,2\n3,4\n", conversionOptions{},
+			`{"a":"1","b":"2"}` + "\n" + `{"a":"3","b":"4"}` + "\n", ""},
+		{"Empty input", "a,b\n", conversionOptions{}, "", ""},
+		{"Skips errors per line", "a,b\n1,2\n3\n4,5\n", conversionOptions{skipErrors: true},
+			`{"a":"1","b":"2"}` + "\n" + `{"a":"4","b":"5"}` + "\n", ""},
+		{"Plucked values", "a,b\n1,2\n3,4\n", conversionOptions{pluck: "b"}, `"2"` + "\n" + `"4"` + "\n", ""},
+		{"Collapsed duplicate headers", "a,a\n1,2\n", conversionOptions{dupHeaders: "array"},
+			`{"a":["1","2"]}` + "\n", ""},
+		{"Batches are streamed alike", "a\n1\n2\n3\n", conversionOptions{batchSize: 2},
+			`{"a":"1"}` + "\n" + `{"a":"2"}` + "\n" + `{"a":"3"}` + "\n", ""},
+		{"Records are not held in memory", "a\n1\n2\n3\n", conversionOptions{maxRecords: 1},
+			`{"a":"1"}` + "\n" + `{"a":"2"}` + "\n" + `{"a":"3"}` + "\n", ""},
+		{"Reordered records are held in memory", "a\n1\n2\n3\n", conversionOptions{reverse: true, maxRecords: 1}, "",
+			"input exceeds 1 records; rerun with streaming output or raise the limit"},
+		{"Reversed records", "a\n1\n2\n3\n", conversionOptions{reverse: true},
+			`{"a":"3"}` + "\n" + `{"a":"2"}` + "\n" + `{"a":"1"}` + "\n", ""},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+			options := tt.options
+			options.csvInputs = []io.Reader{strings.NewReader(tt.csv)}
+			options.jsonOutput = jsonStream
+			options.ndjson = true
+
+			err := csv2Json(options)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantJson, jsonStream.String())
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBatchElements(t *testing.T) {
+	assert.Equal(t, []interface{}{record{"a": "1"}, record{"a": "2"}},
+		batchElements([]record{{"a": "1"}, {"a": "2"}}))
+	assert.Equal(t, []interface{}{map[string]interface{}{"a": []string{"1", "2"}}},
+		batchElements([]map[string]interface{}{{"a": []string{"1", "2"}}}))
+	assert.Equal(t, []interface{}{"1", nil}, batchElements([]interface{}{"1", nil}))
+	assert.Empty(t, batchElements([]record{}))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/segmentio/kafka-go"
+	"log"
+	"time"
+)
+
+// kafkaOptions is used to configure publishing converted records to a Kafka topic when calling csv2Kafka().
+type kafkaOptions struct {
+	brokers   []string
+	topic     string
+	keyColumn string
+	acks      string
+	batchSize int
+}
+
+// messageProducer publishes messages to a message broker. It is satisfied by *kafka.Writer.
+type messageProducer interface {
+	// WriteMessages blocks until msgs are delivered. When only some messages could not be delivered,
+	// the returned error is a kafka.WriteErrors with a non-nil error at the index of each failed message.
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	// Close flushes any pending messages and releases the producer's resources.
+	Close() error
+}
+
+// newKafkaWriter creates a *kafka.Writer which publishes to `kafkaOpts.topic`.
+// Messages with the same key are always published to the same partition.
+func newKafkaWriter(kafkaOpts kafkaOptions) (*kafka.Writer, error) {
+	if kafkaOpts.topic == "" {
+		return nil, usageErrorf("a Kafka topic is required")
+	}
+	var acks kafka.RequiredAcks
+	if err := acks.UnmarshalText([]byte(kafkaOpts.acks)); err != nil {
+		return nil, usageErrorf("invalid Kafka acks %q (expected none, one, or all)", kafkaOpts.acks)
+	}
+
+	return &kafka.Writer{
+		Addr:         kafka.TCP(kafkaOpts.brokers...),
+		Topic:        kafkaOpts.topic,
+		Balancer:     &kafka.Hash{},
+		BatchSize:    kafkaOpts.batchSize,
+		BatchTimeout: 10 * time.Millisecond,
+		RequiredAcks: acks,
+	}, nil
+}
+
+// csv2Kafka converts CSV data from `options.csvInputs` and publishes each record as a JSON message via producer,
+// in batches of up to `kafkaOpts.batchSize` messages. When `kafkaOpts.keyColumn` is set, each message is keyed
+// by the record's value for that column. Messages which cannot be delivered abort publishing, or are logged and
+// skipped when `options.skipErrors` is set. Delivery stops with the error of `options.ctx` once it is done, and
+// records are only counted as converted by `options.stats` once delivered. The producer is always closed, which
+// flushes any pending messages. Returns any errors from reading CSV, delivering messages, or closing the producer.
+func csv2Kafka(options conversionOptions, kafkaOpts kafkaOptions, producer messageProducer) (err error) {
+	defer func() {
+		if closeErr := producer.Close(); err == nil && closeErr != nil {
+			err = &outputError{closeErr}
+		}
+	}()
+
+	reader, colNames, err := newCsvRowReader(options)
+	if err != nil {
+		return err
+	}
+	if kafkaOpts.keyColumn != "" && !containsString(colNames, kafkaOpts.keyColumn) {
+		return usageErrorf("unknown Kafka key column %q", kafkaOpts.keyColumn)
+	}
+	// Records are only converted once delivered, which may fail after eachRow() has accepted them
+	reader.countDelivered = true
+
+	numUndelivered := 0
+	defer func() {
+		if numUndelivered > 0 && logStructured() {
+			logEvent("skipped undelivered records", "count", numUndelivered)
+		} else if numUndelivered > 0 {
+			log.Printf("Skipped %d records (rows) which could not be delivered", numUndelivered)
+		}
+	}()
+
+	pending := make([]kafka.Message, 0, kafkaOpts.batchSize)
+	pendingRowNums := make([]int, 0, kafkaOpts.batchSize)
+	deliver := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		defer func() {
+			pending = pending[:0]
+			pendingRowNums = pendingRowNums[:0]
+		}()
+
+		ctx := options.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		// Each record is counted as converted once its message is delivered
+		err := producer.WriteMessages(ctx, pending...)
+		var writeErrs kafka.WriteErrors
+		if err == nil {
+			if options.stats != nil {
+				options.stats.rowsConverted += len(pending)
+			}
+			return nil
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		} else if !errors.As(err, &writeErrs) {
+			return &outputError{err}
+		}
+		if options.stats != nil {
+			options.stats.rowsConverted += len(writeErrs) - writeErrs.Count()
+		}
+		for i, msgErr := range writeErrs {
+			if msgErr == nil {
+				continue
+			}
+			rowErr := &rowError{pendingRowNums[i], fmt.Errorf("message could not be delivered: %w", msgErr)}
+			if !options.skipErrors {
+				return &outputError{rowErr}
+			}
+			numUndelivered++
+			if options.stats != nil {
+				options.stats.rowSkipped(0, rowErr.row, rowErr)
+			}
+			if logStructured() {
+				logEvent("skipped undelivered record", "row", rowErr.row, "err", msgErr)
+			} else {
+				log.Print(rowErr)
+			}
+		}
+		return nil
+	}
+
+	if err := eachRow(reader, options.skipErrors, func(rowNum int, rowFields []string) error {
+		thisRecord := reader.record(colNames, rowFields)
+		value, err := json.Marshal(fileMetaRecord(thisRecord, options.fileMeta))
+		if err != nil {
+			return err
+		}
+		msg := kafka.Message{Value: value}
+		if kafkaOpts.keyColumn != "" {
+			msg.Key = []byte(thisRecord[kafkaOpts.keyColumn])
+		}
+
+		pending = append(pending, msg)
+		pendingRowNums = append(pendingRowNums, rowNum)
+		if len(pending) >= kafkaOpts.batchSize {
+			return deliver()
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return deliver()
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+// fakeProducer records published messages and fails delivery of those whose values are listed in failValues.
+type fakeProducer struct {
+	failValues map[string]bool
+	batches    [][]kafka.Message
+	closed     bool
+}
+
+func (p *fakeProducer) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if p.closed {
+		return errors.New("write to closed producer")
+	} else if err := ctx.Err(); err != nil {
+		return err
+	}
+	batch := make([]kafka.Message, 0, len(msgs))
+	writeErrs := make(kafka.WriteErrors, len(msgs))
+	for i, msg := range msgs {
+		if p.failValues[string(msg.Value)] {
+			writeErrs[i] = kafka.RequestTimedOut
+			continue
+		}
+		batch = append(batch, msg)
+	}
+	p.batches = append(p.batches, batch)
+	if writeErrs.Count() > 0 {
+		return writeErrs
+	}
+	return nil
+}
+
+func (p *fakeProducer) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestCsv2Kafka(t *testing.T) {
+	// Log errors to nowhere while this test runs
+	discardLogs(t)
+
+	csvData := "id,name\n1,ann\n2,bob\n3,cat\n"
+
+	for _, tt := range []struct {
+		testName    string
+		keyColumn   string
+		failValues  []string
+		skipErrors  bool
+		wantBatches [][]kafka.Message
+		wantStats   conversionStats
+		wantErr     string
+	}{
+		{
+			"Publishes unkeyed messages in batches",
+			"",
+			nil,
+			false,
+			[][]kafka.Message{
+				{{Value: []byte(`{"id":"1","name":"ann"}`)}, {Value: []byte(`{"id":"2","name":"bob"}`)}},
+				{{Value: []byte(`{"id":"3","name":"cat"}`)}},
+			},
+			conversionStats{rowsRead: 3, rowsConverted: 3},
+			"",
+		},
+		{
+			"Publishes messages keyed by column",
+			"id",
+			nil,
+			false,
+			[][]kafka.Message{
+				{
+					{Key: []byte("1"), Value: []byte(`{"id":"1","name":"ann"}`)},
+					{Key: []byte("2"), Value: []byte(`{"id":"2","name":"bob"}`)},
+				},
+				{{Key: []byte("3"), Value: []byte(`{"id":"3","name":"cat"}`)}},
+			},
+			conversionStats{rowsRead: 3, rowsConverted: 3},
+			"",
+		},
+		{
+			"Delivery errors abort by default",
+			"",
+			[]string{`{"id":"2","name":"bob"}`},
+			false,
+			[][]kafka.Message{{{Value: []byte(`{"id":"1","name":"ann"}`)}}},
+			conversionStats{rowsRead: 1, rowsConverted: 1},
+			"row 2: message could not be delivered: " + kafka.RequestTimedOut.Error(),
+		},
+		{
+			"Delivery errors can be skipped",
+			"",
+			[]string{`{"id":"2","name":"bob"}`},
+			true,
+			[][]kafka.Message{
+				{{Value: []byte(`{"id":"1","name":"ann"}`)}},
+				{{Value: []byte(`{"id":"3","name":"cat"}`)}},
+			},
+			conversionStats{rowsRead: 3, rowsConverted: 2, rowsSkipped: 1},
+			"",
+		},
+		{
+			"Unknown key column is an error",
+			"email",
+			nil,
+			false,
+			nil,
+			conversionStats{},
+			`unknown Kafka key column "email"`,
+		},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			producer := &fakeProducer{failValues: make(map[string]bool)}
+			for _, v := range tt.failValues {
+				producer.failValues[v] = true
+			}
+
+			stats := &conversionStats{}
+			err := csv2Kafka(conversionOptions{
+				csvInputs:  []io.Reader{bytes.NewReader([]byte(csvData))},
+				skipErrors: tt.skipErrors,
+				stats:      stats,
+			}, kafkaOptions{topic: "rows", keyColumn: tt.keyColumn, batchSize: 2}, producer)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+			assert.Equal(t, tt.wantBatches, producer.batches)
+			assert.True(t, producer.closed, "Producer must be closed to flush pending messages")
+			assert.Equal(t, tt.wantStats.rowsRead, stats.rowsRead)
+			assert.Equal(t, tt.wantStats.rowsConverted, stats.rowsConverted)
+			assert.Equal(t, tt.wantStats.rowsSkipped, stats.rowsSkipped)
+		})
+	}
+}
+
+func TestCsv2KafkaContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	producer := &fakeProducer{}
+	stats := &conversionStats{}
+
+	err := csv2Kafka(conversionOptions{
+		csvInputs: []io.Reader{strings.NewReader("id\n1\n")},
+		ctx:       ctx,
+		stats:     stats,
+	}, kafkaOptions{topic: "rows", batchSize: 2}, producer)
+
+	assert.Equal(t, context.Canceled, err)
+	assert.Empty(t, producer.batches)
+	assert.Equal(t, 0, stats.rowsConverted)
+}
+
+func TestNewKafkaWriter(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		options  kafkaOptions
+		wantAcks kafka.RequiredAcks
+		wantErr  string
+	}{
+		{"Requires all acks", kafkaOptions{topic: "rows", acks: "all"}, kafka.RequireAll, ""},
+		{"Requires one ack", kafkaOptions{topic: "rows", acks: "one"}, kafka.RequireOne, ""},
+		{"Requires no acks", kafkaOptions{topic: "rows", acks: "none"}, kafka.RequireNone, ""},
+		{"Invalid acks", kafkaOptions{topic: "rows", acks: "some"}, 0, `invalid Kafka acks "some" (expected none, one, or all)`},
+		{"Missing topic", kafkaOptions{acks: "all"}, 0, "a Kafka topic is required"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			tt.options.brokers = []string{"localhost:9092"}
+
+			writer, err := newKafkaWriter(tt.options)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantAcks, writer.RequiredAcks)
+				assert.Equal(t, "rows", writer.Topic)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
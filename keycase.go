@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// keyCases are the cases accepted by --key-case, to which header names are converted.
+var keyCases = []string{"snake", "camel", "lower", "upper"}
+
+// checkKeyCase returns a *usageError unless keyCase is empty or one of keyCases.
+func checkKeyCase(keyCase string) error {
+	if keyCase != "" && !containsString(keyCases, keyCase) {
+		return usageErrorf("unknown --key-case %q (expected one of %s)", keyCase, strings.Join(keyCases, ", "))
+	}
+	return nil
+}
+
+// keyWords splits name into words at each run of characters other than letters and digits, and, when camel is set,
+// where a lower-case letter or digit is followed by an upper-case letter, or an upper-case letter is followed by the
+// start of a capitalized word, as in HTTPServer.
+func keyWords(name string, camel bool) []string {
+	var words []string
+	var word []rune
+	runes := []rune(name)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if len(word) > 0 {
+				words, word = append(words, string(word)), nil
+			}
+			continue
+		}
+		if camel && len(word) > 0 && unicode.IsUpper(r) {
+			prev := word[len(word)-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if !unicode.IsUpper(prev) || nextIsLower {
+				words, word = append(words, string(word)), nil
+			}
+		}
+		word = append(word, r)
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	return words
+}
+
+// convertKeyCase returns name converted to keyCase, one of keyCases: snake gives first_name for First Name or
+// firstName, and camel gives firstName. lower and upper only change the case of the words of name, joining them with
+// underscores, so that FirstName is firstname. Names without any letters or digits are left as they are.
+func convertKeyCase(name, keyCase string) string {
+	words := keyWords(name, keyCase == "snake" || keyCase == "camel")
+	if len(words) == 0 {
+		return name
+	}
+	for i, word := range words {
+		switch {
+		case keyCase == "upper":
+			words[i] = strings.ToUpper(word)
+		case keyCase == "camel" && i > 0:
+			runes := []rune(strings.ToLower(word))
+			runes[0] = unicode.ToUpper(runes[0])
+			words[i] = string(runes)
+		default:
+			words[i] = strings.ToLower(word)
+		}
+	}
+	if keyCase == "camel" {
+		return strings.Join(words, "")
+	}
+	return strings.Join(words, "_")
+}
+
+// convertHeaderCase converts each of the header names read from the named input to keyCase in place, as by
+// convertKeyCase(). Returns an *invalidHeaderError when different names are converted to the same one, rather than
+// letting them be resolved like duplicate names.
+func convertHeaderCase(inputName string, header []string, keyCase string) error {
+	originals := make(map[string]string, len(header))
+	for i, name := range header {
+		converted := convertKeyCase(name, keyCase)
+		if original, ok := originals[converted]; ok && original != name {
+			return &invalidHeaderError{inputName, i + 1, fmt.Sprintf("column names %q and %q are both %q with "+
+				"--key-case %s", original, name, converted, keyCase)}
+		}
+		originals[converted] = name
+		header[i] = converted
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCheckKeyCase(t *testing.T) {
+	assert.NoError(t, checkKeyCase(""))
+	assert.NoError(t, checkKeyCase("camel"))
+	err := checkKeyCase("kebab")
+	assert.EqualError(t, err, `unknown --key-case "kebab" (expected one of snake, camel, lower, upper)`)
+	assert.Equal(t, exitUsage, exitCode(err))
+}
+
+func TestConvertKeyCase(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		wantSnake string
+		wantCamel string
+		wantLower string
+		wantUpper string
+	}{
+		{"First Name", "first_name", "firstName", "first_name", "FIRST_NAME"},
+		{"Last Login (UTC)", "last_login_utc", "lastLoginUtc", "last_login_utc", "LAST_LOGIN_UTC"},
+		{"firstName", "first_name", "firstName", "firstname", "FIRSTNAME"},
+		{"HTTPServer/port", "http_server_port", "httpServerPort", "httpserver_port", "HTTPSERVER_PORT"},
+		{"  address  line 2 ", "address_line_2", "addressLine2", "address_line_2", "ADDRESS_LINE_2"},
+		{"Straße", "straße", "straße", "straße", "STRAßE"},
+		{"()", "()", "()", "()", "()"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantSnake, convertKeyCase(tt.name, "snake"))
+			assert.Equal(t, tt.wantCamel, convertKeyCase(tt.name, "camel"))
+			assert.Equal(t, tt.wantLower, convertKeyCase(tt.name, "lower"))
+			assert.Equal(t, tt.wantUpper, convertKeyCase(tt.name, "upper"))
+		})
+	}
+}
+
+func TestCsv2JsonKeyCase(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		csv      string
+		options  conversionOptions
+		wantJson string
+		wantErr  string
+	}{
+		{"Converts header names", "First Name,Last Login (UTC)\nAnn,2021\n", conversionOptions{keyCase: "camel"},
+			`[{"firstName": "Ann", "lastLoginUtc": "2021"}]`, ""},
+		{"Renames and selects by the converted names", "First Name,Last Name,Age\nAnn,Lee,7\n",
+			conversionOptions{keyCase: "snake", renames: map[string]string{"first_name": "given"},
+				selectColumns: []string{"given", "age"}},
+			`[{"given": "Ann", "age": "7"}]`, ""},
+		{"Different names which become the same", "id,First Name,first_name\n1,Ann,Ann\n",
+			conversionOptions{keyCase: "snake"}, "",
+			`header of input 1, column 3: column names "First Name" and "first_name" are both "first_name" with ` +
+				`--key-case snake`},
+		{"Duplicate names are left to --dup-headers", "First Name,First Name\nAnn,Bo\n",
+			conversionOptions{keyCase: "snake", dupHeaders: "suffix"}, `[{"first_name": "Ann", "first_name_2": "Bo"}]`,
+			""},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+			options := tt.options
+			options.csvInputs = []io.Reader{strings.NewReader(tt.csv)}
+			options.jsonOutput = jsonStream
+
+			err := csv2Json(options)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, jsonStream.String())
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, exitInvalidCsv, exitCode(err))
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// keyOptions is used to configure emitting records as a JSON object keyed by column values when calling
+// csv2KeyedJson().
+type keyOptions struct {
+	columns []string
+	sep     string
+	strip   bool
+	// duplicates is one of keyDuplicatePolicies, which determines what happens to records with the same key
+	duplicates string
+}
+
+// keyDuplicatePolicies are the policies accepted by --key-duplicates for records whose key is the same as an
+// earlier record's: failing, keeping the first record, or replacing it with the later one, in the first's position.
+var keyDuplicatePolicies = []string{"error", "first-wins", "last-wins"}
+
+// defaultKeyDuplicatePolicy is the policy which applies when none is chosen.
+const defaultKeyDuplicatePolicy = "error"
+
+// checkKeyDuplicatePolicy returns a *usageError unless policy is empty or one of keyDuplicatePolicies.
+func checkKeyDuplicatePolicy(policy string) error {
+	if policy == "" || containsString(keyDuplicatePolicies, policy) {
+		return nil
+	}
+	return usageErrorf("unknown --key-duplicates policy %q (expected one of %s)", policy,
+		strings.Join(keyDuplicatePolicies, ", "))
+}
+
+// checkKeyColumns returns a *usageError unless each of columns is one of colNames, or colNames is nil, as when there
+// is no header. Fields added to records by --file-meta may also be keys.
+func checkKeyColumns(columns, colNames, fileMeta []string) error {
+	if colNames == nil {
+		return nil
+	}
+	for _, column := range columns {
+		isMeta := false
+		for _, name := range fileMeta {
+			isMeta = isMeta || column == fileMetaFieldName(name)
+		}
+		if !containsString(colNames, column) && !isMeta {
+			return usageErrorf("unknown key column %q", column)
+		}
+	}
+	return nil
+}
+
+// keyError reports a record for which no unique key could be formed.
+type keyError struct {
+	key     string
+	problem string
+}
+
+func (e *keyError) Error() string {
+	return fmt.Sprintf("key %q: %s", e.key, e.problem)
+}
+
+// csv2KeyedJson converts CSV data from `options.csvInputs` to a single JSON object, emitted to `options.jsonOutput`,
+// which maps the key of each record to the record. Keys are the values of `keyOpts.columns` joined by `keyOpts.sep`,
+// which may not occur within the values, since the key would then be ambiguous. Duplicate keys are resolved by
+// `keyOpts.duplicates`, and are an error by default, naming the numbers of both records in the order they are read.
+// When `keyOpts.strip` is set, the key columns are removed from each record. Entries are emitted in input order.
+// Key columns which are not in the header are an error before any data rows are read.
+// Returns any errors from reading CSV, forming keys, or encoding JSON.
+func csv2KeyedJson(options conversionOptions, keyOpts keyOptions) error {
+	if len(keyOpts.columns) > 1 && keyOpts.sep == "" {
+		return usageErrorf("--key-sep cannot be empty when --key names more than one column")
+	} else if err := checkKeyDuplicatePolicy(keyOpts.duplicates); err != nil {
+		return err
+	} else if keyOpts.duplicates == "" {
+		keyOpts.duplicates = defaultKeyDuplicatePolicy
+	}
+	reader, colNames, err := newCsvRowReader(options)
+	if err != nil {
+		return err
+	}
+	if colNames != nil {
+		// Columns left out of records cannot be keys
+		keptNames := make([]string, 0, len(colNames))
+		for _, name := range colNames {
+			if !reader.omitted[name] {
+				keptNames = append(keptNames, name)
+			}
+		}
+		if err := checkKeyColumns(keyOpts.columns, keptNames, options.fileMeta); err != nil {
+			return err
+		}
+	}
+
+	var keys []string
+	records := make(map[string]record)
+	recordNums := make(map[string]int)
+	if err := eachReaderBatch(options, reader, colNames, 0, func(batch []record) error {
+		keys = make([]string, 0, len(batch))
+		for i, rec := range batch {
+			key, err := recordKey(rec, keyOpts)
+			if err != nil {
+				return err
+			}
+			if keyOpts.strip {
+				for _, column := range keyOpts.columns {
+					delete(rec, column)
+				}
+			}
+			if _, ok := records[key]; !ok {
+				keys = append(keys, key)
+				records[key] = rec
+				recordNums[key] = i + 1
+				continue
+			}
+			switch keyOpts.duplicates {
+			case "error":
+				return &keyError{key, fmt.Sprintf("records %d and %d have the same key", recordNums[key], i+1)}
+			case "last-wins":
+				records[key] = rec
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := writeKeyedRecords(options.jsonOutput, keys, records, options.fileMeta); err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
+
+// recordKey returns the key of rec, formed by joining its values of `keyOpts.columns` with `keyOpts.sep`.
+func recordKey(rec record, keyOpts keyOptions) (string, error) {
+	values := make([]string, len(keyOpts.columns))
+	for i, column := range keyOpts.columns {
+		value, ok := rec[column]
+		if !ok {
+			return "", usageErrorf("unknown key column %q", column)
+		}
+		values[i] = value
+	}
+	for i, value := range values {
+		if len(values) > 1 && strings.Contains(value, keyOpts.sep) {
+			return "", &keyError{strings.Join(values, keyOpts.sep), fmt.Sprintf(
+				"value %q of column %q contains the separator %q", value, keyOpts.columns[i], keyOpts.sep)}
+		}
+	}
+	return strings.Join(values, keyOpts.sep), nil
+}
+
+// writeKeyedRecords writes a JSON object mapping each of keys to its record, in order, followed by a newline.
+// The fields added for the fileMeta attributes are written as by fileMetaRecord().
+func writeKeyedRecords(w io.Writer, keys []string, records map[string]record, fileMeta []string) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		encodedKey, _ := json.Marshal(key)
+		encodedRecord, err := json.Marshal(fileMetaRecord(records[key], fileMeta))
+		if err != nil {
+			return err
+		}
+		bw.Write(encodedKey)
+		bw.WriteByte(':')
+		bw.Write(encodedRecord)
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCsv2KeyedJson(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		csvData    string
+		keyOpts    keyOptions
+		maxRecords int
+		wantJson   string
+		wantErr    string
+	}{
+		{
+			"One column",
+			"id,name\n2,Bob\n1,Alice\n",
+			keyOptions{columns: []string{"id"}, sep: ":"},
+			0,
+			`{"2":{"id":"2","name":"Bob"},"1":{"id":"1","name":"Alice"}}` + "\n",
+			"",
+		},
+		{
+			"Two columns",
+			"region,id,name\nemea,1,Alice\napac,1,Bob\n",
+			keyOptions{columns: []string{"region", "id"}, sep: ":"},
+			0,
+			`{"emea:1":{"id":"1","name":"Alice","region":"emea"},"apac:1":{"id":"1","name":"Bob","region":"apac"}}` +
+				"\n",
+			"",
+		},
+		{
+			"Three columns with a custom separator",
+			"year,month,day,n\n2024,06,01,1\n2024,06,02,2\n",
+			keyOptions{columns: []string{"year", "month", "day"}, sep: "-"},
+			0,
+			`{"2024-06-01":{"day":"01","month":"06","n":"1","year":"2024"},` +
+				`"2024-06-02":{"day":"02","month":"06","n":"2","year":"2024"}}` + "\n",
+			"",
+		},
+		{
+			"Stripped key columns",
+			"region,id,name\nemea,1,Alice\n",
+			keyOptions{columns: []string{"region", "id"}, sep: ":", strip: true},
+			0,
+			`{"emea:1":{"name":"Alice"}}` + "\n",
+			"",
+		},
+		{
+			"Separator within one column's value is allowed",
+			"url,n\nhttp://a,1\n",
+			keyOptions{columns: []string{"url"}, sep: ":"},
+			0,
+			`{"http://a":{"n":"1","url":"http://a"}}` + "\n",
+			"",
+		},
+		{
+			"No records",
+			"id,name\n",
+			keyOptions{columns: []string{"id"}, sep: ":"},
+			0,
+			"{}\n",
+			"",
+		},
+		{
+			"Separator within a value",
+			"region,id\nemea:west,1\n",
+			keyOptions{columns: []string{"region", "id"}, sep: ":"},
+			0,
+			"",
+			`key "emea:west:1": value "emea:west" of column "region" contains the separator ":"`,
+		},
+		{
+			"Duplicate composite key",
+			"region,id,name\nemea,1,Alice\napac,1,Bob\nemea,1,Carol\n",
+			keyOptions{columns: []string{"region", "id"}, sep: ":"},
+			0,
+			"",
+			`key "emea:1": records 1 and 3 have the same key`,
+		},
+		{
+			"Duplicate key keeping the first record",
+			"id,name\n1,Alice\n2,Bob\n1,Carol\n",
+			keyOptions{columns: []string{"id"}, sep: ":", strip: true, duplicates: "first-wins"},
+			0,
+			`{"1":{"name":"Alice"},"2":{"name":"Bob"}}` + "\n",
+			"",
+		},
+		{
+			"Duplicate key keeping the last record",
+			"id,name\n1,Alice\n2,Bob\n1,Carol\n",
+			keyOptions{columns: []string{"id"}, sep: ":", strip: true, duplicates: "last-wins"},
+			0,
+			`{"1":{"name":"Carol"},"2":{"name":"Bob"}}` + "\n",
+			"",
+		},
+		{
+			"Unknown duplicate policy",
+			"id\n1\n",
+			keyOptions{columns: []string{"id"}, sep: ":", duplicates: "merge"},
+			0,
+			"",
+			`unknown --key-duplicates policy "merge" (expected one of error, first-wins, last-wins)`,
+		},
+		{
+			"Unknown column before reading rows",
+			"id,name\n1,Alice,extra\n",
+			keyOptions{columns: []string{"region"}, sep: ":"},
+			0,
+			"",
+			`unknown key column "region"`,
+		},
+		{
+			"Unknown column",
+			"id,name\n1,Alice\n",
+			keyOptions{columns: []string{"id", "region"}, sep: ":"},
+			0,
+			"",
+			`unknown key column "region"`,
+		},
+		{
+			"Empty separator",
+			"region,id\nemea,1\n",
+			keyOptions{columns: []string{"region", "id"}},
+			0,
+			"",
+			"--key-sep cannot be empty when --key names more than one column",
+		},
+		{
+			"Max records",
+			"id\n1\n2\n3\n",
+			keyOptions{columns: []string{"id"}, sep: ":"},
+			2,
+			"",
+			"input exceeds 2 records; rerun with streaming output or raise the limit",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := csv2KeyedJson(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(tt.csvData)},
+				jsonOutput: &buf,
+				maxRecords: tt.maxRecords,
+			}, tt.keyOpts)
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Empty(t, buf.String(), "Nothing should be written when keys are invalid")
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantJson, buf.String())
+			}
+		})
+	}
+}
+
+func TestCsv2KeyedJsonColumns(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		column   string
+		options  conversionOptions
+		wantJson string
+		wantErr  string
+	}{
+		{"File metadata", "_file_size", conversionOptions{fileMeta: []string{"size"}},
+			`{"":{"id":"1","name":"Alice"}}` + "\n", ""},
+		{"Renamed column", "key", conversionOptions{renames: map[string]string{"id": "key"}},
+			`{"1":{"name":"Alice"}}` + "\n", ""},
+		{"Excluded column", "id", conversionOptions{excludeColumns: []string{"id"}}, "", `unknown key column "id"`},
+		{"Forced columns without rows", "n", conversionOptions{colNames: []string{"n"}, csvInputs: []io.Reader{}},
+			"{}\n", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if tt.options.csvInputs == nil {
+				tt.options.csvInputs = []io.Reader{strings.NewReader("id,name\n1,Alice\n")}
+			}
+			tt.options.jsonOutput = &buf
+			err := csv2KeyedJson(tt.options, keyOptions{columns: []string{tt.column}, strip: true})
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantJson, buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Log formats which may be selected with --log-format.
+const (
+	textLogFormat   = "text"
+	logfmtLogFormat = "logfmt"
+	jsonLogFormat   = "json"
+)
+
+// logFormat determines how events are logged. The text format logs most events as plain messages, while
+// the others log every event with structured fields, preceded by a timestamp field in place of the log prefix.
+// It is only set by setLogFormat() before converting, so that concurrent conversions can read it safely.
+var logFormat = textLogFormat
+
+// setLogFormat validates and selects the log format.
+func setLogFormat(format string) error {
+	switch format {
+	case textLogFormat:
+	case logfmtLogFormat, jsonLogFormat:
+		log.SetFlags(0)
+	default:
+		return fmt.Errorf("invalid log format %q (expected %s, %s, or %s)",
+			format, textLogFormat, logfmtLogFormat, jsonLogFormat)
+	}
+	logFormat = format
+	return nil
+}
+
+// logStructured reports whether every event should be logged with structured fields.
+func logStructured() bool {
+	return logFormat != textLogFormat
+}
+
+// logEvent logs msg with structured fields, where keyvals alternate between each field's key and value.
+// Events are logged as JSON objects when using the json format, or otherwise in logfmt.
+func logEvent(msg string, keyvals ...interface{}) {
+	keyvals = append([]interface{}{"msg", msg}, keyvals...)
+	if logStructured() {
+		keyvals = append([]interface{}{"ts", time.Now().UTC().Format(time.RFC3339Nano)}, keyvals...)
+	}
+	for i := 1; i < len(keyvals); i += 2 {
+		if err, ok := keyvals[i].(error); ok {
+			keyvals[i] = err.Error()
+		}
+	}
+
+	var sb strings.Builder
+	if logFormat == jsonLogFormat {
+		sb.WriteByte('{')
+		for i := 0; i+1 < len(keyvals); i += 2 {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			key, _ := json.Marshal(fmt.Sprint(keyvals[i]))
+			value, err := json.Marshal(keyvals[i+1])
+			if err != nil {
+				value, _ = json.Marshal(fmt.Sprint(keyvals[i+1]))
+			}
+			sb.Write(key)
+			sb.WriteByte(':')
+			sb.Write(value)
+		}
+		sb.WriteByte('}')
+	} else {
+		for i := 0; i+1 < len(keyvals); i += 2 {
+			if i > 0 {
+				sb.WriteByte(' ')
+			}
+			fmt.Fprintf(&sb, "%v=%s", keyvals[i], logfmtValue(fmt.Sprint(keyvals[i+1])))
+		}
+	}
+	log.Print(sb.String())
+}
+
+// logfmtValue quotes value when it cannot otherwise be parsed back from logfmt.
+func logfmtValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"") || strings.IndexFunc(value, func(r rune) bool {
+		return r < ' ' || r == utf8.RuneError
+	}) >= 0 {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// truncateText shortens s to at most maxLen bytes without splitting a UTF-8 sequence, marking where it was cut.
+func truncateText(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+	cut := maxLen
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
+
+// skipErrorKinds classify errors which cause rows to be skipped, in the order their counts are logged.
+var skipErrorKinds = []string{"field_count", "bare_quote", "quote", "invalid", "other"}
+
+// skipErrorKind classifies err as one of skipErrorKinds.
+func skipErrorKind(err error) string {
+	switch {
+	case errors.Is(err, csv.ErrFieldCount):
+		return "field_count"
+	case errors.Is(err, csv.ErrBareQuote):
+		return "bare_quote"
+	case errors.Is(err, csv.ErrQuote):
+		return "quote"
+	case errors.As(err, new(*invalidRowError)):
+		return "invalid"
+	}
+	return "other"
+}
+
+// logSkippedRow logs that the row numbered rowNum, which reader most recently read, was skipped due to err.
+func logSkippedRow(reader *csvRowReader, rowNum int, err error) {
+	if !logStructured() && reader.textLimit == 0 {
+		log.Print(err.Error())
+		return
+	}
+
+	var keyvals []interface{}
+	if reader.numInputs > 1 {
+		keyvals = append(keyvals, "file", reader.currentName)
+	}
+	keyvals = append(keyvals, "line", reader.lastLine, "row", rowNum)
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) && parseErr.Column > 0 {
+		keyvals = append(keyvals, "column", parseErr.Column)
+	}
+	if reader.textLimit > 0 {
+		keyvals = append(keyvals, "text", truncateText(reader.lastText, reader.textLimit))
+	}
+	keyvals = append(keyvals, "err_kind", skipErrorKind(err), "err", err)
+	logEvent("skipped row", keyvals...)
+}
+
+// logTruncatedCells logs the number of values which were truncated to maxLength runes.
+func logTruncatedCells(count, maxLength int) {
+	if logStructured() {
+		logEvent("truncated cells", "count", count, "max_length", maxLength)
+	} else {
+		log.Printf("Truncated %d values longer than %d characters", count, maxLength)
+	}
+}
+
+// logRepairs logs how many short rows were padded, how many long rows had their extra fields kept, and how many
+// header names were renamed, naming only those repairs which were made in plain text.
+func logRepairs(numPadded, numExtended, numRenamed int) {
+	if logStructured() {
+		logEvent("repaired input", "rows_padded", numPadded, "rows_with_extra_fields", numExtended,
+			"headers_renamed", numRenamed)
+		return
+	}
+	var repairs []string
+	if numPadded > 0 {
+		repairs = append(repairs, fmt.Sprintf("padded %d short rows", numPadded))
+	}
+	if numExtended > 0 {
+		repairs = append(repairs, fmt.Sprintf("kept extra fields of %d long rows", numExtended))
+	}
+	if numRenamed > 0 {
+		repairs = append(repairs, fmt.Sprintf("renamed %d headers", numRenamed))
+	}
+	if len(repairs) == 0 {
+		return
+	}
+	summary := repairs[len(repairs)-1]
+	if len(repairs) == 2 {
+		summary = repairs[0] + " and " + summary
+	} else if len(repairs) > 2 {
+		summary = strings.Join(repairs[:len(repairs)-1], ", ") + ", and " + summary
+	}
+	log.Print(strings.ToUpper(summary[:1]) + summary[1:])
+}
+
+// logRejectedRows logs how many skipped rows have been written to the reject file named fileName.
+func logRejectedRows(count int, fileName string) {
+	if logStructured() {
+		logEvent("rejected rows", "count", count, "file", fileName)
+	} else {
+		log.Printf("Wrote %d skipped rows to %s", count, fileName)
+	}
+}
+
+// logFailure logs err, which stopped the run with the exit code given by exitCode().
+func logFailure(err error) {
+	if logStructured() {
+		logEvent("failed", "error", err.Error(), "exit_code", exitCode(err))
+	} else {
+		log.Println(err)
+	}
+}
+
+// logCappedDistinctCounts logs how many groups of the named distinct-count() aggregation had more than
+// maxDistinctValues distinct values, whose counts are therefore only lower bounds.
+func logCappedDistinctCounts(name string, numGroups int) {
+	if logStructured() {
+		logEvent("capped distinct count", "aggregation", name, "groups", numGroups, "max", maxDistinctValues)
+	} else {
+		log.Printf("Stopped counting distinct values of %s at %d in %d groups, whose counts are lower bounds",
+			name, maxDistinctValues, numGroups)
+	}
+}
+
+// logEmptyRows logs how many rows were skipped because every field was empty.
+func logEmptyRows(numEmpty int) {
+	if logStructured() {
+		logEvent("skipped empty rows", "rows", numEmpty)
+	} else {
+		log.Printf("Skipped %d rows whose every field is empty", numEmpty)
+	}
+}
+
+// logFullConversion logs that the named input file is being converted in full, rather than from where the previous run
+// stopped, for the given reason.
+func logFullConversion(inputName, reason string) {
+	if logStructured() {
+		logEvent("converting in full", "input", inputName, "reason", reason)
+	} else {
+		log.Printf("Converting %s in full because %s", inputName, reason)
+	}
+}
+
+// logWatchedFile logs the outcome of converting the named file found by watchDir(), which is the name of the output
+// file to which it was converted, or else err.
+func logWatchedFile(fileName, outputName string, err error) {
+	switch {
+	case logStructured() && err != nil:
+		logEvent("conversion failed", "input", fileName, "error", err.Error())
+	case logStructured():
+		logEvent("converted", "input", fileName, "output", outputName)
+	case err != nil:
+		log.Printf("Failed to convert %s, leaving it in place: %v", fileName, err)
+	default:
+		log.Printf("Converted %s to %s", fileName, outputName)
+	}
+}
+
+// logViolations logs how many skipped rows violated each constraint, in order of constraint.
+func logViolations(countsByConstraint map[string]int) {
+	constraints := make([]string, 0, len(countsByConstraint))
+	for constraint := range countsByConstraint {
+		constraints = append(constraints, constraint)
+	}
+	sort.Strings(constraints)
+
+	if logStructured() {
+		for _, constraint := range constraints {
+			logEvent("invalid rows", "constraint", constraint, "count", countsByConstraint[constraint])
+		}
+		return
+	}
+	counts := make([]string, len(constraints))
+	for i, constraint := range constraints {
+		counts[i] = fmt.Sprintf("%s (%d)", constraint, countsByConstraint[constraint])
+	}
+	log.Printf("Invalid rows by constraint: %s", strings.Join(counts, ", "))
+}
+
+// logSkippedRows logs the number of rows which were skipped, both in total and for each of skipErrorKinds.
+func logSkippedRows(countsByKind map[string]int) {
+	total := 0
+	for _, n := range countsByKind {
+		total += n
+	}
+	if !logStructured() {
+		// Invalid rows are well-formed CSV, so they are reported apart from parsing errors
+		numInvalid := countsByKind["invalid"]
+		switch {
+		case numInvalid == 0:
+			log.Printf("Skipped %d lines (rows) due to parsing errors", total)
+		case numInvalid == total:
+			log.Printf("Skipped %d invalid rows", numInvalid)
+		default:
+			log.Printf("Skipped %d lines (rows) due to parsing errors and %d invalid rows", total-numInvalid, numInvalid)
+		}
+		return
+	}
+
+	keyvals := []interface{}{"count", total}
+	for _, kind := range skipErrorKinds {
+		keyvals = append(keyvals, kind, countsByKind[kind])
+	}
+	logEvent("skipped rows", keyvals...)
+}
+
+// logTeeStopped logs that output is no longer written to the named destination of --tee, because of err.
+func logTeeStopped(destination string, err error) {
+	if logStructured() {
+		logEvent("stopped tee", "destination", destination, "error", err.Error())
+	} else {
+		log.Printf("Stopped writing output to %s: %v", destination, err)
+	}
+}
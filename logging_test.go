@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestSkippedRowLogging(t *testing.T) {
+	csvData := "a,b\n1,2\n\n3,\"x\"y\n\"multi\nline\",4,5\n6,7\n"
+
+	for _, tt := range []struct {
+		testName     string
+		textLimit    int
+		forceColumns []string
+		wantLog      string
+	}{
+		{
+			"Logs terse errors by default",
+			0,
+			nil,
+			`parse error on line 4, column 5: extraneous or missing " in quoted-field
+record on line 5: wrong number of fields
+Skipped 2 lines (rows) due to parsing errors
+`,
+		},
+		{
+			"Logs line number and raw text in detail",
+			100,
+			nil,
+			`msg="skipped row" line=4 row=2 column=5 text="3,\"x\"y" err_kind=quote err="parse error on line 4, column 5: extraneous or missing \" in quoted-field"
+msg="skipped row" line=5 row=3 column=1 text="\"multi\nline\",4,5" err_kind=field_count err="record on line 5: wrong number of fields"
+Skipped 2 lines (rows) due to parsing errors
+`,
+		},
+		{
+			"Truncates raw text",
+			4,
+			nil,
+			`msg="skipped row" line=4 row=2 column=5 text="3,\"x..." err_kind=quote err="parse error on line 4, column 5: extraneous or missing \" in quoted-field"
+msg="skipped row" line=5 row=3 column=1 text="\"mul..." err_kind=field_count err="record on line 5: wrong number of fields"
+Skipped 2 lines (rows) due to parsing errors
+`,
+		},
+		{
+			"Logs first line as data row with forced columns",
+			100,
+			[]string{"a"},
+			`msg="skipped row" line=1 row=1 column=1 text=a,b err_kind=field_count err="record on line 1: wrong number of fields"
+msg="skipped row" line=2 row=2 column=1 text=1,2 err_kind=field_count err="record on line 2: wrong number of fields"
+msg="skipped row" line=4 row=3 column=5 text="3,\"x\"y" err_kind=quote err="parse error on line 4, column 5: extraneous or missing \" in quoted-field"
+msg="skipped row" line=5 row=4 column=1 text="\"multi\nline\",4,5" err_kind=field_count err="record on line 5: wrong number of fields"
+msg="skipped row" line=7 row=5 column=1 text=6,7 err_kind=field_count err="record on line 7: wrong number of fields"
+Skipped 5 lines (rows) due to parsing errors
+`,
+		},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			logged := bytes.NewBuffer([]byte{})
+			oldLogOutput, oldLogFlags := log.Writer(), log.Flags()
+			log.SetOutput(logged)
+			log.SetFlags(0)
+			t.Cleanup(func() {
+				log.SetOutput(oldLogOutput)
+				log.SetFlags(oldLogFlags)
+			})
+
+			err := csv2Json(conversionOptions{
+				colNames:            tt.forceColumns,
+				csvInputs:           []io.Reader{strings.NewReader(csvData)},
+				jsonOutput:          ioutil.Discard,
+				skipErrors:          true,
+				skippedRowTextLimit: tt.textLimit,
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantLog, logged.String())
+		})
+	}
+}
+
+func TestSkippedRowLoggingAsJson(t *testing.T) {
+	logged := bytes.NewBuffer([]byte{})
+	oldLogOutput, oldLogFlags := log.Writer(), log.Flags()
+	log.SetOutput(logged)
+	t.Cleanup(func() {
+		log.SetOutput(oldLogOutput)
+		log.SetFlags(oldLogFlags)
+		logFormat = textLogFormat
+	})
+	assert.NoError(t, setLogFormat(jsonLogFormat))
+
+	err := csv2Json(conversionOptions{
+		csvInputs: []io.Reader{
+			strings.NewReader("a,b\n1,x\"y\n2\n"),
+			strings.NewReader("a,b\n3,\"z\"z\"\n"),
+		},
+		jsonOutput: ioutil.Discard,
+		skipErrors: true,
+	})
+	assert.NoError(t, err)
+
+	var events []map[string]interface{}
+	dec := json.NewDecoder(logged)
+	for dec.More() {
+		var event map[string]interface{}
+		assert.NoError(t, dec.Decode(&event))
+		assert.Contains(t, event, "ts")
+		delete(event, "ts")
+		events = append(events, event)
+	}
+	assert.Equal(t, []map[string]interface{}{
+		{
+			"msg": "skipped row", "file": "input 1", "line": 2.0, "row": 1.0, "column": 4.0,
+			"err_kind": "bare_quote", "err": `parse error on line 2, column 4: bare " in non-quoted-field`,
+		},
+		{
+			"msg": "skipped row", "file": "input 1", "line": 3.0, "row": 2.0, "column": 1.0,
+			"err_kind": "field_count", "err": "record on line 3: wrong number of fields",
+		},
+		{
+			"msg": "skipped row", "file": "input 2", "line": 2.0, "row": 3.0, "column": 5.0,
+			"err_kind": "quote", "err": `parse error on line 2, column 5: extraneous or missing " in quoted-field`,
+		},
+		{
+			"msg": "skipped rows", "count": 3.0, "field_count": 1.0, "bare_quote": 1.0, "quote": 1.0, "invalid": 0.0,
+			"other": 0.0,
+		},
+	}, events)
+}
+
+func TestSkipErrorKind(t *testing.T) {
+	assert.Equal(t, "other", skipErrorKind(&rowError{1, errors.New("not a number")}))
+	assert.Equal(t, "field_count", skipErrorKind(&csv.ParseError{Err: csv.ErrFieldCount}))
+}
+
+func TestSetLogFormat(t *testing.T) {
+	assert.EqualError(t, setLogFormat("xml"), `invalid log format "xml" (expected text, logfmt, or json)`)
+	assert.Equal(t, textLogFormat, logFormat)
+}
+
+func TestLogFailure(t *testing.T) {
+	logged := bytes.NewBuffer([]byte{})
+	oldLogOutput, oldLogFlags := log.Writer(), log.Flags()
+	log.SetOutput(logged)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(oldLogOutput)
+		log.SetFlags(oldLogFlags)
+		logFormat = textLogFormat
+	})
+	err := usageErrorf("--max-errors cannot be negative")
+
+	logFailure(err)
+	assert.Equal(t, "--max-errors cannot be negative\n", logged.String())
+
+	logged.Reset()
+	assert.NoError(t, setLogFormat(jsonLogFormat))
+	logFailure(err)
+	var event map[string]interface{}
+	assert.NoError(t, json.Unmarshal(logged.Bytes(), &event))
+	delete(event, "ts")
+	assert.Equal(t, map[string]interface{}{"msg": "failed", "error": "--max-errors cannot be negative",
+		"exit_code": float64(exitUsage)}, event)
+}
+
+func TestLogfmtValue(t *testing.T) {
+	for _, tt := range []struct {
+		value     string
+		wantValue string
+	}{
+		{"plain", "plain"},
+		{"", `""`},
+		{"two words", `"two words"`},
+		{"a=b", `"a=b"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{"line\nbreak", `"line\nbreak"`},
+	} {
+		t.Run(tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.wantValue, logfmtValue(tt.value))
+		})
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	assert.Equal(t, "short", truncateText("short", 5))
+	assert.Equal(t, "sho...", truncateText("shortened", 3))
+	assert.Equal(t, "a...", truncateText("aé", 2), "Should not split a multibyte character")
+}
+
+func TestRepairLogging(t *testing.T) {
+	logged := bytes.NewBuffer([]byte{})
+	oldLogOutput, oldLogFlags := log.Writer(), log.Flags()
+	log.SetOutput(logged)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(oldLogOutput)
+		log.SetFlags(oldLogFlags)
+	})
+	renamedLog := `Resolved duplicate header names "a" of input 1 with --dup-headers=suffix
+Renamed header "a" of input 1, column 2, to "a_2"
+`
+
+	for _, tt := range []struct {
+		testName string
+		options  conversionOptions
+		wantLog  string
+	}{
+		{"Default policies are not repairs", conversionOptions{skipErrors: true}, renamedLog +
+			"record on line 3: wrong number of fields\nSkipped 1 lines (rows) due to parsing errors\n"},
+		{"Names only the repairs made", conversionOptions{padShortRows: true},
+			renamedLog + "Padded 1 short rows and renamed 1 headers\n"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			logged.Reset()
+			tt.options.csvInputs = []io.Reader{strings.NewReader("a,a,b\n1,2,3\n4\n")}
+			tt.options.jsonOutput = ioutil.Discard
+
+			err := csv2Json(tt.options)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantLog, logged.String())
+		})
+	}
+}
@@ -2,137 +2,2750 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/integrii/flaggy"
+	"golang.org/x/term"
 	"io"
-	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
 )
 
+// stdinFileName may be given in place of a file name to explicitly read from stdin.
+const stdinFileName = "-"
+
+// stdinPlaceholder stands in for stdinFileName while parsing command-line arguments.
+const stdinPlaceholder = "\x00stdin"
+
+// stdinIsTerminal reports whether stdin is an interactive terminal, rather than a pipe or file.
+var stdinIsTerminal = func() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
 // record values are a single row's worth of data, keyed by column names
 type record map[string]string
 
-// conversionOptions is used to configure the CSV input source, conversion behaviors, and JSON output destination
-// when calling csv2Json().
-type conversionOptions struct {
-	colNames   []string
-	csvInput   io.Reader
-	jsonOutput io.Writer
-	skipErrors bool
+// conversionOptions is used to configure the CSV input source, conversion behaviors, and JSON output destination
+// when calling csv2Json(). Conversions never modify their options, so a single conversionOptions value may serve as
+// a template for concurrent conversions, provided each is given its own csvInputs, jsonOutput, and stats.
+type conversionOptions struct {
+	colNames      []string
+	csvInputs     []io.Reader
+	dialect       csvDialect
+	rfc4180Strict bool
+	// compression is how inputs are decompressed, as one of compressionModes, before anything else reads them
+	compression string
+	// encoding, when set, is one of inputEncodings, which inputs are transcoded to UTF-8 from once decompressed, as
+	// by decodeInput()
+	encoding string
+	// skipRows, when set, is the number of lines at the start of each input, such as a banner, which are discarded
+	// before reading it as CSV, as by skipLines()
+	skipRows int
+	// sanitizeHeaders removes invisible characters from header names as they are read, and trimHeaders removes
+	// whitespace from either end of them, as by trimHeaders()
+	sanitizeHeaders bool
+	trimHeaders     bool
+	// strictHeaders rejects empty and duplicate header names, as by checkHeaders()
+	strictHeaders bool
+	// failIfEmpty returns errNoRows when the inputs have no data rows
+	failIfEmpty bool
+	// offset, when set, is the number of rows at the start of the inputs which are read but not converted, and limit,
+	// when set, is the number of rows after them to convert before reading stops, as by eachRow()
+	offset int
+	limit  int
+	// rejectInvalidUtf8 treats any header or value which is not valid UTF-8 as an error
+	rejectInvalidUtf8 bool
+	// fillEmptyHeaders and dedupeHeaders rename empty and duplicate header names, as by fillEmptyHeaders() and
+	// dedupeHeaders()
+	fillEmptyHeaders bool
+	dedupeHeaders    bool
+	// keepEmptyHeaders reads empty header names as they are, rather than as an *emptyHeadersError when they are not
+	// filled
+	keepEmptyHeaders bool
+	// dupHeaders is one of dupHeaderPolicies, which resolves duplicate header names instead of dedupeHeaders
+	dupHeaders string
+	// keyCase, when set, is one of keyCases, to which header names are converted as they are read, before they are
+	// renamed or selected, as by convertHeaderCase()
+	keyCase string
+	// headerMismatch is one of headerMismatchPolicies, for inputs whose header differs from the first input's
+	headerMismatch string
+	// headerRows, when more than 1, is the number of header rows of each input, whose names are merged into a single
+	// name for each column as by mergeHeaderRows(), joined with headerJoin
+	headerRows int
+	headerJoin string
+	// padShortRows and keepExtraFields allow data rows to have fewer or more fields than there are columns at their
+	// end, as by csvRowReader.fitFields()
+	padShortRows    bool
+	keepExtraFields bool
+	// padNulls leaves the fields padded by padShortRows out of records, for csv2Json() to emit as null
+	padNulls bool
+	// extraFields, when set, is one of extraFieldPolicies, which determines what happens to the fields of data rows
+	// beyond the last column instead of keepExtraFields. The "keep" policy collects them into an array named
+	// extraFieldName, or defaultExtraFieldName when it is empty.
+	extraFields    string
+	extraFieldName string
+	// emptyRows is one of emptyRowPolicies, which determines what happens to data rows whose every field is empty.
+	// skipBlankRecords is the same as the "skip" policy.
+	emptyRows        string
+	skipBlankRecords bool
+	// base64Columns names columns whose values are decoded from base64, and base64Binary is one of
+	// base64BinaryPolicies, which determines what happens to values which do not decode to UTF-8 text
+	base64Columns []string
+	base64Binary  string
+	// urlDecodeColumns names columns whose values are percent-decoded, as by a mode of urlDecodeModes
+	urlDecodeColumns []string
+	urlDecodeMode    string
+	// fixCp1252 translates stray Windows-1252 punctuation bytes in otherwise UTF-8 inputs, as by cp1252Reader
+	fixCp1252 bool
+	// noSniff reads every input as CSV, even when its first bytes show it is some other format
+	noSniff bool
+	// sniffDelimiter detects the delimiter of each input, as by sniffDelimiter(), unless it was given explicitly.
+	// With logSniffedDelimiters, each detection is logged.
+	sniffDelimiter       bool
+	logSniffedDelimiters bool
+	transforms           []valueTransform
+	// maxCellLength, when set, truncates data row values longer than this many runes, appending truncateMarker.
+	// When truncateFlag is also set, each record gets a field named by truncatedFlagName() for each truncated value.
+	maxCellLength  int
+	truncateMarker string
+	truncateFlag   bool
+	// execFilter, when set, is a command through which records are passed by filterRecords() before being emitted
+	execFilter string
+	// fileMeta names attributes of each input file, from fileMetaFields, to add to each record read from it
+	fileMeta []string
+	// rowNumberField, when set, is a field to add to each record holding the 1-based number of its data row, counted
+	// across every input and including rows which were skipped. It may only be a column when rowNumberReplaces is
+	// set, replacing its values. lineNumber, when set, adds the line of its input on which each data row starts
+	// as lineNumberField, which differs from the row number when quoted values span lines.
+	rowNumberField    string
+	rowNumberReplaces bool
+	lineNumber        bool
+	jsonOutput        io.Writer
+	skipErrors        bool
+	// errorHandler, when set, decides what to do with each data row which fails instead of skipErrors
+	errorHandler errorHandler
+	// validate, when set, is called with the record of each data row after transforms, and any error it returns
+	// makes the row invalid, as reported by an *invalidRowError
+	validate func(rec record) error
+	// skippedRowTextLimit, when set, logs each skipped row in detail, with its raw text truncated to this many bytes
+	skippedRowTextLimit int
+	batchSize           int
+	// flushEvery, when set along with batchSize, flushes jsonOutput whenever at least this many records have been
+	// emitted since it was last flushed
+	flushEvery int
+	// pluck, when set, emits each record's value of this field in place of the record, as by pluckValues().
+	// Records without the field are emitted as null, unless pluckSkipMissing is set.
+	pluck            string
+	pluckSkipMissing bool
+	// maxRecords, when set, limits how many records may be held in memory at once by modes which emit every record
+	// at the end, rather than streaming them
+	maxRecords int
+	// sortBy, when set, orders records by the keys it gives, as by parseSortKeys(), and reverse emits them in reverse
+	// order, after any sorting. Both are only possible when every record is emitted at the end.
+	sortBy  string
+	reverse bool
+	// shuffle, when set, emits records in random order instead, as determined by shuffleSeed
+	shuffle     bool
+	shuffleSeed int64
+	// ndjson, when set, makes csv2Json() emit each record as a line of JSON rather than emitting JSON arrays, and
+	// yaml makes it emit the records as a YAML sequence instead, as by a yamlSequenceWriter
+	ndjson bool
+	yaml   bool
+	// indent, when set, indents the JSON emitted by csv2Json() or aggregate by this many spaces per level
+	indent int
+	// inferTypes, when set, makes csv2Json() emit values as numbers, booleans, or null when they look like them.
+	// columnTypes gives the type of each of its columns, one of columnTypes, which takes precedence.
+	inferTypes  bool
+	columnTypes map[string]string
+	// columnTransforms, when set, rewrite the values of their columns in each data row, after transforms and any
+	// decoding but before columnTypes are checked. Values which cannot be rewritten fail their row like parse errors.
+	columnTransforms map[string]columnTransform
+	// nullValues, when set, are values which csv2Json() emits as null, including empty values when it holds "".
+	// They take precedence over inferTypes and columnTypes.
+	nullValues []string
+	// renames, when set, gives new names to columns as they are read, by which other options refer to them
+	renames map[string]string
+	// selectColumns, when set, are the only columns of the header whose values records get, while excludeColumns are
+	// columns whose values they do not get. Rows are still read and validated in full.
+	selectColumns  []string
+	excludeColumns []string
+	// nestSeparator, when set, makes csv2Json() nest the values of columns in objects by splitting their names on it,
+	// as by nestValues()
+	nestSeparator string
+	// stats, when set, is updated as rows are converted
+	stats *conversionStats
+	// rejects, when set, is written with each data row which is skipped due to an error, along with the error
+	rejects *rejectFile
+	// maxSkippedRows, when set, aborts conversion with a *tooManyErrorsError once more data rows than this have been
+	// skipped due to errors
+	maxSkippedRows int
+	// ctx, when set, stops conversion with its error once it is done, as checked before each data row is read
+	ctx context.Context
+	// progress, when set, logs how far conversion has got every progressRows data rows and every progressInterval,
+	// as by progressLog
+	progress         bool
+	progressRows     int64
+	progressInterval time.Duration
+}
+
+// rowError reports a data row which was parsed as CSV but whose contents could not be processed.
+// Like CSV parsing errors, rowErrors may be skipped instead of aborting.
+type rowError struct {
+	row int
+	err error
+}
+
+func (e *rowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.row, e.err)
+}
+
+func (e *rowError) Unwrap() error {
+	return e.err
+}
+
+// recordLimitError reports that more records would be held in memory than `conversionOptions.maxRecords` allows.
+type recordLimitError struct {
+	limit int
+}
+
+func (e recordLimitError) Error() string {
+	return fmt.Sprintf("input exceeds %d records; rerun with streaming output or raise the limit", e.limit)
+}
+
+// outputFormats are the formats accepted by --format for the records emitted to stdout.
+var outputFormats = []string{"json", "ndjson", "yaml", "xml", "sql", "es-bulk", "geojson"}
+
+// isOutputFormat reports whether format is one of outputFormats.
+func isOutputFormat(format string) bool {
+	for _, f := range outputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// errNoRows reports inputs without any data rows, when `conversionOptions.failIfEmpty` is set.
+var errNoRows = errors.New("input has no data rows")
+
+// headerMismatchError reports an input whose header differs from the column names of the first input.
+type headerMismatchError struct {
+	name     string
+	header   []string
+	colNames []string
+}
+
+func (e *headerMismatchError) Error() string {
+	return fmt.Sprintf("header of %s %q does not match columns %q", e.name, e.header, e.colNames)
+}
+
+func main() {
+	err := runCli()
+	var skipped skippedRowsError
+	if err != nil && !errors.As(err, &skipped) {
+		// Skipped rows have already been logged
+		logFailure(err)
+	}
+	os.Exit(exitCode(err))
+}
+
+func runCli() (err error) {
+	// flaggy has no notion of a variable number of positional values, so one is registered for each argument
+	fileNames := make([]string, len(os.Args))
+	// Output is buffered, and flushed once converting finishes unless --flush-every is given
+	output := bufio.NewWriter(os.Stdout)
+	options := conversionOptions{jsonOutput: output, stats: &conversionStats{}}
+	var dialectName string
+	var overrides dialectOverrides
+	var defangFormulas, stripFormulaEscapes, trim bool
+	var debug bool
+	debugRowLength := 200
+	logFormatName := textLogFormat
+	var explain, explainOnly, headersOnly bool
+	var strict, lenient bool
+	configFileName := defaultConfigFileName()
+	var profileName string
+	var bundled []string
+	var reportFile string
+	var rejectFileName string
+	var maxErrors int
+	var stateFileName string
+	var teeFileName string
+	teePrimary := "file"
+	var outputFileName string
+	var errorPolicies []string
+	var regexSpecs []string
+	var requiredColumns []string
+	var rangeSpecs []string
+	var enumSpecs []string
+	var dateSpecs []string
+	var enumFileSpecs []string
+	var enumCaseInsensitive bool
+
+	flaggy.SetVersion("0.3.0")
+	flaggy.SetDescription("Restructures CSV into JSON")
+	flaggy.StringSlice(&options.colNames, "c", "force-columns",
+		"Column names, which must equal the number of CSV fields if given. "+
+			"When set, the first line of CSV data is treated as a data row instead of column names.")
+	var renameSpecs []string
+	flaggy.StringSlice(&renameSpecs, "", "rename",
+		"Give columns new names, as old=new, e.g. CUST_NM=customerName. Other options refer to columns by their new "+
+			"names. Columns which are not in the header are logged, while giving two columns the same name is an error.")
+	flaggy.StringSlice(&options.selectColumns, "", "select",
+		"Columns whose values records get, leaving out the others. Rows are still read and validated in full.")
+	flaggy.StringSlice(&options.excludeColumns, "", "exclude",
+		"Columns whose values records do not get. Cannot be combined with --select.")
+	flaggy.Bool(&options.skipErrors, "s", "skip-errors",
+		"Skip CSV lines that cause parsing errors. By default, errors abort conversion completely.")
+	flaggy.String(&rejectFileName, "", "reject-file",
+		"Write each row skipped due to an error to this file as a line of JSON, with its line, row number, error, "+
+			"and raw text, e.g. {\"line\":42,\"row\":41,\"error\":\"...\",\"raw\":\"...\"}. "+
+			"The file is only created if a row is skipped. Requires --skip-errors or --on-error.")
+	flaggy.Int(&maxErrors, "", "max-errors",
+		"Abort once more than this many rows have been skipped due to errors, reporting the last error, rather than "+
+			"skipping any number of them. 0 means unlimited. Requires --skip-errors or --on-error.")
+	flaggy.StringSlice(&regexSpecs, "", "validate-regex",
+		"Reject rows whose value of a column does not match a pattern, given as COLUMN=PATTERN, "+
+			"e.g. 'email=^[^@]+@[^@]+$'. May be given more than once, and every pattern for a column must match. "+
+			"Rejected rows abort, unless skipped as invalid rows like parsing errors.")
+	flaggy.StringSlice(&requiredColumns, "", "required",
+		"Reject rows with an empty or missing value for any of these columns, where whitespace counts as empty. "+
+			"Rejected rows abort, unless skipped as invalid rows like parsing errors.")
+	flaggy.StringSlice(&rangeSpecs, "", "range",
+		"Reject rows whose value of a column is not a number within inclusive bounds, given as COLUMN=MIN..MAX, "+
+			"e.g. age=0..150 or price=0.. to leave either bound open. May be given more than once.")
+	flaggy.StringSlice(&dateSpecs, "", "validate-date",
+		"Reject rows whose non-empty value of a column is not a date in a Go time layout, given as COLUMN=LAYOUT, "+
+			"e.g. created_at=2006-01-02. Giving a column more than once allows any of its layouts.")
+	flaggy.StringSlice(&enumSpecs, "", "enum",
+		"Reject rows whose value of a column is not one of a set, given as COLUMN=VALUE,VALUE..., "+
+			"e.g. status=active,inactive. May be given more than once.")
+	flaggy.StringSlice(&enumFileSpecs, "", "enum-file",
+		"Like --enum, but with the allowed values of a column read from a file, one per line, given as COLUMN=FILE.")
+	flaggy.Bool(&enumCaseInsensitive, "", "enum-ci", "Compare values to those allowed by --enum ignoring case.")
+	flaggy.StringSlice(&errorPolicies, "", "on-error",
+		"What to do with rows which fail with a kind of error, given as KIND=ACTION, e.g. field_count=skip. "+
+			"Kinds are field_count, bare_quote, quote, invalid, and other. Actions are abort, skip, and raw, which emits "+
+			"a record with only a _raw field holding the row's text. Other kinds follow --skip-errors.")
+	flaggy.Bool(&trim, "", "trim",
+		"Remove whitespace from either end of header names and values, even within quotes, but not non-breaking "+
+			"spaces. --trim-leading-space instead only trims the leading space of values which are not quoted.")
+	flaggy.Bool(&options.trimHeaders, "", "trim-headers",
+		"Remove whitespace from either end of header names only, before they are checked for duplicates.")
+	flaggy.Bool(&defangFormulas, "", "defang-formulas",
+		"Replace values of the form =\"...\" with the literal between the quotes, as Excel would display it.")
+	flaggy.Bool(&stripFormulaEscapes, "", "strip-leading-formula-chars",
+		"Remove the apostrophe or tab which guards values beginning with =, +, -, or @ from formula interpretation.")
+	flaggy.String(&logFormatName, "", "log-format",
+		"Format of log messages: text, or logfmt or json to log skipped lines and other events with structured fields.")
+	flaggy.Bool(&debug, "vv", "debug",
+		"Log each skipped line in detail, including its line number and raw text.")
+	flaggy.Int(&debugRowLength, "", "debug-row-length",
+		"Maximum number of bytes of raw text to log for each skipped line with --debug.")
+	options.progressRows, options.progressInterval = defaultProgressRows, defaultProgressInterval
+	flaggy.Bool(&options.progress, "", "progress",
+		"Log how many rows have been read and skipped, and how many bytes, as a percentage when every input is a "+
+			"file, every --progress-rows rows and every --progress-interval. Also enabled by --debug.")
+	flaggy.Int64(&options.progressRows, "", "progress-rows",
+		"Number of rows between progress logs, or 0 to log only every --progress-interval.")
+	flaggy.Duration(&options.progressInterval, "", "progress-interval",
+		"Time between progress logs, or 0 to log only every --progress-rows rows.")
+	flaggy.Bool(&explain, "", "explain",
+		"Print the effective options, and where each came from, to stderr as JSON before converting.")
+	flaggy.Bool(&explainOnly, "", "explain-only",
+		"Print the effective options like --explain, then exit without converting.")
+	flaggy.Bool(&headersOnly, "", "headers-only",
+		"Print the column names as a JSON array, like the headers subcommand, then exit without reading data rows.")
+	flaggy.String(&reportFile, "", "report-file",
+		"Write a JSON report of the run to this file when it ends, even if it fails: "+
+			"inputs, options, row counts, the first errors, duration, and exit status.")
+	flaggy.String(&stateFileName, "", "state-file",
+		"Record how much of each input file has been converted in this JSON file, and on later runs convert only "+
+			"the rows appended since. Files whose header changed or which shrank are converted in full. A last line "+
+			"without a line break is left for a later run, as it may still be being written.")
+	flaggy.String(&outputFileName, "o", "output",
+		"Write the output to this file rather than stdout. It only replaces any existing file once conversion "+
+			"succeeds, keeping its permissions, and is synced to disk first.")
+	flaggy.String(&teeFileName, "", "tee",
+		"Also write the output to this file. As with --output, an existing file is not protected from being "+
+			"overwritten, but it is only replaced once conversion succeeds, keeping its permissions.")
+	flaggy.String(&teePrimary, "", "tee-primary",
+		"With --tee, the destination whose write errors abort conversion: file or stdout. Errors writing to the "+
+			"other one, such as a closed pipe on stdout, stop writing to it while the primary one is finished.")
+	flaggy.String(&configFileName, "", "config",
+		"YAML config file from which --profile reads profiles. It is optional unless given explicitly.")
+	flaggy.String(&profileName, "", "profile",
+		"Apply the settings of this profile from the config file's profiles section, which maps profile names to "+
+			"flag names and values. Flags given explicitly take precedence. "+
+			"Use the profiles subcommand to list them.")
+	flaggy.Bool(&strict, "", "strict",
+		"Fail loudly on anything suspicious. Enables --strict-headers, --rfc4180-strict (unless dialect settings are "+
+			"given), --fail-if-empty, and --reject-invalid-utf8, and disables --skip-errors, so that parse errors "+
+			"and rows with the wrong number of fields abort. Flags given explicitly take precedence.")
+	flaggy.Bool(&options.strictHeaders, "", "strict-headers",
+		"Reject header rows with empty or duplicate column names.")
+	flaggy.Bool(&options.failIfEmpty, "", "fail-if-empty",
+		"Fail when the input has no data rows.")
+	flaggy.Bool(&options.rejectInvalidUtf8, "", "reject-invalid-utf8",
+		"Treat headers and values which are not valid UTF-8 as errors, which --skip-errors skips like parse errors.")
+	flaggy.Bool(&lenient, "", "lenient",
+		"Get whatever can be converted out of messy input. Enables --skip-errors, --lazy-quotes, --pad-short-rows, "+
+			"--keep-extra-fields, --trim-leading-space, --trim, --fill-empty-headers, and --dedupe-headers, "+
+			"except where flags are given explicitly. Cannot be combined with --strict.")
+	flaggy.Bool(&options.padShortRows, "", "pad-short-rows",
+		"Pad rows with fewer fields than there are columns, rather than failing. Padded values are null when "+
+			"values are typed, as by --infer-types, and empty otherwise. Only missing trailing fields can be "+
+			"padded, since a missing field elsewhere cannot be told apart.")
+	flaggy.Bool(&options.keepExtraFields, "", "keep-extra-fields",
+		"Keep the fields of rows with more fields than there are columns as _extra_1, _extra_2, and so on, "+
+			"rather than failing.")
+	flaggy.String(&options.extraFields, "", "extra-fields",
+		"What to do with the fields of rows beyond the last column: keep them in an array named _extra, drop "+
+			"them, or treat such rows as errors. Defaults to error, unless --keep-extra-fields is given.")
+	flaggy.String(&options.extraFieldName, "", "extra-field-name",
+		"The name of the array in which --extra-fields keep keeps the extra fields of each row, instead of _extra.")
+	flaggy.String(&options.emptyRows, "", "keep-empty-rows",
+		"What to do with rows whose every field is empty or whitespace, such as padded ,,, rows: emit them like "+
+			"any other row, skip them, counting them in the summary, or treat them as an error. Defaults to emit.")
+	flaggy.Bool(&options.skipBlankRecords, "", "skip-blank-records",
+		"Skip rows whose every field is empty or whitespace. Same as --keep-empty-rows skip.")
+	flaggy.StringSlice(&options.base64Columns, "", "base64-columns",
+		"Decode the values of these columns from base64, in either the standard or URL-safe alphabet, with or "+
+			"without padding. Values which are not base64 are errors.")
+	flaggy.String(&options.base64Binary, "", "base64-binary",
+		"What to do with --base64-columns values which do not decode to UTF-8 text: keep them encoded, logging "+
+			"how many, wrap them as {\"$binary\": base64}, or treat them as an error. Defaults to keep. "+
+			"With wrap, values are decoded as they are emitted, so only JSON arrays can be emitted.")
+	flaggy.StringSlice(&options.urlDecodeColumns, "", "url-decode-columns",
+		"Decode the percent-encoded values of these columns, such as query strings. Values with invalid escapes "+
+			"are errors.")
+	flaggy.String(&options.urlDecodeMode, "", "url-decode-mode",
+		"How --url-decode-columns decodes values: query, where + is a space, or path, where + is left as it is. "+
+			"Defaults to query.")
+	flaggy.Bool(&options.fillEmptyHeaders, "", "fill-empty-headers",
+		"Name columns with empty or blank header names after their position, as column_1, column_2, and so on, "+
+			"with a suffix such as column_3_2 for names which are already taken. Without it, empty header names are "+
+			"errors.")
+	flaggy.String(&options.keyCase, "", "key-case",
+		"Convert header names to snake (first_name), camel (firstName), lower, or upper case, splitting them into "+
+			"words at spaces, punctuation, and for snake and camel, changes of case. Names are converted before "+
+			"--rename, --select, and other options refer to them. Names which become the same are errors.")
+	flaggy.Bool(&options.dedupeHeaders, "", "dedupe-headers",
+		"Rename columns whose header names repeat an earlier column's by appending _2, _3, and so on. "+
+			"Same as --dup-headers suffix.")
+	flaggy.String(&options.dupHeaders, "", "dup-headers",
+		"How to resolve columns whose header names repeat an earlier column's: error, first or last to keep the "+
+			"values of the first or last such column, suffix to rename them as --dedupe-headers does, or array to "+
+			"collapse their values into an array. Defaults to suffix, or to error with --strict-headers. "+
+			"The policy is logged when there are duplicates.")
+	flaggy.String(&options.headerMismatch, "", "header-mismatch",
+		"What to do when the header of an input differs from the first input's: error, or warn to log it and read "+
+			"the input's rows as columns of the first input anyway. Defaults to error.")
+	flaggy.Int(&options.skipRows, "", "skip-rows",
+		"Discard this many lines at the start of each input, such as banners before the header, without parsing "+
+			"them as CSV. Line numbers in messages count from after them.")
+	options.headerRows, options.headerJoin = 1, " "
+	flaggy.Int(&options.headerRows, "", "header-rows",
+		"Number of header rows, for exports which split column names across several rows. The names in each column "+
+			"are joined top to bottom with --header-join, where blank names in every row but the last repeat the "+
+			"name to their left, as for merged cells.")
+	flaggy.String(&options.headerJoin, "", "header-join",
+		"Separator by which --header-rows joins the names in each column.")
+	flaggy.Bool(&options.sanitizeHeaders, "", "sanitize-headers",
+		"Remove control characters and zero-width code points from header names, logging each change.")
+	options.truncateMarker = "…"
+	flaggy.Int(&options.maxCellLength, "", "max-cell-length",
+		"Truncate values longer than this many characters, appending --truncate-marker. "+
+			"Header names are never truncated.")
+	flaggy.String(&options.truncateMarker, "", "truncate-marker",
+		"Text appended to values truncated by --max-cell-length.")
+	flaggy.Bool(&options.truncateFlag, "", "truncate-flag",
+		"With --max-cell-length, add a <column>_truncated field set to \"true\" to records for each truncated value.")
+	flaggy.Bool(&options.fixCp1252, "", "fix-cp1252",
+		"Translate Windows-1252 curly quotes, dashes, and ellipses which appear among UTF-8 text, "+
+			"rather than emitting them as invalid UTF-8.")
+	options.compression = defaultCompressionMode
+	flaggy.String(&options.compression, "", "compression",
+		"How to decompress inputs: auto to decompress those which begin with the gzip magic number, gzip to "+
+			"decompress every input, or none. Defaults to auto.")
+	flaggy.String(&options.encoding, "", "encoding",
+		"Character encoding of inputs without a BOM, which are transcoded to UTF-8: "+strings.Join(inputEncodings, ", ")+
+			". Defaults to utf-8, where inputs beginning with a UTF-16 BOM are read as UTF-16, as Excel saves "+
+			"\"Unicode Text\". Bytes which are invalid in the encoding are an error.")
+	flaggy.Bool(&options.noSniff, "", "no-sniff",
+		"Read every input as CSV, rather than aborting when it looks like JSON or a ZIP (e.g. xlsx), "+
+			"gzip, Parquet, PDF, or other binary file.")
+	flaggy.Bool(&options.rfc4180Strict, "", "rfc4180-strict",
+		"Reject CSV data which does not strictly conform to RFC 4180, such as records not ending in CRLF. "+
+			"Violations cannot be skipped.")
+	flaggy.String(&dialectName, "", "dialect",
+		"Preset CSV parsing settings: "+strings.Join(dialectNames(), ", ")+", whose line endings and quoting apply "+
+			"to CSV written by from-json. Individual settings given by other flags take precedence. "+
+			"Use \"list\" to show each preset's settings.")
+	flaggy.String(&overrides.delimiter, "d", "delimiter",
+		`Field delimiter, as a single character, or \t for a tab. Defaults to a comma.`)
+	flaggy.Bool(&options.sniffDelimiter, "", "sniff-delimiter",
+		"Detect whether each input is delimited by commas, tabs, semicolons, or pipes from its first lines, "+
+			"unless --delimiter is given. Falls back to the delimiter of the dialect when this is ambiguous. "+
+			"Each decision is logged with --debug.")
+	flaggy.Bool(&overrides.lazyQuotes, "", "lazy-quotes",
+		"Allow quotes to appear in unquoted fields, and non-doubled quotes to appear in quoted fields, in the "+
+			"header as well as data rows. This trades strictness for tolerance, since stray quotes are kept as text "+
+			"rather than reported. Rows which still cannot be parsed are skipped with --skip-errors.")
+	flaggy.Bool(&overrides.trimLeadingSpace, "", "trim-leading-space",
+		"Ignore leading whitespace in fields.")
+	flaggy.String(&overrides.comment, "", "comment",
+		"Skip lines which begin with this character, such as #, as comments. Comment lines are not data rows, so "+
+			"they count towards neither --skip-rows nor --limit. A quoted field may still contain the character.")
+	flaggy.String(&options.execFilter, "", "exec-filter",
+		"Pass records through this shell command, which is run once. Each record is written to its stdin as a line "+
+			"of JSON with an added _seq field, and each line of JSON it writes to stdout is emitted in place of the "+
+			"record with the same _seq, in order. Records it does not write back are dropped. "+
+			"Not supported with --kafka-brokers or the aggregate subcommand.")
+	flaggy.Int(&options.batchSize, "", "batch",
+		"Emit records as they are converted, grouped into JSON arrays of up to this many records per line. "+
+			"By default, all records are emitted in a single JSON array.")
+	flaggy.Int(&options.offset, "", "offset",
+		"Discard this many rows at the start of the inputs. They are still parsed, so that errors in them abort "+
+			"unless --skip-errors is given, and rows skipped due to errors do not count towards it.")
+	flaggy.Int(&options.limit, "", "limit",
+		"Emit at most this many records, after any --offset, e.g. to sample a large file, and stop reading once "+
+			"they have been converted. With --sort-by, --reverse, or --shuffle, every row is read and the records "+
+			"are limited once ordered, so that --reverse --limit 2 emits the last two rows. "+
+			"Defaults to 0, which is unlimited.")
+	flaggy.Int(&options.maxRecords, "", "max-records",
+		"Abort once more than this many records would be held in memory, i.e. when emitting records reordered by "+
+			"--sort-by, --reverse, or --shuffle, or a --key object, or aggregating groups. "+
+			"Ignored when records are streamed, as they otherwise are.")
+	flaggy.String(&options.sortBy, "", "sort-by",
+		"Order records by these comma-separated keys, each given as field[:asc|desc[:mode]], where mode is "+
+			strings.Join(sortModes, ", ")+", e.g. dept,salary:desc:numeric. Records with equal keys keep their "+
+			"order. Like --reverse, every record is held in memory. With the aggregate subcommand, orders groups by "+
+			"group-by columns or aggregation names instead, which are otherwise ordered as first seen.")
+	flaggy.Bool(&options.reverse, "", "reverse",
+		"Emit records in reverse input order. Every record is held in memory, subject to --max-records, so records "+
+			"cannot be streamed with --batch or other streaming outputs.")
+	flaggy.Bool(&options.shuffle, "", "shuffle",
+		"Emit records in random order, e.g. for sampling. Like --reverse, every record is held in memory, and it "+
+			"cannot be combined with --sort-by or --reverse.")
+	flaggy.Int64(&options.shuffleSeed, "", "seed",
+		"Seed for --shuffle, so that the same input is always emitted in the same order. "+
+			"By default, the order differs on every run.")
+	flaggy.Int(&options.flushEvery, "", "flush-every",
+		"With --batch, flush output after every N records so that consumers receive them sooner. "+
+			"Use 1 to flush after every batch. By default, output is flushed once converting finishes.")
+
+	postOpts := postOptions{}
+	flaggy.String(&postOpts.url, "", "post-url",
+		"Send records to this URL via HTTP POST, as JSON arrays, instead of writing JSON to stdout.")
+	postOpts.batchSize = 1000
+	flaggy.Int(&postOpts.batchSize, "", "post-batch",
+		"Maximum number of records to send in each POST request.")
+	flaggy.StringSlice(&postOpts.headers, "", "post-header",
+		"Headers to send with each POST request, given as \"Name: value\".")
+	postOpts.retries = 3
+	flaggy.Int(&postOpts.retries, "", "post-retries",
+		"Number of times to retry a POST request after a transient failure, with exponential backoff.")
+	postOpts.timeout = 30 * time.Second
+	flaggy.Duration(&postOpts.timeout, "", "post-timeout",
+		"Time limit for each POST request.")
+	flaggy.Bool(&postOpts.dryRun, "", "post-dry-run",
+		"Print the POST requests that would be sent to stdout instead of sending them.")
+
+	urlOpts := urlInputOptions{timeout: 30 * time.Second}
+	flaggy.Duration(&urlOpts.timeout, "", "http-timeout",
+		"Time limit for the response to each input given as an http or https URL to begin. Its body is streamed "+
+			"as it is converted, without a time limit. Use 0 for no limit.")
+	flaggy.StringSlice(&urlOpts.headers, "", "http-header",
+		"Headers to send with the request for each input given as an http or https URL, given as \"Name: value\", "+
+			"e.g. for an auth token.")
+
+	kafkaOpts := kafkaOptions{acks: "all", batchSize: 100}
+	flaggy.StringSlice(&kafkaOpts.brokers, "", "kafka-brokers",
+		"Publish each record as a JSON message to Kafka using these brokers (host:port), instead of writing JSON to stdout.")
+	flaggy.String(&kafkaOpts.topic, "", "kafka-topic",
+		"Kafka topic to publish messages to.")
+	flaggy.String(&kafkaOpts.keyColumn, "", "kafka-key-column",
+		"Column whose value is used as each message's key. By default, messages are not keyed.")
+	flaggy.String(&kafkaOpts.acks, "", "kafka-acks",
+		"Acknowledgements required for each message to be delivered: none, one, or all.")
+	flaggy.Int(&kafkaOpts.batchSize, "", "kafka-batch",
+		"Maximum number of messages to publish at once.")
+
+	partitionOpts := partitionOptions{maxOpen: 64}
+	flaggy.String(&partitionOpts.column, "", "partition-by",
+		"Write each record as a line of JSON to <output-dir>/<column>=<value>/part.ndjson for its value of this "+
+			"column, instead of writing JSON to stdout. Empty values are written to the __null__ partition.")
+	flaggy.String(&partitionOpts.outputDir, "", "output-dir",
+		"Directory in which --partition-by creates a directory for each partition, "+
+			"and to which the watch subcommand writes converted files.")
+	flaggy.Int(&partitionOpts.maxOpen, "", "max-open-partitions",
+		"Maximum number of partition files to keep open at once. The least recently written file is closed, "+
+			"and reopened to append to it, when another must be opened.")
+
+	flaggy.StringSlice(&options.fileMeta, "", "file-meta",
+		"Add attributes of each input file to its records: mtime, as RFC 3339, and size, in bytes, "+
+			"as _file_mtime and _file_size. They are null for stdin and inputs which are not files.")
+	var rowNumber bool
+	rowNumberField := defaultRowNumberField
+	flaggy.Bool(&rowNumber, "", "row-number",
+		"Add the 1-based number of each data row to its record as "+defaultRowNumberField+", counted across every "+
+			"input. Rows skipped by --skip-errors leave gaps, so that numbers always map back to the input.")
+	flaggy.String(&rowNumberField, "", "row-number-field",
+		"Name of the field added by --row-number, which replaces the values of a column of that name. "+
+			"Implies --row-number.")
+	flaggy.Bool(&options.lineNumber, "", "line-number",
+		"Add the line of its input on which each data row starts to its record as "+lineNumberField+", which "+
+			"differs from --row-number when quoted values span lines or rows are preceded by blank lines.")
+	flaggy.String(&options.pluck, "", "pluck",
+		"Emit only each record's value of this column, e.g. [\"a@x.com\",\"b@y.com\"], rather than the record. "+
+			"With --nested, this may be the path of a nested value or object, such as address.city or address.")
+	flaggy.Bool(&options.pluckSkipMissing, "", "pluck-skip-missing",
+		"Omit records without the --pluck column, such as those an --exec-filter removed it from, "+
+			"rather than emitting null.")
+
+	flaggy.Bool(&options.inferTypes, "", "infer-types",
+		"Emit values which look like numbers, booleans (true or false, in any case), or are empty as JSON "+
+			"numbers, booleans, or null, rather than strings. Numbers are kept as written, and those which would lose "+
+			"information, like 007 or integers too large to be exact as floating-point, remain strings.")
+
+	var typeSpecs []string
+	flaggy.StringSlice(&typeSpecs, "", "types",
+		"Emit the values of columns as the types given for them, as column:type, where type is one of "+
+			strings.Join(columnTypes, ", ")+", e.g. age:int,active:bool. Values which are not of their type are errors, "+
+			"while empty values are null unless the type is string. Takes precedence over --infer-types.")
+
+	var transformSpecs []string
+	flaggy.StringSlice(&transformSpecs, "", "transform",
+		"Rewrite the values of a column, given as COLUMN=TRANSFORM, where TRANSFORM is lower, upper, trim, digits to "+
+			"keep only digits, or date:LAYOUT to rewrite dates in a Go time layout as RFC 3339, e.g. "+
+			"joined=date:01/02/2006. Values which cannot be rewritten are errors. May be given more than once.")
+
+	var emptyAsNull bool
+	flaggy.Bool(&emptyAsNull, "", "empty-as-null",
+		"Emit empty values as JSON null rather than \"\", even in --types string columns.")
+	flaggy.StringSlice(&options.nullValues, "", "null-value",
+		"Emit this value as JSON null rather than a string, e.g. NA, NULL, or \\N. May be given more than once. "+
+			"Takes precedence over --infer-types and --types, whose columns may hold it whatever their type.")
+
+	var nested bool
+	nestSeparator := "."
+	flaggy.Bool(&nested, "", "nested",
+		"Nest the values of columns in JSON objects by splitting their names on --nest-separator, so that columns "+
+			"user.name and user.address.city become {\"user\": {\"name\": ..., \"address\": {\"city\": ...}}}. "+
+			"A column may not be nested in another one, like user.name in user.")
+	flaggy.String(&nestSeparator, "", "nest-separator", "Separator of the names of nested objects. Implies --nested.")
+
+	var pretty bool
+	indent := 2
+	flaggy.Bool(&pretty, "", "pretty",
+		"Indent JSON arrays, NDJSON records, and aggregates over multiple lines, so that they are easier to read.")
+	flaggy.Int(&indent, "", "indent", "Number of spaces per level of indentation. Implies --pretty.")
+
+	outputFormat := "json"
+	flaggy.String(&outputFormat, "", "format",
+		"Format of the records emitted to stdout: json, ndjson for one record per line, which are streamed "+
+			"unless reordered, yaml for a sequence of mappings whose keys are in column order, xml for an element "+
+			"of each record within a root element, named by --xml-row and --xml-root, sql for INSERT statements "+
+			"into --table, es-bulk for the "+
+			"body of an Elasticsearch bulk API request, which requires --es-index, or geojson for a GeoJSON "+
+			"FeatureCollection of points located by --lat-column and --lon-column. With --batch, each es-bulk batch "+
+			"is a separate body, after a blank line.")
+	xmlOpts := xmlOptions{root: "records", row: "record"}
+	flaggy.String(&xmlOpts.root, "", "xml-root", "With --format xml, the name of the root element.")
+	flaggy.String(&xmlOpts.row, "", "xml-row", "With --format xml, the name of the element of each record.")
+	flaggy.Bool(&xmlOpts.attrNames, "", "xml-attr-names",
+		"With --format xml, emit each field as a field element with its column name in a name attribute, rather "+
+			"than as an element named by its column, whose characters which cannot appear in element names, "+
+			"including colons, are replaced by underscores.")
+	sqlOpts := sqlOptions{dialect: "postgres", batchSize: 1}
+	flaggy.String(&sqlOpts.table, "", "table", "With --format sql, the table into which records are inserted.")
+	flaggy.String(&sqlOpts.dialect, "", "sql-dialect",
+		"With --format sql, the dialect for which identifiers and strings are quoted: postgres, mysql, or "+
+			"sqlite. Defaults to postgres.")
+	flaggy.Int(&sqlOpts.batchSize, "", "sql-batch",
+		"With --format sql, the most records inserted by each statement, by a multi-row VALUES list.")
+	var esOpts esBulkOptions
+	flaggy.String(&esOpts.index, "", "es-index", "With --format es-bulk, the index of the documents.")
+	flaggy.String(&esOpts.idColumn, "", "es-id-column",
+		"With --format es-bulk, the column whose values are the document ids. By default, ids are generated.")
+	geoOpts := geoJsonOptions{latColumn: "lat", lonColumn: "lon"}
+	flaggy.String(&geoOpts.latColumn, "", "lat-column", "With --format geojson, the column of latitudes.")
+	flaggy.String(&geoOpts.lonColumn, "", "lon-column", "With --format geojson, the column of longitudes.")
+	flaggy.Bool(&geoOpts.bbox, "", "geojson-bbox",
+		"With --format geojson, add the bounding box of the features to the collection.")
+	keyOpts := keyOptions{sep: ":"}
+	flaggy.StringSlice(&keyOpts.columns, "", "key",
+		"Emit a JSON object mapping each record's key to the record, instead of an array. The key is the record's "+
+			"value of this column, or the values of several comma-separated columns joined by --key-sep. "+
+			"Duplicate keys are an error. With the diff subcommand, identifies the records to compare instead.")
+	flaggy.String(&keyOpts.sep, "", "key-sep",
+		"Separator by which --key joins the values of several columns. Values containing it are an error, "+
+			"since their keys would be ambiguous.")
+	flaggy.Bool(&keyOpts.strip, "", "key-strip",
+		"Remove the --key columns from each record.")
+	var keyBy string
+	flaggy.String(&keyBy, "", "key-by",
+		"Emit a JSON object mapping each record's value of this column to the rest of the record. "+
+			"Same as --key with --key-strip.")
+	flaggy.String(&keyOpts.duplicates, "", "key-duplicates",
+		"What to do with records whose --key is the same as an earlier record's: error, naming both records, "+
+			"first-wins to keep the earlier record, or last-wins to replace it with the later one. Defaults to error.")
+
+	aggOptions := aggregateOptions{}
+	aggregateCmd := flaggy.NewSubcommand("aggregate")
+	aggregateCmd.Description = "Groups CSV rows by column values and emits computed values for each group"
+	aggregateCmd.StringSlice(&aggOptions.groupBy, "g", "group-by",
+		"Column names whose values identify each group.")
+	aggregateCmd.StringSlice(&aggOptions.aggregations, "a", "agg",
+		"Computations given as name=function(column), e.g. total=sum(amount). "+
+			"Supported functions are count, sum, avg, min, max, and distinct-count. "+
+			"count() counts rows, while count(column) counts non-empty cells. distinct-count stops counting at "+
+			strconv.Itoa(maxDistinctValues)+" distinct values, which is logged.")
+	addFilePositionals(aggregateCmd, fileNames,
+		"The CSV files to aggregate, in order. If omitted, input is read from stdin.")
+
+	profilesCmd := flaggy.NewSubcommand("profiles")
+	profilesCmd.Description = "Lists the profiles in the config file, and their settings"
+
+	var plainHeaders, headerStats bool
+	headersCmd := flaggy.NewSubcommand("headers")
+	headersCmd.Description = "Prints the column names of the CSV input, as a JSON array"
+	headersCmd.Bool(&plainHeaders, "", "plain", "Print each column name on its own line instead.")
+	headersCmd.Bool(&headerStats, "", "stats",
+		"Also print the number of fields, and whether any column names are duplicates or empty.")
+	addFilePositionals(headersCmd, fileNames,
+		"The CSV files whose header to read, in order. If omitted, input is read from stdin.")
+
+	var diffOpts diffOptions
+	diffCmd := flaggy.NewSubcommand("diff")
+	diffCmd.Description = "Reports the records added, removed, and changed between an old and a new CSV file"
+	diffCmd.StringSlice(&diffOpts.ignoreColumns, "", "ignore-columns",
+		"Columns whose values are not compared, such as timestamps which always change.")
+	addFilePositionals(diffCmd, fileNames, "The old and new CSV files to compare, in order. Use - for stdin.")
+
+	fromJsonCmd := flaggy.NewSubcommand("from-json")
+	fromJsonCmd.Description = "Converts JSON arrays of flat objects, or NDJSON, back into CSV"
+	sanitizeCsv, noSanitizeCsv := true, false
+	fromJsonCmd.Bool(&sanitizeCsv, "", "sanitize-csv",
+		"Prefix values beginning with =, +, -, @, tab, or carriage return with a single quote, so that spreadsheets "+
+			"show them as text rather than evaluating them as formulas. JSON numbers such as -5 are exempt. "+
+			"On by default.")
+	fromJsonCmd.Bool(&noSanitizeCsv, "", "no-sanitize-csv", "Write values as they are, disabling --sanitize-csv.")
+	addFilePositionals(fromJsonCmd, fileNames,
+		"The JSON files to convert, in order. If omitted, input is read from stdin.")
+
+	var sniffDriftTypes bool
+	driftCmd := flaggy.NewSubcommand("drift")
+	driftCmd.Description = "Reports how the header rows of several CSV files differ from each other and from the first"
+	driftCmd.Bool(&sniffDriftTypes, "", "sniff-types",
+		"Also read the first data row of each file, and report the type of each of its values.")
+	addFilePositionals(driftCmd, fileNames, "The CSV files to compare, in order.")
+
+	distinctLimit := defaultDistinctLimit
+	statsCmd := flaggy.NewSubcommand("stats")
+	statsCmd.Description = "Prints the number of rows of the CSV input, and of empty and distinct values in each column"
+	statsCmd.Int(&distinctLimit, "", "distinct-limit",
+		"How many distinct values of each column to count exactly, beyond which only a lower bound is reported.")
+	addFilePositionals(statsCmd, fileNames,
+		"The CSV files to summarize, in order. If omitted, input is read from stdin.")
+
+	var enumLimit int
+	schemaCmd := flaggy.NewSubcommand("schema")
+	schemaCmd.Description = "Prints a JSON Schema describing the records of the CSV input, as typed by --infer-types"
+	schemaCmd.Int(&enumLimit, "", "enum-limit",
+		"List the values of string columns with at most this many distinct values as an enum. Off when 0.")
+	addFilePositionals(schemaCmd, fileNames,
+		"The CSV files to describe, in order. If omitted, input is read from stdin.")
+
+	serveOpts := serveOptions{listen: "localhost:8080", batchSize: 100}
+	serveCmd := flaggy.NewSubcommand("serve")
+	serveCmd.Description = "Converts the CSV data POSTed to an HTTP server, streaming JSON or NDJSON responses"
+	serveCmd.String(&serveOpts.listen, "", "listen", "Address on which to listen for requests.")
+	serveCmd.Int(&serveOpts.batchSize, "", "records-per-flush",
+		"How many records are written to each response between flushes.")
+
+	watchOpts := watchOptions{quiesce: 2 * time.Second, onSuccess: "keep"}
+	watchCmd := flaggy.NewSubcommand("watch")
+	watchCmd.Description = "Converts each CSV file which appears in a directory into --output-dir, until interrupted"
+	watchCmd.String(&watchOpts.dir, "", "dir",
+		"Directory to watch for *.csv files. Files already there are converted too.")
+	watchCmd.Duration(&watchOpts.quiesce, "", "quiesce",
+		"How long a file must go without changing before it is converted, so that files still being written are not.")
+	watchCmd.String(&watchOpts.onSuccess, "", "on-success",
+		"What to do with each file once it is converted: keep, delete, or move:DIR, where DIR is relative to the "+
+			"working directory rather than --dir. Files which fail to convert are always kept.")
+
+	// flaggy cannot attach subcommands at the same position as a positional value,
+	// so subcommands are only attached when named by the first argument.
+	flaggy.DefaultParser.AdditionalHelpAppend = "\nSubcommands:\n" +
+		"  " + aggregateCmd.Name + "   " + aggregateCmd.Description + "\n" +
+		"  " + diffCmd.Name + "        " + diffCmd.Description + "\n" +
+		"  " + driftCmd.Name + "       " + driftCmd.Description + "\n" +
+		"  " + fromJsonCmd.Name + "   " + fromJsonCmd.Description + "\n" +
+		"  " + headersCmd.Name + "     " + headersCmd.Description + "\n" +
+		"  " + profilesCmd.Name + "    " + profilesCmd.Description + "\n" +
+		"  " + schemaCmd.Name + "      " + schemaCmd.Description + "\n" +
+		"  " + serveCmd.Name + "       " + serveCmd.Description + "\n" +
+		"  " + statsCmd.Name + "       " + statsCmd.Description + "\n" +
+		"  " + watchCmd.Name + "       " + watchCmd.Description + "\n"
+	if len(os.Args) > 1 && os.Args[1] == aggregateCmd.Name {
+		flaggy.AttachSubcommand(aggregateCmd, 1)
+	} else if len(os.Args) > 1 && os.Args[1] == diffCmd.Name {
+		flaggy.AttachSubcommand(diffCmd, 1)
+	} else if len(os.Args) > 1 && os.Args[1] == driftCmd.Name {
+		flaggy.AttachSubcommand(driftCmd, 1)
+	} else if len(os.Args) > 1 && os.Args[1] == fromJsonCmd.Name {
+		flaggy.AttachSubcommand(fromJsonCmd, 1)
+	} else if len(os.Args) > 1 && os.Args[1] == headersCmd.Name {
+		flaggy.AttachSubcommand(headersCmd, 1)
+	} else if len(os.Args) > 1 && os.Args[1] == profilesCmd.Name {
+		flaggy.AttachSubcommand(profilesCmd, 1)
+	} else if len(os.Args) > 1 && os.Args[1] == schemaCmd.Name {
+		flaggy.AttachSubcommand(schemaCmd, 1)
+	} else if len(os.Args) > 1 && os.Args[1] == serveCmd.Name {
+		flaggy.AttachSubcommand(serveCmd, 1)
+	} else if len(os.Args) > 1 && os.Args[1] == statsCmd.Name {
+		flaggy.AttachSubcommand(statsCmd, 1)
+	} else if len(os.Args) > 1 && os.Args[1] == watchCmd.Name {
+		flaggy.AttachSubcommand(watchCmd, 1)
+	} else {
+		addFilePositionals(&flaggy.DefaultParser.Subcommand, fileNames,
+			"The CSV files or http(s) URLs to convert, in order. If omitted, input is read from stdin.")
+	}
+
+	// flaggy would parse a lone "-" as a flag, so it is swapped for a placeholder while parsing arguments.
+	// As a consequence, flags given "-" as their value must use the --flag=- form.
+	args := make([]string, len(os.Args)-1)
+	for i, arg := range os.Args[1:] {
+		if arg == stdinFileName {
+			arg = stdinPlaceholder
+		}
+		args[i] = arg
+	}
+	flaggy.ParseArgs(args)
+	// At most one subcommand is attached, as named by the first argument
+	if len(flaggy.DefaultParser.Subcommands) > 0 && flaggy.DefaultParser.Subcommands[0].Used {
+		undoubleRootSlices()
+	}
+	givenFileNames := make([]string, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		if fileName == stdinPlaceholder {
+			fileName = stdinFileName
+		}
+		if fileName != "" {
+			givenFileNames = append(givenFileNames, fileName)
+		}
+	}
+
+	given := givenFlags(&flaggy.DefaultParser.Subcommand, aggregateCmd, diffCmd, driftCmd, fromJsonCmd, headersCmd,
+		schemaCmd, serveCmd, statsCmd, watchCmd)
+	if given["profile"] || profilesCmd.Used {
+		config, err := readConfigFile(configFileName, given["config"])
+		if err != nil {
+			return err
+		}
+		if profilesCmd.Used {
+			return listProfiles(os.Stdout, config.Profiles)
+		}
+		// Profiles are applied between defaults and explicit flags, and only to what has not been given explicitly
+		settings, ok := config.Profiles[profileName]
+		if !ok {
+			return usageErrorf("unknown profile %q (expected one of %s)",
+				profileName, strings.Join(profileNames(config.Profiles), ", "))
+		}
+		if err := applyProfile(profileName, settings, given,
+			&flaggy.DefaultParser.Subcommand, aggregateCmd, watchCmd); err != nil {
+			return err
+		}
+	}
+	// The log format is set as soon as any profile has been applied, so that the errors which follow are logged in it
+	if err = setLogFormat(logFormatName); err != nil {
+		return &usageError{err}
+	}
+	if dialectName == "list" {
+		return listDialects(os.Stdout)
+	}
+	if keyBy != "" && len(keyOpts.columns) > 0 {
+		return usageErrorf("--key-by cannot be combined with --key")
+	} else if keyBy != "" {
+		keyOpts.columns, keyOpts.strip = []string{keyBy}, true
+	}
+	if err := checkKeyDuplicatePolicy(keyOpts.duplicates); err != nil {
+		return err
+	}
+	explainCli := func() explanation {
+		e := explainConversion(options, dialectName, given)
+		e.explainFlag("profile", profileName, given, "profile")
+		e.explainFlag("on_error", strings.Join(errorPolicies, ","), given, "on-error")
+		e.explainFlag("reject_file", rejectFileName, given, "reject-file")
+		e.explainFlag("max_errors", maxErrors, given, "max-errors")
+		e.explainFlag("validate_regex", strings.Join(regexSpecs, ","), given, "validate-regex")
+		e.explainFlag("required", strings.Join(requiredColumns, ","), given, "required")
+		e.explainFlag("range", strings.Join(rangeSpecs, ","), given, "range")
+		e.explainFlag("validate_date", strings.Join(dateSpecs, ","), given, "validate-date")
+		e.explainFlag("transform", strings.Join(transformSpecs, ","), given, "transform")
+		e.explainFlag("enum", strings.Join(enumSpecs, ","), given, "enum")
+		e.explainFlag("enum_file", strings.Join(enumFileSpecs, ","), given, "enum-file")
+		e.explainFlag("enum_ci", enumCaseInsensitive, given, "enum-ci")
+		e.explainFlag("debug_row_length", debugRowLength, given, "debug-row-length")
+		e.explainFlag("trim", trim, given, "trim")
+		for _, option := range bundled {
+			e[option] = explainedOption{e[option].Value, sourceBundle}
+		}
+		e.explainFlag("http_timeout", urlOpts.timeout.String(), given, "http-timeout")
+		e.explainFlag("defang_formulas", defangFormulas, given, "defang-formulas")
+		e.explainFlag("strip_leading_formula_chars", stripFormulaEscapes, given, "strip-leading-formula-chars")
+		switch {
+		case aggregateCmd.Used:
+			e["output"] = explainedOption{aggregateCmd.Name, sourceFlag}
+		case watchCmd.Used:
+			e["output"] = explainedOption{"watch " + partitionOpts.outputDir, sourceFlag}
+		case fromJsonCmd.Used:
+			e["output"] = explainedOption{"csv", sourceFlag}
+		case postOpts.url != "":
+			e["output"] = explainedOption{"post " + postOpts.url, sourceFlag}
+		case partitionOpts.column != "":
+			e["output"] = explainedOption{"partitions " + partitionOpts.outputDir, sourceFlag}
+		case len(keyOpts.columns) > 0:
+			e["output"] = explainedOption{"object keyed by " + strings.Join(keyOpts.columns, keyOpts.sep), sourceFlag}
+		case len(kafkaOpts.brokers) > 0:
+			e["output"] = explainedOption{"kafka " + kafkaOpts.topic, sourceFlag}
+		case outputFormat == "es-bulk":
+			e["output"] = explainedOption{"es-bulk " + esOpts.index, sourceFlag}
+		case outputFormat == "geojson":
+			e["output"] = explainedOption{"geojson", sourceFlag}
+		case outputFormat == "ndjson":
+			e["output"] = explainedOption{"ndjson", sourceFlag}
+		case outputFormat == "yaml":
+			e["output"] = explainedOption{"yaml", sourceFlag}
+		case outputFormat == "xml":
+			e["output"] = explainedOption{"xml " + xmlOpts.root, sourceFlag}
+		case outputFormat == "sql":
+			e["output"] = explainedOption{"sql " + sqlOpts.table, sourceFlag}
+		default:
+			e["output"] = explainedOption{"stdout", sourceDefault}
+		}
+		return e
+	}
+	if reportFile != "" {
+		started := time.Now()
+		options.stats.maxErrors = maxReportedErrors
+		defer func() {
+			report := newRunReport(givenFileNames, explainCli(), options.stats, time.Since(started), err)
+			if reportErr := writeReport(reportFile, report); reportErr != nil && err == nil {
+				err = &outputError{reportErr}
+			}
+		}()
+	}
+	options.dialect, err = resolveDialect(dialectName, overrides, given)
+	if err != nil {
+		return &usageError{err}
+	}
+	// Bundles are applied once the dialect is resolved, since they may change its settings
+	if strict && lenient {
+		return usageErrorf("--strict and --lenient cannot be combined")
+	} else if strict {
+		bundled = applyBundle(strictBundle(&options), given)
+	} else if lenient {
+		bundled = applyBundle(lenientBundle(&options, &trim), given)
+	}
+	if options.rfc4180Strict && options.dialect.readSettings() != defaultDialect {
+		return usageErrorf("--rfc4180-strict cannot be combined with other dialect settings")
+	}
+
+	if options.errorHandler, err = parseErrorPolicy(errorPolicies, options.skipErrors); err != nil {
+		return
+	}
+	if maxErrors < 0 {
+		return usageErrorf("--max-errors cannot be negative")
+	} else if maxErrors > 0 && !options.skipErrors && options.errorHandler == nil {
+		return usageErrorf("--max-errors can only be used with --skip-errors or --on-error")
+	}
+	options.maxSkippedRows = maxErrors
+	if rejectFileName != "" && !options.skipErrors && options.errorHandler == nil {
+		return usageErrorf("--reject-file can only be used with --skip-errors or --on-error")
+	} else if rejectFileName != "" {
+		options.rejects = &rejectFile{fileName: rejectFileName}
+		defer func() {
+			if closeErr := options.rejects.close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}()
+	}
+	var validators []func(rec record) error
+	if len(requiredColumns) > 0 {
+		validators = append(validators, validateRequired(requiredColumns))
+	}
+	if len(regexSpecs) > 0 {
+		validations, err := parseRegexValidations(regexSpecs)
+		if err != nil {
+			return err
+		}
+		validators = append(validators, validateRegexes(validations))
+	}
+	if len(rangeSpecs) > 0 {
+		validations, err := parseRangeValidations(rangeSpecs)
+		if err != nil {
+			return err
+		}
+		validators = append(validators, validateRanges(validations))
+	}
+	if len(dateSpecs) > 0 {
+		validations, err := parseDateValidations(dateSpecs)
+		if err != nil {
+			return err
+		}
+		validators = append(validators, validateDates(validations))
+	}
+	if len(enumSpecs) > 0 || len(enumFileSpecs) > 0 {
+		validations, err := parseEnumValidations(enumSpecs, enumFileSpecs, enumCaseInsensitive)
+		if err != nil {
+			return err
+		}
+		validators = append(validators, validateEnums(validations))
+	} else if enumCaseInsensitive {
+		return usageErrorf("--enum-ci requires --enum or --enum-file")
+	}
+	options.validate = combineValidators(validators...)
+	if debugRowLength < 1 {
+		return usageErrorf("--debug-row-length must be at least 1")
+	} else if debug {
+		options.skippedRowTextLimit = debugRowLength
+		options.logSniffedDelimiters = true
+		options.progress = true
+	}
+	if options.progressRows < 0 {
+		return usageErrorf("--progress-rows cannot be negative")
+	} else if options.progressInterval < 0 {
+		return usageErrorf("--progress-interval cannot be negative")
+	}
+	if trim {
+		options.trimHeaders = true
+		options.transforms = append(options.transforms, trimValue)
+	}
+	if defangFormulas {
+		options.transforms = append(options.transforms, defangFormula)
+	}
+	if stripFormulaEscapes {
+		options.transforms = append(options.transforms, stripFormulaEscape)
+	}
+
+	if options.execFilter != "" && (aggregateCmd.Used || len(kafkaOpts.brokers) > 0) {
+		return usageErrorf("--exec-filter cannot be combined with --kafka-brokers or the aggregate subcommand")
+	}
+	if aggregateCmd.Used && emitsRawRows(errorPolicies) {
+		return usageErrorf("--on-error raw cannot be used with the aggregate subcommand, which has no records")
+	}
+	if options.renames, err = parseRenames(renameSpecs); err != nil {
+		return err
+	}
+	if err := checkSelectedColumns(options.selectColumns, options.excludeColumns, nil); err != nil {
+		return err
+	} else if (len(options.selectColumns) > 0 || len(options.excludeColumns) > 0) && aggregateCmd.Used {
+		return usageErrorf("--select and --exclude cannot be used with the aggregate subcommand, which has no records")
+	}
+	if stateFileName != "" && aggregateCmd.Used {
+		return usageErrorf("--state-file cannot be combined with the aggregate subcommand")
+	} else if stateFileName != "" && (options.limit > 0 || options.offset > 0) {
+		// The state file records where converting stopped, which is only the end of each input when all of it is
+		return usageErrorf("--state-file cannot be combined with --limit or --offset")
+	}
+	if options.skipRows < 0 {
+		return usageErrorf("--skip-rows cannot be negative")
+	}
+	if options.offset < 0 {
+		return usageErrorf("--offset cannot be negative")
+	} else if options.limit < 0 {
+		return usageErrorf("--limit cannot be negative")
+	} else if options.batchSize < 0 {
+		return usageErrorf("--batch cannot be negative")
+	} else if postOpts.batchSize < 1 {
+		return usageErrorf("--post-batch must be at least 1")
+	} else if kafkaOpts.batchSize < 1 {
+		return usageErrorf("--kafka-batch must be at least 1")
+	}
+	if options.headerRows < 1 {
+		return usageErrorf("--header-rows must be at least 1")
+	} else if options.headerRows > 1 && len(options.colNames) > 0 {
+		return usageErrorf("--header-rows cannot be combined with --force-columns, which reads no header rows")
+	}
+	if err := checkBase64Columns(nil, options.base64Binary, nil); err != nil {
+		return err
+	} else if options.base64Binary == "wrap" && (aggregateCmd.Used || postOpts.url != "" ||
+		partitionOpts.column != "" || len(kafkaOpts.brokers) > 0 || len(keyOpts.columns) > 0 || options.pluck != "" ||
+		(outputFormat != "json" && outputFormat != "ndjson")) {
+		return usageErrorf("--base64-binary wrap can only be used when emitting JSON arrays or NDJSON")
+	}
+	if options.columnTypes, err = parseColumnTypes(typeSpecs); err != nil {
+		return err
+	}
+	if options.columnTransforms, err = parseColumnTransforms(transformSpecs); err != nil {
+		return err
+	}
+	if options.inferTypes && (aggregateCmd.Used || postOpts.url != "" || partitionOpts.column != "" ||
+		len(kafkaOpts.brokers) > 0 || len(keyOpts.columns) > 0 || (outputFormat != "json" && outputFormat != "ndjson")) {
+		return usageErrorf("--infer-types can only be used when emitting JSON arrays or NDJSON")
+	} else if len(options.columnTypes) > 0 && (aggregateCmd.Used || postOpts.url != "" || partitionOpts.column != "" ||
+		len(kafkaOpts.brokers) > 0 || len(keyOpts.columns) > 0 || (outputFormat != "json" && outputFormat != "ndjson")) {
+		return usageErrorf("--types can only be used when emitting JSON arrays or NDJSON")
+	}
+	if emptyAsNull {
+		options.nullValues = append(options.nullValues, "")
+	}
+	if len(options.nullValues) > 0 && (aggregateCmd.Used || postOpts.url != "" || partitionOpts.column != "" ||
+		len(kafkaOpts.brokers) > 0 || len(keyOpts.columns) > 0 ||
+		(outputFormat != "json" && outputFormat != "ndjson" && outputFormat != "sql")) {
+		return usageErrorf("--empty-as-null and --null-value can only be used when emitting JSON arrays, NDJSON, " +
+			"or SQL")
+	}
+	if given["row-number-field"] && rowNumberField == "" {
+		return usageErrorf("--row-number-field cannot be empty")
+	} else if rowNumber || given["row-number-field"] {
+		options.rowNumberField = rowNumberField
+		options.rowNumberReplaces = given["row-number-field"]
+	}
+	if given["nest-separator"] && nestSeparator == "" {
+		return usageErrorf("--nest-separator cannot be empty")
+	} else if nested || given["nest-separator"] {
+		options.nestSeparator = nestSeparator
+	}
+	if options.nestSeparator != "" && (aggregateCmd.Used || postOpts.url != "" || partitionOpts.column != "" ||
+		len(kafkaOpts.brokers) > 0 || len(keyOpts.columns) > 0 ||
+		(outputFormat != "json" && outputFormat != "ndjson")) {
+		return usageErrorf("--nested can only be used when emitting JSON arrays or NDJSON records")
+	}
+	if err := checkUrlDecodeColumns(nil, options.urlDecodeMode, nil); err != nil {
+		return err
+	}
+	if err := checkCompressionMode(options.compression); err != nil {
+		return err
+	}
+	if options.encoding, err = normalizeEncoding(options.encoding); err != nil {
+		return err
+	}
+	if _, err := parseHeaders(urlOpts.headers); err != nil {
+		return err
+	} else if urlOpts.timeout < 0 {
+		return usageErrorf("--http-timeout cannot be negative")
+	}
+	if err := checkEmptyRowPolicy(options.emptyRows); err != nil {
+		return err
+	} else if given["keep-empty-rows"] && options.skipBlankRecords && options.emptyRows != "skip" {
+		return usageErrorf("--skip-blank-records cannot be combined with --keep-empty-rows %s", options.emptyRows)
+	}
+	if err := checkExtraFieldPolicy(options.extraFields); err != nil {
+		return err
+	} else if given["extra-fields"] && given["keep-extra-fields"] {
+		return usageErrorf("--keep-extra-fields cannot be combined with --extra-fields")
+	} else if given["extra-field-name"] && options.extraFields != "keep" {
+		return usageErrorf("--extra-field-name can only be used with --extra-fields keep")
+	} else if options.extraFields == "keep" && (aggregateCmd.Used || postOpts.url != "" ||
+		partitionOpts.column != "" || len(kafkaOpts.brokers) > 0 || len(keyOpts.columns) > 0 ||
+		(outputFormat != "json" && outputFormat != "ndjson") || options.pluck != "") {
+		return usageErrorf("--extra-fields keep can only be used when emitting JSON arrays or NDJSON")
+	}
+	if err := checkHeaderMismatchPolicy(options.headerMismatch); err != nil {
+		return err
+	}
+	if err := checkKeyCase(options.keyCase); err != nil {
+		return err
+	}
+	if err := checkDupHeaderPolicy(options.dupHeaders); err != nil {
+		return err
+	} else if given["dup-headers"] && given["dedupe-headers"] && options.dupHeaders != "suffix" {
+		return usageErrorf("--dedupe-headers cannot be combined with --dup-headers %s", options.dupHeaders)
+	} else if options.dupHeaders == "array" && (aggregateCmd.Used || postOpts.url != "" ||
+		partitionOpts.column != "" || len(kafkaOpts.brokers) > 0 || len(keyOpts.columns) > 0) {
+		return usageErrorf("--dup-headers array can only be used when emitting JSON arrays")
+	}
+	if !isOutputFormat(outputFormat) {
+		return usageErrorf("unknown --format %q (expected one of %s)", outputFormat, strings.Join(outputFormats, ", "))
+	} else if outputFormat != "json" && (aggregateCmd.Used || watchCmd.Used || postOpts.url != "" ||
+		partitionOpts.column != "" || len(kafkaOpts.brokers) > 0 || len(keyOpts.columns) > 0) {
+		return usageErrorf("--format %s can only be used when emitting records to stdout", outputFormat)
+	} else if outputFormat != "json" && outputFormat != "ndjson" && (options.pluck != "" ||
+		options.dupHeaders == "array") {
+		return usageErrorf("--format %s cannot be combined with --pluck or --dup-headers array", outputFormat)
+	} else if outputFormat == "yaml" && options.batchSize > 0 {
+		return usageErrorf("--format yaml cannot be combined with --batch")
+	}
+	options.ndjson = outputFormat == "ndjson"
+	options.yaml = outputFormat == "yaml"
+	if given["indent"] && indent < 1 {
+		return usageErrorf("--indent must be at least 1")
+	} else if pretty || given["indent"] {
+		options.indent = indent
+	}
+	if options.indent > 0 && (serveCmd.Used || postOpts.url != "" || partitionOpts.column != "" ||
+		len(kafkaOpts.brokers) > 0 || len(keyOpts.columns) > 0 || (outputFormat != "json" && outputFormat != "ndjson")) {
+		return usageErrorf("--pretty can only be used when emitting JSON arrays, NDJSON, or aggregates")
+	}
+	if teeFileName != "" && (watchCmd.Used || postOpts.url != "" || partitionOpts.column != "" ||
+		len(kafkaOpts.brokers) > 0) {
+		return usageErrorf("--tee can only be used when writing output to stdout")
+	}
+	if outputFileName != "" && (watchCmd.Used || serveCmd.Used || postOpts.url != "" || partitionOpts.column != "" ||
+		len(kafkaOpts.brokers) > 0) {
+		return usageErrorf("--output can only be used when output would otherwise be written to stdout")
+	}
+	// These formats are always written as records are converted, so records cannot be ordered
+	streamedFormat := outputFormat == "geojson" || outputFormat == "xml" || outputFormat == "sql"
+	if options.reverse && (aggregateCmd.Used || options.batchSize > 0 || postOpts.url != "" ||
+		partitionOpts.column != "" || len(kafkaOpts.brokers) > 0 || streamedFormat) {
+		return usageErrorf("--reverse cannot be used when records are streamed or aggregated")
+	}
+	if options.sortBy != "" && !aggregateCmd.Used && (options.batchSize > 0 || watchCmd.Used || postOpts.url != "" ||
+		partitionOpts.column != "" || len(kafkaOpts.brokers) > 0 || streamedFormat) {
+		return usageErrorf("--sort-by cannot be used when records are streamed")
+	}
+	if options.shuffle && (aggregateCmd.Used || options.batchSize > 0 || watchCmd.Used || postOpts.url != "" ||
+		partitionOpts.column != "" || len(kafkaOpts.brokers) > 0 || streamedFormat) {
+		return usageErrorf("--shuffle cannot be used when records are streamed or aggregated")
+	} else if options.shuffle && !given["seed"] {
+		options.shuffleSeed = time.Now().UnixNano()
+	}
+	if options.pluck != "" && (aggregateCmd.Used || postOpts.url != "" || partitionOpts.column != "" ||
+		len(kafkaOpts.brokers) > 0 || len(keyOpts.columns) > 0) {
+		return usageErrorf("--pluck can only be used when emitting JSON arrays to stdout")
+	}
+
+	// Options are explained only once every one has been resolved, so that their effective values are shown
+	if explain || explainOnly {
+		if err = writeExplanation(os.Stderr, explainCli()); err != nil || explainOnly {
+			return
+		}
+	}
+
+	if watchCmd.Used {
+		if postOpts.url != "" || partitionOpts.column != "" || len(kafkaOpts.brokers) > 0 ||
+			len(keyOpts.columns) > 0 || stateFileName != "" {
+			return usageErrorf("watch writes JSON files, so it cannot be combined with other outputs or --state-file")
+		}
+		watchOpts.outputDir = partitionOpts.outputDir
+		return watchDir(options, watchOpts, interrupted())
+	}
+	if serveCmd.Used {
+		if postOpts.url != "" || partitionOpts.column != "" || len(kafkaOpts.brokers) > 0 ||
+			len(keyOpts.columns) > 0 || stateFileName != "" || teeFileName != "" || outputFormat != "json" {
+			return usageErrorf("serve responds with JSON, so it cannot be combined with other outputs or --state-file")
+		} else if serveOpts.batchSize < 1 {
+			return usageErrorf("--records-per-flush must be at least 1")
+		}
+		return serve(options, serveOpts, interrupted())
+	}
+	if given["sanitize-csv"] && given["no-sanitize-csv"] {
+		return usageErrorf("--sanitize-csv and --no-sanitize-csv cannot be combined")
+	}
+	if fromJsonCmd.Used && (postOpts.url != "" || partitionOpts.column != "" || len(kafkaOpts.brokers) > 0 ||
+		len(keyOpts.columns) > 0 || stateFileName != "" || teeFileName != "" || outputFormat != "json") {
+		return usageErrorf("from-json writes CSV, so it cannot be combined with other outputs or --state-file")
+	}
+
+	if len(givenFileNames) == 0 && stdinIsTerminal() {
+		flaggy.ShowHelp("")
+		return usageErrorf("reading from stdin — pipe CSV data or pass a filename, use - to force stdin")
+	}
+
+	// Interrupts stop conversion between rows, so that the output file or state file is not left partially written
+	ctx, cancel := interruptContext()
+	defer cancel()
+	options.ctx = ctx
+
+	stdout := io.Writer(os.Stdout)
+	if outputFileName != "" {
+		var file *atomicFile
+		if file, err = createAtomicFile(outputFileName); err != nil {
+			return &outputError{err}
+		}
+		defer func() {
+			if commitErr := file.finish(err); commitErr != nil {
+				err = &outputError{commitErr}
+			}
+		}()
+		stdout = file
+		output.Reset(file)
+	}
+
+	options.csvInputs, err = getCsvFiles(givenFileNames, options.compression, urlOpts)
+	if err != nil {
+		return
+	}
+	defer closeCsvFiles(options.csvInputs)
+	if headersCmd.Used || headersOnly {
+		return printHeaders(stdout, options, plainHeaders, headerStats)
+	}
+	if fromJsonCmd.Used {
+		return json2Csv(fromJsonOptions{
+			jsonInputs: options.csvInputs,
+			csvOutput:  stdout,
+			colNames:   options.colNames,
+			delimiter:  options.dialect.delimiter,
+			crlf:       options.dialect.crlf,
+			quoteAll:   options.dialect.quoteAll,
+			sanitize:   sanitizeCsv && !noSanitizeCsv,
+		})
+	}
+	if driftCmd.Used {
+		report, err := csvDrift(options, sniffDriftTypes)
+		if err != nil {
+			return err
+		}
+		return writeDriftReport(stdout, report)
+	}
+	if statsCmd.Used {
+		if distinctLimit < 1 {
+			return usageErrorf("--distinct-limit must be at least 1")
+		}
+		report, err := csvStats(options, distinctLimit)
+		if err != nil {
+			return err
+		}
+		return writeStatsReport(stdout, report)
+	}
+	if schemaCmd.Used {
+		if enumLimit < 0 {
+			return usageErrorf("--enum-limit cannot be negative")
+		}
+		schema, err := csvSchema(options, enumLimit)
+		if err != nil {
+			return err
+		}
+		return writeSchema(stdout, schema)
+	}
+	if diffCmd.Used {
+		diffOpts.key = keyOpts
+		if len(options.csvInputs) != 2 || len(keyOpts.columns) == 0 {
+			return usageErrorf("diff requires --key and exactly two files")
+		}
+		oldOptions, newOptions := options, options
+		oldOptions.csvInputs, newOptions.csvInputs = options.csvInputs[:1], options.csvInputs[1:]
+		report, err := diffCsv(oldOptions, newOptions, diffOpts)
+		if err != nil {
+			return err
+		}
+		return writeDiffReport(stdout, report)
+	}
+	var tee *teeWriter
+	if teeFileName != "" {
+		if tee, err = newTeeWriter(stdout, teeFileName, teePrimary); err != nil {
+			return
+		}
+		// Writing to a closed pipe then fails with EPIPE rather than killing the process, so the file can be finished
+		signal.Ignore(syscall.SIGPIPE)
+		output.Reset(tee)
+	}
+
+	convert := func() error {
+		if aggregateCmd.Used {
+			aggOptions.conversionOptions = options
+			return aggregate(aggOptions)
+		}
+		if postOpts.url != "" {
+			return csv2Post(options, postOpts)
+		}
+		if partitionOpts.column != "" {
+			return csv2Partitions(options, partitionOpts)
+		}
+		if len(kafkaOpts.brokers) > 0 {
+			writer, err := newKafkaWriter(kafkaOpts)
+			if err != nil {
+				return err
+			}
+			return csv2Kafka(options, kafkaOpts, writer)
+		}
+		if len(keyOpts.columns) > 0 {
+			return csv2KeyedJson(options, keyOpts)
+		}
+		switch outputFormat {
+		case "es-bulk":
+			return csv2EsBulk(options, esOpts)
+		case "geojson":
+			return csv2GeoJson(options, geoOpts)
+		case "xml":
+			return csv2Xml(options, xmlOpts)
+		case "sql":
+			return csv2Sql(options, sqlOpts)
+		}
+		return csv2Json(options)
+	}
+	if stateFileName != "" {
+		err = withStateFile(stateFileName, givenFileNames, options.csvInputs, newHeaderLines(options),
+			func(inputs []io.Reader) error {
+				options.csvInputs = inputs
+				if err := convert(); err != nil {
+					return err
+				}
+				// Output must be written in full before the state file records it as converted
+				if err := output.Flush(); err != nil {
+					return &outputError{err}
+				}
+				return nil
+			})
+	} else {
+		err = convert()
+	}
+	if flushErr := output.Flush(); err == nil && flushErr != nil {
+		err = &outputError{flushErr}
+	}
+	if tee != nil {
+		if commitErr := tee.commit(err); err == nil && commitErr != nil {
+			err = &outputError{commitErr}
+		}
+	}
+	if err == nil && options.stats.rowsSkipped > 0 {
+		err = skippedRowsError{options.stats.rowsSkipped}
+	}
+	return
+}
+
+// csv2Json converts CSV data from io.Reader to a JSON array and emits the result to io.Writer.
+// When `options.colNames` is empty, headers are derived from the first line of the CSV file.
+// Records are read from a recordScanner, and so converted exactly as by newRecordScanner(options).
+// Records are written to the array as they are converted, by a jsonArrayWriter, unless they must all be read first
+// to be emitted in a different order, so an error may leave the array unterminated.
+// When `options.batchSize` is set, records are instead emitted as one JSON array per line containing up to
+// `options.batchSize` records, and `options.flushEvery` applies.
+// When `options.pluck` is set, the arrays contain the plucked value of each record instead, which must be one of its
+// fields, or with `options.nestSeparator`, the path of a nested value.
+// When duplicate header names are collapsed into arrays by `options.dupHeaders`, the records hold those arrays.
+// When `options.ndjson` is set, each record is emitted as a line of JSON instead of in arrays.
+// When `options.indent` is set, each array or record is indented over multiple lines instead.
+// When `options.inferTypes` is set, values are emitted as the JSON values they look like, as by inferValue(), and
+// the values of `options.columnTypes` are emitted as the types given, as by coerceValue(). Values among
+// `options.nullValues` are emitted as null, whatever their type.
+// When `options.nestSeparator` is set, values are nested in objects as by nestValues(), after any other changes.
+// Returns any errors from reading CSV or encoding JSON.
+func csv2Json(options conversionOptions) error {
+	if err := checkBatchOptions(options, options.batchSize); err != nil {
+		return err
+	}
+	typed := options.inferTypes || len(options.columnTypes) > 0 || len(options.nullValues) > 0
+	// Padded fields are only null when values are typed, and otherwise empty like any other missing value
+	// Records are plucked from once converted like any other when plucking a path from nested records
+	pluckRecords := options.pluck != "" && options.nestSeparator == ""
+	options.padNulls = typed && !pluckRecords
+	scanner, err := newRecordScanner(options)
+	if err != nil {
+		return err
+	}
+	reader, colNames := scanner.reader, scanner.Columns()
+	if options.nestSeparator != "" {
+		if err := checkNestedColumns(colNames, options.nestSeparator); err != nil {
+			scanner.Close()
+			return err
+		}
+	}
+	if options.pluck != "" && options.execFilter == "" {
+		// The fields of records written back by an exec filter are not known until they are read
+		if err := checkPluckField(options.pluck, reader.fieldNames(colNames), options.nestSeparator); err != nil {
+			scanner.Close()
+			return err
+		}
+	}
+	enc := newJsonEncoder(options.jsonOutput, options.indent)
+	unflushed := 0
+	batchSize := options.batchSize
+	var array *jsonArrayWriter
+	var sequence *yamlSequenceWriter
+	if options.yaml {
+		sequence = newYamlSequenceWriter(options.jsonOutput, colNames, options.nestSeparator)
+	}
+	if batchSize == 0 && options.sortBy == "" && !options.reverse && !options.shuffle {
+		batchSize = 1
+		if !options.ndjson && !options.yaml {
+			array = newJsonArrayWriter(options.jsonOutput, options.indent)
+		}
+	}
+	emit := func(batch []record) error {
+		var values interface{} = batch
+		if (reader.arrayFields != nil || reader.extraArray != "" || reader.base64Binary == "wrap" || typed ||
+			options.nestSeparator != "" || len(options.fileMeta) > 0) &&
+			!pluckRecords {
+			collapsed := make([]map[string]interface{}, len(batch))
+			for i, rec := range batch {
+				collapsed[i] = collapseDuplicateFields(rec, reader.arrayFields)
+				if reader.padNulls {
+					reader.fillPaddedNulls(rec, collapsed[i], colNames)
+				}
+				if reader.extraArray != "" {
+					collapsed[i] = collapseExtraFields(collapsed[i], reader.extraArray)
+				}
+				if reader.base64Binary == "wrap" {
+					collapsed[i] = base64Values(collapsed[i], reader.base64Columns)
+				}
+				if typed {
+					collapsed[i] = typedValues(collapsed[i], options.columnTypes, options.inferTypes,
+						options.nullValues)
+				}
+				collapsed[i] = typedFileMeta(collapsed[i], options.fileMeta)
+				if options.nestSeparator != "" {
+					var err error
+					if collapsed[i], err = nestValues(collapsed[i], options.nestSeparator); err != nil {
+						return err
+					}
+				}
+			}
+			values = collapsed
+		}
+		if options.pluck != "" && !pluckRecords {
+			plucked := pluckNestedValues(values.([]map[string]interface{}), options.pluck, options.nestSeparator,
+				options.pluckSkipMissing)
+			if options.batchSize > 0 && len(plucked) == 0 {
+				return nil
+			}
+			values = plucked
+		} else if options.pluck != "" {
+			plucked := pluckValues(batch, options.pluck, options.pluckSkipMissing)
+			if options.batchSize > 0 && len(plucked) == 0 {
+				return nil
+			}
+			if typed {
+				for i, value := range plucked {
+					plucked[i] = typedValue(options.pluck, value, options.columnTypes, options.inferTypes,
+						options.nullValues)
+				}
+			}
+			for _, name := range options.fileMeta {
+				if options.pluck != fileMetaFieldName(name) {
+					continue
+				}
+				for i, value := range plucked {
+					if s, ok := value.(string); ok {
+						plucked[i] = fileMetaValue(name, s)
+					}
+				}
+			}
+			values = plucked
+		}
+		if sequence != nil {
+			for _, item := range batchElements(values) {
+				if err := sequence.write(item); err != nil {
+					return err
+				}
+			}
+		} else if array != nil {
+			for _, element := range batchElements(values) {
+				if err := array.write(element); err != nil {
+					return err
+				}
+			}
+		} else if options.ndjson {
+			for _, line := range batchElements(values) {
+				if err := enc.Encode(line); err != nil {
+					return &outputError{err}
+				}
+			}
+		} else if err := enc.Encode(values); err != nil {
+			return &outputError{err}
+		}
+
+		unflushed += len(batch)
+		if f, ok := options.jsonOutput.(interface{ Flush() error }); ok && options.batchSize > 0 &&
+			options.flushEvery > 0 && unflushed >= options.flushEvery {
+			unflushed = 0
+			if err := f.Flush(); err != nil {
+				return &outputError{err}
+			}
+		}
+		return nil
+	}
+	batch := make([]record, 0, batchSize)
+	for scanner.Next() {
+		batch = append(batch, scanner.Record())
+		if batchSize > 0 && len(batch) == batchSize {
+			if err = emit(batch); err != nil {
+				break
+			}
+			batch = batch[:0]
+		}
+	}
+	if err != nil {
+		scanner.Close()
+	} else if err = scanner.Err(); err == nil && (batchSize == 0 || len(batch) > 0) {
+		// Ordered records are emitted together once every one has been read, even if there are none
+		err = emit(batch)
+	}
+	if err != nil {
+		return err
+	} else if sequence != nil {
+		return sequence.close()
+	} else if array != nil {
+		return array.close()
+	}
+	return nil
+}
+
+// newJsonEncoder returns a json.Encoder which writes to w, indenting by indent spaces per level when it is set.
+func newJsonEncoder(w io.Writer, indent int) *json.Encoder {
+	enc := json.NewEncoder(w)
+	if indent > 0 {
+		enc.SetIndent("", strings.Repeat(" ", indent))
+	}
+	return enc
+}
+
+// eachBatch converts CSV data from `options.csvInputs` into records, calling emit with successive batches of up to
+// batchSize records. When batchSize is 0, emit is called exactly once with every record, even if there are none,
+// provided that there are no more than `options.maxRecords`, sorted by `options.sortBy`, and in reverse order when
+// `options.reverse` is set, or shuffled when `options.shuffle` is. Otherwise, emit is never called with an empty
+// batch, and those options are a *usageError.
+// The batch slice is reused, so emit must not retain it.
+// When `options.execFilter` is set, batches consist of the records written back by the filter instead.
+func eachBatch(options conversionOptions, batchSize int, emit func(batch []record) error) error {
+	if err := checkBatchOptions(options, batchSize); err != nil {
+		return err
+	}
+	reader, colNames, err := newCsvRowReader(options)
+	if err != nil {
+		return err
+	}
+	return eachReaderBatch(options, reader, colNames, batchSize, emit)
+}
+
+// checkBatchOptions returns a *usageError if the ordering given by options cannot apply to batches of batchSize
+// records, or if `options.sortBy` is invalid, so that eachBatch() can fail before reading any input.
+func checkBatchOptions(options conversionOptions, batchSize int) error {
+	if options.reverse && batchSize > 0 {
+		return usageErrorf("--reverse cannot be used when records are streamed")
+	} else if options.sortBy != "" && batchSize > 0 {
+		return usageErrorf("--sort-by cannot be used when records are streamed")
+	} else if options.shuffle && batchSize > 0 {
+		return usageErrorf("--shuffle cannot be used when records are streamed")
+	} else if options.shuffle && (options.sortBy != "" || options.reverse) {
+		return usageErrorf("--shuffle cannot be combined with --sort-by or --reverse")
+	}
+	if options.sortBy != "" {
+		if _, err := parseSortKeys(options.sortBy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkReaderSortFields returns a *usageError unless every field of `options.sortBy` is a field of the records read
+// from reader, which has the given column names. The fields of records written back by `options.execFilter` are
+// not known until they are read, so they are not checked.
+func checkReaderSortFields(options conversionOptions, reader *csvRowReader, colNames []string) error {
+	if options.sortBy == "" || options.execFilter != "" {
+		return nil
+	}
+	sortKeys, err := parseSortKeys(options.sortBy)
+	if err != nil {
+		return err
+	}
+	return checkSortFields(sortKeys, reader.fieldNames(colNames))
+}
+
+// eachReaderBatch is like eachBatch(), but reads rows with the given column names from reader, as returned by
+// newCsvRowReader(options).
+func eachReaderBatch(options conversionOptions, reader *csvRowReader, colNames []string, batchSize int,
+	emit func(batch []record) error) error {
+	if err := checkBatchOptions(options, batchSize); err != nil {
+		return err
+	}
+	if err := checkReaderSortFields(options, reader, colNames); err != nil {
+		return err
+	}
+	var sortKeys []sortKey
+	if options.sortBy != "" {
+		sortKeys, _ = parseSortKeys(options.sortBy)
+	}
+	// Records which are ordered are limited once ordered, such that e.g. --reverse --limit keeps the last rows
+	limit := 0
+	if batchSize == 0 && (len(sortKeys) > 0 || options.shuffle || options.reverse) {
+		limit, reader.limit = reader.limit, 0
+	}
+	var err error
+	batch := make([]record, 0, batchSize)
+	add := func(thisRecord record) error {
+		if batchSize == 0 && options.maxRecords > 0 && len(batch) == options.maxRecords {
+			return recordLimitError{options.maxRecords}
+		}
+		batch = append(batch, thisRecord)
+		if batchSize > 0 && len(batch) == batchSize {
+			if err := emit(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+		return nil
+	}
+	if options.execFilter != "" {
+		err = filterRecords(options.execFilter, func(send func(rec record) error) error {
+			return eachRow(reader, options.skipErrors, func(_ int, rowFields []string) error {
+				return send(reader.record(colNames, rowFields))
+			})
+		}, add)
+	} else {
+		err = eachRow(reader, options.skipErrors, func(_ int, rowFields []string) error {
+			return add(reader.record(colNames, rowFields))
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	if batchSize > 0 && len(batch) == 0 {
+		// Every record was already emitted in a full batch, or there were no records at all
+		return nil
+	}
+	if len(sortKeys) > 0 {
+		sortRecords(batch, sortKeys)
+	} else if options.shuffle {
+		shuffleRecords(batch, options.shuffleSeed)
+	}
+	if options.reverse {
+		for i, j := 0, len(batch)-1; i < j; i, j = i+1, j-1 {
+			batch[i], batch[j] = batch[j], batch[i]
+		}
+	}
+	if limit > 0 && len(batch) > limit {
+		batch = batch[:limit]
+	}
+	return emit(batch)
+}
+
+// csvRowReader reads CSV rows from a sequence of inputs as though they were a single input.
+// Each input gets its own BOM handling and, unless column names are forced, its own header row,
+// which must match the column names read from the first input.
+type csvRowReader struct {
+	inputs            []io.Reader
+	inputNum          int
+	current           *csv.Reader
+	dialect           csvDialect
+	strict            bool
+	compression       string
+	encoding          string
+	skipRows          int
+	sanitize          bool
+	trimHeaders       bool
+	strictHeaders     bool
+	failIfEmpty       bool
+	rejectInvalidUtf8 bool
+	fillEmptyHeaders  bool
+	keepEmptyHeaders  bool
+	keyCase           string
+	headerRows        int
+	headerJoin        string
+	fixCp1252         bool
+	sniff             bool
+	sniffDelimiter    bool
+	logDelimiters     bool
+	transforms        []valueTransform
+	colNames          []string
+	// Values of data rows are truncated according to maxCellLength, truncateMarker, and truncateFlag, as given by
+	// conversionOptions. truncatedCols holds the indexes of the values truncated in the most recent row, and
+	// numTruncated counts every truncated value.
+	maxCellLength  int
+	truncateMarker string
+	truncateFlag   bool
+	truncatedCols  []int
+	numTruncated   int
+	// Data rows may have fewer or more fields than colNames according to padShortRows and keepExtraFields, as given
+	// by conversionOptions. numPadded and numExtended count such rows, and numRenamed counts renamed header names.
+	// logRepairs is set when any of these repairs, or those of fillEmptyHeaders and dedupeHeaders, were asked for,
+	// rather than being made by default policies.
+	// keepExtraFields is also set by the "keep" and "drop" policies of `conversionOptions.extraFields`: the extra
+	// fields are dropped when dropExtraFields is set, and extraArray names the array which collects them otherwise.
+	padShortRows    bool
+	keepExtraFields bool
+	dropExtraFields bool
+	extraArray      string
+	numPadded       int
+	// padFrom is the number of fields the row most recently read had before fitFields() padded it, whose padded
+	// fields are null when padNulls is set
+	padFrom     int
+	padNulls    bool
+	numExtended int
+	numRenamed  int
+	logRepairs  bool
+	// emptyRows is the policy for data rows whose every field is empty, and numEmpty counts those which were skipped
+	emptyRows string
+	numEmpty  int
+	// offset and limit are as given by conversionOptions
+	offset int
+	limit  int
+	// base64Columns and base64Binary are as given by conversionOptions, and numBinaryKept counts the values of each
+	// column which were kept encoded since they do not decode to UTF-8 text
+	base64Columns []string
+	base64Binary  string
+	numBinaryKept map[string]int
+	// urlDecodeColumns and urlDecodeMode are as given by conversionOptions
+	urlDecodeColumns []string
+	urlDecodeMode    string
+	// columnTypes, columnTransforms, and nullValues are as given by conversionOptions
+	columnTypes      map[string]string
+	columnTransforms map[string]columnTransform
+	nullValues       []string
+	// renames is as given by conversionOptions
+	renames map[string]string
+	// selectColumns and excludeColumns are as given by conversionOptions, and omitted holds the columns they leave out
+	selectColumns  []string
+	excludeColumns []string
+	omitted        map[string]bool
+	// dupHeaders is the policy for duplicate header names. When duplicates are present, firstWins is set by the
+	// "first" policy, and arrayFields maps each duplicated name to its renamed columns for the "array" policy.
+	dupHeaders  string
+	firstWins   bool
+	arrayFields map[string][]string
+	// headerMismatch is as given by conversionOptions
+	headerMismatch string
+	// numInputs counts every input, and currentName describes the input being read. currentMeta holds the values
+	// of the fileMeta attributes of the input being read.
+	numInputs   int
+	currentName string
+	fileMeta    []string
+	currentMeta []string
+	// rowNumberField, rowNumberReplaces, and lineNumber are as given by conversionOptions, and rowNum is the number
+	// of the data row most recently read
+	rowNumberField    string
+	rowNumberReplaces bool
+	lineNumber        bool
+	rowNum            int
+	// When captureText is set, raw records the text of each record as it is read, and the text and starting line
+	// of the most recent record are kept in lastText and lastLine. Skipped rows are logged with up to textLimit
+	// bytes of their text.
+	captureText bool
+	textLimit   int
+	raw         *rawRecorder
+	lastText    string
+	lastLine    int
+	// errorHandler is as given by conversionOptions, and rawRow is set while a failed row is emitted as its raw text
+	errorHandler errorHandler
+	rawRow       bool
+	// rejects, maxSkippedRows, and ctx are as given by conversionOptions
+	rejects        *rejectFile
+	maxSkippedRows int
+	ctx            context.Context
+	// progress, when set, logs progress as rows are read
+	progress *progressLog
+	// validate is as given by conversionOptions, and numViolations counts the skipped rows which violated each
+	// constraint, as described by a *constraintViolation
+	validate      func(rec record) error
+	numViolations map[string]int
+	stats         *conversionStats
+	// countDelivered is set when rows are only converted once the caller of eachRow() has delivered them, and so are
+	// counted by it rather than when accepted
+	countDelivered bool
+	forced         bool
+}
+
+// newCsvRowReader prepares to read rows from `options.csvInputs` according to `options.dialect`, returning it
+// along with the column names for its rows. When `options.colNames` is empty, column names are read from the header
+// row of the first input that is not empty. Otherwise, every line of every input is a data row, which must have
+// the same number of fields as `options.colNames`.
+func newCsvRowReader(options conversionOptions) (*csvRowReader, []string, error) {
+	options = withDefaultPolicies(options)
+	keepExtraFields := options.keepExtraFields || options.extraFields == "keep" || options.extraFields == "drop"
+	extraArray := ""
+	if options.extraFields == "keep" {
+		extraArray = options.extraFieldName
+	}
+	r := &csvRowReader{
+		inputs:            options.csvInputs,
+		dialect:           options.dialect,
+		strict:            options.rfc4180Strict,
+		compression:       options.compression,
+		encoding:          options.encoding,
+		skipRows:          options.skipRows,
+		sanitize:          options.sanitizeHeaders,
+		trimHeaders:       options.trimHeaders,
+		strictHeaders:     options.strictHeaders,
+		failIfEmpty:       options.failIfEmpty,
+		offset:            options.offset,
+		limit:             options.limit,
+		rejectInvalidUtf8: options.rejectInvalidUtf8,
+		fillEmptyHeaders:  options.fillEmptyHeaders,
+		keepEmptyHeaders:  options.keepEmptyHeaders,
+		keyCase:           options.keyCase,
+		headerRows:        options.headerRows,
+		headerJoin:        options.headerJoin,
+		dupHeaders:        options.dupHeaders,
+		headerMismatch:    options.headerMismatch,
+		padShortRows:      options.padShortRows,
+		padNulls:          options.padShortRows && options.padNulls,
+		emptyRows:         options.emptyRows,
+		base64Columns:     options.base64Columns,
+		base64Binary:      options.base64Binary,
+		numBinaryKept:     make(map[string]int),
+		urlDecodeColumns:  options.urlDecodeColumns,
+		urlDecodeMode:     options.urlDecodeMode,
+		columnTypes:       options.columnTypes,
+		columnTransforms:  options.columnTransforms,
+		nullValues:        options.nullValues,
+		renames:           options.renames,
+		selectColumns:     options.selectColumns,
+		excludeColumns:    options.excludeColumns,
+		keepExtraFields:   keepExtraFields,
+		logRepairs:        options.padShortRows || keepExtraFields || options.fillEmptyHeaders || options.dedupeHeaders,
+		dropExtraFields:   options.extraFields == "drop",
+		extraArray:        extraArray,
+		fixCp1252:         options.fixCp1252,
+		sniff:             !options.noSniff,
+		sniffDelimiter:    options.sniffDelimiter,
+		logDelimiters:     options.logSniffedDelimiters,
+		transforms:        options.transforms,
+		colNames:          options.colNames,
+		maxCellLength:     options.maxCellLength,
+		truncateMarker:    options.truncateMarker,
+		truncateFlag:      options.truncateFlag,
+		fileMeta:          options.fileMeta,
+		rowNumberField:    options.rowNumberField,
+		rowNumberReplaces: options.rowNumberReplaces,
+		lineNumber:        options.lineNumber,
+		numInputs:         len(options.csvInputs),
+		// fitFields() relies on the line number of each row to report rows with the wrong number of fields
+		captureText: options.skippedRowTextLimit > 0 || logStructured() ||
+			(options.stats != nil && options.stats.maxErrors > 0) || options.padShortRows || keepExtraFields ||
+			options.errorHandler != nil || options.lineNumber || options.rejects != nil,
+		textLimit:      options.skippedRowTextLimit,
+		errorHandler:   options.errorHandler,
+		rejects:        options.rejects,
+		maxSkippedRows: options.maxSkippedRows,
+		ctx:            options.ctx,
+		validate:       options.validate,
+		numViolations:  make(map[string]int),
+		stats:          options.stats,
+		forced:         len(options.colNames) > 0,
+	}
+	if options.progress {
+		r.progress = newProgressLog(options.csvInputs, options.progressRows, options.progressInterval)
+	}
+	if !r.forced {
+		r.colNames = nil
+		if err := r.openNext(); err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+	} else if len(r.renames) > 0 {
+		var err error
+		if r.colNames, err = renameColumns(r.colNames, r.renames, "--force-columns", true); err != nil {
+			return nil, nil, err
+		}
+	}
+	if columns := emptyHeaderColumns(r.colNames); r.forced && len(columns) > 0 {
+		return nil, nil, usageErrorf("--force-columns has empty column names at positions %s", joinInts(columns, ", "))
+	}
+	if err := checkFileMeta(r.fileMeta, r.colNames); err != nil {
+		return nil, nil, err
+	}
+	if err := checkRowNumberFields(r.rowNumberField, r.rowNumberReplaces, r.lineNumber, r.colNames); err != nil {
+		return nil, nil, err
+	}
+	if err := checkExtraArray(r.extraArray, r.colNames); err != nil {
+		return nil, nil, err
+	}
+	if err := checkBase64Columns(r.base64Columns, r.base64Binary, r.colNames); err != nil {
+		return nil, nil, err
+	}
+	if err := checkUrlDecodeColumns(r.urlDecodeColumns, r.urlDecodeMode, r.colNames); err != nil {
+		return nil, nil, err
+	}
+	if err := checkTypeColumns(r.columnTypes, r.colNames); err != nil {
+		return nil, nil, err
+	}
+	if err := checkColumnTransforms(r.columnTransforms, r.colNames); err != nil {
+		return nil, nil, err
+	}
+	if err := checkSelectedColumns(r.selectColumns, r.excludeColumns, r.colNames); err != nil {
+		return nil, nil, err
+	}
+	r.omitted = omittedColumns(r.selectColumns, r.excludeColumns, r.colNames)
+
+	return r, r.colNames, nil
 }
 
-func main() {
-	if err := runCli(); err != nil {
-		log.Fatalln(err)
+// withDefaultPolicies returns options in which each policy and encoding which was not given is set to the one which
+// applies instead, which may be implied by other options.
+func withDefaultPolicies(options conversionOptions) conversionOptions {
+	if options.dupHeaders == "" && options.dedupeHeaders {
+		options.dupHeaders = "suffix"
+	} else if options.dupHeaders == "" && options.strictHeaders {
+		options.dupHeaders = "error"
+	} else if options.dupHeaders == "" {
+		options.dupHeaders = defaultDupHeaderPolicy
+	}
+	if options.base64Binary == "" {
+		options.base64Binary = defaultBase64BinaryPolicy
+	}
+	if options.urlDecodeMode == "" {
+		options.urlDecodeMode = defaultUrlDecodeMode
+	}
+	if options.emptyRows == "" && options.skipBlankRecords {
+		options.emptyRows = "skip"
+	} else if options.emptyRows == "" {
+		options.emptyRows = defaultEmptyRowPolicy
+	}
+	if options.headerMismatch == "" {
+		options.headerMismatch = "error"
+	}
+	if options.extraFields == "keep" && options.extraFieldName == "" {
+		options.extraFieldName = defaultExtraFieldName
 	}
+	if options.encoding == "" {
+		options.encoding = "utf-8"
+	}
+	return options
 }
 
-func runCli() (err error) {
-	var fileName string
-	options := conversionOptions{jsonOutput: os.Stdout}
+// openNext advances to the next input that is not empty, consuming its header row when column names are not forced.
+// Returns io.EOF when no inputs remain.
+func (r *csvRowReader) openNext() error {
+	for len(r.inputs) > 0 {
+		input := r.inputs[0]
+		r.inputs = r.inputs[1:]
+		r.inputNum++
+		r.currentName = inputName(input, r.inputNum)
+		if len(r.fileMeta) > 0 {
+			var err error
+			if r.currentMeta, err = fileMeta(input, r.fileMeta); err != nil {
+				return err
+			}
+		}
+		source, dialect := input, r.dialect
+		if r.progress != nil {
+			source = r.progress.reader(source)
+		}
+		if _, ok := input.(*gzipCsvFile); !ok {
+			// Files were already decompressed by getCsvFiles() when they could be read at an offset
+			var err error
+			if source, err = decompressInput(source, r.currentName, r.compression); err != nil {
+				return err
+			}
+		}
+		source = decodeInput(source, r.currentName, r.encoding)
+		if r.skipRows > 0 {
+			// Lines are skipped before anything else reads them, since they need not be valid in any way
+			var err error
+			if source, err = skipLines(source, r.skipRows, r.dialect.comment); err != nil {
+				return fmt.Errorf("skipping the first lines of %s: %w", r.currentName, err)
+			}
+		}
+		if r.fixCp1252 {
+			source = newCp1252Reader(source)
+		}
+		if r.strict {
+			// RFC 4180 has no preamble, so a sep= line is validated as a record and cannot change the delimiter
+			source = newRfc4180Validator(source)
+			dialect.keepDelimiter = true
+		}
+		// getCsvReader() and csv.Reader reuse br rather than wrapping it, so any bytes peeked by sniffInput() are
+		// still read as CSV, and when capturing text, it tracks exactly what they consume
+		var br *bufio.Reader
+		if r.captureText {
+			r.raw = newRawRecorder(source)
+			br = bufio.NewReader(r.raw)
+			r.raw.buffered = br
+		} else {
+			br = bufio.NewReader(source)
+		}
+		if r.sniff {
+			if err := sniffInput(r.currentName, br); err != nil {
+				return err
+			}
+		}
+		if r.sniffDelimiter && !dialect.keepDelimiter {
+			delimiter, ok := sniffDelimiter(br, dialect)
+			if ok {
+				dialect.delimiter = delimiter
+			} else if dialect.delimiter == 0 {
+				dialect.delimiter = defaultDialect.delimiter
+			}
+			if r.logDelimiters {
+				logSniffedDelimiter(r.currentName, dialect.delimiter, ok)
+			}
+		}
+		var err error
+		if r.current, err = getCsvReader(br, dialect); err != nil {
+			return err
+		}
+		// Discard any BOM or sep= line, which are not part of the first record
+		r.takeText()
 
-	flaggy.SetVersion("0.3.0")
-	flaggy.SetDescription("Restructures CSV into JSON")
-	flaggy.StringSlice(&options.colNames, "c", "force-columns",
-		"Column names, which must equal the number of CSV fields if given. "+
-			"When set, the first line of CSV data is treated as a data row instead of column names.")
-	flaggy.AddPositionalValue(&fileName, "file", 1, false,
-		"The CSV file to convert. If omitted, input is read from stdin.")
-	flaggy.Bool(&options.skipErrors, "s", "skip-errors",
-		"Skip CSV lines that cause parsing errors. By default, errors abort conversion completely.")
-	flaggy.Parse()
+		if r.forced {
+			// Explicitly set the number of fields per record to be enforced
+			// based on the number of preconfigured column names. Otherwise,
+			// csv.Reader would do this implicitly when reading the first row.
+			r.current.FieldsPerRecord = len(r.colNames)
+			if r.padShortRows || r.keepExtraFields {
+				// Field counts are checked by fitFields() instead
+				r.current.FieldsPerRecord = -1
+			}
+			return nil
+		}
 
-	options.csvInput, err = getCsvFile(fileName)
-	if err == nil {
-		err = csv2Json(options)
+		// Read the first line to get column names
+		header, err := r.readHeader()
+		if err == io.EOF {
+			continue
+		} else if err != nil && r.colNames == nil {
+			return err
+		} else if err != nil {
+			// Header errors in subsequent inputs are wrapped so that they cannot be skipped like data row errors
+			return fmt.Errorf("reading header of %s: %w", r.currentName, err)
+		}
+
+		if r.sanitize {
+			sanitizeHeaders(r.currentName, header)
+		}
+		if r.trimHeaders {
+			trimHeaders(header)
+		}
+		if r.fillEmptyHeaders {
+			r.numRenamed += fillEmptyHeaders(r.currentName, header)
+		}
+		if r.keyCase != "" {
+			if err := convertHeaderCase(r.currentName, header, r.keyCase); err != nil {
+				return err
+			}
+		}
+		if len(r.renames) > 0 {
+			// Only the first header is logged, since any others must match it
+			if header, err = renameColumns(header, r.renames, r.currentName, r.colNames == nil); err != nil {
+				return err
+			}
+		}
+		if columns := emptyHeaderColumns(header); len(columns) > 0 && !r.keepEmptyHeaders {
+			return &emptyHeadersError{r.currentName, columns}
+		}
+		if err := r.resolveDuplicateHeaders(header); err != nil {
+			return err
+		}
+		if r.stats != nil {
+			r.stats.headersRenamed = r.numRenamed
+		}
+		if r.rejectInvalidUtf8 {
+			if i := invalidUtf8Field(header); i >= 0 {
+				return &invalidHeaderError{r.currentName, i + 1, "column name is not valid UTF-8"}
+			}
+		}
+		if r.strictHeaders {
+			if err := checkHeaders(r.currentName, header); err != nil {
+				return err
+			}
+		}
+		if r.colNames == nil {
+			r.colNames = header
+		} else if !equalStrings(header, r.colNames) {
+			if r.headerMismatch != "warn" {
+				return &headerMismatchError{r.currentName, header, r.colNames}
+			}
+			logHeaderMismatch(r.currentName, header, r.colNames)
+			// Rows must fit the first input's columns, rather than this input's header
+			r.current.FieldsPerRecord = len(r.colNames)
+		}
+		if r.padShortRows || r.keepExtraFields {
+			r.current.FieldsPerRecord = -1
+		}
+		return nil
 	}
 
-	return
+	r.current = nil
+	return io.EOF
 }
 
-// csv2Json converts CSV data from io.Reader to a JSON array and emits the result to io.Writer.
-// When `options.colNames` is empty, headers are derived from the first line of the CSV file.
-// Returns any errors from reading CSV or encoding JSON.
-func csv2Json(options conversionOptions) error {
-	reader := getCsvReader(options.csvInput)
+// resolveDuplicateHeaders applies the policy for duplicate header names to the header read from the current input,
+// logging the policy when any are present. Returns an *invalidHeaderError for the "error" policy.
+func (r *csvRowReader) resolveDuplicateHeaders(header []string) error {
+	dups, first := duplicateHeaders(header)
+	if len(dups) == 0 {
+		return nil
+	}
+	logDuplicateHeaders(r.currentName, dups, r.dupHeaders)
+	switch r.dupHeaders {
+	case "error":
+		if len(dups) > 1 {
+			return &invalidHeaderError{r.currentName, first + 1,
+				"duplicate column names " + strings.Join(quoteAll(dups), ", ")}
+		}
+		return &invalidHeaderError{r.currentName, first + 1, fmt.Sprintf("duplicate column name %q", header[first])}
+	case "first":
+		r.firstWins = true
+	case "suffix":
+		r.numRenamed += dedupeHeaders(r.currentName, header)
+	case "array":
+		original := append([]string(nil), header...)
+		suffixDuplicateHeaders(header)
+		r.arrayFields = arrayFields(original, header, dups)
+	}
+	return nil
+}
 
-	colNames := options.colNames
-	if len(colNames) == 0 {
-		// Read the first line to get column names
-		if firstRow, err := reader.Read(); err != nil {
-			if err != io.EOF {
-				return err
+// Read reads the next data row from the current input, advancing through the remaining inputs as each is exhausted.
+// Every field of the row is rewritten by each of `options.transforms` in turn, as given to newCsvRowReader(),
+// and then truncated according to `options.maxCellLength` when the row was parsed without error.
+// Returns io.EOF once every input has been read.
+func (r *csvRowReader) Read() ([]string, error) {
+	for {
+		if r.current == nil {
+			if err := r.openNext(); err != nil {
+				return nil, err
 			}
-		} else {
-			colNames = firstRow
 		}
-	} else {
-		// Explicitly set the number of fields per record to be enforced
-		// based on the number of preconfigured column names. Otherwise,
-		// csv.Reader would do this implicitly when reading the first row.
-		reader.FieldsPerRecord = len(colNames)
+
+		rowFields, err := r.current.Read()
+		r.takeText()
+		if err == io.EOF {
+			r.current = nil
+			continue
+		}
+		if err == nil && (r.padShortRows || r.keepExtraFields) {
+			rowFields, err = r.fitFields(rowFields)
+		}
+		r.truncatedCols = r.truncatedCols[:0]
+		for i := range rowFields {
+			for _, transform := range r.transforms {
+				rowFields[i] = transform(rowFields[i])
+			}
+
+			var truncated bool
+			if r.maxCellLength > 0 && err == nil {
+				rowFields[i], truncated = truncateCell(rowFields[i], r.maxCellLength, r.truncateMarker)
+			}
+			if truncated {
+				r.truncatedCols = append(r.truncatedCols, i)
+				r.numTruncated++
+				if r.stats != nil {
+					r.stats.cellsTruncated++
+				}
+			}
+		}
+		return rowFields, err
+	}
+}
+
+// record creates a record from the column names and fields of the row most recently read, as by fullRecord(), but
+// without the columns omitted by `options.selectColumns` or `options.excludeColumns`.
+func (r *csvRowReader) record(colNames, rowFields []string) record {
+	rec := r.fullRecord(colNames, rowFields)
+	for name := range r.omitted {
+		delete(rec, name)
+		if r.truncateFlag {
+			delete(rec, truncatedFlagName(name))
+		}
+	}
+	return rec
+}
+
+// fieldNames returns the names of the fields which records created by record() may have, given the column names
+// colNames, other than those of any extra fields.
+func (r *csvRowReader) fieldNames(colNames []string) []string {
+	names := make([]string, 0, len(colNames))
+	for _, name := range colNames {
+		if r.omitted[name] {
+			continue
+		}
+		names = append(names, name)
+		if r.truncateFlag {
+			names = append(names, truncatedFlagName(name))
+		}
+	}
+	for _, name := range r.fileMeta {
+		names = append(names, fileMetaFieldName(name))
+	}
+	if r.rowNumberField != "" {
+		names = append(names, r.rowNumberField)
+	}
+	if r.lineNumber {
+		names = append(names, lineNumberField)
+	}
+	return names
+}
+
+// fullRecord creates a record from the column names and fields of the row most recently read, including any extra
+// fields kept by fitFields(). The record is marked with a field for each truncated value when `options.truncateFlag`
+// was given to newCsvRowReader(), and gets a field for each of `options.fileMeta`, along with its row and line number
+// when `options.rowNumberField` and `options.lineNumber` are set.
+func (r *csvRowReader) fullRecord(colNames, rowFields []string) record {
+	if r.rawRow {
+		return record{rawFieldName: rowFields[0]}
+	}
+	rec := fieldsToRecord(&colNames, &rowFields)
+	if r.firstWins {
+		for i := len(colNames) - 1; i >= 0; i-- {
+			rec[colNames[i]] = rowFields[i]
+		}
+	}
+	fieldName := func(i int) string {
+		if i < len(colNames) {
+			return colNames[i]
+		}
+		if r.extraArray != "" {
+			return extraArrayFieldName(r.extraArray, i-len(colNames)+1)
+		}
+		return extraFieldName(i - len(colNames) + 1)
+	}
+	if r.padNulls {
+		for i := r.padFrom; i < len(colNames); i++ {
+			delete(rec, colNames[i])
+		}
+	}
+	for i := len(colNames); i < len(rowFields); i++ {
+		rec[fieldName(i)] = rowFields[i]
 	}
+	if r.truncateFlag {
+		for _, i := range r.truncatedCols {
+			rec[truncatedFlagName(fieldName(i))] = "true"
+		}
+	}
+	for i, name := range r.fileMeta {
+		rec[fileMetaFieldName(name)] = r.currentMeta[i]
+	}
+	if r.rowNumberField != "" {
+		rec[r.rowNumberField] = strconv.Itoa(r.rowNum)
+	}
+	if r.lineNumber {
+		rec[lineNumberField] = strconv.Itoa(r.lastLine)
+	}
+	return rec
+}
+
+// readHeader reads the header row of the current input, merging its `headerRows` rows as by mergeHeaderRows().
+// Returns io.EOF if the input is empty.
+func (r *csvRowReader) readHeader() ([]string, error) {
+	rows := make([][]string, 0, r.headerRows)
+	for len(rows) == 0 || len(rows) < r.headerRows {
+		row, err := r.current.Read()
+		r.takeText()
+		if err == io.EOF && len(rows) > 0 {
+			return nil, fmt.Errorf("%s ends within its %d header rows", r.currentName, r.headerRows)
+		} else if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 && len(row) == 1 && strings.TrimSpace(row[0]) == "" {
+			// Lines of only whitespace before the header are skipped, like the empty lines skipped by csv.Reader
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 1 {
+		return rows[0], nil
+	}
+	return mergeHeaderRows(rows, r.headerJoin), nil
+}
+
+// takeText updates lastText and lastLine from what has been read since they were last updated, if capturing text.
+func (r *csvRowReader) takeText() {
+	if r.captureText {
+		r.lastText, r.lastLine = r.raw.take()
+	}
+}
 
-	numRowsWithErrors := 0
+// inputName describes the nth input for error messages, using its file name when available.
+func inputName(r io.Reader, n int) string {
+	if r == io.Reader(os.Stdin) {
+		return stdinFileName
+	} else if f, ok := r.(interface{ Name() string }); ok {
+		return f.Name()
+	}
+	return fmt.Sprintf("input %d", n)
+}
+
+// interruptContext returns a context which is cancelled once the process receives an interrupt signal, along with
+// a function which cancels it and stops listening for the signal. Further interrupts kill the process as usual.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		select {
+		case <-signals:
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// eachRow reads CSV rows from reader until EOF, calling fn with the 1-based data row number and fields of each row.
+// When skipErrors is true, rows that cannot be parsed (or for which fn returns a *rowError) are logged and skipped.
+// Otherwise, the first such error aborts reading and is returned. Any other error always aborts. When
+// `options.errorHandler` was given to newCsvRowReader(), it decides instead whether each such row is skipped, aborts,
+// or is passed to fn again as a single field holding its raw text, while reader.record() returns a rawFieldName record.
+// Skipped rows are logged as described by logSkippedRow(), followed by a summary from logSkippedRows().
+// Any truncated values are summarized by logTruncatedCells(), and any repaired rows and headers by logRepairs() when
+// repairs were asked for.
+// Rows with values which are not valid UTF-8 cause a *rowError when rejected by `options.rejectInvalidUtf8`, rows
+// whose records are rejected by `options.validate` cause an *invalidRowError, and
+// reading no rows at all causes errNoRows when `options.failIfEmpty` is set, as given to newCsvRowReader().
+// Reading stops with the error of `options.ctx` once it is done.
+// The first `options.offset` rows without errors are read but not passed to fn, and reading stops once fn has been
+// called for `options.limit` rows after them.
+func eachRow(reader *csvRowReader, skipErrors bool, fn func(rowNum int, rowFields []string) error) error {
+	numRowsWithErrors := make(map[string]int)
 	defer func() {
-		if options.skipErrors && numRowsWithErrors > 0 {
-			log.Printf("Skipped %d lines (rows) due to parsing errors", numRowsWithErrors)
+		if len(numRowsWithErrors) > 0 {
+			logSkippedRows(numRowsWithErrors)
+		}
+		if reader.rejects != nil && reader.rejects.count > 0 {
+			logRejectedRows(reader.rejects.count, reader.rejects.fileName)
+		}
+		if len(reader.numViolations) > 0 {
+			logViolations(reader.numViolations)
+		}
+		if reader.numTruncated > 0 {
+			logTruncatedCells(reader.numTruncated, reader.maxCellLength)
+		}
+		if reader.logRepairs && (reader.numPadded > 0 || reader.numExtended > 0 || reader.numRenamed > 0) {
+			logRepairs(reader.numPadded, reader.numExtended, reader.numRenamed)
+		}
+		if reader.numEmpty > 0 {
+			logEmptyRows(reader.numEmpty)
+		}
+		if len(reader.numBinaryKept) > 0 {
+			logBinaryKept(reader.numBinaryKept)
 		}
 	}()
+	if reader.progress != nil {
+		reader.progress.start()
+		defer reader.progress.stop()
+	}
 
-	allRecords := make([]record, 0)
-	for {
+	numRows, numSkipped := 0, 0
+	for rowNum := 1; ; rowNum++ {
+		if reader.ctx != nil && reader.ctx.Err() != nil {
+			return reader.ctx.Err()
+		}
 		rowFields, err := reader.Read()
-		if err != nil {
-			if err == io.EOF {
-				break
-			} else if options.skipErrors {
-				numRowsWithErrors++
-				log.Printf(err.Error())
+		reader.rowNum = rowNum
+		if err != io.EOF && reader.progress != nil {
+			reader.progress.rowRead()
+		}
+		if err == io.EOF && rowNum == 1 && reader.failIfEmpty {
+			return errNoRows
+		}
+		if err == nil && reader.emptyRows != "emit" && isEmptyRow(rowFields) {
+			if reader.emptyRows == "error" {
+				err = &rowError{rowNum, errEmptyRow}
+			} else {
+				reader.numEmpty++
+				if reader.stats != nil {
+					reader.stats.rowsRead++
+					reader.stats.rowsEmpty++
+				}
 				continue
 			}
+		}
+		if err == nil && len(reader.base64Columns) > 0 {
+			err = reader.decodeBase64Fields(rowNum, rowFields)
+		}
+		if err == nil && len(reader.urlDecodeColumns) > 0 {
+			err = reader.urlDecodeFields(rowNum, rowFields)
+		}
+		if err == nil && len(reader.columnTransforms) > 0 {
+			err = reader.transformColumns(rowNum, rowFields)
+		}
+		if err == nil && len(reader.columnTypes) > 0 {
+			err = reader.checkColumnTypes(rowNum, rowFields)
+		}
+		if err == nil && reader.rejectInvalidUtf8 {
+			if i := invalidUtf8Field(rowFields); i >= 0 {
+				err = &rowError{rowNum, fmt.Errorf("value %d is not valid UTF-8", i+1)}
+			}
+		}
+		if err == nil && reader.validate != nil {
+			validateErr := reader.validate(reader.fullRecord(reader.colNames, rowFields))
+			var usage *usageError
+			if errors.As(validateErr, &usage) {
+				// Validation which cannot apply to the input, rather than to this row, is never skipped
+				return validateErr
+			} else if validateErr != nil {
+				err = &invalidRowError{rowNum, validateErr}
+			}
+		}
+		offsetRow := err == nil && numRows < reader.offset
+		if err == nil && !offsetRow {
+			err = fn(rowNum, rowFields)
+		}
+		if reader.stats != nil && (err == nil || isSkippable(err)) {
+			reader.stats.rowsRead++
+			if err == nil && !offsetRow && !reader.countDelivered {
+				reader.stats.rowsConverted++
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err == nil {
+			if numRows++; reader.limit > 0 && numRows >= reader.offset+reader.limit {
+				break
+			}
+			continue
+		}
+
+		action := errorAbort
+		if isSkippable(err) && reader.errorHandler != nil {
+			action = reader.errorHandler(failedRow{reader.lastLine, reader.lastText, rowNum, err})
+		} else if isSkippable(err) && skipErrors {
+			action = errorSkip
+		}
+		switch action {
+		case errorSkip:
+			numRowsWithErrors[skipErrorKind(err)]++
+			var violation *constraintViolation
+			if errors.As(err, &violation) {
+				reader.numViolations[violation.constraint]++
+			}
+			if reader.stats != nil {
+				reader.stats.rowSkipped(reader.lastLine, rowNum, err)
+			}
+			if reader.progress != nil {
+				reader.progress.rowSkipped()
+			}
+			logSkippedRow(reader, rowNum, err)
+			if reader.rejects != nil {
+				rejected := rejectedRow{Line: reader.lastLine, Row: rowNum, Error: err.Error(), Raw: reader.lastText}
+				if reader.numInputs > 1 {
+					rejected.File = reader.currentName
+				}
+				if err := reader.rejects.write(rejected); err != nil {
+					return err
+				}
+			}
+			if numSkipped++; reader.maxSkippedRows > 0 && numSkipped > reader.maxSkippedRows {
+				return &tooManyErrorsError{reader.maxSkippedRows, numSkipped, err}
+			}
+		case errorRaw:
+			reader.rawRow = true
+			err = fn(rowNum, []string{reader.lastText})
+			reader.rawRow = false
+			if err != nil {
+				return err
+			}
+			if reader.stats != nil && !reader.countDelivered {
+				reader.stats.rowsConverted++
+			}
+		default:
 			return err
 		}
+	}
+
+	return nil
+}
+
+// isSkippable reports whether err only affects a single data row, such that the row can be skipped.
+func isSkippable(err error) bool {
+	switch err.(type) {
+	case *csv.ParseError, *rowError, *invalidRowError:
+		return true
+	}
+	return false
+}
+
+// getCsvFiles gets open files for each of fileNames, as given to getCsvFile(), or else os.Stdin when none are named.
+// Files compressed with gzip are decompressed according to compression, as by decompressCsvFile(). Names which are
+// http or https URLs are fetched according to urlOpts, as by fetchCsvUrl(), and their responses read in place of
+// files. Since stdin can only be read once, "-" may not be given more than once. If any file cannot be opened, those
+// already opened are closed. The files should be closed with closeCsvFiles() once read.
+func getCsvFiles(fileNames []string, compression string, urlOpts urlInputOptions) ([]io.Reader, error) {
+	if len(fileNames) == 0 {
+		return []io.Reader{os.Stdin}, nil
+	}
+
+	files := make([]io.Reader, 0, len(fileNames))
+	readsStdin := false
+	for _, fileName := range fileNames {
+		if fileName == stdinFileName {
+			if readsStdin {
+				closeCsvFiles(files)
+				return nil, usageErrorf("%q (stdin) may only be given once", stdinFileName)
+			}
+			readsStdin = true
+		}
+		if isInputUrl(fileName) {
+			input, err := fetchCsvUrl(fileName, urlOpts)
+			if err != nil {
+				closeCsvFiles(files)
+				return nil, err
+			}
+			files = append(files, input)
+			continue
+		}
 
-		thisRecord := fieldsToRecord(&colNames, &rowFields)
-		allRecords = append(allRecords, thisRecord)
+		f, err := getCsvFile(fileName)
+		if err != nil {
+			closeCsvFiles(files)
+			return nil, err
+		}
+		input, err := decompressCsvFile(f, compression)
+		if err != nil {
+			f.Close()
+			closeCsvFiles(files)
+			return nil, err
+		}
+		files = append(files, input)
 	}
 
-	enc := json.NewEncoder(options.jsonOutput)
-	if err := enc.Encode(allRecords); err != nil {
-		return err
+	return files, nil
+}
+
+// closeCsvFiles closes each of files which was opened by getCsvFiles(), returning the first error.
+func closeCsvFiles(files []io.Reader) error {
+	var firstErr error
+	for _, f := range files {
+		if c, ok := f.(io.Closer); ok && f != io.Reader(os.Stdin) {
+			if err := c.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
 	}
+	return firstErr
+}
 
-	return nil
+// gzipCsvFile decompresses a CSV file compressed with gzip, while still describing the file for inputName() and
+// fileMeta().
+type gzipCsvFile struct {
+	*gzipReader
+	file *os.File
+}
+
+func (g *gzipCsvFile) Name() string {
+	return g.file.Name()
+}
+
+func (g *gzipCsvFile) Stat() (os.FileInfo, error) {
+	return g.file.Stat()
+}
+
+func (g *gzipCsvFile) Close() error {
+	return g.file.Close()
+}
+
+// decompressCsvFile returns f itself, unless it is to be decompressed according to compression, in which case it
+// returns a *gzipCsvFile for reading its decompressed contents. In auto mode, that is when it begins with gzipMagic.
+// Stdin and other files which cannot be read at an offset, such as pipes, are left to be decompressed by
+// decompressInput() as they are read, since checking them here would consume their first bytes.
+func decompressCsvFile(f *os.File, compression string) (io.Reader, error) {
+	if f == os.Stdin || compression == "" || compression == "none" {
+		return f, nil
+	}
+	if compression != "gzip" {
+		magic := make([]byte, len(gzipMagic))
+		if n, _ := f.ReadAt(magic, 0); n < len(magic) || string(magic) != gzipMagic {
+			return f, nil
+		}
+	}
+	zr, err := newGzipReader(f, f.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &gzipCsvFile{zr, f}, nil
 }
 
-// getCsvFile gets a pointer to an open os.File named by filename, or else os.Stdin.
+// getCsvFile gets a pointer to an open os.File named by filename, or else os.Stdin when filename is empty or "-".
 // Errors encountered when opening named files are propagated from os.Open().
 func getCsvFile(fileName string) (*os.File, error) {
-	if fileName == "" {
+	if fileName == "" || fileName == stdinFileName {
 		return os.Stdin, nil
 	} else {
 		return os.Open(fileName)
 	}
 }
 
-// getCsvReader prepares the given io.Reader and returns a new *csv.Reader for parsing its contents as a CSV.
-func getCsvReader(r io.Reader) *csv.Reader {
+// getCsvReader prepares the given io.Reader and returns a new *csv.Reader for parsing its contents as a CSV
+// according to dialect. A "sep=" preamble line is skipped, and its delimiter used unless `dialect.keepDelimiter`.
+// Returns any error from reading the start of r, other than io.EOF.
+func getCsvReader(r io.Reader, dialect csvDialect) (*csv.Reader, error) {
 	// Skip the first rune if it is a BOM
 	br := bufio.NewReader(r)
 	firstRune, _, err := br.ReadRune()
-	if err != nil {
-		if err != io.EOF {
-			log.Fatal(err)
-		}
+	if err != nil && err != io.EOF {
+		return nil, err
 	}
 	if firstRune != '\uFEFF' {
 		// First rune is not a BOM, so put it back
 		br.UnreadRune()
 	}
 
-	return csv.NewReader(br)
+	sep, hasSepLine := readSepLine(br)
+	if hasSepLine && !dialect.keepDelimiter {
+		dialect.delimiter = sep
+	}
+
+	reader := csv.NewReader(br)
+	dialect.configure(reader)
+	return reader, nil
+}
+
+// addFilePositionals registers optional positional values on sc for each of fileNames, which receive the file names
+// given as arguments in order. Only the first is shown in help output.
+func addFilePositionals(sc *flaggy.Subcommand, fileNames []string, description string) {
+	for i := range fileNames {
+		sc.AddPositionalValue(&fileNames[i], "file", i+1, false, description)
+		sc.PositionalFlags[len(sc.PositionalFlags)-1].Hidden = i > 0
+	}
+}
+
+// undoubleRootSlices undoes flaggy parsing the root flags given after a subcommand twice, once for the root and once
+// for the subcommand, which appends the values of each slice flag twice.
+func undoubleRootSlices() {
+	for _, f := range flaggy.DefaultParser.Flags {
+		if values, ok := f.AssignmentVar.(*[]string); ok {
+			n := len(*values)
+			if n%2 == 0 && equalStrings((*values)[:n/2], (*values)[n/2:]) {
+				*values = (*values)[:n/2]
+			}
+		}
+	}
+}
+
+// givenFlags returns the names of flags which were explicitly given on the command line for any of scs,
+// keyed by their long name, even when the short name was used. applyProfile() adds the flags set by a profile.
+func givenFlags(scs ...*flaggy.Subcommand) map[string]bool {
+	// Root flags given after a subcommand are parsed by it, so short names are resolved against the flags of each
+	longNames := make(map[string]string)
+	for _, sc := range scs {
+		for _, f := range sc.Flags {
+			if f.ShortName != "" && f.LongName != "" {
+				longNames[f.ShortName] = f.LongName
+			}
+		}
+	}
+	given := make(map[string]bool)
+	for _, sc := range scs {
+		for _, pv := range sc.ParsedValues {
+			if !pv.IsPositional {
+				// Flags given as --name=value are recorded with their value
+				name := strings.SplitN(pv.Key, "=", 2)[0]
+				if longName, ok := longNames[name]; ok {
+					name = longName
+				}
+				given[name] = true
+			}
+		}
+	}
+	return given
+}
+
+// invalidUtf8Field returns the index of the first of fields which is not valid UTF-8, or -1 if they all are.
+func invalidUtf8Field(fields []string) int {
+	for i, field := range fields {
+		if !utf8.ValidString(field) {
+			return i
+		}
+	}
+	return -1
+}
+
+// equalStrings reports whether a and b contain the same strings in the same order.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
 // fieldsToRecord creates key/value pairs from column names and row values at corresponding indexes
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/integrii/flaggy"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -10,6 +16,9 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -41,6 +50,15 @@ func TestCli(t *testing.T) {
 			nil,
 			[]string{},
 		},
+		{
+			"Basic conversion from explicit stdin",
+			false,
+			true,
+			"a,b,c\n1,2,3\nz,y,x\n",
+			`[{"a": "1", "b": "2", "c": "3"}, {"a": "z", "b": "y", "c": "x"}]`,
+			nil,
+			[]string{"--skip-errors", "-"},
+		},
 		{
 			"Fails when missing file is named",
 			true,
@@ -74,9 +92,126 @@ func TestCli(t *testing.T) {
 			true,
 			"a,b,c\n1,\"\"2',3\nz,y,x\n",
 			`[{"a": "z", "b": "y", "c": "x"}]`,
-			nil,
+			skippedRowsError{1},
 			[]string{"--skip-errors"},
 		},
+		{
+			"Lazy quotes read stray quotes, and what still fails can be skipped",
+			true,
+			true,
+			"a,b\n1,2\n3,x\"y\n4,5,6\n6,7\n",
+			`[{"a": "1", "b": "2"}, {"a": "3", "b": "x\"y"}, {"a": "6", "b": "7"}]`,
+			skippedRowsError{1},
+			[]string{"--lazy-quotes", "--skip-errors"},
+		},
+		{
+			"Lazy quotes read stray quotes in the header",
+			true,
+			true,
+			"a,b\"\n1,2\n",
+			`[{"a": "1", "b\"": "2"}]`,
+			nil,
+			[]string{"--lazy-quotes"},
+		},
+		{
+			"Empty values and sentinels as null",
+			true,
+			true,
+			"a,b,c\n,NA,1\n",
+			`[{"a": null, "b": null, "c": 1}]`,
+			nil,
+			[]string{"--empty-as-null", "--null-value", "NA", "--infer-types"},
+		},
+		{
+			"Row and line numbers",
+			true,
+			true,
+			"a\n\"x\ny\"\nz\n",
+			`[{"_line": "2", "_row": "1", "a": "x\ny"}, {"_line": "4", "_row": "2", "a": "z"}]`,
+			nil,
+			[]string{"--row-number", "--line-number"},
+		},
+		{
+			"Aggregate subcommand from named input",
+			true,
+			true,
+			"region,amount\nemea,1\nus,2\nemea,3\n",
+			`[{"region": "emea", "total": 4}, {"region": "us", "total": 2}]`,
+			nil,
+			[]string{"aggregate", "--group-by", "region", "--agg", "total=sum(amount)"},
+		},
+		{
+			"Dialect preset from named input",
+			true,
+			true,
+			"a\tb\n1\t2\"\n",
+			`[{"a": "1", "b": "2\""}]`,
+			nil,
+			[]string{"--dialect", "postgres"},
+		},
+		{
+			"Delimiter overrides dialect preset",
+			true,
+			true,
+			"a;b\n1; 2\n",
+			`[{"a": "1", "b": "2"}]`,
+			nil,
+			[]string{"--dialect=excel-tab", "--delimiter=;", "--trim-leading-space"},
+		},
+		{
+			"Short delimiter flag with escaped tab",
+			false,
+			true,
+			"a\tb\n1\t2\n",
+			`[{"a": "1", "b": "2"}]`,
+			nil,
+			[]string{"-d", `\t`},
+		},
+		{
+			"Strict bundle fails on empty input",
+			true,
+			true,
+			"a,b\r\n",
+			"",
+			errNoRows,
+			[]string{"--strict"},
+		},
+		{
+			"Explicit flag overrides strict bundle",
+			true,
+			true,
+			"a,b\r\n",
+			`[]`,
+			nil,
+			[]string{"--strict", "--fail-if-empty=false"},
+		},
+		{
+			"Keyed by a column",
+			false,
+			true,
+			"id,name\n1,ann\n2,bob\n1,cy\n",
+			`{"1": {"name": "cy"}, "2": {"name": "bob"}}`,
+			nil,
+			[]string{"--key-by", "id", "--key-duplicates", "last-wins"},
+		},
+		{
+			"Offset and limit",
+			false,
+			true,
+			"n\n1\n2\n3\n4\n",
+			`[{"n": "2"}, {"n": "3"}]`,
+			nil,
+			[]string{"--offset", "1", "--limit", "2"},
+		},
+		{
+			"Headers only",
+			true,
+			true,
+			"\uFEFFFirst Name;ID\nann;1\n",
+			`["first_name", "id"]`,
+			nil,
+			[]string{"--headers-only", "--delimiter", ";", "--key-case", "snake"},
+		},
 	} {
 		t.Run(tt.testName, func(t *testing.T) {
 
@@ -142,19 +277,216 @@ func TestCli(t *testing.T) {
 				assert.JSONEq(t, tt.wantJsonOut, capturedStdout)
 			} else {
 				assert.ErrorIs(t, runErr, tt.wantErr)
+				if tt.wantJsonOut != "" {
+					assert.JSONEq(t, tt.wantJsonOut, capturedStdout)
+				}
 			}
 		})
 	}
 }
 
-func TestCsv2Json(t *testing.T) {
-	// Log errors to nowhere while this test runs
-	oldLogOutput := log.Writer()
-	log.SetOutput(bytes.NewBuffer([]byte{}))
+func TestCliMultipleFiles(t *testing.T) {
+	fileNames := make([]string, 5)
+	for i, contents := range []string{"a,b\n1,2\n", "a,b\n3,4\n", "a,b\n5,6\n", "", "a,c\n7,8\n"} {
+		tempFile, err := ioutil.TempFile("", "csv2json-test-*")
+		require.NoError(t, err, "Test cannot run without a temp file")
+		_, err = tempFile.WriteString(contents)
+		require.NoError(t, err, "Test cannot run without populated CSV")
+		_, err = tempFile.Seek(0, io.SeekStart)
+		require.NoError(t, err, "Test cannot run without CSV ready for reading")
+		fileNames[i] = tempFile.Name()
+		t.Cleanup(func() {
+			if err := os.Remove(tempFile.Name()); err != nil {
+				t.Fatalf("Error removing tempfile during cleanup")
+			}
+		})
+
+		if i == 1 {
+			// Substitute the middle CSV temp file for stdin
+			oldStdIn := os.Stdin
+			os.Stdin = tempFile
+			t.Cleanup(func() {
+				// Restore stdin
+				os.Stdin = oldStdIn
+			})
+		}
+	}
+
+	for _, tt := range []struct {
+		testName    string
+		cliArgs     []string
+		wantJsonOut string
+		wantErr     bool
+	}{
+		{
+			"Stdin is read at its position among named files",
+			[]string{fileNames[0], "-", fileNames[2]},
+			`[{"a": "1", "b": "2"}, {"a": "3", "b": "4"}, {"a": "5", "b": "6"}]`,
+			false,
+		},
+		{
+			"Stdin cannot be named twice",
+			[]string{"-", fileNames[0], "-"},
+			"",
+			true,
+		},
+		{
+			"Empty files are skipped",
+			[]string{fileNames[0], fileNames[3], fileNames[2]},
+			`[{"a": "1", "b": "2"}, {"a": "5", "b": "6"}]`,
+			false,
+		},
+		{
+			"Mismatched headers are an error",
+			[]string{fileNames[0], fileNames[4]},
+			"",
+			true,
+		},
+		{
+			"Mismatched headers can be read anyway",
+			[]string{"--header-mismatch", "warn", fileNames[0], fileNames[4]},
+			`[{"a": "1", "b": "2"}, {"a": "7", "b": "8"}]`,
+			false,
+		},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			os.Args = append([]string{"csv2json"}, tt.cliArgs...)
+			flaggy.ResetParser()
+
+			// Capture stdout for JSON assertions
+			oldStdout := os.Stdout
+			stdoutReader, stdoutWriter, _ := os.Pipe()
+			os.Stdout = stdoutWriter
+			runErr := runCli()
+			stdoutWriter.Close()
+			os.Stdout = oldStdout
+			var buf bytes.Buffer
+			io.Copy(&buf, stdoutReader)
+
+			if tt.wantErr {
+				assert.Error(t, runErr)
+			} else {
+				assert.NoError(t, runErr)
+				assert.JSONEq(t, tt.wantJsonOut, buf.String())
+			}
+		})
+	}
+}
+
+func TestCliStdinTerminal(t *testing.T) {
+	oldStdinIsTerminal := stdinIsTerminal
+	stdinIsTerminal = func() bool { return true }
+	oldStdin := os.Stdin
+	os.Stdin = nil
+	oldStderr := os.Stderr
+	os.Stderr, _ = os.Open(os.DevNull) // Discard help output
 	t.Cleanup(func() {
-		log.SetOutput(oldLogOutput)
+		stdinIsTerminal = oldStdinIsTerminal
+		os.Stdin = oldStdin
+		os.Stderr.Close()
+		os.Stderr = oldStderr
 	})
 
+	os.Args = []string{"csv2json"}
+	flaggy.ResetParser()
+
+	assert.EqualError(t, runCli(), "reading from stdin — pipe CSV data or pass a filename, use - to force stdin")
+}
+
+func TestCliStrictAndLenient(t *testing.T) {
+	os.Args = []string{"csv2json", "--strict", "--lenient", os.DevNull}
+	flaggy.ResetParser()
+
+	err := runCli()
+	assert.EqualError(t, err, "--strict and --lenient cannot be combined")
+	assert.Equal(t, exitUsage, exitCode(err))
+}
+
+func TestCliInvalidBatchSizes(t *testing.T) {
+	for _, tt := range []struct {
+		cliArgs []string
+		wantErr string
+	}{
+		{[]string{"--batch", "-1"}, "--batch cannot be negative"},
+		{[]string{"--post-url", "http://localhost", "--post-batch", "-1"}, "--post-batch must be at least 1"},
+		{[]string{"--post-url", "http://localhost", "--post-batch", "0"}, "--post-batch must be at least 1"},
+		{[]string{"--kafka-brokers", "localhost:9092", "--kafka-batch", "-1"}, "--kafka-batch must be at least 1"},
+		{[]string{"--kafka-brokers", "localhost:9092", "--kafka-batch", "0"}, "--kafka-batch must be at least 1"},
+	} {
+		t.Run(strings.Join(tt.cliArgs, " "), func(t *testing.T) {
+			os.Args = append(append([]string{"csv2json"}, tt.cliArgs...), os.DevNull)
+			flaggy.ResetParser()
+
+			err := runCli()
+			assert.EqualError(t, err, tt.wantErr)
+			assert.Equal(t, exitUsage, exitCode(err))
+		})
+	}
+}
+
+func TestCliInvalidDebugRowLength(t *testing.T) {
+	for _, length := range []string{"0", "-1"} {
+		t.Run(length, func(t *testing.T) {
+			os.Args = []string{"csv2json", "--debug", "--debug-row-length", length, os.DevNull}
+			flaggy.ResetParser()
+
+			err := runCli()
+			assert.EqualError(t, err, "--debug-row-length must be at least 1")
+			assert.Equal(t, exitUsage, exitCode(err))
+		})
+	}
+}
+
+func TestCliErrorsLeaveOutputUnterminated(t *testing.T) {
+	discardLogs(t)
+	csvFileName := filepath.Join(t.TempDir(), "ragged.csv")
+	require.NoError(t, ioutil.WriteFile(csvFileName, []byte("lat,lon,name\n1,2,a\n3,4,b\n5\n6,7,d\n"), 0600))
+
+	for _, tt := range []struct {
+		testName string
+		cliArgs  []string
+	}{
+		{"JSON array", nil},
+		{"Indented JSON array", []string{"--pretty"}},
+		{"GeoJSON", []string{"--format", "geojson"}},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			stdout, err := runTestCli(t, append(tt.cliArgs, csvFileName)...)
+			assert.EqualError(t, err, "record on line 4: wrong number of fields")
+			assert.NotEqual(t, exitOK, exitCode(err))
+			assert.False(t, json.Valid([]byte(stdout)), "Output should not be valid JSON: %s", stdout)
+			assert.Contains(t, stdout, `"b"`, "Records before the error should be emitted")
+		})
+	}
+}
+
+func TestCliStrictDuplicateHeaders(t *testing.T) {
+	discardLogs(t)
+	csvFileName := filepath.Join(t.TempDir(), "dups.csv")
+	require.NoError(t, ioutil.WriteFile(csvFileName, []byte("a,a\r\n1,2\r\n"), 0600))
+
+	for _, tt := range []struct {
+		testName string
+		cliArgs  []string
+	}{
+		{"Strict bundle", []string{"--strict"}},
+		{"Strict headers", []string{"--strict-headers"}},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			os.Args = append(append([]string{"csv2json"}, tt.cliArgs...), csvFileName)
+			flaggy.ResetParser()
+
+			err := runCli()
+			assert.EqualError(t, err, `header of `+csvFileName+`, column 2: duplicate column name "a"`)
+			assert.Equal(t, exitInvalidCsv, exitCode(err))
+		})
+	}
+}
+
+func TestCsv2Json(t *testing.T) {
+	// Log errors to nowhere while this test runs
+	discardLogs(t)
+
 	for _, tt := range []struct {
 		testName      string
 		forceColumns  []string
@@ -229,7 +561,7 @@ func TestCsv2Json(t *testing.T) {
 			jsonStream := bytes.NewBuffer([]byte{})
 			options := conversionOptions{
 				colNames:   tt.forceColumns,
-				csvInput:   bytes.NewReader([]byte(tt.csv)),
+				csvInputs:  []io.Reader{bytes.NewReader([]byte(tt.csv))},
 				jsonOutput: jsonStream,
 				skipErrors: tt.skipErrors,
 			}
@@ -246,6 +578,285 @@ func TestCsv2Json(t *testing.T) {
 	}
 }
 
+func TestCsv2JsonBatches(t *testing.T) {
+	for _, tt := range []struct {
+		testName       string
+		numRecords     int
+		batchSize      int
+		wantBatchSizes []int
+	}{
+		{"Records divide evenly into batches", 6, 3, []int{3, 3}},
+		{"Last batch may be smaller", 7, 3, []int{3, 3, 1}},
+		{"Batch larger than input", 2, 500, []int{2}},
+		{"Batch of one record", 3, 1, []int{1, 1, 1}},
+		{"Zero records emits nothing", 0, 3, []int{}},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			csvData := bytes.NewBufferString("n\n")
+			for i := 0; i < tt.numRecords; i++ {
+				fmt.Fprintf(csvData, "%d\n", i)
+			}
+			jsonStream := bytes.NewBuffer([]byte{})
+			options := conversionOptions{
+				csvInputs:  []io.Reader{csvData},
+				jsonOutput: jsonStream,
+				batchSize:  tt.batchSize,
+			}
+
+			err := csv2Json(options)
+			require.NoError(t, err)
+
+			gotBatchSizes := make([]int, 0)
+			gotRecords := make([]record, 0)
+			scanner := bufio.NewScanner(jsonStream)
+			for scanner.Scan() {
+				var batch []record
+				require.NoError(t, json.Unmarshal(scanner.Bytes(), &batch), "Each line must be a JSON array")
+				gotBatchSizes = append(gotBatchSizes, len(batch))
+				gotRecords = append(gotRecords, batch...)
+			}
+			assert.Equal(t, tt.wantBatchSizes, gotBatchSizes)
+			require.Len(t, gotRecords, tt.numRecords)
+			for i, rec := range gotRecords {
+				assert.Equal(t, record{"n": strconv.Itoa(i)}, rec)
+			}
+		})
+	}
+}
+
+func TestCsv2JsonMaxRecords(t *testing.T) {
+	for _, tt := range []struct {
+		testName   string
+		numRecords int
+		batchSize  int
+		sortBy     string
+		wantErr    string
+	}{
+		{"Allows exactly the limit", 3, 0, "n", ""},
+		{"Aborts at one more than the limit", 4, 0, "n",
+			"input exceeds 3 records; rerun with streaming output or raise the limit"},
+		{"Ignores the limit when streaming batches", 10, 2, "", ""},
+		{"Ignores the limit when streaming an array", 10, 0, "", ""},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			csvData := bytes.NewBufferString("n\n")
+			for i := 0; i < tt.numRecords; i++ {
+				fmt.Fprintf(csvData, "%d\n", i)
+			}
+			jsonStream := bytes.NewBuffer([]byte{})
+			options := conversionOptions{
+				csvInputs:  []io.Reader{csvData},
+				jsonOutput: jsonStream,
+				batchSize:  tt.batchSize,
+				sortBy:     tt.sortBy,
+				maxRecords: 3,
+			}
+
+			err := csv2Json(options)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.numRecords, strings.Count(jsonStream.String(), `"n"`))
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Empty(t, jsonStream.String(), "Nothing should be emitted")
+			}
+		})
+	}
+}
+
+func TestCsv2JsonOffsetAndLimit(t *testing.T) {
+	discardLogs(t)
+	csvData := "n\n1\n2\n3\n4\n5\n"
+
+	for _, tt := range []struct {
+		testName      string
+		csvData       string
+		offset        int
+		limit         int
+		skipErrors    bool
+		wantJson      string
+		wantErr       string
+		wantConverted int
+		wantSkipped   int
+	}{
+		{"Neither", csvData, 0, 0, false, `[{"n":"1"},{"n":"2"},{"n":"3"},{"n":"4"},{"n":"5"}]`, "", 5, 0},
+		{"Offset", csvData, 3, 0, false, `[{"n":"4"},{"n":"5"}]`, "", 2, 0},
+		{"Limit", csvData, 0, 2, false, `[{"n":"1"},{"n":"2"}]`, "", 2, 0},
+		{"Offset and limit", csvData, 1, 2, false, `[{"n":"2"},{"n":"3"}]`, "", 2, 0},
+		{"Limit past EOF", csvData, 4, 10, false, `[{"n":"5"}]`, "", 1, 0},
+		{"Offset past EOF", csvData, 10, 2, false, `[]`, "", 0, 0},
+		{"Errors within the offset abort", "n\n1\n2,x\n3\n", 2, 0, false, "",
+			"record on line 3: wrong number of fields", 0, 0},
+		{"Rows skipped within the offset do not count towards it", "n\n1\n2,x\n3\n4\n", 2, 1, true,
+			`[{"n":"4"}]`, "", 1, 1},
+		{"Rows after the limit are not read", "n\n1\n2\n3,x\n", 0, 2, false, `[{"n":"1"},{"n":"2"}]`, "", 2, 0},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+			stats := &conversionStats{}
+			err := csv2Json(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(tt.csvData)},
+				jsonOutput: jsonStream,
+				offset:     tt.offset,
+				limit:      tt.limit,
+				skipErrors: tt.skipErrors,
+				stats:      stats,
+			})
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, jsonStream.String())
+				assert.Equal(t, tt.wantConverted, stats.rowsConverted)
+				assert.Equal(t, tt.wantSkipped, stats.rowsSkipped)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCsv2JsonLimitStopsReading(t *testing.T) {
+	csvData := bytes.NewBufferString("n\n")
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(csvData, "%d\n", i)
+	}
+	var count int64
+
+	err := csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{readCounter{csvData, &count}},
+		jsonOutput: ioutil.Discard,
+		limit:      2,
+	})
+	assert.NoError(t, err)
+	assert.Less(t, count, int64(64*1024), "Only the start of the input should be read")
+}
+
+func TestCsv2JsonChecksOptionsBeforeReading(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		options  conversionOptions
+		wantErr  string
+	}{
+		{"Streamed sort", conversionOptions{sortBy: "a", batchSize: 1}, "--sort-by cannot be used when records are streamed"},
+		{"Shuffled sort", conversionOptions{sortBy: "a", shuffle: true},
+			"--shuffle cannot be combined with --sort-by or --reverse"},
+		{"Invalid sort", conversionOptions{sortBy: "a:sideways"},
+			`invalid --sort-by key "a:sideways" (expected field[:asc|desc[:mode]])`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var count int64
+			options := tt.options
+			options.csvInputs = []io.Reader{readCounter{strings.NewReader("a\n1\n"), &count}}
+			options.jsonOutput = ioutil.Discard
+
+			err := csv2Json(options)
+			assert.EqualError(t, err, tt.wantErr)
+			assert.Equal(t, exitUsage, exitCode(err))
+			assert.Zero(t, count, "Invalid options should be reported before any input is read")
+		})
+	}
+}
+
+func TestCsv2JsonContext(t *testing.T) {
+	csvData := bytes.NewBufferString("n\n")
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(csvData, "%d\n", i)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	numRecords := 0
+	err := eachBatch(conversionOptions{csvInputs: []io.Reader{csvData}, ctx: ctx}, 1, func(batch []record) error {
+		if numRecords++; numRecords == 100 {
+			cancel()
+		}
+		return nil
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 100, numRecords, "No records should be read once cancelled")
+
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	t.Cleanup(cancel)
+	err = csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader("n\n1\n")},
+		jsonOutput: ioutil.Discard,
+		ctx:        ctx,
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, exitTimeout, exitCode(err))
+}
+
+// flushRecorder is a buffered writer which records how many lines had been written at each flush.
+type flushRecorder struct {
+	bytes.Buffer
+	flushedAtLines []int
+}
+
+func (f *flushRecorder) Flush() error {
+	f.flushedAtLines = append(f.flushedAtLines, bytes.Count(f.Bytes(), []byte("\n")))
+	return nil
+}
+
+func TestCsv2JsonRejectInvalidUtf8(t *testing.T) {
+	csvData := "a,b\n1,caf\xe9\n2,ok\n"
+
+	err := csv2Json(conversionOptions{
+		csvInputs:         []io.Reader{strings.NewReader(csvData)},
+		jsonOutput:        ioutil.Discard,
+		rejectInvalidUtf8: true,
+	})
+	assert.EqualError(t, err, "row 1: value 2 is not valid UTF-8")
+
+	jsonStream := bytes.NewBuffer([]byte{})
+	err = csv2Json(conversionOptions{
+		csvInputs:         []io.Reader{strings.NewReader(csvData)},
+		jsonOutput:        jsonStream,
+		skipErrors:        true,
+		rejectInvalidUtf8: true,
+	})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"a": "2", "b": "ok"}]`, jsonStream.String())
+
+	err = csv2Json(conversionOptions{
+		csvInputs:         []io.Reader{strings.NewReader("a,\xff\n1,2\n")},
+		jsonOutput:        ioutil.Discard,
+		skipErrors:        true,
+		rejectInvalidUtf8: true,
+	})
+	assert.EqualError(t, err, "header of input 1, column 2: column name is not valid UTF-8")
+}
+
+func TestCsv2JsonFlushEvery(t *testing.T) {
+	for _, tt := range []struct {
+		testName       string
+		batchSize      int
+		flushEvery     int
+		wantFlushLines []int
+	}{
+		{"Flushes after every record", 1, 1, []int{1, 2, 3, 4, 5}},
+		{"Flushes after every N records", 1, 2, []int{2, 4}},
+		{"Flushes after the batch which reaches N records", 2, 3, []int{2}},
+		{"Does not flush by default", 1, 0, nil},
+		{"Does not flush without batches", 0, 1, nil},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			output := &flushRecorder{}
+			options := conversionOptions{
+				csvInputs:  []io.Reader{bytes.NewBufferString("n\n1\n2\n3\n4\n5\n")},
+				jsonOutput: output,
+				batchSize:  tt.batchSize,
+				flushEvery: tt.flushEvery,
+			}
+
+			err := csv2Json(options)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantFlushLines, output.flushedAtLines)
+		})
+	}
+}
+
 func TestGetCsvFile(t *testing.T) {
 	tempFile, err := ioutil.TempFile("", "csv2json-test-*")
 	require.NoError(t, err, "Tests cannot run without a temp file")
@@ -264,6 +875,7 @@ func TestGetCsvFile(t *testing.T) {
 	}{
 		{"Gets named file", tempFile.Name(), tempFile},
 		{"Gets stdin when no named file", "", os.Stdin},
+		{"Gets stdin when explicitly named", "-", os.Stdin},
 		{"Error when named file does not exist", testBadFileName, nil},
 	} {
 		t.Run(tt.testName, func(t *testing.T) {
@@ -279,6 +891,193 @@ func TestGetCsvFile(t *testing.T) {
 	}
 }
 
+func TestGetCsvFiles(t *testing.T) {
+	tempFile, err := ioutil.TempFile("", "csv2json-test-*")
+	require.NoError(t, err, "Tests cannot run without a temp file")
+	t.Cleanup(func() {
+		if err := os.Remove(tempFile.Name()); err != nil {
+			t.Fatalf("Error removing tempfile during cleanup")
+		}
+	})
+
+	for _, tt := range []struct {
+		testName      string
+		testFileNames []string
+		wantFds       []*os.File
+		wantErr       bool
+	}{
+		{"Gets stdin when no named files", []string{}, []*os.File{os.Stdin}, false},
+		{"Gets named files and stdin in order", []string{tempFile.Name(), "-", tempFile.Name()},
+			[]*os.File{tempFile, os.Stdin, tempFile}, false},
+		{"Error when stdin named more than once", []string{"-", tempFile.Name(), "-"}, nil, true},
+		{"Error when named file does not exist", []string{tempFile.Name(), "thisFileDoesNotExist"}, nil, true},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			files, err := getCsvFiles(tt.testFileNames, "", urlInputOptions{})
+
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				require.Len(t, files, len(tt.wantFds))
+				for i := range files {
+					assert.Equal(t, tt.wantFds[i].Name(), files[i].(*os.File).Name())
+				}
+			}
+		})
+	}
+}
+
+func TestGetCsvFilesMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "first.csv")
+	require.NoError(t, ioutil.WriteFile(fileName, []byte("a\n1\n"), 0644))
+	missingFileName := filepath.Join(dir, "missing.csv")
+
+	files, err := getCsvFiles([]string{fileName, missingFileName, fileName}, "", urlInputOptions{})
+
+	assert.Nil(t, files)
+	assert.True(t, errors.Is(err, os.ErrNotExist), "Error should wrap os.ErrNotExist")
+	assert.Contains(t, err.Error(), missingFileName, "Error should name the missing file")
+	assert.Equal(t, exitInputNotFound, exitCode(err))
+}
+
+func TestGetCsvFilesGzip(t *testing.T) {
+	dir := t.TempDir()
+	plainFileName := filepath.Join(dir, "first.csv")
+	require.NoError(t, ioutil.WriteFile(plainFileName, []byte("a,b\n1,2\n"), 0644))
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, err := zw.Write([]byte("a,b\n3,4\n"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	gzipFileName := filepath.Join(dir, "second.csv.gz")
+	require.NoError(t, ioutil.WriteFile(gzipFileName, compressed.Bytes(), 0644))
+
+	files, err := getCsvFiles([]string{plainFileName, gzipFileName}, "auto", urlInputOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, gzipFileName, inputName(files[1], 2), "Compressed file should still be named")
+
+	jsonStream := bytes.NewBuffer([]byte{})
+	err = csv2Json(conversionOptions{csvInputs: files, jsonOutput: jsonStream})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"a": "1", "b": "2"}, {"a": "3", "b": "4"}]`, jsonStream.String(),
+		"Header of the compressed file should be consumed like any other")
+	assert.NoError(t, closeCsvFiles(files))
+	_, err = files[1].(*gzipCsvFile).Stat()
+	assert.Error(t, err, "Compressed file should be closed")
+}
+
+func TestCsvRowReader(t *testing.T) {
+	for _, tt := range []struct {
+		testName     string
+		inputs       []string
+		forceColumns []string
+		wantColNames []string
+		wantRows     [][]string
+		wantErr      string
+	}{
+		{
+			"Reads inputs in order, skipping each header",
+			[]string{"a,b\n1,2\n", "\uFEFFa,b\n3,4\n5,6\n"},
+			nil,
+			[]string{"a", "b"},
+			[][]string{{"1", "2"}, {"3", "4"}, {"5", "6"}},
+			"",
+		},
+		{
+			"Empty inputs are skipped",
+			[]string{"", "a,b\n1,2\n", "", "a,b\n3,4\n"},
+			nil,
+			[]string{"a", "b"},
+			[][]string{{"1", "2"}, {"3", "4"}},
+			"",
+		},
+		{
+			"Every line of every input is data with forced columns",
+			[]string{"a,b\n1,2\n", "\uFEFFa,b\n3,4\n"},
+			[]string{"x", "y"},
+			[]string{"x", "y"},
+			[][]string{{"a", "b"}, {"1", "2"}, {"a", "b"}, {"3", "4"}},
+			"",
+		},
+		{
+			"Mismatched header is an error",
+			[]string{"a,b\n1,2\n", "a,c\n3,4\n"},
+			nil,
+			[]string{"a", "b"},
+			[][]string{{"1", "2"}},
+			`header of input 2 ["a" "c"] does not match columns ["a" "b"]`,
+		},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			inputs := make([]io.Reader, len(tt.inputs))
+			for i, input := range tt.inputs {
+				inputs[i] = bytes.NewReader([]byte(input))
+			}
+
+			reader, colNames, err := newCsvRowReader(conversionOptions{csvInputs: inputs, colNames: tt.forceColumns})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantColNames, colNames)
+
+			gotRows := make([][]string, 0)
+			for {
+				row, err := reader.Read()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					assert.EqualError(t, err, tt.wantErr)
+					break
+				}
+				gotRows = append(gotRows, row)
+			}
+			assert.Equal(t, tt.wantRows, gotRows)
+		})
+	}
+}
+
+func TestCsvRowReaderHeaderMismatch(t *testing.T) {
+	discardLogs(t)
+
+	for _, tt := range []struct {
+		testName string
+		policy   string
+		second   string
+		wantRows [][]string
+		wantErr  string
+	}{
+		{"Errors by default", "", "a,c\n3,4\n", [][]string{{"1", "2"}},
+			`header of input 2 ["a" "c"] does not match columns ["a" "b"]`},
+		{"Errors", "error", "a,c\n3,4\n", [][]string{{"1", "2"}},
+			`header of input 2 ["a" "c"] does not match columns ["a" "b"]`},
+		{"Warns and reads rows by position", "warn", "b,a\n3,4\n", [][]string{{"1", "2"}, {"3", "4"}}, ""},
+		{"Warns but rows must fit the first columns", "warn", "a,b,c\n3,4,5\n", [][]string{{"1", "2"}},
+			"record on line 2: wrong number of fields"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			reader, _, err := newCsvRowReader(conversionOptions{
+				csvInputs:      []io.Reader{strings.NewReader("a,b\n1,2\n"), strings.NewReader(tt.second)},
+				headerMismatch: tt.policy,
+			})
+			require.NoError(t, err)
+
+			gotRows := make([][]string, 0)
+			for {
+				row, err := reader.Read()
+				if err == io.EOF {
+					assert.Empty(t, tt.wantErr)
+					break
+				} else if err != nil {
+					assert.EqualError(t, err, tt.wantErr)
+					break
+				}
+				gotRows = append(gotRows, row)
+			}
+			assert.Equal(t, tt.wantRows, gotRows)
+		})
+	}
+}
+
 func TestGetCsvReader(t *testing.T) {
 	for _, tt := range []struct {
 		testName string
@@ -298,13 +1097,25 @@ func TestGetCsvReader(t *testing.T) {
 		_, err = tempFile.Seek(0, io.SeekStart)
 		require.NoError(t, err, "Could not prepare temp file")
 
-		reader := getCsvReader(tempFile)
+		reader, err := getCsvReader(tempFile, csvDialect{})
+		require.NoError(t, err)
 		firstRow, err := reader.Read()
 		assert.NoError(t, err)
 		assert.Equal(t, firstRow, []string{"a", "b", "c"})
 	}
 }
 
+func TestCsv2JsonReadError(t *testing.T) {
+	errRead := errors.New("device not ready")
+	pr, pw := io.Pipe()
+	pw.CloseWithError(errRead)
+
+	err := csv2Json(conversionOptions{csvInputs: []io.Reader{pr}, jsonOutput: ioutil.Discard})
+
+	assert.True(t, errors.Is(err, errRead), "Unexpected error %v", err)
+	assert.Equal(t, exitFailure, exitCode(err))
+}
+
 func TestFieldsToRecords(t *testing.T) {
 	for _, tt := range []struct {
 		testName            string
@@ -334,3 +1145,199 @@ func TestFieldsToRecords(t *testing.T) {
 		})
 	}
 }
+
+func TestCsv2JsonReverse(t *testing.T) {
+	for _, tt := range []struct {
+		testName   string
+		numRecords int
+		maxRecords int
+		batchSize  int
+		wantJson   string
+		wantErr    string
+	}{
+		{"Reverses records", 3, 0, 0, `[{"n":"2"},{"n":"1"},{"n":"0"}]` + "\n", ""},
+		{"Allows no records", 0, 0, 0, "[]\n", ""},
+		{"Respects the limit", 4, 3, 0, "", "input exceeds 3 records; rerun with streaming output or raise the limit"},
+		{"Cannot stream", 3, 0, 1, "", "--reverse cannot be used when records are streamed"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			csvData := bytes.NewBufferString("n\n")
+			for i := 0; i < tt.numRecords; i++ {
+				fmt.Fprintf(csvData, "%d\n", i)
+			}
+			jsonStream := bytes.NewBuffer([]byte{})
+
+			err := csv2Json(conversionOptions{
+				csvInputs:  []io.Reader{csvData},
+				jsonOutput: jsonStream,
+				batchSize:  tt.batchSize,
+				maxRecords: tt.maxRecords,
+				reverse:    true,
+			})
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantJson, jsonStream.String())
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Empty(t, jsonStream.String(), "Nothing should be emitted")
+			}
+		})
+	}
+}
+
+func TestCsv2JsonIndent(t *testing.T) {
+	csvData := "a,b\n1,2\n3,4\n"
+
+	for _, tt := range []struct {
+		testName  string
+		csv       string
+		indent    int
+		ndjson    bool
+		batchSize int
+		wantJson  string
+	}{
+		{"Compact by default", csvData, 0, false, 0, `[{"a":"1","b":"2"},{"a":"3","b":"4"}]` + "\n"},
+		{"Indented array", csvData, 2, false, 0, `[
+  {
+    "a": "1",
+    "b": "2"
+  },
+  {
+    "a": "3",
+    "b": "4"
+  }
+]
+`},
+		{"Indented batches", csvData, 4, false, 1, `[
+    {
+        "a": "1",
+        "b": "2"
+    }
+]
+[
+    {
+        "a": "3",
+        "b": "4"
+    }
+]
+`},
+		{"Indented NDJSON", csvData, 2, true, 0, `{
+  "a": "1",
+  "b": "2"
+}
+{
+  "a": "3",
+  "b": "4"
+}
+`},
+		{"Indented empty array", "a,b\n", 2, false, 0, "[]\n"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+
+			err := csv2Json(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(tt.csv)},
+				jsonOutput: jsonStream,
+				indent:     tt.indent,
+				ndjson:     tt.ndjson,
+				batchSize:  tt.batchSize,
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantJson, jsonStream.String())
+		})
+	}
+}
+
+func TestCsv2JsonEmptyRows(t *testing.T) {
+	discardLogs(t)
+	csvData := "a,b,c\n1,2,3\n,,\n4,,\n , ,\t\n5,6,7\n"
+
+	for _, tt := range []struct {
+		testName     string
+		policy       string
+		padShortRows bool
+		csv          string
+		wantJson     string
+		wantEmpty    int
+		wantErr      string
+	}{
+		{"Emits empty rows by default", "", false, csvData,
+			`[{"a":"1","b":"2","c":"3"},{"a":"","b":"","c":""},{"a":"4","b":"","c":""},
+				{"a":" ","b":" ","c":"\t"},{"a":"5","b":"6","c":"7"}]`, 0, ""},
+		{"Emits empty rows", "emit", false, csvData,
+			`[{"a":"1","b":"2","c":"3"},{"a":"","b":"","c":""},{"a":"4","b":"","c":""},
+				{"a":" ","b":" ","c":"\t"},{"a":"5","b":"6","c":"7"}]`, 0, ""},
+		{"Skips empty rows but not sparse ones", "skip", false, csvData,
+			`[{"a":"1","b":"2","c":"3"},{"a":"4","b":"","c":""},{"a":"5","b":"6","c":"7"}]`, 2, ""},
+		{"Skips padded rows", "skip", true, "a,b,c\n1,2,3\n \n,\n", `[{"a":"1","b":"2","c":"3"}]`, 2, ""},
+		{"Empty rows are errors", "error", false, csvData, "", 0, "row 2: every field is empty"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+			stats := &conversionStats{}
+
+			err := csv2Json(conversionOptions{
+				csvInputs:    []io.Reader{strings.NewReader(tt.csv)},
+				jsonOutput:   jsonStream,
+				emptyRows:    tt.policy,
+				padShortRows: tt.padShortRows,
+				stats:        stats,
+			})
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, jsonStream.String())
+				assert.Equal(t, tt.wantEmpty, stats.rowsEmpty)
+				assert.Zero(t, stats.rowsSkipped, "Empty rows should not count as skipped due to errors")
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckEmptyRowPolicy(t *testing.T) {
+	assert.NoError(t, checkEmptyRowPolicy(""))
+	assert.NoError(t, checkEmptyRowPolicy("skip"))
+	assert.EqualError(t, checkEmptyRowPolicy("drop"),
+		`unknown --keep-empty-rows policy "drop" (expected one of emit, skip, error)`)
+}
+
+func TestCsv2JsonSkipBlankRecords(t *testing.T) {
+	discardLogs(t)
+
+	for _, tt := range []struct {
+		testName   string
+		csv        string
+		transforms []valueTransform
+		wantJson   string
+		wantBlank  int
+	}{
+		{"Trailing padding rows", "a,b\n1,2\n,\n,\n,\n", nil, `[{"a":"1","b":"2"}]`, 3},
+		{"An interior blank row", "a,b\n1,2\n,\n3,\n", nil, `[{"a":"1","b":"2"},{"a":"3","b":""}]`, 1},
+		{"A single space", "a,b\n1,2\n ,\n", nil, `[{"a":"1","b":"2"}]`, 1},
+		{"A single space which is trimmed", "a,b\n1,2\n ,\n", []valueTransform{strings.TrimSpace},
+			`[{"a":"1","b":"2"}]`, 1},
+		{"Sparse rows are kept", "a,b\n,x\n", nil, `[{"a":"","b":"x"}]`, 0},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+			stats := &conversionStats{}
+
+			err := csv2Json(conversionOptions{
+				csvInputs:        []io.Reader{strings.NewReader(tt.csv)},
+				jsonOutput:       jsonStream,
+				transforms:       tt.transforms,
+				skipBlankRecords: true,
+				stats:            stats,
+			})
+
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.wantJson, jsonStream.String())
+			assert.Equal(t, tt.wantBlank, stats.rowsEmpty)
+			assert.Zero(t, stats.rowsSkipped, "Blank records should not count as skipped due to errors")
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// nestedObject is an object built by nestValues() from the values of columns whose names share a prefix, which,
+// unlike the values themselves, further values may be nested in.
+type nestedObject map[string]interface{}
+
+// nestValues returns the values of a record nested into objects by splitting their column names on sep, so that
+// with a sep of ".", the value of "user.address.city" is the "city" of the "address" object of the "user" object.
+// Returns an error naming both columns when one would be nested in the value of the other, such as "user.name" in
+// "user".
+func nestValues(values map[string]interface{}, sep string) (map[string]interface{}, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	// Column names sort before the names of the columns nested in them, so a conflict is found at the nested column
+	sort.Strings(names)
+	nested := make(map[string]interface{}, len(values))
+	for _, name := range names {
+		path := strings.Split(name, sep)
+		obj := nested
+		for i, key := range path[:len(path)-1] {
+			switch child := obj[key].(type) {
+			case nil:
+				if _, ok := obj[key]; ok {
+					return nil, nestingConflictError(name, strings.Join(path[:i+1], sep))
+				}
+				next := make(nestedObject)
+				obj[key] = next
+				obj = next
+			case nestedObject:
+				obj = child
+			default:
+				return nil, nestingConflictError(name, strings.Join(path[:i+1], sep))
+			}
+		}
+		obj[path[len(path)-1]] = values[name]
+	}
+	return nested, nil
+}
+
+func nestingConflictError(name, parentName string) error {
+	return fmt.Errorf("column %q cannot be nested in the value of column %q", name, parentName)
+}
+
+// checkNestedColumns returns a *usageError unless the values of colNames can be nested by nestValues().
+func checkNestedColumns(colNames []string, sep string) error {
+	values := make(map[string]interface{}, len(colNames))
+	for _, name := range colNames {
+		values[name] = ""
+	}
+	if _, err := nestValues(values, sep); err != nil {
+		return &usageError{fmt.Errorf("cannot nest columns split on %q: %w", sep, err)}
+	}
+	return nil
+}
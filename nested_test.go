@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNestValues(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		values   map[string]interface{}
+		sep      string
+		want     map[string]interface{}
+		wantErr  string
+	}{
+		{"Flat", map[string]interface{}{"a": "1", "b": nil}, ".", map[string]interface{}{"a": "1", "b": nil}, ""},
+		{"Deeply nested", map[string]interface{}{"user.name": "ann", "user.address.city": "Oslo",
+			"user.address.geo.lat": "59.9", "order.total": "5"}, ".",
+			map[string]interface{}{
+				"user": nestedObject{"name": "ann", "address": nestedObject{"city": "Oslo",
+					"geo": nestedObject{"lat": "59.9"}}},
+				"order": nestedObject{"total": "5"},
+			}, ""},
+		{"Other separators", map[string]interface{}{"user__name": "ann", "user.id": "1"}, "__",
+			map[string]interface{}{"user": nestedObject{"name": "ann"}, "user.id": "1"}, ""},
+		{"Values which are objects", map[string]interface{}{"a": base64Binary{"AA=="}, "b.c": "1"}, ".",
+			map[string]interface{}{"a": base64Binary{"AA=="}, "b": nestedObject{"c": "1"}}, ""},
+		{"Columns nested in values", map[string]interface{}{"user": "ann", "user.name": "ann"}, ".", nil,
+			`column "user.name" cannot be nested in the value of column "user"`},
+		{"Columns deeply nested in values", map[string]interface{}{"a.b.c": "1", "a.b": nil}, ".", nil,
+			`column "a.b.c" cannot be nested in the value of column "a.b"`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			nested, err := nestValues(tt.values, tt.sep)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, nested)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCsv2JsonNested(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		csvData  string
+		options  conversionOptions
+		wantJson string
+		wantErr  string
+	}{
+		{"Nests columns", "id,user.name,user.address.city\n1,ann,Oslo\n", conversionOptions{},
+			`[{"id": "1", "user": {"name": "ann", "address": {"city": "Oslo"}}}]`, ""},
+		{"Nests forced columns", "1,ann\n", conversionOptions{colNames: []string{"user.id", "user.name"}},
+			`[{"user": {"id": "1", "name": "ann"}}]`, ""},
+		{"Nests typed values", "n.int,n.inferred\n1,true\n",
+			conversionOptions{columnTypes: map[string]string{"n.int": "int"}, inferTypes: true},
+			`[{"n": {"int": 1, "inferred": true}}]`, ""},
+		{"Nests NDJSON", "a.b\n1\n2\n", conversionOptions{ndjson: true},
+			`{"a":{"b":"1"}}` + "\n" + `{"a":{"b":"2"}}` + "\n", ""},
+		{"Rejects conflicting columns", "user,user.name\nann,ann\n", conversionOptions{}, "",
+			`cannot nest columns split on ".": column "user.name" cannot be nested in the value of column "user"`},
+		{"Rejects conflicting forced columns", "1,2\n", conversionOptions{colNames: []string{"a.b", "a.b.c"}}, "",
+			`cannot nest columns split on ".": column "a.b.c" cannot be nested in the value of column "a.b"`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var jsonStream bytes.Buffer
+			tt.options.csvInputs = []io.Reader{strings.NewReader(tt.csvData)}
+			tt.options.jsonOutput = &jsonStream
+			tt.options.nestSeparator = "."
+			err := csv2Json(tt.options)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				if tt.options.ndjson {
+					assert.Equal(t, tt.wantJson, jsonStream.String())
+				} else {
+					assert.JSONEq(t, tt.wantJson, jsonStream.String())
+				}
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, exitUsage, exitCode(err))
+				assert.Empty(t, jsonStream.String())
+			}
+		})
+	}
+}
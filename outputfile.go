@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// atomicFile is a temporary file in the same directory as the file it replaces once committed, so that readers of
+// the named file never see it partially written. It is written in place of the files named by --output, --tee,
+// --report-file, and --state-file, and of those written by the watch subcommand.
+type atomicFile struct {
+	file     *os.File
+	fileName string
+}
+
+// createAtomicFile returns an atomicFile which replaces the file named fileName once committed. The temporary file has
+// the permissions of any existing file, so that replacing it never makes it readable by anyone who could not read it
+// before, or otherwise those of a new file, as permitted by the umask.
+// Returns any error creating the temporary file.
+func createAtomicFile(fileName string) (*atomicFile, error) {
+	perm, keepPerm := os.FileMode(0666), false
+	if info, err := os.Stat(fileName); err == nil {
+		perm, keepPerm = info.Mode().Perm(), true
+	}
+	prefix := filepath.Join(filepath.Dir(fileName), "."+filepath.Base(fileName)+".tmp-")
+	for attempt := 0; ; attempt++ {
+		// ioutil.TempFile() would create the file only readable by its owner, whatever the umask
+		name := prefix + strconv.FormatInt(time.Now().UnixNano(), 36) + strconv.Itoa(attempt)
+		file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) && attempt < 100 {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		// The umask applies when the file is created, but the existing file's permissions are kept as they were
+		if keepPerm {
+			if err := file.Chmod(perm); err != nil {
+				file.Close()
+				os.Remove(name)
+				return nil, err
+			}
+		}
+		return &atomicFile{file: file, fileName: fileName}, nil
+	}
+}
+
+func (f *atomicFile) Write(p []byte) (int, error) {
+	return f.file.Write(p)
+}
+
+// commit syncs the temporary file to disk and renames it to the file it replaces. Errors syncing or closing the file,
+// such as when the disk is full, are returned, and leave any existing file in place.
+func (f *atomicFile) commit() error {
+	defer os.Remove(f.file.Name())
+	if err := f.file.Sync(); err != nil {
+		f.file.Close()
+		return err
+	}
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.file.Name(), f.fileName)
+}
+
+// discard removes the temporary file, leaving any existing file in place.
+func (f *atomicFile) discard() {
+	f.file.Close()
+	os.Remove(f.file.Name())
+}
+
+// finish commits the file named by --output, unless conversion failed with convErr, in which case it is discarded
+// instead, so that a failed conversion never leaves it partially written. Skipped rows do not count as failing.
+func (f *atomicFile) finish(convErr error) error {
+	if convErr != nil && !errors.As(convErr, new(skippedRowsError)) {
+		f.discard()
+		return nil
+	}
+	return f.commit()
+}
+
+// writeFileAtomically writes data to an atomicFile which then replaces the file named fileName.
+func writeFileAtomically(fileName string, data []byte) error {
+	file, err := createAtomicFile(fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.discard()
+		return err
+	}
+	return file.commit()
+}
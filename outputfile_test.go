@@ -0,0 +1,131 @@
+package main
+
+import (
+	"github.com/integrii/flaggy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAtomicFile(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		convErr  error
+		wantData string
+	}{
+		{"Replaces the existing file", nil, "new"},
+		{"Replaces the existing file after skipping rows", skippedRowsError{1}, "new"},
+		{"Keeps the existing file on error", &rowError{3, os.ErrInvalid}, "old"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			dir := t.TempDir()
+			fileName := filepath.Join(dir, "out.json")
+			require.NoError(t, ioutil.WriteFile(fileName, []byte("old"), 0600))
+			file, err := createAtomicFile(fileName)
+			require.NoError(t, err)
+			_, err = file.Write([]byte("new"))
+			require.NoError(t, err)
+
+			assert.NoError(t, file.finish(tt.convErr))
+
+			data, err := ioutil.ReadFile(fileName)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantData, string(data))
+			entries, err := ioutil.ReadDir(dir)
+			assert.NoError(t, err)
+			assert.Len(t, entries, 1, "The temp file should not be left behind")
+		})
+	}
+}
+
+func TestAtomicFilePermissions(t *testing.T) {
+	dir := t.TempDir()
+	// Files created by the process get the permissions allowed by its umask
+	umaskedFileName := filepath.Join(dir, "umasked")
+	require.NoError(t, ioutil.WriteFile(umaskedFileName, nil, 0666))
+	umasked, err := os.Stat(umaskedFileName)
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		testName string
+		existing os.FileMode
+		wantPerm os.FileMode
+	}{
+		{"New file", 0, umasked.Mode().Perm()},
+		{"Private file", 0600, 0600},
+		{"Shared file", 0664, 0664},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			fileName := filepath.Join(dir, "out.json")
+			os.Remove(fileName)
+			if tt.existing != 0 {
+				require.NoError(t, ioutil.WriteFile(fileName, []byte("old"), tt.existing))
+				require.NoError(t, os.Chmod(fileName, tt.existing))
+			}
+
+			require.NoError(t, writeFileAtomically(fileName, []byte("new")))
+
+			info, err := os.Stat(fileName)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantPerm, info.Mode().Perm())
+		})
+	}
+}
+
+func TestAtomicFileCloseError(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "out.json")
+	file, err := createAtomicFile(fileName)
+	require.NoError(t, err)
+	file.file.Close()
+
+	assert.Error(t, file.finish(nil))
+
+	entries, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries, "Nothing should be written when the file cannot be synced")
+}
+
+func TestCliOutput(t *testing.T) {
+	discardLogs(t)
+
+	for _, tt := range []struct {
+		testName string
+		csvData  string
+		cliArgs  []string
+		wantJson string
+		wantErr  bool
+	}{
+		{"Overwrites an existing file", "a,b\n1,2\n", nil, `[{"a": "1", "b": "2"}]`, false},
+		{"Writes the records which were not skipped", "a,b\n1,2\n3\n", []string{"--skip-errors"},
+			`[{"a": "1", "b": "2"}]`, true},
+		{"Keeps the existing file on error", "a,b\n1,2\n3\n", nil, `{"old": true}`, true},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			dir := t.TempDir()
+			csvFileName := filepath.Join(dir, "in.csv")
+			require.NoError(t, ioutil.WriteFile(csvFileName, []byte(tt.csvData), 0600))
+			outputFileName := filepath.Join(dir, "out.json")
+			require.NoError(t, ioutil.WriteFile(outputFileName, []byte(`{"old": true}`), 0600))
+			os.Args = append([]string{"csv2json", "-o", outputFileName, csvFileName}, tt.cliArgs...)
+			flaggy.ResetParser()
+
+			err := runCli()
+
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			data, err := ioutil.ReadFile(outputFileName)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.wantJson, string(data))
+			entries, err := ioutil.ReadDir(dir)
+			assert.NoError(t, err)
+			assert.Len(t, entries, 2, "The temp file should not be left behind")
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// nullPartition is the directory value for records whose partition column is empty.
+const nullPartition = "__null__"
+
+// partitionFileName is the name of the file within each partition's directory to which its records are written.
+const partitionFileName = "part.ndjson"
+
+// partitionOptions is used to configure writing converted records to partitioned files when calling csv2Partitions().
+type partitionOptions struct {
+	column    string
+	outputDir string
+	maxOpen   int
+}
+
+// csv2Partitions converts CSV data from `options.csvInputs` and streams each record as a line of JSON to the file
+// for its value of `partitionOpts.column`, within a hive-style directory named column=value under
+// `partitionOpts.outputDir`. Values are escaped by partitionDirName(). At most `partitionOpts.maxOpen` files are
+// kept open at once, closing the least recently written when another must be opened. A column which the header does
+// not have is rejected before any row is read.
+// Returns any errors from reading CSV, or from creating or writing files.
+func csv2Partitions(options conversionOptions, partitionOpts partitionOptions) error {
+	if partitionOpts.outputDir == "" {
+		return usageErrorf("--partition-by requires --output-dir")
+	} else if partitionOpts.maxOpen < 1 {
+		return usageErrorf("--max-open-partitions must be at least 1")
+	}
+
+	if err := checkBatchOptions(options, 1); err != nil {
+		return err
+	}
+	reader, colNames, err := newCsvRowReader(options)
+	if err != nil {
+		return err
+	}
+	// Records from --exec-filter may have other fields, so are only checked once they are converted
+	if options.execFilter == "" && !containsString(reader.fieldNames(colNames), partitionOpts.column) {
+		return usageErrorf("unknown partition column %q", partitionOpts.column)
+	}
+
+	w := newPartitionWriter(partitionOpts)
+	err = eachReaderBatch(options, reader, colNames, 1, func(batch []record) error {
+		for _, rec := range batch {
+			value, ok := rec[partitionOpts.column]
+			if !ok {
+				return usageErrorf("unknown partition column %q", partitionOpts.column)
+			}
+			if err := w.write(value, fileMetaRecord(rec, options.fileMeta)); err != nil {
+				return &outputError{err}
+			}
+		}
+		return nil
+	})
+	if closeErr := w.closeAll(); err == nil && closeErr != nil {
+		err = &outputError{closeErr}
+	}
+	return err
+}
+
+// partitionDirName returns the directory name for records with the given value of column. Empty values are
+// replaced by nullPartition, and any character which is unsafe in file names is escaped as %XX, as Hive does.
+func partitionDirName(column, value string) string {
+	if value == "" {
+		value = nullPartition
+	}
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < ' ' || c == 0x7F || strings.IndexByte(`"#%'*/:=?\[]^{}<>|`, c) >= 0 {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	escaped := sb.String()
+	if escaped == "." || escaped == ".." {
+		escaped = strings.Repeat("%2E", len(escaped))
+	}
+	return column + "=" + escaped
+}
+
+// openPartition is a partition file which is currently open for writing.
+type openPartition struct {
+	value   string
+	file    *os.File
+	buf     *bufio.Writer
+	enc     *json.Encoder
+	element *list.Element
+}
+
+// partitionWriter writes records to the file of each partition, keeping the most recently written files open.
+type partitionWriter struct {
+	opts partitionOptions
+	open map[string]*openPartition
+	// recent orders the values of open partitions from most to least recently written
+	recent *list.List
+	// created holds the values of every partition whose file was created by this writer, which is appended to
+	// rather than replaced when it is reopened
+	created map[string]bool
+}
+
+func newPartitionWriter(opts partitionOptions) *partitionWriter {
+	return &partitionWriter{
+		opts:    opts,
+		open:    make(map[string]*openPartition),
+		recent:  list.New(),
+		created: make(map[string]bool),
+	}
+}
+
+// write writes rec as a line of JSON to the file for the partition with the given value.
+func (w *partitionWriter) write(value string, rec interface{}) error {
+	p, ok := w.open[value]
+	if ok {
+		w.recent.MoveToFront(p.element)
+	} else {
+		var err error
+		if p, err = w.openFile(value); err != nil {
+			return err
+		}
+	}
+	return p.enc.Encode(rec)
+}
+
+// openFile opens the file for the partition with the given value, first closing the least recently written file
+// when the limit is reached. The file is replaced when it is first opened, and appended to thereafter.
+func (w *partitionWriter) openFile(value string) (*openPartition, error) {
+	if len(w.open) >= w.opts.maxOpen {
+		if err := w.close(w.recent.Back().Value.(string)); err != nil {
+			return nil, err
+		}
+	}
+
+	dir := filepath.Join(w.opts.outputDir, partitionDirName(w.opts.column, value))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if w.created[value] {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(filepath.Join(dir, partitionFileName), flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w.created[value] = true
+
+	p := &openPartition{value: value, file: f, buf: bufio.NewWriter(f)}
+	p.enc = json.NewEncoder(p.buf)
+	p.element = w.recent.PushFront(value)
+	w.open[value] = p
+	return p, nil
+}
+
+// close flushes and closes the file for the partition with the given value.
+func (w *partitionWriter) close(value string) error {
+	p := w.open[value]
+	delete(w.open, value)
+	w.recent.Remove(p.element)
+	if err := p.buf.Flush(); err != nil {
+		p.file.Close()
+		return err
+	}
+	return p.file.Close()
+}
+
+// closeAll flushes and closes every open file, returning the first error.
+func (w *partitionWriter) closeAll() error {
+	var firstErr error
+	for value := range w.open {
+		if err := w.close(value); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
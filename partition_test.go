@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// readPartitions returns the contents of every partition file under dir, keyed by its path relative to dir.
+func readPartitions(t *testing.T, dir string) map[string]string {
+	contents := make(map[string]string)
+	require.NoError(t, filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		rel, _ := filepath.Rel(dir, path)
+		contents[filepath.ToSlash(rel)] = string(data)
+		return err
+	}))
+	return contents
+}
+
+func TestCsv2Partitions(t *testing.T) {
+	csvData := "date,n\n2024-06-01,1\n2024-06-02,2\n2024-06-01,3\n,4\n2024-06-03,5\n2024-06-02,6\n2024-06-01,7\n"
+	want := map[string]string{
+		"date=2024-06-01/part.ndjson": "{\"date\":\"2024-06-01\",\"n\":\"1\"}\n{\"date\":\"2024-06-01\",\"n\":\"3\"}\n" +
+			"{\"date\":\"2024-06-01\",\"n\":\"7\"}\n",
+		"date=2024-06-02/part.ndjson": "{\"date\":\"2024-06-02\",\"n\":\"2\"}\n{\"date\":\"2024-06-02\",\"n\":\"6\"}\n",
+		"date=2024-06-03/part.ndjson": "{\"date\":\"2024-06-03\",\"n\":\"5\"}\n",
+		"date=__null__/part.ndjson":   "{\"date\":\"\",\"n\":\"4\"}\n",
+	}
+
+	for _, maxOpen := range []int{1, 2, 64} {
+		t.Run(fmt.Sprintf("At most %d open files", maxOpen), func(t *testing.T) {
+			outputDir := t.TempDir()
+			// A file left by an earlier run should be replaced, not appended to
+			require.NoError(t, os.MkdirAll(filepath.Join(outputDir, "date=2024-06-03"), 0755))
+			require.NoError(t, ioutil.WriteFile(filepath.Join(outputDir, "date=2024-06-03", partitionFileName),
+				[]byte("stale\n"), 0644))
+
+			err := csv2Partitions(conversionOptions{
+				csvInputs: []io.Reader{strings.NewReader(csvData)},
+			}, partitionOptions{column: "date", outputDir: outputDir, maxOpen: maxOpen})
+
+			assert.NoError(t, err)
+			assert.Equal(t, want, readPartitions(t, outputDir), "Contents should not depend on max open files")
+		})
+	}
+}
+
+func TestCsv2PartitionsUnknownColumn(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		csvData  string
+	}{
+		{"With records", "a\n1\n"},
+		{"Without records", "a\n"},
+		{"With only skipped rows", "a\n\"1\n"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			err := csv2Partitions(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(tt.csvData)},
+				skipErrors: true,
+			}, partitionOptions{column: "b", outputDir: t.TempDir(), maxOpen: 1})
+			assert.EqualError(t, err, `unknown partition column "b"`)
+			assert.Equal(t, exitUsage, exitCode(err))
+		})
+	}
+}
+
+func TestPartitionDirName(t *testing.T) {
+	for _, tt := range []struct {
+		value   string
+		wantDir string
+	}{
+		{"emea", "region=emea"},
+		{"", "region=__null__"},
+		{"a/b", "region=a%2Fb"},
+		{`..\x:y`, `region=..%5Cx%3Ay`},
+		{"..", "region=%2E%2E"},
+		{"50%=half", "region=50%25%3Dhalf"},
+		{"São Paulo", "region=São Paulo"},
+		{"tab\there", "region=tab%09here"},
+	} {
+		t.Run(tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.wantDir, partitionDirName("region", tt.value))
+		})
+	}
+}
+
+func TestPartitionWriterOpenLimit(t *testing.T) {
+	w := newPartitionWriter(partitionOptions{column: "k", outputDir: t.TempDir(), maxOpen: 2})
+	recentlyOpen := func() []string {
+		var values []string
+		for e := w.recent.Front(); e != nil; e = e.Next() {
+			values = append(values, e.Value.(string))
+		}
+		return values
+	}
+
+	for _, value := range []string{"a", "b", "a", "c"} {
+		require.NoError(t, w.write(value, record{"k": value}))
+		assert.LessOrEqual(t, len(w.open), 2)
+	}
+	assert.Equal(t, []string{"c", "a"}, recentlyOpen(), "The least recently written file should be closed")
+
+	require.NoError(t, w.write("b", record{"k": "b"}))
+	assert.Equal(t, []string{"b", "c"}, recentlyOpen())
+	require.NoError(t, w.closeAll())
+	assert.Empty(t, w.open)
+
+	contents := readPartitions(t, w.opts.outputDir)
+	names := make([]string, 0, len(contents))
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"k=a/part.ndjson", "k=b/part.ndjson", "k=c/part.ndjson"}, names)
+	assert.Equal(t, "{\"k\":\"b\"}\n{\"k\":\"b\"}\n", contents["k=b/part.ndjson"], "Reopened files should be appended to")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+)
+
+// pluckValues returns the value of field for each record of batch, in order. Records without the field, such as
+// those written back by an exec filter which removed it, are given a nil value, or are omitted when skipMissing is set.
+func pluckValues(batch []record, field string, skipMissing bool) []interface{} {
+	values := make([]interface{}, 0, len(batch))
+	for _, rec := range batch {
+		if value, ok := rec[field]; ok {
+			values = append(values, value)
+		} else if !skipMissing {
+			values = append(values, nil)
+		}
+	}
+	return values
+}
+
+// pluckNestedValues is like pluckValues(), but for records nested by nestValues(), in which path is split on sep to
+// find the value, which may be an object of further nested values.
+func pluckNestedValues(nested []map[string]interface{}, path, sep string, skipMissing bool) []interface{} {
+	keys := strings.Split(path, sep)
+	values := make([]interface{}, 0, len(nested))
+	for _, obj := range nested {
+		var value interface{} = obj
+		ok := true
+		for _, key := range keys {
+			var parent map[string]interface{}
+			switch v := value.(type) {
+			case map[string]interface{}:
+				parent = v
+			case nestedObject:
+				parent = v
+			}
+			if value, ok = parent[key]; !ok {
+				break
+			}
+		}
+		if ok {
+			values = append(values, value)
+		} else if !skipMissing {
+			values = append(values, nil)
+		}
+	}
+	return values
+}
+
+// checkPluckField returns a *usageError unless field is one of fields, or when sep is set, the path of an object
+// in which some of them are nested by nestValues().
+func checkPluckField(field string, fields []string, sep string) error {
+	for _, name := range fields {
+		if name == field || sep != "" && strings.HasPrefix(name, field+sep) {
+			return nil
+		}
+	}
+	return usageErrorf("unknown --pluck column %q", field)
+}
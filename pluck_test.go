@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCsv2JsonPluck(t *testing.T) {
+	csvData := "name,email,address.city,address.zip\nAlice,a@x.com,Paris,75001\nBob,b@y.com,Lima,15001\n"
+	for _, tt := range []struct {
+		name          string
+		pluck         string
+		nestSeparator string
+		batchSize     int
+		wantJson      string
+		wantErr       string
+	}{
+		{"Scalar", "email", "", 0, `["a@x.com","b@y.com"]` + "\n", ""},
+		{"Dotted column name", "address.city", "", 0, `["Paris","Lima"]` + "\n", ""},
+		{"Batches", "name", "", 1, `["Alice"]` + "\n" + `["Bob"]` + "\n", ""},
+		{"Nested path", "address.city", ".", 0, `["Paris","Lima"]` + "\n", ""},
+		{"Nested object", "address", ".", 0,
+			`[{"city":"Paris","zip":"75001"},{"city":"Lima","zip":"15001"}]` + "\n", ""},
+		{"Unknown column", "phone", "", 0, "", `unknown --pluck column "phone"`},
+		{"Path without nesting", "address", "", 0, "", `unknown --pluck column "address"`},
+		{"Unknown nested path", "address.country", ".", 0, "", `unknown --pluck column "address.country"`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := csv2Json(conversionOptions{
+				csvInputs:     []io.Reader{strings.NewReader(csvData)},
+				jsonOutput:    &buf,
+				batchSize:     tt.batchSize,
+				pluck:         tt.pluck,
+				nestSeparator: tt.nestSeparator,
+			})
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, exitUsage, exitCode(err))
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantJson, buf.String())
+		})
+	}
+}
+
+func TestCsv2JsonPluckNdjson(t *testing.T) {
+	var buf bytes.Buffer
+	err := csv2Json(conversionOptions{
+		csvInputs:     []io.Reader{strings.NewReader("id,user.name\n1,ann\n2,bob\n")},
+		jsonOutput:    &buf,
+		ndjson:        true,
+		pluck:         "user",
+		nestSeparator: ".",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"ann"}`+"\n"+`{"name":"bob"}`+"\n", buf.String())
+}
+
+func TestPluckValues(t *testing.T) {
+	batch := []record{{"id": "1"}, {"name": "Bob"}, {"id": ""}}
+	assert.Equal(t, []interface{}{"1", nil, ""}, pluckValues(batch, "id", false),
+		"Missing values should be null")
+	assert.Equal(t, []interface{}{"1", ""}, pluckValues(batch, "id", true),
+		"Missing values should be skipped, but not empty ones")
+	assert.Equal(t, []interface{}{}, pluckValues(batch, "email", true))
+}
+
+func TestPluckNestedValues(t *testing.T) {
+	nested := []map[string]interface{}{
+		{"user": nestedObject{"name": "Ann", "address": nestedObject{"city": "Paris"}}},
+		{"user": nestedObject{"name": "Bob"}},
+		{"user": ""},
+	}
+	assert.Equal(t, []interface{}{"Paris", nil, nil}, pluckNestedValues(nested, "user.address.city", ".", false),
+		"Missing values should be null")
+	assert.Equal(t, []interface{}{"Paris"}, pluckNestedValues(nested, "user.address.city", ".", true),
+		"Missing values should be skipped")
+	assert.Equal(t, []interface{}{nestedObject{"name": "Ann", "address": nestedObject{"city": "Paris"}},
+		nestedObject{"name": "Bob"}, ""}, pluckNestedValues(nested, "user", ".", false))
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultPostBackoff is the delay before the first retry of a failed POST, which doubles after each attempt.
+const defaultPostBackoff = 500 * time.Millisecond
+
+// postOptions is used to configure delivery of converted records to an HTTP endpoint when calling csv2Post().
+type postOptions struct {
+	url       string
+	batchSize int
+	headers   []string
+	retries   int
+	timeout   time.Duration
+	backoff   time.Duration
+	dryRun    bool
+}
+
+// csv2Post converts CSV data from `options.csvInputs` and sends the records to `postOpts.url` via HTTP POST,
+// as JSON arrays of up to `postOpts.batchSize` records. Transient failures (connection errors, 429, and 5xx
+// responses) are retried with exponential backoff. When `postOpts.dryRun` is set, the requests that would be sent
+// are written to `options.jsonOutput` instead. Sending stops with the error of `options.ctx` once it is done.
+// Returns any errors from reading CSV, encoding JSON, or a batch that could not be delivered.
+func csv2Post(options conversionOptions, postOpts postOptions) error {
+	headers, err := parseHeaders(postOpts.headers)
+	if err != nil {
+		return err
+	}
+	if headers.Get("Content-Type") == "" {
+		headers.Set("Content-Type", "application/json")
+	}
+	if postOpts.backoff == 0 {
+		postOpts.backoff = defaultPostBackoff
+	}
+	client := &http.Client{Timeout: postOpts.timeout}
+	ctx := options.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	batchNum := 0
+	return eachBatch(options, postOpts.batchSize, func(batch []record) error {
+		batchNum++
+		body, err := json.Marshal(fileMetaRecords(batch, options.fileMeta))
+		if err != nil {
+			return err
+		}
+
+		if postOpts.dryRun {
+			if err := writeDryRunRequest(options.jsonOutput, postOpts.url, headers, body); err != nil {
+				return &outputError{err}
+			}
+			return nil
+		}
+
+		if err := postWithRetries(ctx, client, postOpts, headers, body); ctx.Err() != nil {
+			return ctx.Err()
+		} else if err != nil {
+			return &outputError{fmt.Errorf("batch %d (%d records) could not be sent: %w", batchNum, len(batch), err)}
+		}
+		return nil
+	})
+}
+
+// postWithRetries sends body to `postOpts.url`, retrying transient failures up to `postOpts.retries` times.
+// Requests and the waits between them stop once ctx is done, returning its error.
+// Returns the error from the final attempt if none succeeded.
+func postWithRetries(ctx context.Context, client *http.Client, postOpts postOptions, headers http.Header,
+	body []byte) error {
+	delay := postOpts.backoff
+	for attempt := 0; ; attempt++ {
+		retryable, err := post(ctx, client, postOpts.url, headers, body)
+		if err == nil || !retryable || attempt >= postOpts.retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post sends a single POST request with ctx and reports whether an unsuccessful attempt is worth retrying.
+func post(ctx context.Context, client *http.Client, url string, headers http.Header,
+	body []byte) (retryable bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header = headers.Clone()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	// Drain the body so that the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retryable = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retryable, fmt.Errorf("%s responded with status %s", url, resp.Status)
+}
+
+// writeDryRunRequest describes the POST request which would be sent, for inspection without sending it.
+func writeDryRunRequest(w io.Writer, url string, headers http.Header, body []byte) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "POST %s\n", url)
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range headers[name] {
+			fmt.Fprintf(&buf, "%s: %s\n", name, v)
+		}
+	}
+	fmt.Fprintf(&buf, "\n%s\n\n", body)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// headerNamePrefix matches the "Name:" which begins a header given as "Name: value", whose name is an HTTP token.
+var headerNamePrefix = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+:")
+
+// parseHeaders parses HTTP headers given as "Name: value".
+// Since comma-separated flag values are split into separate elements, any element which does not begin with a
+// header name and a colon, such as " b:c" split from "Accept: a, b:c", is treated as the continuation of the
+// preceding header's value.
+func parseHeaders(values []string) (http.Header, error) {
+	headers := make(http.Header)
+	lastName := ""
+	for _, v := range values {
+		if !headerNamePrefix.MatchString(v) {
+			if lastName == "" {
+				return nil, usageErrorf("invalid header %q (expected Name: value)", v)
+			}
+			lastValues := headers[http.CanonicalHeaderKey(lastName)]
+			lastValues[len(lastValues)-1] += "," + v
+			continue
+		}
+		parts := strings.SplitN(v, ":", 2)
+		lastName = parts[0]
+		headers.Add(lastName, strings.TrimSpace(parts[1]))
+	}
+
+	return headers, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCsv2Post(t *testing.T) {
+	csvData := "a,b\n1,2\n3,4\n5,6\n"
+
+	for _, tt := range []struct {
+		testName string
+		// statuses are returned for successive requests, after which requests succeed
+		statuses    []int
+		retries     int
+		wantBatches [][]record
+		wantCalls   int
+		wantErr     string
+	}{
+		{
+			"Sends all batches",
+			nil,
+			0,
+			[][]record{{{"a": "1", "b": "2"}, {"a": "3", "b": "4"}}, {{"a": "5", "b": "6"}}},
+			2,
+			"",
+		},
+		{
+			"Retries transient failures then succeeds",
+			[]int{http.StatusServiceUnavailable, http.StatusTooManyRequests},
+			2,
+			[][]record{{{"a": "1", "b": "2"}, {"a": "3", "b": "4"}}, {{"a": "5", "b": "6"}}},
+			4,
+			"",
+		},
+		{
+			"Aborts when retries are exhausted",
+			[]int{http.StatusOK, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			2,
+			[][]record{{{"a": "1", "b": "2"}, {"a": "3", "b": "4"}}},
+			4,
+			"batch 2 (1 records) could not be sent: %s responded with status 502 Bad Gateway",
+		},
+		{
+			"Does not retry permanent failures",
+			[]int{http.StatusUnauthorized},
+			3,
+			[][]record{},
+			1,
+			"batch 1 (2 records) could not be sent: %s responded with status 401 Unauthorized",
+		},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var mu sync.Mutex
+			calls := 0
+			gotBatches := make([][]record, 0)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				calls++
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+				if calls <= len(tt.statuses) && tt.statuses[calls-1] != http.StatusOK {
+					w.WriteHeader(tt.statuses[calls-1])
+					return
+				}
+				var batch []record
+				body, _ := ioutil.ReadAll(r.Body)
+				assert.NoError(t, json.Unmarshal(body, &batch))
+				gotBatches = append(gotBatches, batch)
+			}))
+			t.Cleanup(server.Close)
+
+			err := csv2Post(conversionOptions{
+				csvInputs:  []io.Reader{bytes.NewReader([]byte(csvData))},
+				jsonOutput: bytes.NewBuffer([]byte{}),
+			}, postOptions{
+				url:       server.URL,
+				batchSize: 2,
+				headers:   []string{"Authorization: Bearer secret"},
+				retries:   tt.retries,
+				timeout:   time.Second,
+				backoff:   time.Millisecond,
+			})
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, fmt.Sprintf(tt.wantErr, server.URL))
+			}
+			assert.Equal(t, tt.wantCalls, calls)
+			assert.Equal(t, tt.wantBatches, gotBatches)
+		})
+	}
+}
+
+func TestCsv2PostContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	var mu sync.Mutex
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		cancel()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	err := csv2Post(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader("a\n1\n")},
+		jsonOutput: bytes.NewBuffer([]byte{}),
+		ctx:        ctx,
+	}, postOptions{url: server.URL, batchSize: 1, retries: 3, timeout: time.Second, backoff: time.Hour})
+
+	assert.Equal(t, context.Canceled, err, "Retries should stop without waiting once cancelled")
+	assert.Equal(t, 1, calls)
+}
+
+func TestCsv2PostDryRun(t *testing.T) {
+	out := bytes.NewBuffer([]byte{})
+
+	err := csv2Post(conversionOptions{
+		csvInputs:  []io.Reader{bytes.NewReader([]byte("a\n1\n2\n3\n"))},
+		jsonOutput: out,
+	}, postOptions{
+		url:       "http://example.invalid/ingest",
+		batchSize: 2,
+		headers:   []string{"X-Token: abc"},
+		dryRun:    true,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "POST http://example.invalid/ingest\n"+
+		"Content-Type: application/json\n"+
+		"X-Token: abc\n"+
+		"\n"+
+		`[{"a":"1"},{"a":"2"}]`+"\n\n"+
+		"POST http://example.invalid/ingest\n"+
+		"Content-Type: application/json\n"+
+		"X-Token: abc\n"+
+		"\n"+
+		`[{"a":"3"}]`+"\n\n", out.String())
+}
+
+func TestParseHeaders(t *testing.T) {
+	for _, tt := range []struct {
+		testName    string
+		values      []string
+		wantHeaders http.Header
+		wantErr     bool
+	}{
+		{
+			"Parses headers",
+			[]string{"Authorization: Bearer abc", "x-custom:1"},
+			http.Header{"Authorization": {"Bearer abc"}, "X-Custom": {"1"}},
+			false,
+		},
+		{
+			"Rejoins values split on commas",
+			[]string{"Accept: text/csv", " application/json", "X-A: b"},
+			http.Header{"Accept": {"text/csv, application/json"}, "X-A": {"b"}},
+			false,
+		},
+		{
+			"Rejoins split values which contain colons",
+			[]string{"Link: <http://a>", " rel=next", "X-Time: 12:30", " 13:45"},
+			http.Header{"Link": {"<http://a>, rel=next"}, "X-Time": {"12:30, 13:45"}},
+			false,
+		},
+		{"Missing colon", []string{"Authorization"}, nil, true},
+		{"Missing name", []string{": value"}, nil, true},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			headers, err := parseHeaders(tt.values)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantHeaders, headers)
+			}
+		})
+	}
+}
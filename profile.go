@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/integrii/flaggy"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// configFile is the structure of the YAML file named by --config.
+type configFile struct {
+	// Profiles maps each profile name to its settings, which map flag long names to the values they are set to
+	Profiles map[string]map[string]interface{} `yaml:"profiles"`
+}
+
+// defaultConfigFileName returns the file name from which configuration is read unless --config is given,
+// or an empty string when there is no user configuration directory.
+func defaultConfigFileName() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "csv2json", "config.yaml")
+}
+
+// readConfigFile reads the config file named fileName. A file which does not exist is read as an empty config file,
+// unless it is required.
+func readConfigFile(fileName string, required bool) (configFile, error) {
+	var config configFile
+	if fileName == "" {
+		return config, nil
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return config, nil
+	} else if err != nil {
+		return config, err
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, usageErrorf("invalid config file %s: %v", fileName, err)
+	}
+	return config, nil
+}
+
+// profileFlagKey is the key under which givenFlags() results record that the flag named flagName was set by a profile,
+// in addition to flagName itself.
+func profileFlagKey(flagName string) string {
+	return "profile:" + flagName
+}
+
+// applyProfile sets the flags of scs named by the keys of the named profile's settings to their values, except for
+// flags which are in given, as returned by givenFlags(), since explicit flags take precedence over profiles.
+// Each flag which is set is recorded in given, both by name and by profileFlagKey().
+func applyProfile(name string, settings map[string]interface{}, given map[string]bool,
+	scs ...*flaggy.Subcommand) error {
+	for key, value := range settings {
+		if key == "profile" || key == "config" {
+			return usageErrorf("profile %q cannot set --%s", name, key)
+		}
+		f := findFlag(key, scs...)
+		if f == nil {
+			return usageErrorf("profile %q sets unknown flag --%s", name, key)
+		} else if given[f.LongName] || (f.ShortName != "" && given[f.ShortName]) {
+			continue
+		}
+
+		if err := setFlagValue(f, value); err != nil {
+			return usageErrorf("profile %q sets --%s to %v: %v", name, key, value, err)
+		}
+		for _, flagName := range []string{f.LongName, f.ShortName} {
+			if flagName != "" {
+				given[flagName] = true
+				given[profileFlagKey(flagName)] = true
+			}
+		}
+	}
+	return nil
+}
+
+// findFlag returns the flag of scs with the given long name, or nil when there is none.
+func findFlag(longName string, scs ...*flaggy.Subcommand) *flaggy.Flag {
+	for _, sc := range scs {
+		for _, f := range sc.Flags {
+			if f.LongName == longName {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// setFlagValue assigns value, as decoded from YAML, to the variable of f.
+func setFlagValue(f *flaggy.Flag, value interface{}) error {
+	switch v := f.AssignmentVar.(type) {
+	case *string:
+		s, ok := value.(string)
+		if !ok {
+			return errors.New("expected a string")
+		}
+		*v = s
+	case *bool:
+		b, ok := value.(bool)
+		if !ok {
+			return errors.New("expected true or false")
+		}
+		*v = b
+	case *int:
+		n, ok := value.(int)
+		if !ok {
+			return errors.New("expected an integer")
+		}
+		*v = n
+	case *time.Duration:
+		s, ok := value.(string)
+		if !ok {
+			return errors.New("expected a duration such as 30s")
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*v = d
+	case *[]string:
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		*v = make([]string, len(values))
+		for i, value := range values {
+			s, ok := value.(string)
+			if !ok {
+				return errors.New("expected a string or list of strings")
+			}
+			(*v)[i] = s
+		}
+	default:
+		return fmt.Errorf("flag cannot be set by a profile")
+	}
+	return nil
+}
+
+// profileNames returns the names of profiles, sorted.
+func profileNames(profiles map[string]map[string]interface{}) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// listProfiles writes a table of each of profiles and its settings.
+func listProfiles(w io.Writer, profiles map[string]map[string]interface{}) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROFILE\tSETTINGS")
+	for _, name := range profileNames(profiles) {
+		settings := make([]string, 0, len(profiles[name]))
+		for key, value := range profiles[name] {
+			settings = append(settings, fmt.Sprintf("--%s=%v", key, value))
+		}
+		sort.Strings(settings)
+		fmt.Fprintf(tw, "%s\t%s\n", name, strings.Join(settings, " "))
+	}
+	return tw.Flush()
+}
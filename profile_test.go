@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/integrii/flaggy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `profiles:
+  salesforce:
+    delimiter: ";"
+    skip-errors: true
+    force-columns: [id, name]
+  excel:
+    dialect: excel-tab
+    max-cell-length: 3
+`
+
+// writeTestConfig writes testConfig to a temporary file, returning its name.
+func writeTestConfig(t *testing.T) string {
+	configFileName := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, ioutil.WriteFile(configFileName, []byte(testConfig), 0644),
+		"Test cannot run without a config file")
+	return configFileName
+}
+
+// runTestCli runs the CLI with args, returning what it writes to stdout. Stdout is read while the CLI runs, so that
+// it is not blocked by output which would fill the pipe.
+func runTestCli(t *testing.T, args ...string) (string, error) {
+	os.Args = append([]string{"csv2json"}, args...)
+	flaggy.ResetParser()
+
+	oldStdout := os.Stdout
+	stdoutReader, stdoutWriter, _ := os.Pipe()
+	os.Stdout = stdoutWriter
+	defer func() {
+		os.Stdout = oldStdout
+	}()
+	var buf bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(&buf, stdoutReader)
+		close(copied)
+	}()
+
+	err := runCli()
+	stdoutWriter.Close()
+	<-copied
+	return buf.String(), err
+}
+
+// discardLogs discards anything logged until the test finishes.
+func discardLogs(t *testing.T) {
+	oldLogOutput := log.Writer()
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() { log.SetOutput(oldLogOutput) })
+}
+
+func TestRunTestCliLargeOutput(t *testing.T) {
+	csvFileName := filepath.Join(t.TempDir(), "input.csv")
+	require.NoError(t, ioutil.WriteFile(csvFileName, bytes.Repeat([]byte("abcdefghij\n"), 20000), 0644),
+		"Test cannot run without CSV")
+
+	stdout, err := runTestCli(t, "-c", "letters", "--format", "ndjson", csvFileName)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 20000, strings.Count(stdout, "\n"), "All output beyond the pipe's buffer should be read")
+}
+
+func TestReadConfigFile(t *testing.T) {
+	config, err := readConfigFile(writeTestConfig(t), true)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]interface{}{
+		"salesforce": {"delimiter": ";", "skip-errors": true, "force-columns": []interface{}{"id", "name"}},
+		"excel":      {"dialect": "excel-tab", "max-cell-length": 3},
+	}, config.Profiles)
+
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+	config, err = readConfigFile(missing, false)
+	assert.NoError(t, err, "A default config file which does not exist should be ignored")
+	assert.Empty(t, config.Profiles)
+	_, err = readConfigFile(missing, true)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestCliProfile(t *testing.T) {
+	configFileName := writeTestConfig(t)
+	csvFileName := filepath.Join(t.TempDir(), "input.csv")
+	require.NoError(t, ioutil.WriteFile(csvFileName, []byte("1;ann\n2;bob;x\n3;cy\n"), 0644),
+		"Test cannot run without CSV")
+
+	for _, tt := range []struct {
+		testName    string
+		args        []string
+		wantJsonOut string
+	}{
+		{
+			"Applies profile",
+			[]string{"--config", configFileName, "--profile", "salesforce", csvFileName},
+			`[{"id": "1", "name": "ann"}, {"id": "3", "name": "cy"}]`,
+		},
+		{
+			"Explicit flags take precedence over profile",
+			[]string{"--config", configFileName, "--profile", "salesforce", "-c", "a,b", csvFileName},
+			`[{"a": "1", "b": "ann"}, {"a": "3", "b": "cy"}]`,
+		},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			discardLogs(t)
+
+			stdout, err := runTestCli(t, tt.args...)
+
+			assert.ErrorIs(t, err, skippedRowsError{1})
+			assert.JSONEq(t, tt.wantJsonOut, stdout)
+		})
+	}
+}
+
+func TestCliProfileExplained(t *testing.T) {
+	oldStderr := os.Stderr
+	stderrReader, stderrWriter, _ := os.Pipe()
+	os.Stderr = stderrWriter
+	t.Cleanup(func() { os.Stderr = oldStderr })
+
+	_, err := runTestCli(t, "--config", writeTestConfig(t), "--profile", "excel", "--trim-leading-space",
+		"--explain-only")
+	stderrWriter.Close()
+	var explained bytes.Buffer
+	io.Copy(&explained, stderrReader)
+
+	assert.NoError(t, err)
+	var e explanation
+	require.NoError(t, json.Unmarshal(explained.Bytes(), &e))
+	assert.Equal(t, explainedOption{"excel", sourceFlag}, e["profile"])
+	assert.Equal(t, explainedOption{"excel-tab", sourceProfile}, e["dialect"])
+	assert.Equal(t, explainedOption{"\t", sourceDialect}, e["delimiter"])
+	assert.Equal(t, explainedOption{3.0, sourceProfile}, e["max_cell_length"])
+	assert.Equal(t, explainedOption{true, sourceFlag}, e["trim_leading_space"])
+}
+
+func TestCliUnknownProfile(t *testing.T) {
+	_, err := runTestCli(t, "--config", writeTestConfig(t), "--profile", "sap", os.DevNull)
+	assert.EqualError(t, err, `unknown profile "sap" (expected one of excel, salesforce)`)
+	assert.Equal(t, exitUsage, exitCode(err))
+}
+
+func TestCliProfilesSubcommand(t *testing.T) {
+	stdout, err := runTestCli(t, "profiles", "--config", writeTestConfig(t))
+	assert.NoError(t, err)
+	assert.Equal(t, `PROFILE     SETTINGS
+excel       --dialect=excel-tab --max-cell-length=3
+salesforce  --delimiter=; --force-columns=[id name] --skip-errors=true
+`, stdout)
+}
+
+func TestApplyProfileInvalidValue(t *testing.T) {
+	var n int
+	sc := flaggy.NewSubcommand("test")
+	sc.Int(&n, "", "batch", "")
+
+	err := applyProfile("p", map[string]interface{}{"batch": "many"}, map[string]bool{}, sc)
+	assert.EqualError(t, err, `profile "p" sets --batch to many: expected an integer`)
+	err = applyProfile("p", map[string]interface{}{"batches": 2}, map[string]bool{}, sc)
+	assert.EqualError(t, err, `profile "p" sets unknown flag --batches`)
+}
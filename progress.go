@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// defaultProgressRows and defaultProgressInterval are how often --progress logs progress by default.
+const (
+	defaultProgressRows     = 100000
+	defaultProgressInterval = 5 * time.Second
+)
+
+// progressLog periodically logs how far a conversion has got: once every rows data rows, and once every interval
+// in case rows are slow to arrive. Its counts are updated as rows are read, while a goroutine started by start()
+// logs on each interval until stop() is called.
+type progressLog struct {
+	rows     int64
+	interval time.Duration
+	// totalBytes is the combined size of the inputs, when each is a regular file, or else 0
+	totalBytes int64
+	// rowsRead, rowsSkipped, and bytesRead are updated atomically, since they are logged by another goroutine
+	rowsRead    int64
+	rowsSkipped int64
+	bytesRead   int64
+	started     time.Time
+	done        chan struct{}
+	stopped     chan struct{}
+}
+
+// newProgressLog returns a progressLog for reading inputs, logging every rows data rows and every interval, either of
+// which may be 0 to log only on the other.
+func newProgressLog(inputs []io.Reader, rows int64, interval time.Duration) *progressLog {
+	p := &progressLog{rows: rows, interval: interval}
+	for _, input := range inputs {
+		f, ok := input.(*os.File)
+		if !ok || f == os.Stdin {
+			return p
+		}
+		info, err := f.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return p
+		}
+		p.totalBytes += info.Size()
+	}
+	return p
+}
+
+// start starts logging on each interval, until stop() is called.
+func (p *progressLog) start() {
+	p.started = time.Now()
+	p.done, p.stopped = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(p.stopped)
+		if p.interval <= 0 {
+			<-p.done
+			return
+		}
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.log()
+			case <-p.done:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops logging on each interval, waiting until it has stopped, and logs the final progress.
+func (p *progressLog) stop() {
+	close(p.done)
+	<-p.stopped
+	p.log()
+}
+
+// rowRead counts a data row which was read, logging progress every p.rows rows.
+func (p *progressLog) rowRead() {
+	if n := atomic.AddInt64(&p.rowsRead, 1); p.rows > 0 && n%p.rows == 0 {
+		p.log()
+	}
+}
+
+// rowSkipped counts a data row which was read and then skipped due to an error.
+func (p *progressLog) rowSkipped() {
+	atomic.AddInt64(&p.rowsSkipped, 1)
+}
+
+// reader returns r, counting the bytes read from it.
+func (p *progressLog) reader(r io.Reader) io.Reader {
+	return &progressReader{r, &p.bytesRead}
+}
+
+// log logs the current progress, as a percentage of totalBytes when it is known.
+func (p *progressLog) log() {
+	rowsRead, rowsSkipped := atomic.LoadInt64(&p.rowsRead), atomic.LoadInt64(&p.rowsSkipped)
+	bytesRead := atomic.LoadInt64(&p.bytesRead)
+	elapsed := time.Since(p.started).Round(time.Millisecond)
+	if logStructured() {
+		keyvals := []interface{}{"rows_read", rowsRead, "rows_skipped", rowsSkipped, "bytes_read", bytesRead}
+		if p.totalBytes > 0 {
+			keyvals = append(keyvals, "total_bytes", p.totalBytes)
+		}
+		logEvent("progress", append(keyvals, "elapsed", elapsed.String())...)
+	} else if p.totalBytes > 0 {
+		log.Printf("Read %d rows (%d skipped) and %d of %d bytes (%.1f%%) in %s", rowsRead, rowsSkipped, bytesRead,
+			p.totalBytes, 100*float64(bytesRead)/float64(p.totalBytes), elapsed)
+	} else {
+		log.Printf("Read %d rows (%d skipped) and %d bytes in %s", rowsRead, rowsSkipped, bytesRead, elapsed)
+	}
+}
+
+// progressReader adds the number of bytes read from an io.Reader to a count, atomically.
+type progressReader struct {
+	r     io.Reader
+	count *int64
+}
+
+func (c *progressReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.count, int64(n))
+	return n, err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCsv2JsonProgress(t *testing.T) {
+	oldLogOutput, oldFlags := log.Writer(), log.Flags()
+	logOutput := bytes.NewBuffer([]byte{})
+	log.SetOutput(logOutput)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(oldLogOutput)
+		log.SetFlags(oldFlags)
+	})
+
+	csvData := "a,b\n1,2\n3\n4,5\n6,7\n"
+	fileName := filepath.Join(t.TempDir(), "data.csv")
+	require.NoError(t, ioutil.WriteFile(fileName, []byte(csvData), 0644))
+	f, err := os.Open(fileName)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		f.Close()
+	})
+
+	err = csv2Json(conversionOptions{
+		csvInputs:    []io.Reader{f},
+		jsonOutput:   ioutil.Discard,
+		skipErrors:   true,
+		progress:     true,
+		progressRows: 2,
+	})
+	assert.NoError(t, err)
+	var progressLines []string
+	for _, line := range strings.Split(logOutput.String(), "\n") {
+		if strings.HasPrefix(line, "Read ") {
+			progressLines = append(progressLines, line[:strings.LastIndex(line, " in ")])
+		}
+	}
+	assert.Equal(t, []string{
+		"Read 2 rows (0 skipped) and 18 of 18 bytes (100.0%)",
+		"Read 4 rows (1 skipped) and 18 of 18 bytes (100.0%)",
+		"Read 4 rows (1 skipped) and 18 of 18 bytes (100.0%)",
+	}, progressLines, "Progress should be logged every 2 rows, and once finished")
+
+	logOutput.Reset()
+	err = csv2Json(conversionOptions{
+		csvInputs:    []io.Reader{strings.NewReader(csvData)},
+		jsonOutput:   ioutil.Discard,
+		skipErrors:   true,
+		progress:     true,
+		progressRows: 0,
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, logOutput.String(), "Read 4 rows (1 skipped) and 18 bytes in ",
+		"Inputs which are not files have no percentage")
+}
+
+func TestCsv2JsonProgressInterval(t *testing.T) {
+	oldLogOutput := log.Writer()
+	logOutput := bytes.NewBuffer([]byte{})
+	log.SetOutput(logOutput)
+	t.Cleanup(func() {
+		log.SetOutput(oldLogOutput)
+	})
+
+	// Logged on each interval while waiting for more input
+	pr, pw := io.Pipe()
+	go func() {
+		io.WriteString(pw, strings.Repeat("a\n", sniffLength))
+		time.Sleep(50 * time.Millisecond)
+		io.WriteString(pw, "1\n")
+		pw.Close()
+	}()
+	err := csv2Json(conversionOptions{
+		csvInputs:        []io.Reader{pr},
+		jsonOutput:       ioutil.Discard,
+		progress:         true,
+		progressInterval: 5 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	numLogged := strings.Count(logOutput.String(), "Read ")
+	assert.Greater(t, numLogged, 2, "Progress should be logged on each interval")
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, numLogged, strings.Count(logOutput.String(), "Read "),
+		"Progress should not be logged once conversion has finished")
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// emptyRowPolicies are the policies accepted by --keep-empty-rows for data rows whose every field is empty after
+// trimming whitespace: emitting them like any other row, skipping them, or treating them as errors.
+var emptyRowPolicies = []string{"emit", "skip", "error"}
+
+// defaultEmptyRowPolicy is the policy which applies when none is chosen.
+const defaultEmptyRowPolicy = "emit"
+
+// errEmptyRow reports a data row whose every field is empty, with the "error" policy of emptyRowPolicies.
+var errEmptyRow = errors.New("every field is empty")
+
+// checkEmptyRowPolicy returns a *usageError unless policy is empty or one of emptyRowPolicies.
+func checkEmptyRowPolicy(policy string) error {
+	if policy == "" || containsString(emptyRowPolicies, policy) {
+		return nil
+	}
+	return usageErrorf("unknown --keep-empty-rows policy %q (expected one of %s)", policy,
+		strings.Join(emptyRowPolicies, ", "))
+}
+
+// isEmptyRow reports whether every one of rowFields is empty or whitespace.
+func isEmptyRow(rowFields []string) bool {
+	for _, field := range rowFields {
+		if strings.TrimFunc(field, unicode.IsSpace) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// fitFields checks that the data row most recently read has a field for each column, unless it is allowed to vary
+// by `conversionOptions.padShortRows` or `conversionOptions.keepExtraFields`. Short rows are padded with empty
+// fields, while the extra fields of long rows are kept for record() to name with extraFieldName().
+// Returns a *csv.ParseError like csv.Reader does for any other row with the wrong number of fields.
+func (r *csvRowReader) fitFields(rowFields []string) ([]string, error) {
+	numCols := len(r.colNames)
+	r.padFrom = len(rowFields)
+	switch {
+	case len(rowFields) < numCols && r.padShortRows:
+		r.numPadded++
+		if r.stats != nil {
+			r.stats.rowsPadded++
+		}
+		return append(rowFields, make([]string, numCols-len(rowFields))...), nil
+	case len(rowFields) > numCols && r.dropExtraFields:
+		return rowFields[:numCols], nil
+	case len(rowFields) > numCols && r.keepExtraFields:
+		r.numExtended++
+		if r.stats != nil {
+			r.stats.rowsExtended++
+		}
+		return rowFields, nil
+	case len(rowFields) != numCols:
+		return rowFields, &csv.ParseError{StartLine: r.lastLine, Line: r.lastLine, Column: 1, Err: csv.ErrFieldCount}
+	}
+	return rowFields, nil
+}
+
+// fillPaddedNulls sets the field of collapsed for each of colNames to nil when it is missing from rec, the record it
+// was collapsed from, since fullRecord() leaves out the fields padded by fitFields() when `padNulls` is set.
+// Omitted columns, and duplicate columns which were collapsed into an array, are left out.
+func (r *csvRowReader) fillPaddedNulls(rec record, collapsed map[string]interface{}, colNames []string) {
+	collapsedNames := make(map[string]bool)
+	for _, names := range r.arrayFields {
+		for _, name := range names {
+			collapsedNames[name] = true
+		}
+	}
+	for _, name := range colNames {
+		if _, ok := rec[name]; !ok && !r.omitted[name] && !collapsedNames[name] {
+			collapsed[name] = nil
+		}
+	}
+}
+
+// extraFieldName is the name of the nth field beyond the last column of a row, counting from 1.
+func extraFieldName(n int) string {
+	return extraArrayFieldName(defaultExtraFieldName, n)
+}
+
+// extraFieldPolicies are the policies accepted by --extra-fields for the fields of data rows beyond the last column:
+// collecting them into an array, dropping them, or treating such rows as errors.
+var extraFieldPolicies = []string{"keep", "drop", "error"}
+
+// defaultExtraFieldName is the array into which the "keep" policy of extraFieldPolicies collects extra fields, unless
+// --extra-field-name names another.
+const defaultExtraFieldName = "_extra"
+
+// checkExtraFieldPolicy returns a *usageError unless policy is empty or one of extraFieldPolicies.
+func checkExtraFieldPolicy(policy string) error {
+	if policy == "" || containsString(extraFieldPolicies, policy) {
+		return nil
+	}
+	return usageErrorf("unknown --extra-fields policy %q (expected one of %s)", policy,
+		strings.Join(extraFieldPolicies, ", "))
+}
+
+// checkExtraArray returns a *usageError when the array named name, into which the "keep" policy of
+// extraFieldPolicies collects extra fields, or one of the fields it is collected from, would have the same name as
+// one of colNames.
+func checkExtraArray(name string, colNames []string) error {
+	if name == "" {
+		return nil
+	}
+	for _, colName := range colNames {
+		n, err := strconv.Atoi(strings.TrimPrefix(colName, name+"_"))
+		if colName == name || (strings.HasPrefix(colName, name+"_") && err == nil && n > 0) {
+			return usageErrorf("--extra-fields keep cannot add %s, which is already a column (use "+
+				"--extra-field-name to name another)", colName)
+		}
+	}
+	return nil
+}
+
+// extraArrayFieldName is the name of the record field holding the nth extra field of a row, counting from 1, before
+// collapseExtraFields() collects it into the array named name.
+func extraArrayFieldName(name string, n int) string {
+	return fmt.Sprintf("%s_%d", name, n)
+}
+
+// collapseExtraFields replaces the extra fields of rec, as named by extraArrayFieldName(), with a single array of
+// their values named name. Records without extra fields are left as they are.
+func collapseExtraFields(rec map[string]interface{}, name string) map[string]interface{} {
+	var values []string
+	for n := 1; ; n++ {
+		fieldName := extraArrayFieldName(name, n)
+		value, ok := rec[fieldName].(string)
+		if !ok {
+			break
+		}
+		values = append(values, value)
+		delete(rec, fieldName)
+	}
+	if len(values) > 0 {
+		rec[name] = values
+	}
+	return rec
+}
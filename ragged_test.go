@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCheckExtraFieldPolicy(t *testing.T) {
+	for _, policy := range append([]string{""}, extraFieldPolicies...) {
+		assert.NoError(t, checkExtraFieldPolicy(policy))
+	}
+	err := checkExtraFieldPolicy("ignore")
+	assert.EqualError(t, err, `unknown --extra-fields policy "ignore" (expected one of keep, drop, error)`)
+	assert.Equal(t, exitUsage, exitCode(err))
+}
+
+func TestCsv2JsonExtraFields(t *testing.T) {
+	discardLogs(t)
+	csvData := "id,name\n1,ann\n2,bob,likes tea,and cake\n3\n4,cy,\n"
+
+	for _, tt := range []struct {
+		testName string
+		options  conversionOptions
+		wantJson string
+		wantErr  string
+	}{
+		{"Keep", conversionOptions{extraFields: "keep", padShortRows: true},
+			`[{"id":"1","name":"ann"},{"id":"2","name":"bob","_extra":["likes tea","and cake"]},` +
+				`{"id":"3","name":""},{"id":"4","name":"cy","_extra":[""]}]`, ""},
+		{"Keep NDJSON", conversionOptions{extraFields: "keep", padShortRows: true, ndjson: true},
+			`{"id":"1","name":"ann"}` + "\n" + `{"_extra":["likes tea","and cake"],"id":"2","name":"bob"}` + "\n" +
+				`{"id":"3","name":""}` + "\n" + `{"_extra":[""],"id":"4","name":"cy"}` + "\n", ""},
+		{"Keep named", conversionOptions{extraFields: "keep", extraFieldName: "notes", skipErrors: true},
+			`[{"id":"1","name":"ann"},{"id":"2","name":"bob","notes":["likes tea","and cake"]},` +
+				`{"id":"4","name":"cy","notes":[""]}]`, ""},
+		{"Keep typed", conversionOptions{extraFields: "keep", inferTypes: true, skipErrors: true},
+			`[{"id":1,"name":"ann"},{"id":2,"name":"bob","_extra":["likes tea","and cake"]},` +
+				`{"id":4,"name":"cy","_extra":[null]}]`, ""},
+		{"Drop", conversionOptions{extraFields: "drop", padShortRows: true},
+			`[{"id":"1","name":"ann"},{"id":"2","name":"bob"},{"id":"3","name":""},{"id":"4","name":"cy"}]`, ""},
+		{"Drop skips short rows", conversionOptions{extraFields: "drop", skipErrors: true},
+			`[{"id":"1","name":"ann"},{"id":"2","name":"bob"},{"id":"4","name":"cy"}]`, ""},
+		{"Error", conversionOptions{extraFields: "error"}, "", "record on line 3: wrong number of fields"},
+		{"Error skips long rows", conversionOptions{extraFields: "error", skipErrors: true},
+			`[{"id":"1","name":"ann"}]`, ""},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+			tt.options.csvInputs = []io.Reader{strings.NewReader(csvData)}
+			tt.options.jsonOutput = jsonStream
+			err := csv2Json(tt.options)
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			if tt.options.ndjson {
+				assert.Equal(t, tt.wantJson, jsonStream.String())
+			} else {
+				assert.JSONEq(t, tt.wantJson, jsonStream.String())
+			}
+		})
+	}
+}
+
+func TestCsv2JsonPadShortRows(t *testing.T) {
+	discardLogs(t)
+	csvData := "id,name,age\n1,ann,30\n2,bob\n\n3\n4,cy,x,y\n5,\"dee\n"
+
+	for _, tt := range []struct {
+		testName string
+		options  conversionOptions
+		wantJson string
+	}{
+		{"Empty", conversionOptions{},
+			`[{"id":"1","name":"ann","age":"30"},{"id":"2","name":"bob","age":""},{"id":"3","name":"","age":""}]`},
+		{"Inferred", conversionOptions{inferTypes: true},
+			`[{"id":1,"name":"ann","age":30},{"id":2,"name":"bob","age":null},{"id":3,"name":null,"age":null}]`},
+		{"Typed", conversionOptions{columnTypes: map[string]string{"age": "int"}},
+			`[{"id":"1","name":"ann","age":30},{"id":"2","name":"bob","age":null},{"id":"3","name":null,"age":null}]`},
+		{"Selected", conversionOptions{inferTypes: true, selectColumns: []string{"id", "age"}},
+			`[{"id":1,"age":30},{"id":2,"age":null},{"id":3,"age":null}]`},
+		{"Plucked", conversionOptions{inferTypes: true, pluck: "age"}, `[30,null,null]`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+			tt.options.csvInputs = []io.Reader{strings.NewReader(csvData)}
+			tt.options.jsonOutput = jsonStream
+			tt.options.padShortRows = true
+			tt.options.skipErrors = true
+			assert.NoError(t, csv2Json(tt.options))
+			assert.JSONEq(t, tt.wantJson, jsonStream.String(),
+				"Rows one or more fields short should be padded, while the empty line is not a row, and the long and "+
+					"unparseable rows are skipped")
+		})
+	}
+}
+
+func TestCsv2JsonExtraFieldsCollision(t *testing.T) {
+	for _, tt := range []struct {
+		header  string
+		name    string
+		wantErr string
+	}{
+		{"id,_extra", "", "--extra-fields keep cannot add _extra, which is already a column (use " +
+			"--extra-field-name to name another)"},
+		{"id,notes_2", "notes", "--extra-fields keep cannot add notes_2, which is already a column (use " +
+			"--extra-field-name to name another)"},
+		{"id,_extra_x", "", ""},
+		{"id,notes", "", ""},
+	} {
+		t.Run(tt.header, func(t *testing.T) {
+			err := csv2Json(conversionOptions{
+				csvInputs:      []io.Reader{strings.NewReader(tt.header + "\n1,2,3\n")},
+				jsonOutput:     ioutil.Discard,
+				extraFields:    "keep",
+				extraFieldName: tt.name,
+			})
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, exitUsage, exitCode(err))
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// rawRecorder retains the raw bytes read through it, so that the text of each CSV record can be recovered after
+// it is parsed. The bufio.Reader which consumes from the recorder must be set as buffered, since the bytes it has
+// read ahead do not yet belong to a record.
+type rawRecorder struct {
+	r        io.Reader
+	buffered *bufio.Reader
+	// pending holds bytes which have been read but not yet taken, the first of which is on the given line
+	pending []byte
+	line    int
+}
+
+func newRawRecorder(r io.Reader) *rawRecorder {
+	return &rawRecorder{r: r, line: 1}
+}
+
+func (rec *rawRecorder) Read(p []byte) (int, error) {
+	n, err := rec.r.Read(p)
+	rec.pending = append(rec.pending, p[:n]...)
+	return n, err
+}
+
+// take returns the text consumed from buffered since the last call, without its line ending, along with the line
+// number on which it starts. Preceding blank lines are excluded, since csv.Reader skips them.
+func (rec *rawRecorder) take() (string, int) {
+	n := len(rec.pending) - rec.buffered.Buffered()
+	text := rec.pending[:n]
+	line := rec.line
+	rec.line += bytes.Count(text, []byte("\n"))
+	for len(text) > 0 && (text[0] == '\n' || text[0] == '\r') {
+		if text[0] == '\n' {
+			line++
+		}
+		text = text[1:]
+	}
+
+	s := string(bytes.TrimRight(text, "\r\n"))
+	rec.pending = append(rec.pending[:0], rec.pending[n:]...)
+	return s, line
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// rejectedRow is written by a rejectFile for each skipped data row, as a line of JSON.
+type rejectedRow struct {
+	// File is set when there is more than one input
+	File  string `json:"file,omitempty"`
+	Line  int    `json:"line"`
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+	Raw   string `json:"raw"`
+}
+
+// rejectFile writes the rows which --reject-file was given to, as NDJSON, so that they can be repaired and converted
+// again. The file is only created once the first row is written, so that runs without skipped rows leave none.
+type rejectFile struct {
+	fileName string
+	file     *os.File
+	enc      *json.Encoder
+	// count is the number of rows written
+	count int
+}
+
+// write writes row to the file, creating it first if this is the first row.
+// Returns an *outputError if the file cannot be created or written.
+func (f *rejectFile) write(row rejectedRow) error {
+	if f.file == nil {
+		file, err := os.Create(f.fileName)
+		if err != nil {
+			return &outputError{err}
+		}
+		f.file = file
+		f.enc = json.NewEncoder(file)
+	}
+	if err := f.enc.Encode(row); err != nil {
+		return &outputError{err}
+	}
+	f.count++
+	return nil
+}
+
+// close closes the file, if it was created. Returns an *outputError if it cannot be closed.
+func (f *rejectFile) close() error {
+	if f.file == nil {
+		return nil
+	}
+	if err := f.file.Close(); err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"github.com/integrii/flaggy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCsv2JsonRejectFile(t *testing.T) {
+	oldLogOutput := log.Writer()
+	logOutput := bytes.NewBuffer([]byte{})
+	log.SetOutput(logOutput)
+	t.Cleanup(func() {
+		log.SetOutput(oldLogOutput)
+	})
+
+	for _, tt := range []struct {
+		testName    string
+		csvData     []string
+		wantRejects string
+	}{
+		{"No rejects", []string{"a,b\n1,2\n"}, ""},
+		{"Rejects", []string{"a,b\n1,2\n3\n\"x\ny\",4,5\n6,7\n"},
+			`{"line":3,"row":2,"error":"record on line 3: wrong number of fields","raw":"3"}` + "\n" +
+				`{"line":4,"row":3,"error":"record on line 4: wrong number of fields","raw":"\"x\ny\",4,5"}` + "\n"},
+		{"Rejects from multiple inputs", []string{"a,b\n1,2\n", "a,b\n3,4\n5\n"},
+			`{"file":"input 2","line":3,"row":3,"error":"record on line 3: wrong number of fields","raw":"5"}` + "\n"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "rejects.ndjson")
+			rejects := &rejectFile{fileName: fileName}
+			var csvInputs []io.Reader
+			for _, data := range tt.csvData {
+				csvInputs = append(csvInputs, strings.NewReader(data))
+			}
+			logOutput.Reset()
+
+			err := csv2Json(conversionOptions{
+				csvInputs:  csvInputs,
+				jsonOutput: ioutil.Discard,
+				skipErrors: true,
+				rejects:    rejects,
+			})
+			assert.NoError(t, err)
+			require.NoError(t, rejects.close())
+
+			got, err := ioutil.ReadFile(fileName)
+			if tt.wantRejects == "" {
+				assert.True(t, os.IsNotExist(err), "The reject file should only be created once a row is rejected")
+				assert.NotContains(t, logOutput.String(), fileName)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantRejects, string(got))
+			assert.Contains(t, logOutput.String(), "skipped rows to "+fileName)
+		})
+	}
+}
+
+func TestCliRejectFileRequiresSkipping(t *testing.T) {
+	os.Args = []string{"csv2json", "--reject-file", filepath.Join(t.TempDir(), "rejects.ndjson"), os.DevNull}
+	flaggy.ResetParser()
+
+	err := runCli()
+	assert.EqualError(t, err, "--reject-file can only be used with --skip-errors or --on-error")
+	assert.Equal(t, exitUsage, exitCode(err))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+)
+
+// parseRenames parses --rename entries each given as old=new into the new name of each column.
+// Returns a *usageError for malformed entries, or columns given more than once.
+func parseRenames(specs []string) (map[string]string, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	renames := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		i := strings.Index(spec, "=")
+		if i <= 0 || i == len(spec)-1 {
+			return nil, usageErrorf("invalid --rename entry %q (expected old=new)", spec)
+		}
+		column := spec[:i]
+		if _, ok := renames[column]; ok {
+			return nil, usageErrorf("column %q is given more than once by --rename", column)
+		}
+		renames[column] = spec[i+1:]
+	}
+	return renames, nil
+}
+
+// renameColumns returns a copy of colNames with each column of renames given its new name. When logMissing is set,
+// any columns of renames which are not among colNames are logged by logRenameMissing(), with source describing
+// where colNames came from.
+// Returns a *usageError when two different columns would have the same name, since the value of one would replace
+// the other's.
+func renameColumns(colNames []string, renames map[string]string, source string, logMissing bool) ([]string,
+	error) {
+	renamed := make([]string, len(colNames))
+	renamedFrom := make(map[string]string, len(colNames))
+	for i, name := range colNames {
+		renamed[i] = name
+		if newName, ok := renames[name]; ok {
+			renamed[i] = newName
+		}
+		if other, ok := renamedFrom[renamed[i]]; ok && other != name {
+			return nil, usageErrorf("--rename gives columns %q and %q the same name %q", other, name, renamed[i])
+		}
+		renamedFrom[renamed[i]] = name
+	}
+	if logMissing {
+		var missing []string
+		for column := range renames {
+			if !containsString(colNames, column) {
+				missing = append(missing, column)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			logRenameMissing(source, missing)
+		}
+	}
+	return renamed, nil
+}
+
+// logRenameMissing logs the columns given by --rename which are not among the columns of source.
+func logRenameMissing(source string, columns []string) {
+	if logStructured() {
+		logEvent("rename columns missing", "file", source, "columns", strings.Join(columns, ","))
+	} else {
+		log.Printf("Columns %s given by --rename are not among the columns of %s", strings.Join(quoteAll(columns), ", "),
+			source)
+	}
+}
+
+// formatRenames formats renames as the --rename entries which give them, sorted by column.
+func formatRenames(renames map[string]string) string {
+	columns := make([]string, 0, len(renames))
+	for column := range renames {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	entries := make([]string, len(columns))
+	for i, column := range columns {
+		entries[i] = column + "=" + renames[column]
+	}
+	return strings.Join(entries, ",")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestParseRenames(t *testing.T) {
+	for _, tt := range []struct {
+		testName    string
+		specs       []string
+		wantRenames map[string]string
+		wantErr     string
+	}{
+		{"None", nil, nil, ""},
+		{"Several", []string{"CUST_NM=customerName", "ACCT_BAL=accountBalance"},
+			map[string]string{"CUST_NM": "customerName", "ACCT_BAL": "accountBalance"}, ""},
+		{"New names with equals signs", []string{"a=b=c"}, map[string]string{"a": "b=c"}, ""},
+		{"Missing new name", []string{"a="}, nil, `invalid --rename entry "a=" (expected old=new)`},
+		{"Missing old name", []string{"=a"}, nil, `invalid --rename entry "=a" (expected old=new)`},
+		{"Missing separator", []string{"a"}, nil, `invalid --rename entry "a" (expected old=new)`},
+		{"Repeated column", []string{"a=b", "a=c"}, nil, `column "a" is given more than once by --rename`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			renames, err := parseRenames(tt.specs)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantRenames, renames)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCsv2JsonRename(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		csvData  []string
+		options  conversionOptions
+		wantJson string
+		wantLogs string
+		wantErr  string
+	}{
+		{"Renames columns", []string{"CUST_NM,ACCT_BAL\nann,5\n"},
+			conversionOptions{renames: map[string]string{"CUST_NM": "customerName", "ACCT_BAL": "accountBalance"}},
+			`[{"customerName": "ann", "accountBalance": "5"}]`, "", ""},
+		{"Renames forced columns", []string{"ann,5\n"},
+			conversionOptions{colNames: []string{"a", "b"}, renames: map[string]string{"a": "name"}},
+			`[{"name": "ann", "b": "5"}]`, "", ""},
+		{"Renames the header of every input", []string{"a,b\n1,2\n", "a,b\n3,4\n"},
+			conversionOptions{renames: map[string]string{"a": "x"}}, `[{"x": "1", "b": "2"}, {"x": "3", "b": "4"}]`,
+			"", ""},
+		{"Selects renamed columns", []string{"a,b,c\n1,2,3\n"},
+			conversionOptions{renames: map[string]string{"a": "x"}, selectColumns: []string{"x", "c"}},
+			`[{"x": "1", "c": "3"}]`, "", ""},
+		{"Swaps names", []string{"a,b\n1,2\n"}, conversionOptions{renames: map[string]string{"a": "b", "b": "a"}},
+			`[{"b": "1", "a": "2"}]`, "", ""},
+		{"Logs missing columns", []string{"a,b\n1,2\n"},
+			conversionOptions{renames: map[string]string{"z": "y", "a": "x", "c": "d"}}, `[{"x": "1", "b": "2"}]`,
+			`Columns "c", "z" given by --rename are not among the columns of input 1`, ""},
+		{"Logs missing forced columns", []string{"1\n"},
+			conversionOptions{colNames: []string{"a"}, renames: map[string]string{"b": "c"}}, `[{"a": "1"}]`,
+			`Columns "b" given by --rename are not among the columns of --force-columns`, ""},
+		{"Rejects the same new name", []string{"a,b\n1,2\n"},
+			conversionOptions{renames: map[string]string{"a": "x", "b": "x"}}, "", "",
+			`--rename gives columns "a" and "b" the same name "x"`},
+		{"Rejects the name of another column", []string{"a,b\n1,2\n"},
+			conversionOptions{renames: map[string]string{"b": "a"}}, "", "",
+			`--rename gives columns "a" and "b" the same name "a"`},
+		{"Rejects selecting old names", []string{"a,b\n1,2\n"},
+			conversionOptions{renames: map[string]string{"a": "x"}, selectColumns: []string{"a"}}, "", "",
+			`unknown --select column "a"`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var logs bytes.Buffer
+			oldLogOutput := log.Writer()
+			log.SetOutput(&logs)
+			t.Cleanup(func() {
+				log.SetOutput(oldLogOutput)
+			})
+			var jsonStream bytes.Buffer
+			for _, csvData := range tt.csvData {
+				tt.options.csvInputs = append(tt.options.csvInputs, strings.NewReader(csvData))
+			}
+			tt.options.jsonOutput = &jsonStream
+			err := csv2Json(tt.options)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, jsonStream.String())
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, exitUsage, exitCode(err))
+			}
+			if tt.wantLogs == "" {
+				assert.Empty(t, logs.String())
+			} else {
+				assert.Contains(t, logs.String(), tt.wantLogs)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+// conversionStats counts what happened during a conversion.
+type conversionStats struct {
+	rowsRead      int
+	rowsConverted int
+	rowsSkipped   int
+	// rowsInvalid counts the skipped rows which were rejected by `conversionOptions.validate`
+	rowsInvalid int
+	// cellsTruncated counts values which were truncated by `conversionOptions.maxCellLength`
+	cellsTruncated int
+	// rowsPadded and rowsExtended count rows repaired by csvRowReader.fitFields(), and headersRenamed counts header
+	// names which were renamed
+	rowsPadded     int
+	rowsExtended   int
+	headersRenamed int
+	// rowsEmpty counts rows which were skipped because every field was empty, which are not counted as rowsSkipped
+	rowsEmpty int
+	// errors holds the first maxErrors errors which caused rows to be skipped
+	maxErrors int
+	errors    []reportedError
+}
+
+// rowSkipped counts a row which was read and then skipped due to err. The row starts on the given line,
+// which is 0 when unknown.
+func (s *conversionStats) rowSkipped(line, row int, err error) {
+	s.rowsSkipped++
+	if errors.As(err, new(*invalidRowError)) {
+		s.rowsInvalid++
+	}
+	if len(s.errors) < s.maxErrors {
+		s.errors = append(s.errors, reportedError{Line: line, Row: row, Message: err.Error()})
+	}
+}
+
+// maxReportedErrors limits how many errors are included in a runReport.
+const maxReportedErrors = 20
+
+// reportVersion is incremented whenever the structure of runReport changes incompatibly.
+const reportVersion = 1
+
+// runReport is written by --report-file to describe a run.
+type runReport struct {
+	Version         int             `json:"version"`
+	Inputs          []reportedInput `json:"inputs"`
+	Options         explanation     `json:"options"`
+	RowsRead        int             `json:"rows_read"`
+	RowsConverted   int             `json:"rows_converted"`
+	RowsSkipped     int             `json:"rows_skipped"`
+	RowsInvalid     int             `json:"rows_invalid"`
+	CellsTruncated  int             `json:"cells_truncated"`
+	RowsPadded      int             `json:"rows_padded"`
+	RowsExtended    int             `json:"rows_with_extra_fields"`
+	HeadersRenamed  int             `json:"headers_renamed"`
+	RowsEmpty       int             `json:"rows_empty"`
+	Errors          []reportedError `json:"errors"`
+	DurationSeconds float64         `json:"duration_seconds"`
+	ExitStatus      int             `json:"exit_status"`
+	// Error is the error which ended the run unsuccessfully, if any
+	Error string `json:"error,omitempty"`
+}
+
+// reportedInput describes an input file, whose size is unknown for stdin.
+type reportedInput struct {
+	Name      string `json:"name"`
+	SizeBytes *int64 `json:"size_bytes"`
+}
+
+// reportedError describes an error which caused a row to be skipped.
+type reportedError struct {
+	Line    int    `json:"line,omitempty"`
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+// newRunReport describes a run which took duration to read fileNames (or stdin, when there are none) using options,
+// recorded in stats, and which ended with err.
+func newRunReport(fileNames []string, options explanation, stats *conversionStats, duration time.Duration,
+	err error) runReport {
+	if len(fileNames) == 0 {
+		fileNames = []string{stdinFileName}
+	}
+	inputs := make([]reportedInput, len(fileNames))
+	for i, fileName := range fileNames {
+		inputs[i].Name = fileName
+		if info, statErr := os.Stat(fileName); fileName != stdinFileName && statErr == nil {
+			size := info.Size()
+			inputs[i].SizeBytes = &size
+		}
+	}
+
+	report := runReport{
+		Version:         reportVersion,
+		Inputs:          inputs,
+		Options:         options,
+		RowsRead:        stats.rowsRead,
+		RowsConverted:   stats.rowsConverted,
+		RowsSkipped:     stats.rowsSkipped,
+		RowsInvalid:     stats.rowsInvalid,
+		CellsTruncated:  stats.cellsTruncated,
+		RowsPadded:      stats.rowsPadded,
+		RowsExtended:    stats.rowsExtended,
+		HeadersRenamed:  stats.headersRenamed,
+		RowsEmpty:       stats.rowsEmpty,
+		Errors:          stats.errors,
+		DurationSeconds: duration.Seconds(),
+		ExitStatus:      exitCode(err),
+	}
+	if report.Errors == nil {
+		report.Errors = []reportedError{}
+	}
+	if _, skipped := err.(skippedRowsError); err != nil && !skipped {
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// writeReport writes report as JSON to the file named fileName, atomically replacing any existing file.
+func writeReport(fileName string, report runReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(fileName, append(data, '\n'))
+}
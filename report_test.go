@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/integrii/flaggy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReportFile(t *testing.T) {
+	for _, tt := range []struct {
+		testName       string
+		csv            string
+		cliArgs        []string
+		wantExitStatus int
+		wantRead       int
+		wantConverted  int
+		wantErrors     []reportedError
+		wantError      string
+	}{
+		{
+			"Reports successful run",
+			"a,b\n1,2\n3,4\n",
+			nil,
+			exitOK,
+			2,
+			2,
+			[]reportedError{},
+			"",
+		},
+		{
+			"Reports skipped rows",
+			"a,b\n1,2\n3\n\n4,5,6\n7,8\n",
+			[]string{"--skip-errors"},
+			exitPartialSuccess,
+			4,
+			2,
+			[]reportedError{
+				{3, 2, "record on line 3: wrong number of fields"},
+				{5, 3, "record on line 5: wrong number of fields"},
+			},
+			"",
+		},
+		{
+			"Reports failed run",
+			"a,b\n1,2\n3\n",
+			nil,
+			exitInvalidCsv,
+			2,
+			1,
+			[]reportedError{},
+			"record on line 3: wrong number of fields",
+		},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "csv2json-test-*")
+			require.NoError(t, err, "Test cannot run without a temp directory")
+			t.Cleanup(func() {
+				os.RemoveAll(dir)
+			})
+			csvFileName := filepath.Join(dir, "input.csv")
+			require.NoError(t, ioutil.WriteFile(csvFileName, []byte(tt.csv), 0644))
+			reportFileName := filepath.Join(dir, "report.json")
+
+			os.Args = append(append([]string{"csv2json", "--report-file", reportFileName}, tt.cliArgs...), csvFileName)
+			flaggy.ResetParser()
+			discardLogs(t)
+			oldStdout := os.Stdout
+			os.Stdout, err = os.Create(filepath.Join(dir, "output.json"))
+			require.NoError(t, err, "Test cannot run without somewhere to write output")
+			runErr := runCli()
+			os.Stdout.Close()
+			os.Stdout = oldStdout
+
+			data, err := ioutil.ReadFile(reportFileName)
+			require.NoError(t, err, "Report should be written")
+			var report runReport
+			require.NoError(t, json.Unmarshal(data, &report))
+
+			assert.Equal(t, exitCode(runErr), report.ExitStatus)
+			assert.Equal(t, tt.wantExitStatus, report.ExitStatus)
+			assert.Equal(t, reportVersion, report.Version)
+			size := int64(len(tt.csv))
+			assert.Equal(t, []reportedInput{{csvFileName, &size}}, report.Inputs)
+			assert.Equal(t, explainedOption{"stdout", sourceDefault}, report.Options["output"])
+			assert.Equal(t, tt.wantRead, report.RowsRead)
+			assert.Equal(t, tt.wantConverted, report.RowsConverted)
+			assert.Equal(t, len(tt.wantErrors), report.RowsSkipped)
+			assert.Equal(t, tt.wantErrors, report.Errors)
+			assert.Equal(t, tt.wantError, report.Error)
+			assert.GreaterOrEqual(t, report.DurationSeconds, 0.0)
+
+			tempFiles, err := filepath.Glob(filepath.Join(dir, ".report.json.tmp-*"))
+			assert.NoError(t, err)
+			assert.Empty(t, tempFiles, "Temporary report file should be removed")
+		})
+	}
+}
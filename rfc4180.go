@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+)
+
+// rfc4180Error describes where input violates RFC 4180.
+type rfc4180Error struct {
+	line   int
+	column int
+	msg    string
+}
+
+func (e *rfc4180Error) Error() string {
+	return fmt.Sprintf("RFC 4180 violation on line %d, column %d: %s", e.line, e.column, e.msg)
+}
+
+// rfc4180State is the position of an rfc4180Validator within the CSV grammar.
+type rfc4180State int
+
+const (
+	atFieldStart  rfc4180State = iota // nothing read yet for the current field
+	inUnquoted                        // reading an unquoted field
+	inQuoted                          // reading a quoted field
+	afterQuote                        // read a quote within a quoted field, which either escapes a quote or ends the field
+	afterCR                           // read a carriage return outside a quoted field, which must end the record
+	atRecordStart                     // read the CRLF ending a record
+)
+
+// utf8BOM is ignored at the start of input, as it is by getCsvReader().
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// rfc4180Validator passes raw CSV data through from an io.Reader while checking it against the grammar of RFC 4180,
+// which is stricter than encoding/csv. Reading stops with an *rfc4180Error just before the first byte which
+// violates the grammar, so that every record before it can still be parsed. A final record which is not terminated
+// by CRLF is allowed by the RFC, but is logged as a warning.
+type rfc4180Validator struct {
+	r   io.Reader
+	err error
+	// offset counts the bytes read so far, of which bomBytes were part of a leading BOM
+	offset   int
+	bomBytes int
+	state    rfc4180State
+	line     int
+	column   int
+	// numFields counts the fields of the current record, and wantFields those of the first record
+	numFields  int
+	wantFields int
+	// quoteLine and quoteColumn locate the opening quote of the current quoted field
+	quoteLine   int
+	quoteColumn int
+}
+
+func newRfc4180Validator(r io.Reader) *rfc4180Validator {
+	return &rfc4180Validator{r: r, state: atRecordStart, line: 1}
+}
+
+func (v *rfc4180Validator) Read(p []byte) (int, error) {
+	if v.err != nil {
+		return 0, v.err
+	}
+
+	n, err := v.r.Read(p)
+	for i, b := range p[:n] {
+		if v.offset == v.bomBytes && v.bomBytes < len(utf8BOM) && b == utf8BOM[v.bomBytes] {
+			v.offset++
+			v.bomBytes++
+			continue
+		}
+		v.offset++
+		v.column++
+		if msg := v.next(b); msg != "" {
+			v.err = &rfc4180Error{v.line, v.column, msg}
+			return i, v.err
+		}
+	}
+
+	if err == io.EOF {
+		if msg := v.finish(); msg != "" {
+			v.err = &rfc4180Error{v.line, v.column, msg}
+			return n, v.err
+		}
+	}
+	return n, err
+}
+
+// next advances the validator past b, returning a description of the violation when b is not allowed.
+func (v *rfc4180Validator) next(b byte) string {
+	switch v.state {
+	case afterCR:
+		if b != '\n' {
+			return "carriage return in unquoted field"
+		}
+		return v.endRecord()
+	case afterQuote:
+		switch b {
+		case '"':
+			v.state = inQuoted
+			return ""
+		case ',', '\r':
+			// The quoted field has ended, so handle b as it would be after an unquoted field
+		default:
+			return `unescaped " in quoted field`
+		}
+	case inQuoted:
+		if b == '"' {
+			v.state = afterQuote
+		} else if b == '\n' {
+			v.line++
+			v.column = 0
+		}
+		return ""
+	}
+
+	switch b {
+	case ',':
+		v.numFields++
+		v.state = atFieldStart
+	case '\r':
+		v.state = afterCR
+	case '\n':
+		return "line feed in unquoted field (records must end with CRLF)"
+	case '"':
+		if v.state != atFieldStart && v.state != atRecordStart {
+			return `bare " in unquoted field`
+		}
+		v.state = inQuoted
+		v.quoteLine, v.quoteColumn = v.line, v.column
+	default:
+		v.state = inUnquoted
+	}
+	return ""
+}
+
+// endRecord checks the field count of the record which was just terminated by CRLF.
+func (v *rfc4180Validator) endRecord() string {
+	if msg := v.checkFieldCount(); msg != "" {
+		return msg
+	}
+	v.state = atRecordStart
+	v.line++
+	v.column = 0
+	v.numFields = 0
+	return ""
+}
+
+// checkFieldCount reports when the current record has a different number of fields than the first record.
+func (v *rfc4180Validator) checkFieldCount() string {
+	numFields := v.numFields + 1
+	if v.wantFields == 0 {
+		v.wantFields = numFields
+	} else if numFields != v.wantFields {
+		return fmt.Sprintf("record has %d fields but the first record has %d", numFields, v.wantFields)
+	}
+	return ""
+}
+
+// finish checks the end of input, where the final record may omit its terminating CRLF.
+func (v *rfc4180Validator) finish() string {
+	switch v.state {
+	case atRecordStart:
+		return ""
+	case inQuoted:
+		v.line, v.column = v.quoteLine, v.quoteColumn
+		return "quoted field is not terminated"
+	case afterCR:
+		return "carriage return in unquoted field"
+	}
+
+	if msg := v.checkFieldCount(); msg != "" {
+		return msg
+	}
+	log.Printf("line %d: final record does not end with CRLF", v.line)
+	v.state = atRecordStart
+	return ""
+}
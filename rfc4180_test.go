@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"log"
+	"testing"
+	"testing/iotest"
+)
+
+func TestRfc4180Strict(t *testing.T) {
+	for _, tt := range []struct {
+		testName    string
+		csv         string
+		wantJson    string
+		wantErr     string
+		wantWarning string
+	}{
+		{
+			"Compliant input is converted",
+			"a,b\r\n1,\"x,\"\"y\"\"\r\nz\"\r\n,\r\n",
+			`[{"a": "1", "b": "x,\"y\"\nz"}, {"a": "", "b": ""}]`,
+			"",
+			"",
+		},
+		{
+			"Leading BOM is ignored",
+			"\uFEFFa,b\r\n1,2\r\n",
+			`[{"a": "1", "b": "2"}]`,
+			"",
+			"",
+		},
+		{
+			"Missing final CRLF is only a warning",
+			"a,b\r\n1,2",
+			`[{"a": "1", "b": "2"}]`,
+			"",
+			"line 2: final record does not end with CRLF",
+		},
+		{
+			"Bare quote in unquoted field",
+			"a,b\r\n1,x\"y\r\n",
+			"",
+			`RFC 4180 violation on line 2, column 4: bare " in unquoted field`,
+			"",
+		},
+		{
+			"Unescaped quote in quoted field",
+			"a,b\r\n1,\"x\"y\"\r\n",
+			"",
+			`RFC 4180 violation on line 2, column 6: unescaped " in quoted field`,
+			"",
+		},
+		{
+			"Carriage return in unquoted field",
+			"a,b\r\n1,x\ry\r\n",
+			"",
+			"RFC 4180 violation on line 2, column 5: carriage return in unquoted field",
+			"",
+		},
+		{
+			"Line feed in unquoted field",
+			"a,b\r\n1,x\ny\r\n",
+			"",
+			"RFC 4180 violation on line 2, column 4: line feed in unquoted field (records must end with CRLF)",
+			"",
+		},
+		{
+			"Inconsistent field count",
+			"a,b\r\n1,2\r\n3,4,5\r\n",
+			"",
+			"RFC 4180 violation on line 3, column 7: record has 3 fields but the first record has 2",
+			"",
+		},
+		{
+			"Inconsistent field count in final record without CRLF",
+			"a,b\r\n1",
+			"",
+			"RFC 4180 violation on line 2, column 1: record has 1 fields but the first record has 2",
+			"",
+		},
+		{
+			"Unterminated quoted field",
+			"a,b\r\n1,\"2\r\n",
+			"",
+			"RFC 4180 violation on line 2, column 3: quoted field is not terminated",
+			"",
+		},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			logged := bytes.NewBuffer([]byte{})
+			oldLogOutput, oldLogFlags := log.Writer(), log.Flags()
+			log.SetOutput(logged)
+			log.SetFlags(0)
+			t.Cleanup(func() {
+				log.SetOutput(oldLogOutput)
+				log.SetFlags(oldLogFlags)
+			})
+
+			jsonStream := bytes.NewBuffer([]byte{})
+			err := csv2Json(conversionOptions{
+				csvInputs:     []io.Reader{bytes.NewReader([]byte(tt.csv))},
+				rfc4180Strict: true,
+				jsonOutput:    jsonStream,
+				skipErrors:    true,
+			})
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, jsonStream.String())
+			} else {
+				assert.EqualError(t, err, tt.wantErr, "Violations should abort even when skipping errors")
+			}
+			if tt.wantWarning == "" {
+				assert.Empty(t, logged.String())
+			} else {
+				assert.Equal(t, tt.wantWarning+"\n", logged.String())
+			}
+		})
+	}
+}
+
+func TestRfc4180ValidatorAcrossReads(t *testing.T) {
+	// Reading one byte at a time should locate violations the same as reading all at once
+	v := newRfc4180Validator(iotest.OneByteReader(bytes.NewReader([]byte("\uFEFFa,b\r\n1,x\"y\r\n"))))
+
+	data, err := ioutil.ReadAll(v)
+
+	assert.EqualError(t, err, `RFC 4180 violation on line 2, column 4: bare " in unquoted field`)
+	assert.Equal(t, "\uFEFFa,b\r\n1,x", string(data))
+}
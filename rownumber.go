@@ -0,0 +1,21 @@
+package main
+
+// defaultRowNumberField is the field to which --row-number adds the number of each data row, unless
+// --row-number-field names another.
+const defaultRowNumberField = "_row"
+
+// lineNumberField is the field to which --line-number adds the line on which each data row starts.
+const lineNumberField = "_line"
+
+// checkRowNumberFields returns a *usageError when the fields added by `conversionOptions.rowNumberField` or
+// `conversionOptions.lineNumber` would have the same name as one of colNames, unless the row number field was chosen
+// to replace that column, as allowed by `conversionOptions.rowNumberReplaces`.
+func checkRowNumberFields(rowNumberField string, replaces, lineNumber bool, colNames []string) error {
+	if rowNumberField != "" && !replaces && containsString(colNames, rowNumberField) {
+		return usageErrorf("--row-number cannot add %s, which is already a column (use --row-number-field to "+
+			"name another, or to replace it)", rowNumberField)
+	} else if lineNumber && containsString(colNames, lineNumberField) {
+		return usageErrorf("--line-number cannot add %s, which is already a column", lineNumberField)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCheckRowNumberFields(t *testing.T) {
+	colNames := []string{"a", "_row", "_line"}
+	assert.NoError(t, checkRowNumberFields("", false, false, colNames))
+	assert.NoError(t, checkRowNumberFields("n", false, false, colNames))
+	assert.NoError(t, checkRowNumberFields("_row", true, false, colNames))
+	assert.NoError(t, checkRowNumberFields("_row", false, true, []string{"a"}))
+	assert.EqualError(t, checkRowNumberFields("_row", false, false, colNames),
+		"--row-number cannot add _row, which is already a column (use --row-number-field to name another, or to "+
+			"replace it)")
+	assert.EqualError(t, checkRowNumberFields("n", true, true, colNames),
+		"--line-number cannot add _line, which is already a column")
+}
+
+func TestCsv2JsonRowNumbers(t *testing.T) {
+	discardLogs(t)
+
+	for _, tt := range []struct {
+		testName string
+		csvData  []string
+		options  conversionOptions
+		wantJson string
+	}{
+		{"Row numbers", []string{"a\nx\ny\n"}, conversionOptions{rowNumberField: "_row"},
+			`[{"_row": "1", "a": "x"}, {"_row": "2", "a": "y"}]`},
+		{"Skipped rows leave gaps", []string{"a,b\n1,2\n3\n4,5\n"},
+			conversionOptions{rowNumberField: "_row", lineNumber: true, skipErrors: true},
+			`[{"_line": "2", "_row": "1", "a": "1", "b": "2"}, {"_line": "4", "_row": "3", "a": "4", "b": "5"}]`},
+		{"Line numbers differ across multi-line values", []string{"a,b\n\"x\ny\",1\n\n2,3\n"},
+			conversionOptions{rowNumberField: "n", lineNumber: true},
+			`[{"_line": "2", "n": "1", "a": "x\ny", "b": "1"}, {"_line": "5", "n": "2", "a": "2", "b": "3"}]`},
+		{"Rows are numbered across inputs, and lines within each", []string{"a\nx\n", "a\ny\n"},
+			conversionOptions{rowNumberField: "_row", lineNumber: true},
+			`[{"_line": "2", "_row": "1", "a": "x"}, {"_line": "2", "_row": "2", "a": "y"}]`},
+		{"Offset rows keep their numbers", []string{"a\nx\ny\n"}, conversionOptions{rowNumberField: "_row", offset: 1},
+			`[{"_row": "2", "a": "y"}]`},
+		{"Replaces a chosen column", []string{"id,a\n9,x\n"},
+			conversionOptions{rowNumberField: "id", rowNumberReplaces: true},
+			`[{"id": "1", "a": "x"}]`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+			options := tt.options
+			for _, data := range tt.csvData {
+				options.csvInputs = append(options.csvInputs, strings.NewReader(data))
+			}
+			options.jsonOutput = jsonStream
+
+			err := csv2Json(options)
+
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.wantJson, jsonStream.String())
+		})
+	}
+
+	err := csv2Json(conversionOptions{
+		csvInputs:      []io.Reader{strings.NewReader("_row,a\n1,x\n")},
+		jsonOutput:     ioutil.Discard,
+		rowNumberField: "_row",
+	})
+	assert.EqualError(t, err,
+		"--row-number cannot add _row, which is already a column (use --row-number-field to name another, or to "+
+			"replace it)")
+	assert.Equal(t, exitUsage, exitCode(err))
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+)
+
+// recordScanner reads converted records one at a time, in the manner of bufio.Scanner, for callers which want
+// records rather than output. Records are converted by eachReaderBatch() exactly as for every other output, in a
+// goroutine which only reads the input while Next() waits for it, so that the csvRowReader is never used by both at
+// once. Close() must be called unless Next() has returned false.
+type recordScanner struct {
+	reader  *csvRowReader
+	columns []string
+	// next asks the goroutine for another record, which it hands over by scanned
+	next    chan struct{}
+	scanned chan scannedRecord
+	// done is closed once the goroutine has stopped, after setting err and stats
+	done   chan struct{}
+	cancel context.CancelFunc
+	err    error
+	closed bool
+	stats  *conversionStats
+	// rec is the record most recently scanned, and skipped counts the rows skipped before it
+	rec     record
+	skipped int
+}
+
+// scannedRecord is a record handed to recordScanner.Next(), along with the number of rows skipped so far.
+type scannedRecord struct {
+	rec     record
+	skipped int
+}
+
+// newRecordScanner returns a recordScanner which converts CSV data according to options. The header is read before
+// it returns, so that any error reading it, or from options naming fields it does not have, is returned rather than
+// by Err(). Records are scanned in the order given by `options.sortBy`, `options.reverse`, and `options.shuffle`, in
+// which case every record is read by the first call to Next(). `options.batchSize` does not apply.
+func newRecordScanner(options conversionOptions) (*recordScanner, error) {
+	batchSize := 1
+	if options.sortBy != "" || options.reverse || options.shuffle {
+		batchSize = 0
+	}
+	if err := checkBatchOptions(options, batchSize); err != nil {
+		return nil, err
+	}
+	ctx := options.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	options.ctx = ctx
+	if options.stats == nil {
+		options.stats = &conversionStats{}
+	}
+	reader, colNames, err := newCsvRowReader(options)
+	if err == nil {
+		err = checkReaderSortFields(options, reader, colNames)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	s := &recordScanner{
+		reader:  reader,
+		columns: colNames,
+		next:    make(chan struct{}),
+		scanned: make(chan scannedRecord),
+		done:    make(chan struct{}),
+		cancel:  cancel,
+		stats:   options.stats,
+	}
+	go func() {
+		defer close(s.done)
+		if s.err = s.wait(ctx); s.err != nil {
+			return
+		}
+		s.err = eachReaderBatch(options, reader, colNames, batchSize, func(batch []record) error {
+			for _, rec := range batch {
+				// Next() is waiting to receive, having asked for this record
+				s.scanned <- scannedRecord{rec, options.stats.rowsSkipped}
+				if err := s.wait(ctx); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}()
+	return s, nil
+}
+
+// wait waits until Next() asks for another record, returning the error of ctx if it is done first.
+func (s *recordScanner) wait(ctx context.Context) error {
+	select {
+	case <-s.next:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Next advances to the next record, which is then returned by Record(). Returns false once there are no more
+// records, or conversion failed with the error returned by Err().
+func (s *recordScanner) Next() bool {
+	select {
+	case s.next <- struct{}{}:
+	case <-s.done:
+		s.rec, s.skipped = nil, s.stats.rowsSkipped
+		return false
+	}
+	select {
+	case scanned := <-s.scanned:
+		s.rec, s.skipped = scanned.rec, scanned.skipped
+		return true
+	case <-s.done:
+		s.rec, s.skipped = nil, s.stats.rowsSkipped
+		return false
+	}
+}
+
+// Record returns the record most recently scanned by Next(), which the scanner does not retain.
+func (s *recordScanner) Record() record {
+	return s.rec
+}
+
+// Columns returns the column names of the records, as read from the header unless `conversionOptions.colNames` was
+// given.
+func (s *recordScanner) Columns() []string {
+	return s.columns
+}
+
+// Skipped returns the number of rows which were skipped due to errors before the record most recently scanned, or in
+// total once Next() has returned false.
+func (s *recordScanner) Skipped() int {
+	return s.skipped
+}
+
+// Err returns the error which stopped conversion, once Next() has returned false. Returns nil if every record was
+// scanned, or if the scanner was closed first.
+func (s *recordScanner) Err() error {
+	select {
+	case <-s.done:
+	default:
+		return nil
+	}
+	if s.closed {
+		return nil
+	}
+	return s.err
+}
+
+// Close stops converting records, waiting until no more will be read.
+func (s *recordScanner) Close() {
+	select {
+	case <-s.done:
+	default:
+		s.closed = true
+	}
+	s.cancel()
+	<-s.done
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRecordScanner(t *testing.T) {
+	discardLogs(t)
+	csvData := "a,b\n1,2\n3\n4,5\n6\n7,8\n"
+
+	for _, tt := range []struct {
+		testName    string
+		skipErrors  bool
+		wantRecords []record
+		wantSkipped []int
+		wantErr     error
+	}{
+		{"Skips errors", true, []record{{"a": "1", "b": "2"}, {"a": "4", "b": "5"}, {"a": "7", "b": "8"}},
+			[]int{0, 1, 2, 2}, nil},
+		{"Stops at an error", false, []record{{"a": "1", "b": "2"}}, []int{0, 0}, csv.ErrFieldCount},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			s, err := newRecordScanner(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(csvData)},
+				skipErrors: tt.skipErrors,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a", "b"}, s.Columns())
+
+			var records []record
+			var skipped []int
+			for s.Next() {
+				records = append(records, s.Record())
+				skipped = append(skipped, s.Skipped())
+			}
+			skipped = append(skipped, s.Skipped())
+			assert.Equal(t, tt.wantRecords, records)
+			assert.Equal(t, tt.wantSkipped, skipped)
+			if tt.wantErr == nil {
+				assert.NoError(t, s.Err())
+			} else {
+				assert.True(t, errors.Is(s.Err(), tt.wantErr), "Unexpected error %v", s.Err())
+			}
+			assert.False(t, s.Next(), "Nothing should be scanned once finished")
+			s.Close()
+		})
+	}
+}
+
+func TestRecordScannerHeaderError(t *testing.T) {
+	_, err := newRecordScanner(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader("a,a\n1,2\n")},
+		dupHeaders: "error",
+	})
+	assert.EqualError(t, err, `header of input 1, column 2: duplicate column name "a"`,
+		"Header errors should be returned before scanning")
+}
+
+func TestRecordScannerClose(t *testing.T) {
+	csvData := bytes.NewBufferString("n\n")
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(csvData, "%d\n", i)
+	}
+	var count int64
+	s, err := newRecordScanner(conversionOptions{csvInputs: []io.Reader{readCounter{csvData, &count}}})
+	require.NoError(t, err)
+
+	for i := 0; i < 3 && s.Next(); i++ {
+		assert.Equal(t, record{"n": fmt.Sprint(i)}, s.Record())
+	}
+	s.Close()
+	assert.False(t, s.Next(), "Nothing should be scanned once closed")
+	assert.NoError(t, s.Err(), "Closing early should not be an error")
+	assert.Less(t, count, int64(64*1024), "Only the start of the input should be read")
+}
+
+func TestRecordScannerOrdered(t *testing.T) {
+	s, err := newRecordScanner(conversionOptions{
+		csvInputs: []io.Reader{strings.NewReader("n\n2\n3\n1\n")},
+		sortBy:    "n:desc",
+		limit:     2,
+	})
+	require.NoError(t, err)
+
+	var records []record
+	for s.Next() {
+		records = append(records, s.Record())
+	}
+	assert.NoError(t, s.Err())
+	assert.Equal(t, []record{{"n": "3"}, {"n": "2"}}, records, "Records should be sorted before being limited")
+}
+
+func TestRecordScannerInvalidOrdering(t *testing.T) {
+	_, err := newRecordScanner(conversionOptions{
+		csvInputs: []io.Reader{strings.NewReader("n\n1\n")},
+		sortBy:    "n",
+		shuffle:   true,
+	})
+	assert.EqualError(t, err, "--shuffle cannot be combined with --sort-by or --reverse")
+}
+
+func Example_recordScanner() {
+	s, err := newRecordScanner(conversionOptions{
+		csvInputs: []io.Reader{strings.NewReader("name,qty\napple,3\npear\nplum,5\n")},
+		// The short row is skipped, and counted by Skipped(), rather than stopping the scanner
+		skipErrors: true,
+	})
+	if err != nil {
+		fmt.Println("header error:", err)
+		return
+	}
+	fmt.Println(s.Columns())
+	for s.Next() {
+		rec := s.Record()
+		fmt.Println(rec["name"], rec["qty"])
+		if rec["name"] == "plum" {
+			// Stop early, so that the rest of the input is never read
+			s.Close()
+			break
+		}
+	}
+	if err := s.Err(); err != nil {
+		fmt.Println("error:", err)
+	}
+	fmt.Println("skipped:", s.Skipped())
+	// Output:
+	// [name qty]
+	// apple 3
+	// plum 5
+	// skipped: 1
+}
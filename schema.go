@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+)
+
+// jsonSchemaDraft identifies the draft of JSON Schema which the schema subcommand writes.
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchema is a JSON Schema describing the records converted from CSV inputs with --infer-types, as written by the
+// schema subcommand.
+type jsonSchema struct {
+	Schema     string                     `json:"$schema"`
+	Type       string                     `json:"type"`
+	Properties map[string]*schemaProperty `json:"properties"`
+	Required   []string                   `json:"required"`
+}
+
+// schemaProperty describes the values of a column. Type is a single type, or a list of types when values of the
+// column have several, which includes "null" when it is sometimes empty. Enum lists the values of string columns with
+// few distinct values, along with null when they are sometimes empty.
+type schemaProperty struct {
+	Type interface{}   `json:"type"`
+	Enum []interface{} `json:"enum,omitempty"`
+}
+
+// schemaTypes are the types which csvSchema() infers for non-empty values, in the order in which they are listed.
+var schemaTypes = []string{"integer", "number", "boolean", "string"}
+
+// columnProfile accumulates what csvSchema() learns about the values of a column.
+type columnProfile struct {
+	// types holds the types of its non-empty values, as by inferredType()
+	types map[string]bool
+	empty bool
+	// numRecords counts the records with a field for the column
+	numRecords int
+	// distinct holds the distinct string values while there are no more than the enum limit
+	distinct map[string]bool
+}
+
+// newColumnProfile returns a profile of a column for which no values have been read.
+func newColumnProfile() *columnProfile {
+	return &columnProfile{types: make(map[string]bool), distinct: make(map[string]bool)}
+}
+
+// inferredType returns the JSON Schema type of the value inferred for value by inferValue(), or empty for null.
+func inferredType(value string) string {
+	switch v := inferValue(value).(type) {
+	case nil:
+		return ""
+	case bool:
+		return "boolean"
+	case json.Number:
+		if strings.IndexAny(string(v), ".eE") < 0 {
+			return "integer"
+		}
+		return "number"
+	}
+	return "string"
+}
+
+// schemaType returns the JSON Schema type which describes values of the given types, along with null when empty is
+// set. Integers are described by "number" when there are other numbers too. Several types are returned as a list,
+// in the order of schemaTypes followed by "null", and no types at all as "null".
+func schemaType(types map[string]bool, empty bool) interface{} {
+	var list []string
+	for _, typ := range schemaTypes {
+		if types[typ] && !(typ == "integer" && types["number"]) {
+			list = append(list, typ)
+		}
+	}
+	if empty || len(list) == 0 {
+		list = append(list, "null")
+	}
+	if len(list) == 1 {
+		return list[0]
+	}
+	return list
+}
+
+// csvSchema reads every record of `options.csvInputs`, one at a time, and returns a JSON Schema describing them as
+// they would be converted with --infer-types, as by inferValue(), such that every record is valid. Each column is
+// required when none of its values are empty. Columns whose only values are strings, and which have no more than
+// enumLimit distinct values when it is set, are given an enum of them.
+// Returns any errors from reading CSV.
+func csvSchema(options conversionOptions, enumLimit int) (jsonSchema, error) {
+	reader, colNames, err := newCsvRowReader(options)
+	if err != nil {
+		return jsonSchema{}, err
+	}
+	profiles := make(map[string]*columnProfile, len(colNames))
+	for _, name := range colNames {
+		profiles[name] = newColumnProfile()
+	}
+	numRecords := 0
+	err = eachReaderBatch(options, reader, colNames, 1, func(batch []record) error {
+		for _, rec := range batch {
+			numRecords++
+			for k, v := range rec {
+				profile, ok := profiles[k]
+				if !ok {
+					profile = newColumnProfile()
+					profiles[k] = profile
+				}
+				profile.numRecords++
+				typ := inferredType(v)
+				if typ == "" {
+					profile.empty = true
+				} else {
+					profile.types[typ] = true
+				}
+				if profile.distinct != nil && typ != "" {
+					profile.distinct[v] = true
+					if len(profile.distinct) > enumLimit {
+						profile.distinct = nil
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return jsonSchema{}, err
+	}
+
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	newColumnOrder(colNames).sort(names)
+	schema := jsonSchema{Schema: jsonSchemaDraft, Type: "object", Properties: make(map[string]*schemaProperty),
+		Required: []string{}}
+	for _, name := range names {
+		profile := profiles[name]
+		property := &schemaProperty{Type: schemaType(profile.types, profile.empty)}
+		// Fields such as extra fields kept by fitFields() are missing from some records
+		if len(profile.types) > 0 && !profile.empty && profile.numRecords == numRecords && numRecords > 0 {
+			schema.Required = append(schema.Required, name)
+		}
+		if len(profile.types) == 1 && profile.types["string"] && profile.distinct != nil {
+			values := make([]string, 0, len(profile.distinct))
+			for value := range profile.distinct {
+				values = append(values, value)
+			}
+			sort.Strings(values)
+			for _, value := range values {
+				property.Enum = append(property.Enum, value)
+			}
+			if profile.empty {
+				property.Enum = append(property.Enum, nil)
+			}
+		}
+		schema.Properties[name] = property
+	}
+	return schema, nil
+}
+
+// writeSchema writes schema to w as indented JSON.
+func writeSchema(w io.Writer, schema jsonSchema) error {
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestInferredType(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"42", "integer"},
+		{"-7", "integer"},
+		{"1.5", "number"},
+		{"1e3", "number"},
+		{"007", "string"},
+		{"TRUE", "boolean"},
+		{"false", "boolean"},
+		{"99999999999999999999", "string"},
+		{"ann", "string"},
+	} {
+		t.Run(tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.want, inferredType(tt.value))
+		})
+	}
+}
+
+func TestSchemaType(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		types    []string
+		empty    bool
+		want     interface{}
+	}{
+		{"No values", nil, false, "null"},
+		{"Only empty values", nil, true, "null"},
+		{"One type", []string{"integer"}, false, "integer"},
+		{"Sometimes empty", []string{"string"}, true, []string{"string", "null"}},
+		{"Integers are numbers", []string{"number", "integer"}, false, "number"},
+		{"Integers and strings", []string{"string", "integer"}, false, []string{"integer", "string"}},
+		{"Booleans, integers, and empties", []string{"boolean", "integer"}, true,
+			[]string{"integer", "boolean", "null"}},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			types := make(map[string]bool)
+			for _, typ := range tt.types {
+				types[typ] = true
+			}
+			assert.Equal(t, tt.want, schemaType(types, tt.empty))
+		})
+	}
+}
+
+func TestCsvSchema(t *testing.T) {
+	csvData := "id,name,price,active,status,note,blank\n" +
+		"1,ann,9.99,true,open,,\n" +
+		"2,bob,5,false,closed,late,\n" +
+		"3,cy,12,TRUE,open,,\n"
+
+	for _, tt := range []struct {
+		testName   string
+		csvData    string
+		options    conversionOptions
+		enumLimit  int
+		wantSchema string
+	}{
+		{"Representative fixture", csvData, conversionOptions{}, 2, `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "properties": {
+    "active": {
+      "type": "boolean"
+    },
+    "blank": {
+      "type": "null"
+    },
+    "id": {
+      "type": "integer"
+    },
+    "name": {
+      "type": "string"
+    },
+    "note": {
+      "type": [
+        "string",
+        "null"
+      ],
+      "enum": [
+        "late",
+        null
+      ]
+    },
+    "price": {
+      "type": "number"
+    },
+    "status": {
+      "type": "string",
+      "enum": [
+        "closed",
+        "open"
+      ]
+    }
+  },
+  "required": [
+    "id",
+    "name",
+    "price",
+    "active",
+    "status"
+  ]
+}
+`},
+		{"No enums without a limit", "status\nopen\n", conversionOptions{}, 0, `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "properties": {
+    "status": {
+      "type": "string"
+    }
+  },
+  "required": [
+    "status"
+  ]
+}
+`},
+		{"Extra fields are not required", "a\n1\n2,x\n", conversionOptions{keepExtraFields: true}, 0, `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "properties": {
+    "_extra_1": {
+      "type": "string"
+    },
+    "a": {
+      "type": "integer"
+    }
+  },
+  "required": [
+    "a"
+  ]
+}
+`},
+		{"Empty input", "a,b\n", conversionOptions{}, 0, `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "properties": {
+    "a": {
+      "type": "null"
+    },
+    "b": {
+      "type": "null"
+    }
+  },
+  "required": []
+}
+`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			tt.options.csvInputs = []io.Reader{strings.NewReader(tt.csvData)}
+			schema, err := csvSchema(tt.options, tt.enumLimit)
+			require.NoError(t, err)
+			var buf bytes.Buffer
+			require.NoError(t, writeSchema(&buf, schema))
+			assert.Equal(t, tt.wantSchema, buf.String())
+		})
+	}
+}
+
+func TestCsvSchemaDescribesRecords(t *testing.T) {
+	csvData := "id,mixed,flag,amount,status,note\n" +
+		"1,1,true,1,open,\n" +
+		"2,x,2,2.5,closed,007\n" +
+		"3,,false,-3,open,TRUE\n" +
+		"4,1e3,,4,,\n"
+
+	schema, err := csvSchema(conversionOptions{csvInputs: []io.Reader{strings.NewReader(csvData)}}, 3)
+	require.NoError(t, err)
+	var jsonData bytes.Buffer
+	require.NoError(t, csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader(csvData)},
+		jsonOutput: &jsonData,
+		inferTypes: true,
+	}))
+	var records []map[string]interface{}
+	require.NoError(t, json.Unmarshal(jsonData.Bytes(), &records))
+
+	require.Len(t, records, 4)
+	for i, rec := range records {
+		assert.NoError(t, validateSchemaRecord(schema, rec), "Record %d should be valid: %v", i+1, rec)
+	}
+}
+
+// validateSchemaRecord returns an error unless rec, decoded from JSON, has the required properties of schema and
+// properties of its types and enums.
+func validateSchemaRecord(schema jsonSchema, rec map[string]interface{}) error {
+	for _, name := range schema.Required {
+		if _, ok := rec[name]; !ok {
+			return fmt.Errorf("missing required %q", name)
+		}
+	}
+	for name, value := range rec {
+		property, ok := schema.Properties[name]
+		if !ok {
+			return fmt.Errorf("unknown property %q", name)
+		}
+		var valueType string
+		switch v := value.(type) {
+		case nil:
+			valueType = "null"
+		case bool:
+			valueType = "boolean"
+		case float64:
+			valueType = "number"
+			if v == math.Trunc(v) {
+				valueType = "integer"
+			}
+		case string:
+			valueType = "string"
+		}
+		types, ok := property.Type.([]string)
+		if !ok {
+			types = []string{property.Type.(string)}
+		}
+		if !containsString(types, valueType) && !(valueType == "integer" && containsString(types, "number")) {
+			return fmt.Errorf("%q is %s, not %v", name, valueType, property.Type)
+		}
+		if property.Enum != nil && !containsValue(property.Enum, value) {
+			return fmt.Errorf("%q is %v, not one of %v", name, value, property.Enum)
+		}
+	}
+	return nil
+}
+
+// containsValue reports whether values holds value.
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
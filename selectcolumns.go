@@ -0,0 +1,37 @@
+package main
+
+// checkSelectedColumns returns a *usageError when both selected and excluded columns are given, or unless each of
+// them is one of colNames, or colNames is nil, as when there is no header.
+func checkSelectedColumns(selected, excluded, colNames []string) error {
+	if len(selected) > 0 && len(excluded) > 0 {
+		return usageErrorf("--select and --exclude cannot be combined")
+	} else if colNames == nil {
+		return nil
+	}
+	for _, column := range selected {
+		if !containsString(colNames, column) {
+			return usageErrorf("unknown --select column %q", column)
+		}
+	}
+	for _, column := range excluded {
+		if !containsString(colNames, column) {
+			return usageErrorf("unknown --exclude column %q", column)
+		}
+	}
+	return nil
+}
+
+// omittedColumns returns which of colNames are left out of records: those not selected, when any columns are, or
+// those excluded.
+func omittedColumns(selected, excluded, colNames []string) map[string]bool {
+	if len(selected) == 0 && len(excluded) == 0 {
+		return nil
+	}
+	omitted := make(map[string]bool)
+	for _, name := range colNames {
+		if len(selected) > 0 && !containsString(selected, name) || containsString(excluded, name) {
+			omitted[name] = true
+		}
+	}
+	return omitted
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCheckSelectedColumns(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		selected []string
+		excluded []string
+		colNames []string
+		wantErr  string
+	}{
+		{"Neither", nil, nil, []string{"a"}, ""},
+		{"Known columns", []string{"a", "b"}, nil, []string{"a", "b", "c"}, ""},
+		{"Without a header", []string{"x"}, nil, nil, ""},
+		{"Unknown selected column", []string{"a", "x"}, nil, []string{"a", "b"}, `unknown --select column "x"`},
+		{"Unknown excluded column", nil, []string{"x"}, []string{"a", "b"}, `unknown --exclude column "x"`},
+		{"Both", []string{"a"}, []string{"b"}, nil, "--select and --exclude cannot be combined"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			err := checkSelectedColumns(tt.selected, tt.excluded, tt.colNames)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, exitUsage, exitCode(err))
+			}
+		})
+	}
+}
+
+func TestCsv2JsonSelectColumns(t *testing.T) {
+	discardLogs(t)
+
+	for _, tt := range []struct {
+		testName string
+		csvData  string
+		options  conversionOptions
+		wantJson string
+		wantErr  string
+	}{
+		{"Selects columns", "a,b,c\n1,2,3\n", conversionOptions{selectColumns: []string{"c", "a"}},
+			`[{"a": "1", "c": "3"}]`, ""},
+		{"Excludes columns", "a,b,c\n1,2,3\n", conversionOptions{excludeColumns: []string{"b"}},
+			`[{"a": "1", "c": "3"}]`, ""},
+		{"Selects forced columns", "1,2,3\n",
+			conversionOptions{colNames: []string{"a", "b", "c"}, selectColumns: []string{"b"}}, `[{"b": "2"}]`, ""},
+		{"Keeps file metadata", "a,b\n1,2\n", conversionOptions{selectColumns: []string{"a"}, fileMeta: []string{"size"}},
+			`[{"a": "1", "_file_size": null}]`, ""},
+		{"Omits the truncation flags of omitted columns", "a,b\nxxxx,yyyy\n", conversionOptions{
+			excludeColumns: []string{"b"}, maxCellLength: 2, truncateFlag: true}, `[{"a": "xx", "a_truncated": "true"}]`,
+			""},
+		{"Validates every column", "a,b\n1,\n", conversionOptions{excludeColumns: []string{"b"},
+			validate: validateRequired([]string{"b"})}, "", `row 1 is invalid: missing required field "b"`},
+		{"Checks the number of fields", "a,b\n1,2,3\n", conversionOptions{selectColumns: []string{"a"}}, "",
+			"record on line 2: wrong number of fields"},
+		{"Rejects unknown columns before reading rows", "a,b\n1,2\n", conversionOptions{selectColumns: []string{"c"}},
+			"", `unknown --select column "c"`},
+		{"Rejects unknown forced columns", "1,2\n",
+			conversionOptions{colNames: []string{"a", "b"}, excludeColumns: []string{"c"}}, "",
+			`unknown --exclude column "c"`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var jsonStream bytes.Buffer
+			tt.options.csvInputs = []io.Reader{strings.NewReader(tt.csvData)}
+			tt.options.jsonOutput = &jsonStream
+			err := csv2Json(tt.options)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, jsonStream.String())
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
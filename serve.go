@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Media types of the responses of the serve subcommand.
+const (
+	jsonMediaType   = "application/json"
+	ndjsonMediaType = "application/x-ndjson"
+	// multipartMediaType is the media type of requests which upload CSV data with options
+	multipartMediaType = "multipart/form-data"
+)
+
+// serveOptions is used to configure the HTTP server of the serve subcommand.
+type serveOptions struct {
+	listen string
+	// batchSize is how many records are written to a response between flushes
+	batchSize int
+}
+
+// serveRequestOptions are the conversion options which a request may give as the JSON "options" part of a multipart
+// upload, overriding those the server was started with.
+type serveRequestOptions struct {
+	Delimiter    *string  `json:"delimiter"`
+	LazyQuotes   *bool    `json:"lazy_quotes"`
+	SkipErrors   *bool    `json:"skip_errors"`
+	ForceColumns []string `json:"force_columns"`
+}
+
+// apply returns a copy of options with the request's options applied.
+// Returns a *usageError if any are invalid.
+func (o serveRequestOptions) apply(options conversionOptions) (conversionOptions, error) {
+	if o.Delimiter != nil {
+		delimiter, err := parseDelimiter(*o.Delimiter)
+		if err != nil {
+			return options, &usageError{err}
+		}
+		options.dialect.delimiter = delimiter
+		options.dialect.keepDelimiter = true
+	}
+	if o.LazyQuotes != nil {
+		options.dialect.lazyQuotes = *o.LazyQuotes
+	}
+	if o.SkipErrors != nil {
+		options.skipErrors = *o.SkipErrors
+	}
+	if o.ForceColumns != nil {
+		options.colNames = o.ForceColumns
+	}
+	return options, nil
+}
+
+// serve converts the CSV data POSTed to an HTTP server listening on `serveOpts.listen`, using options for each
+// request, as by newServeHandler(), until stop is closed.
+// Returns any error from listening.
+func serve(options conversionOptions, serveOpts serveOptions, stop <-chan struct{}) error {
+	server := &http.Server{Addr: serveOpts.listen, Handler: newServeHandler(options, serveOpts)}
+	go func() {
+		<-stop
+		server.Shutdown(context.Background())
+	}()
+	logServing(serveOpts.listen)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// newServeHandler returns a handler which converts the CSV data of each POST request, which is either the request
+// body or the "file" part of a multipart upload, preceded by any "options" part, as described by
+// serveRequestOptions. Records are streamed as a JSON array or as NDJSON, as negotiated by negotiateMediaType(), and
+// flushed after every `serveOpts.batchSize` records. Errors before any records are written are responses with a
+// JSON error message, while later errors abort the response, so that it is never mistaken for a complete one.
+func newServeHandler(options conversionOptions, serveOpts serveOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			writeServeError(w, http.StatusMethodNotAllowed, "CSV data must be POSTed")
+			return
+		}
+		mediaType, err := negotiateMediaType(req.Header.Get("Accept"))
+		if err != nil {
+			writeServeError(w, http.StatusNotAcceptable, err.Error())
+			return
+		}
+
+		// Records are flushed while the request is still being read, which HTTP/1 servers otherwise prevent
+		if d, ok := w.(interface{ EnableFullDuplex() error }); ok {
+			d.EnableFullDuplex()
+		}
+		reqOptions := options
+		reqOptions.stats = nil
+		input := io.Reader(req.Body)
+		if contentType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); contentType == multipartMediaType {
+			if reqOptions, input, err = readMultipartRequest(req, options); err != nil {
+				writeServeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		reqOptions.csvInputs = []io.Reader{input}
+		// Conversion stops once the client goes away
+		reqOptions.ctx = req.Context()
+
+		response := &serveResponse{w: w, mediaType: mediaType}
+		reqOptions.jsonOutput = response
+		err = streamRecords(reqOptions, mediaType, serveOpts.batchSize)
+		if err != nil && !response.written {
+			status := http.StatusBadRequest
+			if exitCode(err) == exitInvalidCsv {
+				status = http.StatusUnprocessableEntity
+			}
+			writeServeError(w, status, err.Error())
+		} else if err != nil {
+			logServeError(req, err)
+			// Aborting the handler ends a chunked response without its final chunk
+			panic(http.ErrAbortHandler)
+		}
+	})
+}
+
+// negotiateMediaType returns the media type of the response to a request with the given Accept header, which is
+// the first of its media ranges which matches jsonMediaType or ndjsonMediaType, or jsonMediaType when it has none.
+// Returns an error if no media type is acceptable.
+func negotiateMediaType(accept string) (string, error) {
+	if strings.TrimSpace(accept) == "" {
+		return jsonMediaType, nil
+	}
+	acceptsCsv := false
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+			continue
+		}
+		switch mediaType {
+		case ndjsonMediaType:
+			return ndjsonMediaType, nil
+		case jsonMediaType, "application/*", "*/*":
+			return jsonMediaType, nil
+		case "text/csv":
+			acceptsCsv = true
+		}
+	}
+	if acceptsCsv {
+		return "", fmt.Errorf("text/csv responses are not supported yet (expected %s or %s)", jsonMediaType,
+			ndjsonMediaType)
+	}
+	return "", fmt.Errorf("no acceptable media type (expected %s or %s)", jsonMediaType, ndjsonMediaType)
+}
+
+// readMultipartRequest reads the parts of a multipart upload up to its "file" part, returning options with those
+// of any "options" part applied, along with the file part to read as CSV. Parts after the file part are not read.
+func readMultipartRequest(req *http.Request, options conversionOptions) (conversionOptions, io.Reader, error) {
+	parts, err := req.MultipartReader()
+	if err != nil {
+		return options, nil, err
+	}
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			return options, nil, fmt.Errorf("multipart upload has no file part")
+		} else if err != nil {
+			return options, nil, err
+		}
+		switch part.FormName() {
+		case "file":
+			return options, part, nil
+		case "options":
+			var reqOptions serveRequestOptions
+			dec := json.NewDecoder(part)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&reqOptions); err != nil {
+				return options, nil, fmt.Errorf("invalid options part: %w", err)
+			}
+			if options, err = reqOptions.apply(options); err != nil {
+				return options, nil, err
+			}
+		default:
+			return options, nil, fmt.Errorf("unknown multipart part %q (expected options or file)", part.FormName())
+		}
+	}
+}
+
+// streamRecords converts CSV data from `options.csvInputs` to `options.jsonOutput` as a JSON array, or as NDJSON
+// for ndjsonMediaType, flushing after every batch of up to batchSize records.
+// Returns any errors from reading CSV or writing JSON.
+func streamRecords(options conversionOptions, mediaType string, batchSize int) error {
+	w := options.jsonOutput
+	enc := json.NewEncoder(w)
+	var array *jsonArrayWriter
+	if mediaType != ndjsonMediaType {
+		array = newJsonArrayWriter(w, 0)
+	}
+	err := eachBatch(options, batchSize, func(batch []record) error {
+		for _, rec := range batch {
+			if array != nil {
+				if err := array.write(rec); err != nil {
+					return err
+				}
+			} else if err := enc.Encode(rec); err != nil {
+				return &outputError{err}
+			}
+		}
+		if f, ok := w.(interface{ Flush() error }); ok {
+			return f.Flush()
+		}
+		return nil
+	})
+	if err != nil || array == nil {
+		return err
+	}
+	return array.close()
+}
+
+// serveResponse writes a response with the given media type, tracking whether any of it has been written, after
+// which its status can no longer change.
+type serveResponse struct {
+	w         http.ResponseWriter
+	mediaType string
+	written   bool
+}
+
+func (r *serveResponse) Write(p []byte) (int, error) {
+	if !r.written {
+		r.w.Header().Set("Content-Type", r.mediaType)
+		r.written = true
+	}
+	return r.w.Write(p)
+}
+
+// Flush sends everything written so far to the client.
+func (r *serveResponse) Flush() error {
+	if f, ok := r.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// writeServeError responds with the given status and a JSON object describing the error.
+func writeServeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", jsonMediaType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// logServing logs the address on which the serve subcommand is listening.
+func logServing(addr string) {
+	if logStructured() {
+		logEvent("serving", "addr", addr)
+	} else {
+		log.Printf("Serving on %s", addr)
+	}
+}
+
+// logServeError logs an error which aborted the response to req after some of it was written.
+func logServeError(req *http.Request, err error) {
+	if logStructured() {
+		logEvent("aborted response", "remote_addr", req.RemoteAddr, "error", err.Error())
+	} else {
+		log.Printf("Aborted response to %s: %v", req.RemoteAddr, err)
+	}
+}
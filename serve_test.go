@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateMediaType(t *testing.T) {
+	for _, tt := range []struct {
+		accept        string
+		wantMediaType string
+		wantErr       string
+	}{
+		{"", jsonMediaType, ""},
+		{"application/json", jsonMediaType, ""},
+		{"application/x-ndjson", ndjsonMediaType, ""},
+		{"text/html, application/x-ndjson;q=0.9, application/json", ndjsonMediaType, ""},
+		{"application/x-ndjson;q=0, */*", jsonMediaType, ""},
+		{"text/csv", "", "text/csv responses are not supported yet (expected application/json or application/x-ndjson)"},
+		{"text/csv, application/json", jsonMediaType, ""},
+		{"text/html", "", "no acceptable media type (expected application/json or application/x-ndjson)"},
+	} {
+		t.Run(tt.accept, func(t *testing.T) {
+			mediaType, err := negotiateMediaType(tt.accept)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantMediaType, mediaType)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServeHandler(t *testing.T) {
+	discardLogs(t)
+	server := httptest.NewServer(newServeHandler(conversionOptions{}, serveOptions{batchSize: 2}))
+	t.Cleanup(server.Close)
+
+	for _, tt := range []struct {
+		testName        string
+		accept          string
+		csv             string
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{"Streams a JSON array", "application/json", "a,b\n1,2\n3,4\n5,6\n", http.StatusOK, jsonMediaType,
+			`[{"a":"1","b":"2"},{"a":"3","b":"4"},{"a":"5","b":"6"}]` + "\n"},
+		{"Streams an empty JSON array", "", "a,b\n", http.StatusOK, jsonMediaType, "[]\n"},
+		{"Streams NDJSON", "application/x-ndjson", "a,b\n1,2\n3,4\n5,6\n", http.StatusOK, ndjsonMediaType,
+			`{"a":"1","b":"2"}` + "\n" + `{"a":"3","b":"4"}` + "\n" + `{"a":"5","b":"6"}` + "\n"},
+		{"CSV is not acceptable", "text/csv", "a,b\n", http.StatusNotAcceptable, jsonMediaType,
+			`{"error":"text/csv responses are not supported yet (expected application/json or application/x-ndjson)"}` +
+				"\n"},
+		{"Errors before any output are reported", "application/x-ndjson", "a,b\n1,2\n3\n",
+			http.StatusUnprocessableEntity, jsonMediaType,
+			`{"error":"record on line 3: wrong number of fields"}` + "\n"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(tt.csv))
+			require.NoError(t, err)
+			req.Header.Set("Accept", tt.accept)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			assert.Equal(t, tt.wantContentType, resp.Header.Get("Content-Type"))
+			assert.Equal(t, tt.wantBody, string(body))
+		})
+	}
+}
+
+func TestServeHandlerMultipart(t *testing.T) {
+	server := httptest.NewServer(newServeHandler(conversionOptions{}, serveOptions{batchSize: 2}))
+	t.Cleanup(server.Close)
+
+	for _, tt := range []struct {
+		testName   string
+		parts      [][2]string
+		wantStatus int
+		wantBody   string
+	}{
+		{"Applies options", [][2]string{{"options", `{"delimiter": ";", "force_columns": ["x", "y"]}`},
+			{"file", "1;2\n"}}, http.StatusOK, `[{"x":"1","y":"2"}]` + "\n"},
+		{"Without options", [][2]string{{"file", "a,b\n1,2\n"}}, http.StatusOK, `[{"a":"1","b":"2"}]` + "\n"},
+		{"Unknown options", [][2]string{{"options", `{"delimeter": ";"}`}, {"file", "a\n"}}, http.StatusBadRequest,
+			`{"error":"invalid options part: json: unknown field \"delimeter\""}` + "\n"},
+		{"Invalid options", [][2]string{{"options", `{"delimiter": ";;"}`}, {"file", "a\n"}}, http.StatusBadRequest,
+			`{"error":"invalid delimiter \";;\" (expected a single character)"}` + "\n"},
+		{"Missing file", [][2]string{{"options", `{}`}}, http.StatusBadRequest,
+			`{"error":"multipart upload has no file part"}` + "\n"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			for _, part := range tt.parts {
+				w, err := mw.CreateFormField(part[0])
+				require.NoError(t, err)
+				io.WriteString(w, part[1])
+			}
+			require.NoError(t, mw.Close())
+
+			resp, err := http.Post(server.URL, mw.FormDataContentType(), &body)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			respBody, err := ioutil.ReadAll(resp.Body)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			assert.Equal(t, tt.wantBody, string(respBody))
+		})
+	}
+}
+
+func TestServeHandlerAbortsMidStream(t *testing.T) {
+	var logs bytes.Buffer
+	oldLogOutput := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() {
+		log.SetOutput(oldLogOutput)
+	})
+	server := httptest.NewServer(newServeHandler(conversionOptions{}, serveOptions{batchSize: 2}))
+	t.Cleanup(server.Close)
+	csvData := bytes.NewBufferString("n\n")
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(csvData, "%d\n", i)
+	}
+	csvData.WriteString("5,extra\n")
+
+	req, err := http.NewRequest(http.MethodPost, server.URL, csvData)
+	require.NoError(t, err)
+	req.Header.Set("Accept", ndjsonMediaType)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	assert.Error(t, err, "The response should not end like a complete one")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"n":"0"}`+"\n"+`{"n":"1"}`+"\n"+`{"n":"2"}`+"\n"+`{"n":"3"}`+"\n", string(body))
+	// Closing the server waits for the handler to finish logging
+	server.Close()
+	assert.Contains(t, logs.String(), "wrong number of fields")
+}
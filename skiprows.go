@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bufio"
+	"io"
+)
+
+// skipLines discards the first n lines of r, however they would be parsed as CSV, returning a reader of the rest.
+// Lines end with a newline, so a CR LF line ending is discarded along with its line. Any BOM is discarded along with
+// the first line. Lines which begin with comment, when it is set, are discarded without counting towards n. Inputs
+// with n lines or fewer are left empty.
+// Returns any error from reading r.
+func skipLines(r io.Reader, n int, comment rune) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	for skipped, lineStart, isComment := 0, true, false; skipped < n; {
+		if lineStart && comment != 0 {
+			next, _, err := br.ReadRune()
+			if err == nil {
+				br.UnreadRune()
+			}
+			isComment = next == comment
+		}
+		_, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// The line continues past what fits in the buffer
+			lineStart = false
+			continue
+		} else if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		lineStart = true
+		if !isComment {
+			skipped++
+		}
+	}
+	return br, nil
+}
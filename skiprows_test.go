@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestSkipLines(t *testing.T) {
+	longLine := strings.Repeat("x", 10000) + "\n"
+	for _, tt := range []struct {
+		testName string
+		input    string
+		n        int
+		comment  rune
+		want     string
+	}{
+		{"None", "a\nb\n", 0, 0, "a\nb\n"},
+		{"Some", "banner\n\na,b\n", 2, 0, "a,b\n"},
+		{"CR LF", "banner\r\n\r\na,b\r\n", 2, 0, "a,b\r\n"},
+		{"Unbalanced quotes", "\"banner\n\"a\",b\n", 1, 0, "\"a\",b\n"},
+		{"BOM", "\uFEFFbanner\na\n", 1, 0, "a\n"},
+		{"Long lines", longLine + "a\n", 1, 0, "a\n"},
+		{"Every line", "banner\na\n", 2, 0, ""},
+		{"More than every line", "banner", 3, 0, ""},
+		{"Comments do not count", "banner\n# note\n#\nid\n1\n", 2, '#', "1\n"},
+		{"Long comments do not count", "#" + longLine + "banner\nid\n", 1, '#', "id\n"},
+		{"Comment characters after the start of a line", "banner #\nid\n", 1, '#', "id\n"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			r, err := skipLines(strings.NewReader(tt.input), tt.n, tt.comment)
+			require.NoError(t, err)
+			rest, err := ioutil.ReadAll(r)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(rest))
+		})
+	}
+}
+
+func TestCsv2JsonSkipRows(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		csvData  []string
+		options  conversionOptions
+		wantJson string
+	}{
+		{"Skips banners", []string{"\uFEFFReport generated \"2024-05-01\n\nid,name\n1,ann\n"}, conversionOptions{},
+			`[{"id": "1", "name": "ann"}]`},
+		{"Skips banners of every input", []string{"banner\n\nid\n1\n", "banner\n\nid\n2\n"}, conversionOptions{},
+			`[{"id": "1"}, {"id": "2"}]`},
+		{"Skips banners before forced columns", []string{"banner\n\n1,ann\n"},
+			conversionOptions{colNames: []string{"id", "name"}}, `[{"id": "1", "name": "ann"}]`},
+		{"Skips banners before strict parsing", []string{"banner \"\r\n\r\nid\r\n1\r\n"},
+			conversionOptions{rfc4180Strict: true}, `[{"id": "1"}]`},
+		{"Skips banners before capturing text", []string{"banner\n\nid\n1\n"},
+			conversionOptions{padShortRows: true}, `[{"id": "1"}]`},
+		{"Skips every line", []string{"banner\n"}, conversionOptions{}, `[]`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var jsonStream bytes.Buffer
+			for _, csvData := range tt.csvData {
+				tt.options.csvInputs = append(tt.options.csvInputs, strings.NewReader(csvData))
+			}
+			tt.options.jsonOutput = &jsonStream
+			tt.options.skipRows = 2
+			err := csv2Json(tt.options)
+
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.wantJson, jsonStream.String())
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// sniffLength is the number of bytes at the start of each input which are inspected by sniffInput().
+const sniffLength = 1024
+
+// notCsvError reports an input which was recognized as some format other than CSV before it was parsed.
+type notCsvError struct {
+	name   string
+	format string
+	hint   string
+}
+
+func (e *notCsvError) Error() string {
+	return fmt.Sprintf("%s: input looks like %s, not CSV — %s", e.name, e.format, e.hint)
+}
+
+// magicNumbers identify binary formats which are commonly mistaken for CSV by the bytes they begin with.
+var magicNumbers = []struct {
+	magic  string
+	format string
+	hint   string
+}{
+	{"PK\x03\x04", "a ZIP archive (such as an .xlsx workbook)", "export it as CSV first"},
+	{gzipMagic, "gzip-compressed data", "use --compression auto to decompress it"},
+	{"PAR1", "a Parquet file", "export it as CSV first"},
+	{"%PDF-", "a PDF document", "extract its tables as CSV first"},
+}
+
+// sniffInput inspects up to sniffLength bytes at the start of br, named name, without consuming them.
+// Returns a *notCsvError when they begin with one of magicNumbers, contain a NUL byte (which CSV text never does),
+// or look like JSON according to looksLikeJson().
+func sniffInput(name string, br *bufio.Reader) error {
+	peeked, _ := br.Peek(sniffLength)
+	for _, m := range magicNumbers {
+		if bytes.HasPrefix(peeked, []byte(m.magic)) {
+			return &notCsvError{name, m.format, m.hint + ", or use --no-sniff to read it anyway"}
+		}
+	}
+	if bytes.IndexByte(peeked, 0) >= 0 {
+		return &notCsvError{name, "binary data (it contains NUL bytes)",
+			"use --encoding utf-16le or utf-16be for UTF-16 text without a BOM, or use --no-sniff to read it anyway"}
+	}
+	if looksLikeJson(peeked) {
+		return &notCsvError{name, "JSON", "did you mean the from-json subcommand?"}
+	}
+	return nil
+}
+
+// looksLikeJson reports whether peeked, ignoring any leading BOM and whitespace, begins with a JSON object or array
+// and is valid JSON up to where it ends, which may be in the middle of a value. A CSV header which merely begins
+// with a brace or bracket, such as [weird], is not valid JSON.
+func looksLikeJson(peeked []byte) bool {
+	peeked = bytes.TrimLeft(bytes.TrimPrefix(peeked, utf8BOM), " \t\r\n")
+	if len(peeked) == 0 || (peeked[0] != '{' && peeked[0] != '[') {
+		return false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(peeked))
+	for {
+		if _, err := dec.Token(); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return true
+		} else if err != nil {
+			return false
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSniffInput(t *testing.T) {
+	for _, tt := range []struct {
+		testName   string
+		csvData    string
+		wantFormat string
+	}{
+		{"ZIP archive", "PK\x03\x04\x14\x00\x06\x00", "a ZIP archive (such as an .xlsx workbook)"},
+		{"gzip", "\x1f\x8b\x08\x00\x00\x00\x00\x00", "gzip-compressed data"},
+		{"Parquet", "PAR1\x15\x04\x15\x10", "a Parquet file"},
+		{"PDF", "%PDF-1.7\n%\xe2\xe3\xcf\xd3\n", "a PDF document"},
+		{"NUL bytes", "a\x00,\x00b\x00\n\x00", "binary data (it contains NUL bytes)"},
+		{"NUL byte after the header", "a,b\n1," + strings.Repeat("2", 900) + "\x00\n", "binary data (it contains NUL bytes)"},
+		{"Textual CSV beginning with PK", "PK,Name\n1,ann\n", ""},
+		{"Textual CSV beginning with %PDF", "%PDF,PAR\n1,2\n", ""},
+		{"Textual CSV with control and non-ASCII bytes", "\uFEFF\x1b[1mé\x1b[0m,\x7f\n1,2\n", ""},
+		{"JSON array", "[\n  {\"id\": 1, \"name\": \"ann\"},\n  {\"id\": 2", "JSON"},
+		{"JSON object", "\uFEFF  {\"id\": 1}\n", "JSON"},
+		{"Newline-delimited JSON", "{\"id\": 1}\n{\"id\": 2}\n", "JSON"},
+		{"Header beginning with a bracket", "[weird],b\n1,2\n", ""},
+		{"Header beginning with a brace", "{id},name\n1,ann\n", ""},
+		{"Header of bracketed values", "[1],[2]\n1,2\n", ""},
+		{"NUL byte beyond the sniffed bytes", "a,b\n1," + strings.Repeat("2", sniffLength) + "\x00\n", ""},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			err := csv2Json(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(tt.csvData)},
+				jsonOutput: bytes.NewBuffer([]byte{}),
+				skipErrors: true,
+			})
+
+			var notCsv *notCsvError
+			if tt.wantFormat == "" {
+				assert.NoError(t, err)
+			} else if assert.ErrorAs(t, err, &notCsv) {
+				assert.Equal(t, tt.wantFormat, notCsv.format)
+				assert.Regexp(t, "--no-sniff|from-json", err.Error())
+			}
+		})
+	}
+}
+
+func TestNoSniff(t *testing.T) {
+	jsonStream := bytes.NewBuffer([]byte{})
+	err := csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader("PAR1,b\n1,\x00\n")},
+		jsonOutput: jsonStream,
+		noSniff:    true,
+	})
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"PAR1": "1", "b": "\u0000"}]`, jsonStream.String())
+}
+
+func TestSniffLaterInput(t *testing.T) {
+	err := csv2Json(conversionOptions{
+		csvInputs: []io.Reader{
+			strings.NewReader("a,b\n1,2\n"),
+			strings.NewReader("\x1f\x8b\x08\x00"),
+		},
+		jsonOutput: bytes.NewBuffer([]byte{}),
+		skipErrors: true,
+	})
+
+	assert.EqualError(t, err,
+		"input 2: input looks like gzip-compressed data, not CSV — use --compression auto to decompress it, "+
+			"or use --no-sniff to read it anyway")
+}
+
+func TestSniffJson(t *testing.T) {
+	err := csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader(`[{"id": "1"}]`)},
+		jsonOutput: bytes.NewBuffer([]byte{}),
+	})
+	assert.EqualError(t, err, "input 1: input looks like JSON, not CSV — did you mean the from-json subcommand?")
+
+	jsonStream := bytes.NewBuffer([]byte{})
+	err = csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader("[weird],b\n1,2\n")},
+		jsonOutput: jsonStream,
+	})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"[weird]": "1", "b": "2"}]`, jsonStream.String(), "Peeked bytes should be read as CSV")
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sortModes are the ways of comparing values accepted by --sort-by. auto compares numbers numerically before any
+// strings, which compare lexically, string compares every value lexically, numeric compares values as numbers, and
+// natural compares runs of digits within values as integers, so that file2 sorts before file10.
+var sortModes = []string{"auto", "string", "numeric", "natural"}
+
+// sortKey is one of the keys given to --sort-by, by which records or groups are ordered.
+type sortKey struct {
+	field      string
+	descending bool
+	// mode is one of sortModes
+	mode string
+}
+
+// parseSortKeys parses a --sort-by spec, which is a comma-separated list of keys, each given as field, field:dir, or
+// field:dir:mode, where dir is asc or desc and mode is one of sortModes. The direction defaults to asc and the mode
+// to auto. Returns a *usageError if the spec is invalid.
+func parseSortKeys(spec string) ([]sortKey, error) {
+	var keys []sortKey
+	for _, part := range strings.Split(spec, ",") {
+		key := sortKey{field: strings.TrimSpace(part), mode: "auto"}
+		if fields := strings.Split(key.field, ":"); len(fields) > 1 {
+			key.field = fields[0]
+			if len(fields) > 3 || (fields[1] != "asc" && fields[1] != "desc") {
+				return nil, usageErrorf("invalid --sort-by key %q (expected field[:asc|desc[:mode]])", part)
+			}
+			key.descending = fields[1] == "desc"
+			if len(fields) == 3 {
+				key.mode = fields[2]
+				if !containsString(sortModes, key.mode) {
+					return nil, usageErrorf("unknown --sort-by mode %q (expected one of %s)", key.mode,
+						strings.Join(sortModes, ", "))
+				}
+			}
+		}
+		if key.field == "" {
+			return nil, usageErrorf("invalid --sort-by key %q (expected field[:asc|desc[:mode]])", part)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// checkSortFields returns a *usageError unless the field of each of keys is one of fields, since records would
+// otherwise be left in their original order.
+func checkSortFields(keys []sortKey, fields []string) error {
+	for _, key := range keys {
+		if !containsString(fields, key.field) {
+			return usageErrorf("unknown --sort-by field %q", key.field)
+		}
+	}
+	return nil
+}
+
+// sortOrder returns the indexes of n items in the order given by keys, where value returns the named field of the
+// ith item. Items are compared by each key in turn, and those whose keys are all equal keep their order.
+func sortOrder(n int, keys []sortKey, value func(i int, field string) interface{}) []int {
+	values := make([][]interface{}, n)
+	order := make([]int, n)
+	for i := range values {
+		order[i] = i
+		values[i] = make([]interface{}, len(keys))
+		for k, key := range keys {
+			values[i][k] = value(i, key.field)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		for k, key := range keys {
+			if c := compareSortValues(values[order[i]][k], values[order[j]][k], key); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	return order
+}
+
+// sortRecords orders records in place by keys, as by sortOrder(). Fields missing from a record sort last.
+func sortRecords(records []record, keys []sortKey) {
+	order := sortOrder(len(records), keys, func(i int, field string) interface{} {
+		if v, ok := records[i][field]; ok {
+			return v
+		}
+		return nil
+	})
+	sorted := make([]record, len(records))
+	for i, j := range order {
+		sorted[i] = records[j]
+	}
+	copy(records, sorted)
+}
+
+// shuffleRecords orders records in place uniformly at random by a Fisher-Yates shuffle, which is always the same for
+// the same seed.
+func shuffleRecords(records []record, seed int64) {
+	random := rand.New(rand.NewSource(seed))
+	for i := len(records) - 1; i > 0; i-- {
+		j := random.Intn(i + 1)
+		records[i], records[j] = records[j], records[i]
+	}
+}
+
+// compareSortValues compares a and b by the mode of key, returning a negative number when a sorts first, a positive
+// one when b does, and 0 when they are equal. Nulls, and values which are not numbers in numeric mode, sort last in
+// either direction.
+func compareSortValues(a, b interface{}, key sortKey) int {
+	av, aOk := sortValue(a, key.mode)
+	bv, bOk := sortValue(b, key.mode)
+	if !aOk || !bOk {
+		return compareBools(!aOk, !bOk)
+	}
+
+	var c int
+	switch key.mode {
+	case "natural":
+		c = compareNatural(av.(string), bv.(string))
+	default:
+		an, aIsNum := av.(float64)
+		bn, bIsNum := bv.(float64)
+		switch {
+		case aIsNum && bIsNum:
+			c = compareBools(bn < an, an < bn)
+		case aIsNum != bIsNum:
+			c = compareBools(bIsNum, aIsNum)
+		default:
+			c = strings.Compare(av.(string), bv.(string))
+		}
+	}
+	if key.descending {
+		return -c
+	}
+	return c
+}
+
+// sortValue converts v for comparing by mode, to a float64 for a number or a string otherwise, reporting whether
+// it can be compared at all. Numbers in strings are only parsed in numeric mode.
+func sortValue(v interface{}, mode string) (interface{}, bool) {
+	if v == nil {
+		return nil, false
+	}
+	n, isNum := toFloat(v)
+	switch mode {
+	case "numeric":
+		if s, ok := v.(string); ok {
+			var err error
+			n, err = strconv.ParseFloat(strings.TrimSpace(s), 64)
+			isNum = err == nil && !math.IsNaN(n)
+		}
+		return n, isNum
+	case "auto":
+		if isNum {
+			return n, true
+		}
+	}
+	return fmt.Sprint(v), true
+}
+
+// compareNatural compares strings lexically, except that runs of ASCII digits are compared by their integer values.
+func compareNatural(a, b string) int {
+	for a != "" && b != "" {
+		aChunk, bChunk := naturalChunk(a), naturalChunk(b)
+		a, b = a[len(aChunk):], b[len(bChunk):]
+		if isDigit(aChunk[0]) && isDigit(bChunk[0]) {
+			aChunk, bChunk = strings.TrimLeft(aChunk, "0"), strings.TrimLeft(bChunk, "0")
+			if len(aChunk) != len(bChunk) {
+				return compareBools(len(bChunk) < len(aChunk), len(aChunk) < len(bChunk))
+			}
+		}
+		if c := strings.Compare(aChunk, bChunk); c != 0 {
+			return c
+		}
+	}
+	return compareBools(b == "", a == "")
+}
+
+// naturalChunk returns the leading run of s which consists either entirely of ASCII digits or of no digits.
+func naturalChunk(s string) string {
+	i := 1
+	for i < len(s) && isDigit(s[i]) == isDigit(s[0]) {
+		i++
+	}
+	return s[:i]
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// compareBools returns 1 when only greater is set, -1 when only less is set, and 0 otherwise.
+func compareBools(greater, less bool) int {
+	switch {
+	case greater && !less:
+		return 1
+	case less && !greater:
+		return -1
+	}
+	return 0
+}
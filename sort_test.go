@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseSortKeys(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		spec     string
+		wantKeys []sortKey
+		wantErr  string
+	}{
+		{"Defaults to ascending auto", "dept", []sortKey{{"dept", false, "auto"}}, ""},
+		{
+			"Parses directions and modes",
+			"dept:asc, salary:desc:numeric,name:asc:natural",
+			[]sortKey{{"dept", false, "auto"}, {"salary", true, "numeric"}, {"name", false, "natural"}},
+			"",
+		},
+		{"Unknown direction", "dept:up", nil, `invalid --sort-by key "dept:up" (expected field[:asc|desc[:mode]])`},
+		{"Unknown mode", "dept:asc:roman", nil,
+			`unknown --sort-by mode "roman" (expected one of auto, string, numeric, natural)`},
+		{"Empty key", "dept,", nil, `invalid --sort-by key "" (expected field[:asc|desc[:mode]])`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			keys, err := parseSortKeys(tt.spec)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantKeys, keys)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompareNatural(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want int
+	}{
+		{"file2", "file10", -1},
+		{"file10", "file2", 1},
+		{"file02", "file2", 0},
+		{"file", "file1", -1},
+		{"a10b2", "a10b10", -1},
+		{"10", "9", 1},
+		{"b", "a1", 1},
+		{"", "", 0},
+	} {
+		t.Run(tt.a+" vs "+tt.b, func(t *testing.T) {
+			assert.Equal(t, tt.want, compareNatural(tt.a, tt.b))
+		})
+	}
+}
+
+func TestCsv2JsonSortBy(t *testing.T) {
+	staffCsv := "id,dept,salary,name\n1,eng,90,file10\n2,ops,n/a,file2\n3,eng,100,file2\n4,ops,80,file1\n" +
+		"5,eng,90,file9\n6,ops,,file2\n"
+
+	for _, tt := range []struct {
+		testName  string
+		sortBy    string
+		reverse   bool
+		batchSize int
+		wantIDs   string
+		wantErr   string
+	}{
+		{"Compares strings lexically by default", "salary", false, 0, "6,3,4,1,5,2", ""},
+		{"Compares numbers, with non-numeric values last in either direction", "salary:desc:numeric", false, 0,
+			"3,1,5,4,2,6", ""},
+		{"Compares embedded integers naturally", "name:asc:natural", false, 0, "4,2,3,6,5,1", ""},
+		{"Compares strings lexically", "name:asc:string", false, 0, "4,1,2,3,6,5", ""},
+		{"Compares composite keys, keeping equal records in input order", "dept,salary:desc:numeric,name:asc:natural",
+			false, 0, "3,5,1,4,2,6", ""},
+		{"Reverses after sorting", "dept", true, 0, "6,4,2,5,3,1", ""},
+		{"Cannot stream", "dept", false, 1, "", "--sort-by cannot be used when records are streamed"},
+		{"Invalid spec", "dept:sideways", false, 0, "",
+			`invalid --sort-by key "dept:sideways" (expected field[:asc|desc[:mode]])`},
+		{"Unknown field", "dept,salery", false, 0, "", `unknown --sort-by field "salery"`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+
+			err := csv2Json(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(staffCsv)},
+				jsonOutput: jsonStream,
+				batchSize:  tt.batchSize,
+				sortBy:     tt.sortBy,
+				reverse:    tt.reverse,
+				pluck:      "id",
+			})
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.JSONEq(t, `["`+strings.ReplaceAll(tt.wantIDs, ",", `","`)+`"]`, jsonStream.String())
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCsv2JsonSortByRenamedField(t *testing.T) {
+	options := conversionOptions{renames: map[string]string{"n": "name"}, pluck: "id"}
+	for _, tt := range []struct {
+		sortBy   string
+		wantJson string
+		wantErr  string
+	}{
+		{"name", `["2","1"]`, ""},
+		{"n", "", `unknown --sort-by field "n"`},
+	} {
+		t.Run(tt.sortBy, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+			options.csvInputs = []io.Reader{strings.NewReader("id,n\n1,b\n2,a\n")}
+			options.jsonOutput, options.sortBy = jsonStream, tt.sortBy
+
+			err := csv2Json(options)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, jsonStream.String())
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, exitUsage, exitCode(err))
+			}
+		})
+	}
+}
+
+func TestCsv2JsonShuffle(t *testing.T) {
+	csvData := "n\n0\n1\n2\n3\n4\n5\n6\n7\n"
+
+	for _, tt := range []struct {
+		testName   string
+		seed       int64
+		sortBy     string
+		reverse    bool
+		maxRecords int
+		wantJson   string
+		wantErr    string
+	}{
+		{"Shuffles in the same order for a seed", 42, "", false, 0, `["4","5","7","3","0","6","2","1"]`, ""},
+		{"Shuffles in another order for another seed", 7, "", false, 0, `["1","4","2","0","5","3","7","6"]`, ""},
+		{"Respects the limit", 42, "", false, 5, "",
+			"input exceeds 5 records; rerun with streaming output or raise the limit"},
+		{"Cannot sort", 42, "n", false, 0, "", "--shuffle cannot be combined with --sort-by or --reverse"},
+		{"Cannot reverse", 42, "", true, 0, "", "--shuffle cannot be combined with --sort-by or --reverse"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+
+			err := csv2Json(conversionOptions{
+				csvInputs:   []io.Reader{strings.NewReader(csvData)},
+				jsonOutput:  jsonStream,
+				maxRecords:  tt.maxRecords,
+				sortBy:      tt.sortBy,
+				reverse:     tt.reverse,
+				shuffle:     true,
+				shuffleSeed: tt.seed,
+				pluck:       "n",
+			})
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, jsonStream.String())
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCsv2JsonLimitAfterOrdering(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		csvData  string
+		options  conversionOptions
+		wantJson string
+	}{
+		{"Limits as read without ordering", "n\n1\n2\n3\n4\n5\n", conversionOptions{limit: 2}, `["1","2"]`},
+		{"Limits after reversing", "n\n1\n2\n3\n4\n5\n", conversionOptions{reverse: true, limit: 2}, `["5","4"]`},
+		{"Limits after sorting", "n\n3\n1\n5\n2\n4\n", conversionOptions{sortBy: "n:desc", limit: 2}, `["5","4"]`},
+		{"Limits after shuffling", "n\n0\n1\n2\n3\n4\n5\n6\n7\n",
+			conversionOptions{shuffle: true, shuffleSeed: 42, limit: 2}, `["4","5"]`},
+		{"Offsets as read before reversing", "n\n1\n2\n3\n4\n5\n",
+			conversionOptions{reverse: true, offset: 1, limit: 2}, `["5","4"]`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+			tt.options.csvInputs = []io.Reader{strings.NewReader(tt.csvData)}
+			tt.options.jsonOutput = jsonStream
+			tt.options.pluck = "n"
+
+			assert.NoError(t, csv2Json(tt.options))
+			assert.JSONEq(t, tt.wantJson, jsonStream.String())
+		})
+	}
+}
+
+func TestShuffleRecordsKeepsEveryRecord(t *testing.T) {
+	records := make([]record, 100)
+	for i := range records {
+		records[i] = record{"n": strconv.Itoa(i)}
+	}
+
+	shuffleRecords(records, 1)
+
+	seen := make(map[string]int)
+	moved := 0
+	for i, rec := range records {
+		seen[rec["n"]]++
+		if rec["n"] != strconv.Itoa(i) {
+			moved++
+		}
+	}
+	assert.Len(t, seen, 100, "Every record should be present")
+	for n, count := range seen {
+		assert.Equal(t, 1, count, "Record %s should be present exactly once", n)
+	}
+	assert.NotZero(t, moved, "Records should be reordered")
+}
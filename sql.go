@@ -0,0 +1,170 @@
+package main
+
+import (
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// sqlDialects are the dialects accepted by --sql-dialect, which determine how identifiers and strings are quoted.
+var sqlDialects = []string{"postgres", "mysql", "sqlite"}
+
+// sqlOptions is used to configure emitting records as SQL INSERT statements when calling csv2Sql().
+type sqlOptions struct {
+	table string
+	// dialect is one of sqlDialects
+	dialect string
+	// batchSize is the most records which a single statement inserts
+	batchSize int
+}
+
+// bareIdentifier matches the table names accepted by --table, which need no quoting in any of sqlDialects.
+var bareIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// checkSqlOptions returns a *usageError unless sqlOpts name a bare table, one of sqlDialects, and a positive batch.
+func checkSqlOptions(sqlOpts sqlOptions) error {
+	if sqlOpts.table == "" {
+		return usageErrorf("--format sql requires --table")
+	} else if !bareIdentifier.MatchString(sqlOpts.table) {
+		return usageErrorf("--table %q is not a bare identifier (expected letters, digits, and underscores)",
+			sqlOpts.table)
+	} else if !containsString(sqlDialects, sqlOpts.dialect) {
+		return usageErrorf("unknown --sql-dialect %q (expected one of %s)", sqlOpts.dialect,
+			strings.Join(sqlDialects, ", "))
+	} else if sqlOpts.batchSize < 1 {
+		return usageErrorf("--sql-batch must be at least 1")
+	}
+	return nil
+}
+
+// quoteSqlIdentifier quotes name as an identifier of dialect, with backticks for mysql and double quotes otherwise.
+func quoteSqlIdentifier(name, dialect string) string {
+	quote := `"`
+	if dialect == "mysql" {
+		quote = "`"
+	}
+	return quote + strings.ReplaceAll(name, quote, quote+quote) + quote
+}
+
+// quoteSqlString quotes value as a string literal of dialect, doubling single quotes. Backslashes are doubled too
+// for mysql, which otherwise reads them as escapes.
+func quoteSqlString(value, dialect string) string {
+	if dialect == "mysql" {
+		value = strings.ReplaceAll(value, `\`, `\\`)
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// columnOrder ranks the fields of records by the first of the column names they are named by, so that they can be
+// written in column order where formats have an order, unlike JSON objects.
+type columnOrder map[string]int
+
+// newColumnOrder returns the columnOrder of colNames.
+func newColumnOrder(colNames []string) columnOrder {
+	order := make(columnOrder, len(colNames))
+	for i, name := range colNames {
+		if _, ok := order[name]; !ok {
+			order[name] = i
+		}
+	}
+	return order
+}
+
+// keys returns the keys of rec in column order, as by sort().
+func (o columnOrder) keys(rec record) []string {
+	keys := make([]string, 0, len(rec))
+	for k := range rec {
+		keys = append(keys, k)
+	}
+	o.sort(keys)
+	return keys
+}
+
+// sort sorts names in place in column order, followed by any which are not column names, such as those added by
+// --row-number, in sorted order.
+func (o columnOrder) sort(names []string) {
+	rank := func(key string) int {
+		if r, ok := o[key]; ok {
+			return r
+		}
+		return math.MaxInt32
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if ri, rj := rank(names[i]), rank(names[j]); ri != rj {
+			return ri < rj
+		}
+		return names[i] < names[j]
+	})
+}
+
+// csv2Sql converts CSV data from `options.csvInputs` to SQL statements, emitted to `options.jsonOutput`, which insert
+// each record into `sqlOpts.table`, with up to `sqlOpts.batchSize` records per statement. Consecutive records with the
+// same fields share a statement, whose columns are in column order. Values among `options.nullValues` are NULL,
+// and every other value is a string. Identifiers and strings are quoted for `sqlOpts.dialect`.
+// Statements are emitted as they are converted.
+// Returns a *usageError for invalid sqlOpts, as by checkSqlOptions(), or any errors from reading CSV or writing SQL.
+func csv2Sql(options conversionOptions, sqlOpts sqlOptions) error {
+	if err := checkSqlOptions(sqlOpts); err != nil {
+		return err
+	}
+	reader, colNames, err := newCsvRowReader(options)
+	if err != nil {
+		return err
+	}
+	order := newColumnOrder(colNames)
+
+	var columns []string
+	var rows []string
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		quoted := make([]string, len(columns))
+		for i, column := range columns {
+			quoted[i] = quoteSqlIdentifier(column, sqlOpts.dialect)
+		}
+		statement := "INSERT INTO " + sqlOpts.table + " (" + strings.Join(quoted, ", ") + ") VALUES"
+		if len(rows) > 1 {
+			statement += "\n  " + strings.Join(rows, ",\n  ")
+		} else {
+			statement += " " + rows[0]
+		}
+		rows = rows[:0]
+		if _, err := io.WriteString(options.jsonOutput, statement+";\n"); err != nil {
+			return &outputError{err}
+		}
+		return nil
+	}
+	err = eachReaderBatch(options, reader, colNames, 1, func(batch []record) error {
+		for _, rec := range batch {
+			keys := order.keys(rec)
+			if !equalStrings(keys, columns) {
+				if err := flush(); err != nil {
+					return err
+				}
+				columns = keys
+			}
+			values := make([]string, len(keys))
+			for i, k := range keys {
+				if containsString(options.nullValues, rec[k]) {
+					values[i] = "NULL"
+				} else {
+					values[i] = quoteSqlString(rec[k], sqlOpts.dialect)
+				}
+			}
+			rows = append(rows, "("+strings.Join(values, ", ")+")")
+			if len(rows) == sqlOpts.batchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
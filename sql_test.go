@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"github.com/integrii/flaggy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckSqlOptions(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		sqlOpts  sqlOptions
+		wantErr  string
+	}{
+		{"Valid", sqlOptions{"people_2", "sqlite", 10}, ""},
+		{"Missing table", sqlOptions{"", "postgres", 1}, "--format sql requires --table"},
+		{"Qualified table", sqlOptions{"public.people", "postgres", 1},
+			`--table "public.people" is not a bare identifier (expected letters, digits, and underscores)`},
+		{"Leading digit", sqlOptions{"2people", "postgres", 1},
+			`--table "2people" is not a bare identifier (expected letters, digits, and underscores)`},
+		{"Unknown dialect", sqlOptions{"people", "oracle", 1},
+			`unknown --sql-dialect "oracle" (expected one of postgres, mysql, sqlite)`},
+		{"Empty batch", sqlOptions{"people", "mysql", 0}, "--sql-batch must be at least 1"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			err := checkSqlOptions(tt.sqlOpts)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, exitUsage, exitCode(err))
+			}
+		})
+	}
+}
+
+func TestCsv2Sql(t *testing.T) {
+	csvData := "id,name,\"say \"\"hi\"\"\"\n1,O'Brien,\"two\nlines\"\n2,,C:\\temp\n3,Zoë,日本語\n"
+
+	for _, tt := range []struct {
+		testName   string
+		sqlOpts    sqlOptions
+		nullValues []string
+		wantSql    string
+	}{
+		{"Postgres", sqlOptions{"people", "postgres", 1}, nil,
+			`INSERT INTO people ("id", "name", "say ""hi""") VALUES ('1', 'O''Brien', 'two` + "\n" + `lines');` + "\n" +
+				`INSERT INTO people ("id", "name", "say ""hi""") VALUES ('2', '', 'C:\temp');` + "\n" +
+				`INSERT INTO people ("id", "name", "say ""hi""") VALUES ('3', 'Zoë', '日本語');` + "\n"},
+		{"MySQL", sqlOptions{"people", "mysql", 1}, []string{""},
+			"INSERT INTO people (`id`, `name`, `say \"hi\"`) VALUES ('1', 'O''Brien', 'two\nlines');\n" +
+				"INSERT INTO people (`id`, `name`, `say \"hi\"`) VALUES ('2', NULL, 'C:\\\\temp');\n" +
+				"INSERT INTO people (`id`, `name`, `say \"hi\"`) VALUES ('3', 'Zoë', '日本語');\n"},
+		{"Batched", sqlOptions{"people", "sqlite", 2}, []string{"", "NULL"},
+			`INSERT INTO people ("id", "name", "say ""hi""") VALUES` + "\n" +
+				`  ('1', 'O''Brien', 'two` + "\n" + `lines'),` + "\n" +
+				`  ('2', NULL, 'C:\temp');` + "\n" +
+				`INSERT INTO people ("id", "name", "say ""hi""") VALUES ('3', 'Zoë', '日本語');` + "\n"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, csv2Sql(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(csvData)},
+				jsonOutput: &buf,
+				nullValues: tt.nullValues,
+			}, tt.sqlOpts))
+			assert.Equal(t, tt.wantSql, buf.String())
+		})
+	}
+}
+
+func TestCsv2SqlColumnsChange(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, csv2Sql(conversionOptions{
+		csvInputs:       []io.Reader{strings.NewReader("b,a\n1,2\n3,4,5\n6,7\n")},
+		jsonOutput:      &buf,
+		keepExtraFields: true,
+	}, sqlOptions{"t", "postgres", 10}))
+	assert.Equal(t, `INSERT INTO t ("b", "a") VALUES ('1', '2');`+"\n"+
+		`INSERT INTO t ("b", "a", "_extra_1") VALUES ('3', '4', '5');`+"\n"+
+		`INSERT INTO t ("b", "a") VALUES ('6', '7');`+"\n", buf.String(),
+		"Columns should be in column order, and records with other fields should start another statement")
+}
+
+func TestCliSqlRejectsOrdering(t *testing.T) {
+	for _, tt := range []struct {
+		cliArgs []string
+		wantErr string
+	}{
+		{[]string{"--sort-by", "a"}, "--sort-by cannot be used when records are streamed"},
+		{[]string{"--reverse"}, "--reverse cannot be used when records are streamed or aggregated"},
+		{[]string{"--shuffle"}, "--shuffle cannot be used when records are streamed or aggregated"},
+	} {
+		t.Run(strings.Join(tt.cliArgs, " "), func(t *testing.T) {
+			dir := t.TempDir()
+			csvFileName := filepath.Join(dir, "in.csv")
+			require.NoError(t, ioutil.WriteFile(csvFileName, []byte("a\n1\n"), 0600))
+			outputFileName := filepath.Join(dir, "out.sql")
+			os.Args = append([]string{"csv2json", "--format", "sql", "--table", "t", "-o", outputFileName, csvFileName},
+				tt.cliArgs...)
+			flaggy.ResetParser()
+
+			err := runCli()
+			assert.EqualError(t, err, tt.wantErr)
+			assert.Equal(t, exitUsage, exitCode(err))
+			assert.NoFileExists(t, outputFileName, "Nothing should be written")
+		})
+	}
+}
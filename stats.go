@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// defaultDistinctLimit is the default number of distinct values of a column which the stats subcommand counts
+// exactly, beyond which it only reports that there are at least as many.
+const defaultDistinctLimit = 10000
+
+// statsReport summarizes the rows of CSV inputs, as reported by the stats subcommand. Rows counts every data row
+// read, including empty rows and the RowsWithErrors which were skipped by --skip-errors.
+type statsReport struct {
+	Rows           int           `json:"rows"`
+	RowsWithErrors int           `json:"rows_with_errors"`
+	Columns        []columnStats `json:"columns"`
+}
+
+// columnStats summarizes the values of a column. Empty counts the records whose value is empty, and Distinct counts
+// the distinct non-empty values, or is a string such as ">=10000" once there are more than the distinct limit.
+type columnStats struct {
+	Name     string      `json:"name"`
+	Empty    int         `json:"empty"`
+	Distinct interface{} `json:"distinct"`
+
+	// values holds the distinct values until there are more than the distinct limit, when it is set to nil
+	values map[string]bool
+}
+
+// csvStats reads every record of `options.csvInputs`, one at a time, and reports how many rows were read and skipped,
+// along with the number of empty and distinct values of each column, in column order followed by any others, such as
+// extra fields, in sorted order. No more than distinctLimit distinct values of each column are kept in memory.
+// Returns any errors from reading CSV.
+func csvStats(options conversionOptions, distinctLimit int) (statsReport, error) {
+	if options.stats == nil {
+		options.stats = &conversionStats{}
+	}
+	reader, colNames, err := newCsvRowReader(options)
+	if err != nil {
+		return statsReport{}, err
+	}
+	columns := make(map[string]*columnStats, len(colNames))
+	for _, name := range colNames {
+		columns[name] = &columnStats{Name: name, values: make(map[string]bool)}
+	}
+	err = eachReaderBatch(options, reader, colNames, 1, func(batch []record) error {
+		for _, rec := range batch {
+			for k, v := range rec {
+				column, ok := columns[k]
+				if !ok {
+					column = &columnStats{Name: k, values: make(map[string]bool)}
+					columns[k] = column
+				}
+				if v == "" {
+					column.Empty++
+				} else if column.values != nil && !column.values[v] {
+					if len(column.values) == distinctLimit {
+						column.values = nil
+					} else {
+						column.values[v] = true
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return statsReport{}, err
+	}
+
+	report := statsReport{Rows: options.stats.rowsRead, RowsWithErrors: options.stats.rowsSkipped,
+		Columns: make([]columnStats, 0, len(columns))}
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		names = append(names, name)
+	}
+	newColumnOrder(colNames).sort(names)
+	for _, name := range names {
+		column := columns[name]
+		if column.values != nil {
+			column.Distinct = len(column.values)
+		} else {
+			column.Distinct = ">=" + strconv.Itoa(distinctLimit)
+		}
+		report.Columns = append(report.Columns, *column)
+	}
+	return report, nil
+}
+
+// writeStatsReport writes report to w as indented JSON, without escaping the ">" of capped distinct counts.
+func writeStatsReport(w io.Writer, report statsReport) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCsvStats(t *testing.T) {
+	discardLogs(t)
+
+	csvData := "id,city,note\n" +
+		"1,Paris,\n" +
+		"2,Oslo,x\n" +
+		"3,too,many,fields\n" +
+		"4,Paris,\n" +
+		"5,,y\n"
+
+	for _, tt := range []struct {
+		testName      string
+		csvData       string
+		options       conversionOptions
+		distinctLimit int
+		wantReport    string
+	}{
+		{"Empties and duplicates", csvData, conversionOptions{skipErrors: true}, defaultDistinctLimit, `{
+  "rows": 5,
+  "rows_with_errors": 1,
+  "columns": [
+    {
+      "name": "id",
+      "empty": 0,
+      "distinct": 4
+    },
+    {
+      "name": "city",
+      "empty": 1,
+      "distinct": 2
+    },
+    {
+      "name": "note",
+      "empty": 2,
+      "distinct": 2
+    }
+  ]
+}
+`},
+		{"Distinct values are capped", csvData, conversionOptions{skipErrors: true}, 3, `{
+  "rows": 5,
+  "rows_with_errors": 1,
+  "columns": [
+    {
+      "name": "id",
+      "empty": 0,
+      "distinct": ">=3"
+    },
+    {
+      "name": "city",
+      "empty": 1,
+      "distinct": 2
+    },
+    {
+      "name": "note",
+      "empty": 2,
+      "distinct": 2
+    }
+  ]
+}
+`},
+		{"Forced columns", "1,a\n2,a\n", conversionOptions{colNames: []string{"n", "letter"}}, defaultDistinctLimit, `{
+  "rows": 2,
+  "rows_with_errors": 0,
+  "columns": [
+    {
+      "name": "n",
+      "empty": 0,
+      "distinct": 2
+    },
+    {
+      "name": "letter",
+      "empty": 0,
+      "distinct": 1
+    }
+  ]
+}
+`},
+		{"Delimiter", "a;b\n1;\n", conversionOptions{dialect: csvDialect{delimiter: ';'}}, defaultDistinctLimit, `{
+  "rows": 1,
+  "rows_with_errors": 0,
+  "columns": [
+    {
+      "name": "a",
+      "empty": 0,
+      "distinct": 1
+    },
+    {
+      "name": "b",
+      "empty": 1,
+      "distinct": 0
+    }
+  ]
+}
+`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			tt.options.csvInputs = []io.Reader{strings.NewReader(tt.csvData)}
+			report, err := csvStats(tt.options, tt.distinctLimit)
+			require.NoError(t, err)
+			var buf bytes.Buffer
+			require.NoError(t, writeStatsReport(&buf, report))
+			assert.Equal(t, tt.wantReport, buf.String())
+		})
+	}
+}
+
+func TestCsvStatsErrors(t *testing.T) {
+	_, err := csvStats(conversionOptions{csvInputs: []io.Reader{strings.NewReader("a,b\n1,2,3\n")}},
+		defaultDistinctLimit)
+	assert.Error(t, err, "Rows with errors should not be skipped without --skip-errors")
+}
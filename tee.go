@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+)
+
+// teePrimaries are the destinations accepted by --tee-primary, an error writing to which aborts conversion.
+var teePrimaries = []string{"file", "stdout"}
+
+// teeWriter writes everything written to it to both stdout and a file, as for --tee. Errors writing to the primary
+// destination are returned, while an error writing to the other one only stops writing to it, so that the primary
+// destination still gets the complete output. The file is written to an atomicFile, so that it is never left partially
+// written.
+type teeWriter struct {
+	stdout   io.Writer
+	file     *atomicFile
+	fileName string
+	// primary is one of teePrimaries, and stdoutErr and fileErr hold the first error writing to each destination
+	primary   string
+	stdoutErr error
+	fileErr   error
+}
+
+// newTeeWriter returns a teeWriter which writes to stdout and a temporary file in the same directory as fileName,
+// aborting on errors writing to primary, which is one of teePrimaries.
+func newTeeWriter(stdout io.Writer, fileName, primary string) (*teeWriter, error) {
+	switch primary {
+	case "file", "stdout":
+	default:
+		return nil, usageErrorf("invalid --tee-primary %q (expected file or stdout)", primary)
+	}
+	file, err := createAtomicFile(fileName)
+	if err != nil {
+		return nil, &outputError{err}
+	}
+	return &teeWriter{stdout: stdout, file: file, fileName: fileName, primary: primary}, nil
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	if t.stdoutErr == nil {
+		if _, err := t.stdout.Write(p); err != nil {
+			t.stdoutErr = err
+			if t.primary == "stdout" {
+				return 0, err
+			}
+			logTeeStopped("stdout", err)
+		}
+	}
+	if t.fileErr == nil {
+		if _, err := t.file.Write(p); err != nil {
+			t.fileErr = err
+			if t.primary == "file" {
+				return 0, err
+			}
+			logTeeStopped(t.fileName, err)
+		}
+	}
+	return len(p), nil
+}
+
+// commit replaces the file named by --tee with what was written, unless writing to it failed or conversion failed
+// with convErr, in which case it is discarded instead, leaving any existing file in place.
+func (t *teeWriter) commit(convErr error) error {
+	if convErr != nil || t.fileErr != nil {
+		t.file.discard()
+		return nil
+	}
+	return t.file.commit()
+}
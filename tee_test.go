@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// failingWriter accepts the first n bytes written to it, and then fails with err.
+type failingWriter struct {
+	buf bytes.Buffer
+	n   int
+	err error
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > w.n {
+		return 0, w.err
+	}
+	return w.buf.Write(p)
+}
+
+func TestTeeWriter(t *testing.T) {
+	discardLogs(t)
+	csvData := "a,b\n1,2\n3,4\n5,6\n"
+	wantJson := "[{\"a\":\"1\",\"b\":\"2\"}]\n[{\"a\":\"3\",\"b\":\"4\"}]\n[{\"a\":\"5\",\"b\":\"6\"}]\n"
+	errPipe := errors.New("broken pipe")
+
+	for _, tt := range []struct {
+		testName    string
+		primary     string
+		stdoutLimit int
+		closeFile   bool
+		wantStdout  string
+		wantFile    bool
+		wantErr     error
+	}{
+		{"Writes both", "file", len(wantJson), false, wantJson, true, nil},
+		{"Finishes file when stdout fails", "file", 20, false, "", true, nil},
+		{"Aborts when stdout fails", "stdout", 20, false, "", false, errPipe},
+		{"Finishes stdout when file fails", "stdout", len(wantJson), true, wantJson, false, nil},
+		{"Aborts when file fails", "file", len(wantJson), true, wantJson[:20], false, os.ErrClosed},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "out.json")
+			stdout := &failingWriter{n: tt.stdoutLimit, err: errPipe}
+			tee, err := newTeeWriter(stdout, fileName, tt.primary)
+			require.NoError(t, err)
+			if tt.closeFile {
+				tee.file.file.Close()
+			}
+
+			// Each batch is written as it is converted, as with --batch and --flush-every 1
+			err = csv2Json(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(csvData)},
+				jsonOutput: tee,
+				batchSize:  1,
+			})
+			if err == nil {
+				err = tee.commit(nil)
+			} else {
+				assert.NoError(t, tee.commit(err))
+			}
+
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "Unexpected error %v", err)
+			} else {
+				assert.NoError(t, err)
+			}
+			if tt.wantStdout != "" {
+				assert.Equal(t, tt.wantStdout, stdout.buf.String())
+			}
+			if tt.wantFile {
+				data, err := ioutil.ReadFile(fileName)
+				require.NoError(t, err)
+				assert.Equal(t, wantJson, string(data), "File should have the complete output")
+			} else {
+				assert.NoFileExists(t, fileName)
+			}
+			assert.Equal(t, []string(nil), temporaryFiles(t, filepath.Dir(fileName)),
+				"Temporary files should be removed")
+		})
+	}
+}
+
+// temporaryFiles returns the names of the hidden files in dir.
+func temporaryFiles(t *testing.T, dir string) []string {
+	var names []string
+	for _, name := range sortedDirNames(t, dir) {
+		if strings.HasPrefix(name, ".") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func TestTeeWriterKeepsFileOnFailure(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "out.json")
+	require.NoError(t, ioutil.WriteFile(fileName, []byte("[]\n"), 0644))
+	var stdout bytes.Buffer
+	tee, err := newTeeWriter(&stdout, fileName, "file")
+	require.NoError(t, err)
+
+	_, err = tee.Write([]byte(`[{"a":`))
+	require.NoError(t, err)
+	assert.NoError(t, tee.commit(errors.New("conversion failed")))
+	data, err := ioutil.ReadFile(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, "[]\n", string(data), "Existing file should be left in place")
+
+	_, err = newTeeWriter(&stdout, fileName, "both")
+	assert.Equal(t, exitUsage, exitCode(err))
+}
+
+func TestCliTeeReplacesExistingFile(t *testing.T) {
+	discardLogs(t)
+
+	for _, tt := range []struct {
+		testName string
+		csvData  string
+		wantFile string
+	}{
+		{"Replaced once converted", "a\n1\n", "[{\"a\":\"1\"}]\n"},
+		{"Kept on error", "a\n1,2\n", "old"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			dir := t.TempDir()
+			csvFileName := filepath.Join(dir, "in.csv")
+			require.NoError(t, ioutil.WriteFile(csvFileName, []byte(tt.csvData), 0600))
+			teeFileName := filepath.Join(dir, "out.json")
+			require.NoError(t, ioutil.WriteFile(teeFileName, []byte("old"), 0600))
+
+			stdout, err := runTestCli(t, "--tee", teeFileName, csvFileName)
+
+			data, readErr := ioutil.ReadFile(teeFileName)
+			require.NoError(t, readErr)
+			assert.Equal(t, tt.wantFile, string(data))
+			if tt.wantFile == "old" {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantFile, stdout, "Both destinations should get the same output")
+			}
+			info, statErr := os.Stat(teeFileName)
+			require.NoError(t, statErr)
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "The file should keep its permissions")
+		})
+	}
+}
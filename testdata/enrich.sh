@@ -0,0 +1,3 @@
+#!/bin/sh
+# Filters records for TestFilterRecords: drops bob's record, and marks everyone else's name
+exec sed -e '/"name":"bob"/d' -e 's/"name":"\([^"]*\)"/"name":"\1!"/'
@@ -0,0 +1,19 @@
+package main
+
+import "strings"
+
+// trimmedSpace is the whitespace which trimValue() and trimHeaders() remove. Like csv.Reader.TrimLeadingSpace, it
+// does not include non-breaking spaces, which are more likely to be intentional.
+const trimmedSpace = " \t\r\n\v\f"
+
+// trimValue removes whitespace from either end of value, whether or not it was quoted.
+func trimValue(value string) string {
+	return strings.Trim(value, trimmedSpace)
+}
+
+// trimHeaders removes whitespace from either end of each of the header names in place.
+func trimHeaders(header []string) {
+	for i, name := range header {
+		header[i] = trimValue(name)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTrimValue(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		want  string
+	}{
+		{"  Name ", "Name"},
+		{"\ta b\t", "a b"},
+		{"\r\n a\n", "a"},
+		{"\u00a0a\u00a0", "\u00a0a\u00a0"},
+		{" \u00a0a ", "\u00a0a"},
+		{"   ", ""},
+	} {
+		t.Run(tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.want, trimValue(tt.value))
+		})
+	}
+}
+
+func TestCsv2JsonTrim(t *testing.T) {
+	discardLogs(t)
+
+	for _, tt := range []struct {
+		testName string
+		csvData  string
+		options  conversionOptions
+		wantJson string
+		wantErr  string
+	}{
+		{"Trims headers", " Name ,\tage\n ann , 5\n", conversionOptions{trimHeaders: true},
+			`[{"Name": " ann ", "age": " 5"}]`, ""},
+		{"Trims headers and values", " Name ,\tage\n ann ,\" 5\t\"\n",
+			conversionOptions{trimHeaders: true, transforms: []valueTransform{trimValue}},
+			`[{"Name": "ann", "age": "5"}]`, ""},
+		{"Keeps non-breaking spaces", "\u00a0a\n\u00a0b\u00a0\n",
+			conversionOptions{trimHeaders: true, transforms: []valueTransform{trimValue}},
+			`[{"\u00a0a": "\u00a0b\u00a0"}]`, ""},
+		{"Finds duplicates after trimming", "a, a\n1,2\n", conversionOptions{trimHeaders: true},
+			`[{"a": "1", "a_2": "2"}]`, ""},
+		{"Rejects duplicates after trimming", "a, a\n1,2\n", conversionOptions{trimHeaders: true, dupHeaders: "error"},
+			"", `header of input 1, column 2: duplicate column name "a"`},
+		{"Selects trimmed columns", " a , b \n1,2\n",
+			conversionOptions{trimHeaders: true, selectColumns: []string{"b"}}, `[{"b": "2"}]`, ""},
+		{"Only trims leading space outside quotes", "a,b\n  1,\"  2\"\n",
+			conversionOptions{dialect: csvDialect{trimLeadingSpace: true}}, `[{"a": "1", "b": "  2"}]`, ""},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var jsonStream bytes.Buffer
+			tt.options.csvInputs = []io.Reader{strings.NewReader(tt.csvData)}
+			tt.options.jsonOutput = &jsonStream
+			err := csv2Json(tt.options)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, jsonStream.String())
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+// truncateCell shortens value to at most maxLength runes followed by marker, reporting whether it was shortened.
+// Values of at most maxLength runes are returned unchanged.
+func truncateCell(value string, maxLength int, marker string) (string, bool) {
+	if len(value) <= maxLength {
+		// Every rune is at least one byte
+		return value, false
+	}
+	runes := 0
+	for i := range value {
+		if runes == maxLength {
+			return value[:i] + marker, true
+		}
+		runes++
+	}
+	return value, false
+}
+
+// truncatedFlagName is the name of the field which marks that the value of the column named colName was truncated.
+func truncatedFlagName(colName string) string {
+	return colName + "_truncated"
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestTruncateCell(t *testing.T) {
+	for _, tt := range []struct {
+		testName      string
+		value         string
+		maxLength     int
+		wantValue     string
+		wantTruncated bool
+	}{
+		{"Short value", "abc", 5, "abc", false},
+		{"Exact length", "abcde", 5, "abcde", false},
+		{"Long value", "abcdef", 5, "abcde…", true},
+		{"Multibyte value within length", "héllo", 5, "héllo", false},
+		{"Multibyte boundary", "naïveté", 3, "naï…", true},
+		{"Four-byte runes", "😀😁😂😃", 2, "😀😁…", true},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			got, truncated := truncateCell(tt.value, tt.maxLength, "…")
+			assert.Equal(t, tt.wantValue, got)
+			assert.Equal(t, tt.wantTruncated, truncated)
+		})
+	}
+}
+
+func TestCsv2JsonMaxCellLength(t *testing.T) {
+	csvData := "description,note\nöäüöäü,ok\nshort,également\n"
+
+	for _, tt := range []struct {
+		testName     string
+		truncateFlag bool
+		wantJson     string
+	}{
+		{
+			"Truncates values",
+			false,
+			`[{"description": "öäüöä[cut]", "note": "ok"}, {"description": "short", "note": "égale[cut]"}]`,
+		},
+		{
+			"Flags truncated values",
+			true,
+			`[
+				{"description": "öäüöä[cut]", "description_truncated": "true", "note": "ok"},
+				{"description": "short", "note": "égale[cut]", "note_truncated": "true"}
+			]`,
+		},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			logged := bytes.NewBuffer([]byte{})
+			oldLogOutput, oldLogFlags := log.Writer(), log.Flags()
+			log.SetOutput(logged)
+			log.SetFlags(0)
+			t.Cleanup(func() {
+				log.SetOutput(oldLogOutput)
+				log.SetFlags(oldLogFlags)
+			})
+			jsonStream := bytes.NewBuffer([]byte{})
+			stats := &conversionStats{}
+
+			err := csv2Json(conversionOptions{
+				csvInputs:      []io.Reader{strings.NewReader(csvData)},
+				maxCellLength:  5,
+				truncateMarker: "[cut]",
+				truncateFlag:   tt.truncateFlag,
+				jsonOutput:     jsonStream,
+				stats:          stats,
+			})
+
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.wantJson, jsonStream.String())
+			assert.Equal(t, 2, stats.cellsTruncated)
+			assert.Equal(t, "Truncated 2 values longer than 5 characters\n", logged.String())
+		})
+	}
+}
+
+func TestMaxCellLengthSkipsHeader(t *testing.T) {
+	jsonStream := bytes.NewBuffer([]byte{})
+	err := csv2Json(conversionOptions{
+		csvInputs:     []io.Reader{strings.NewReader("a_long_column_name\nvalue\n")},
+		maxCellLength: 3,
+		jsonOutput:    jsonStream,
+	})
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"a_long_column_name": "val"}]`, jsonStream.String())
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// columnTypes are the types accepted by --types, to which the values of a column are coerced.
+var columnTypes = []string{"int", "float", "bool", "string"}
+
+// parseColumnTypes parses --types entries each given as column:type, where type is one of columnTypes, into the
+// type of each column.
+// Returns a *usageError for malformed entries, unknown types, or columns given more than once.
+func parseColumnTypes(specs []string) (map[string]string, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	types := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		i := strings.LastIndex(spec, ":")
+		if i <= 0 {
+			return nil, usageErrorf("invalid --types entry %q (expected column:type)", spec)
+		}
+		column, typ := spec[:i], spec[i+1:]
+		if !containsString(columnTypes, typ) {
+			return nil, usageErrorf("unknown --types type %q for column %q (expected one of %s)", typ, column,
+				strings.Join(columnTypes, ", "))
+		} else if _, ok := types[column]; ok {
+			return nil, usageErrorf("column %q is given more than once by --types", column)
+		}
+		types[column] = typ
+	}
+	return types, nil
+}
+
+// checkTypeColumns returns a *usageError unless each column of types is one of colNames, or colNames is nil, as
+// when there is no header.
+func checkTypeColumns(types map[string]string, colNames []string) error {
+	if colNames == nil {
+		return nil
+	}
+	for _, column := range typedColumns(types) {
+		if !containsString(colNames, column) {
+			return usageErrorf("unknown --types column %q", column)
+		}
+	}
+	return nil
+}
+
+// typedColumns returns the columns of types, sorted.
+func typedColumns(types map[string]string) []string {
+	columns := make([]string, 0, len(types))
+	for column := range types {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// formatColumnTypes formats types as they are given to --types, ordered by column.
+func formatColumnTypes(types map[string]string) string {
+	specs := make([]string, 0, len(types))
+	for _, column := range typedColumns(types) {
+		specs = append(specs, column+":"+types[column])
+	}
+	return strings.Join(specs, ",")
+}
+
+// coerceValue returns value as typ, one of columnTypes, ignoring surrounding whitespace except for strings.
+// Empty values are nil unless typ is string. Booleans are parsed as by strconv.ParseBool().
+// Returns an error if value is not of typ.
+func coerceValue(value, typ string) (interface{}, error) {
+	trimmed := strings.TrimSpace(value)
+	if typ == "string" {
+		return value, nil
+	} else if trimmed == "" {
+		return nil, nil
+	}
+	var coerced interface{}
+	var err error
+	switch typ {
+	case "int":
+		coerced, err = strconv.ParseInt(trimmed, 10, 64)
+	case "float":
+		var n float64
+		n, err = strconv.ParseFloat(trimmed, 64)
+		if err == nil && (math.IsInf(n, 0) || math.IsNaN(n)) {
+			err = fmt.Errorf("%v cannot be represented in JSON", n)
+		}
+		coerced = n
+	case "bool":
+		coerced, err = strconv.ParseBool(trimmed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("value %q is not a valid %s", truncateText(value, invalidValueLength), typ)
+	}
+	return coerced, nil
+}
+
+// checkColumnTypes checks that the values of the data row with the given number can be coerced to the types given
+// by `conversionOptions.columnTypes`, as they will be by coerceTypes(). Values which are emitted as null, since
+// they are among `conversionOptions.nullValues`, are not checked.
+// Returns a *rowError for the first value which cannot.
+func (r *csvRowReader) checkColumnTypes(rowNum int, rowFields []string) error {
+	for i, name := range r.colNames {
+		typ, ok := r.columnTypes[name]
+		padded := r.padNulls && i >= r.padFrom
+		if !ok || i >= len(rowFields) || padded || containsString(r.nullValues, rowFields[i]) {
+			continue
+		}
+		if _, err := coerceValue(rowFields[i], typ); err != nil {
+			return &rowError{rowNum, fmt.Errorf("column %q %v", name, err)}
+		}
+	}
+	return nil
+}
+
+// typedValues returns a copy of fields in which the values of each column of types, which were checked as they were
+// read by checkColumnTypes(), are coerced to its type, as by coerceFieldValue(). When infer is set, the values of
+// other columns are those inferred by inferFieldValue() instead. Values which are among nulls are nil, whatever
+// their column's type.
+func typedValues(fields map[string]interface{}, types map[string]string, infer bool,
+	nulls []string) map[string]interface{} {
+	typed := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		typed[k] = typedValue(k, v, types, infer, nulls)
+	}
+	return typed
+}
+
+// typedValue returns the value v of column as by typedValues().
+func typedValue(column string, v interface{}, types map[string]string, infer bool, nulls []string) interface{} {
+	switch s := v.(type) {
+	case string:
+		if containsString(nulls, s) {
+			return nil
+		}
+	case []string:
+		if len(nulls) > 0 {
+			values := make([]interface{}, len(s))
+			for i, element := range s {
+				values[i] = typedValue(column, element, types, infer, nulls)
+			}
+			return values
+		}
+	}
+	if typ, ok := types[column]; ok {
+		return coerceFieldValue(v, typ)
+	} else if infer {
+		return inferFieldValue(v)
+	}
+	return v
+}
+
+// coerceFieldValue returns v coerced to typ when it is a string, or each of its elements when it is a []string,
+// or else v itself. Values which cannot be coerced are left as they are.
+func coerceFieldValue(v interface{}, typ string) interface{} {
+	switch v := v.(type) {
+	case string:
+		if coerced, err := coerceValue(v, typ); err == nil {
+			return coerced
+		}
+	case []string:
+		values := make([]interface{}, len(v))
+		for i, s := range v {
+			values[i] = coerceFieldValue(s, typ)
+		}
+		return values
+	}
+	return v
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseColumnTypes(t *testing.T) {
+	for _, tt := range []struct {
+		testName  string
+		specs     []string
+		wantTypes map[string]string
+		wantErr   string
+	}{
+		{"None", nil, nil, ""},
+		{"Every type", []string{"age:int", "active:bool", "score:float", "notes:string"},
+			map[string]string{"age": "int", "active": "bool", "score": "float", "notes": "string"}, ""},
+		{"Column names with colons", []string{"a:b:int"}, map[string]string{"a:b": "int"}, ""},
+		{"Unknown type", []string{"age:integer"}, nil,
+			`unknown --types type "integer" for column "age" (expected one of int, float, bool, string)`},
+		{"Missing type", []string{"age"}, nil, `invalid --types entry "age" (expected column:type)`},
+		{"Missing column", []string{":int"}, nil, `invalid --types entry ":int" (expected column:type)`},
+		{"Repeated column", []string{"age:int", "age:float"}, nil, `column "age" is given more than once by --types`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			types, err := parseColumnTypes(tt.specs)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantTypes, types)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCoerceValue(t *testing.T) {
+	for _, tt := range []struct {
+		value   string
+		typ     string
+		want    interface{}
+		wantErr string
+	}{
+		{"42", "int", int64(42), ""},
+		{" 007 ", "int", int64(7), ""},
+		{"4.2", "int", nil, `value "4.2" is not a valid int`},
+		{"1.5e3", "float", 1500.0, ""},
+		{"NaN", "float", nil, `value "NaN" is not a valid float`},
+		{"abc", "float", nil, `value "abc" is not a valid float`},
+		{"true", "bool", true, ""},
+		{"0", "bool", false, ""},
+		{"yes", "bool", nil, `value "yes" is not a valid bool`},
+		{"", "int", nil, ""},
+		{" ", "bool", nil, ""},
+		{"007", "string", "007", ""},
+		{"", "string", "", ""},
+	} {
+		t.Run(tt.typ+" "+tt.value, func(t *testing.T) {
+			coerced, err := coerceValue(tt.value, tt.typ)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, coerced)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCsv2JsonColumnTypes(t *testing.T) {
+	discardLogs(t)
+	types := map[string]string{"id": "string", "age": "int", "active": "bool"}
+
+	for _, tt := range []struct {
+		testName   string
+		csv        string
+		inferTypes bool
+		skipErrors bool
+		wantJson   string
+		wantErr    string
+	}{
+		{"Coerces listed columns", "id,age,active,score\n007,42,true,1.5\n008,,FALSE,2\n", false, false,
+			`[{"active":true,"age":42,"id":"007","score":"1.5"},{"active":false,"age":null,"id":"008","score":"2"}]` +
+				"\n", ""},
+		{"Takes precedence over inference", "id,age,active,score\n007,42,true,1.5\n", true, false,
+			`[{"active":true,"age":42,"id":"007","score":1.5}]` + "\n", ""},
+		{"Invalid values are errors", "id,age,active\n1,42,true\n2,old,true\n", false, false, "",
+			`row 2: column "age" value "old" is not a valid int`},
+		{"Invalid values can be skipped", "id,age,active\n1,42,true\n2,old,true\n3,7,no\n", false, true,
+			`[{"active":true,"age":42,"id":"1"}]` + "\n", ""},
+		{"Unknown column", "id,age\n1,2\n", false, false, "", `unknown --types column "active"`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+
+			err := csv2Json(conversionOptions{
+				csvInputs:   []io.Reader{strings.NewReader(tt.csv)},
+				jsonOutput:  jsonStream,
+				columnTypes: types,
+				inferTypes:  tt.inferTypes,
+				skipErrors:  tt.skipErrors,
+			})
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantJson, jsonStream.String())
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFormatColumnTypes(t *testing.T) {
+	assert.Equal(t, "active:bool,age:int", formatColumnTypes(map[string]string{"age": "int", "active": "bool"}))
+	assert.Equal(t, "", formatColumnTypes(nil))
+}
+
+func TestCsv2JsonNullValues(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		csv      string
+		options  conversionOptions
+		wantJson string
+	}{
+		{"Empty values", "a,b\n1,\n,\n", conversionOptions{nullValues: []string{""}},
+			`[{"a":"1","b":null},{"a":null,"b":null}]` + "\n"},
+		{"Sentinels", "a,b\nNA,\\N\nNULL,na\n", conversionOptions{nullValues: []string{"NA", "\\N", "NULL"}},
+			`[{"a":null,"b":null},{"a":null,"b":"na"}]` + "\n"},
+		{"Sentinels take precedence over inference", "a,b\nNA,1\ntrue,\n",
+			conversionOptions{nullValues: []string{"NA"}, inferTypes: true},
+			`[{"a":null,"b":1},{"a":true,"b":null}]` + "\n"},
+		{"Sentinels are not checked against types", "a,b\nNA,\n2,x\n",
+			conversionOptions{nullValues: []string{"NA", ""}, columnTypes: map[string]string{"a": "int", "b": "string"}},
+			`[{"a":null,"b":null},{"a":2,"b":"x"}]` + "\n"},
+		{"Plucked values", "a\nNA\n1\n", conversionOptions{nullValues: []string{"NA"}, pluck: "a"},
+			`[null,"1"]` + "\n"},
+		{"Collapsed duplicate headers", "n,n\n1,NA\n", conversionOptions{nullValues: []string{"NA"}, dupHeaders: "array"},
+			`[{"n":["1",null]}]` + "\n"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+			options := tt.options
+			options.csvInputs = []io.Reader{strings.NewReader(tt.csv)}
+			options.jsonOutput = jsonStream
+
+			err := csv2Json(options)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantJson, jsonStream.String())
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// urlDecodeModes are the modes accepted by --url-decode-mode: query decodes values as by url.QueryUnescape(), where
+// + is a space, while path decodes them as by url.PathUnescape(), where + is left as it is.
+var urlDecodeModes = []string{"query", "path"}
+
+// defaultUrlDecodeMode is the mode which applies when none is chosen.
+const defaultUrlDecodeMode = "query"
+
+// checkUrlDecodeColumns returns a *usageError unless mode is empty or one of urlDecodeModes, and each of columns is
+// one of colNames.
+func checkUrlDecodeColumns(columns []string, mode string, colNames []string) error {
+	if mode != "" && !containsString(urlDecodeModes, mode) {
+		return usageErrorf("unknown --url-decode-mode %q (expected one of %s)", mode, strings.Join(urlDecodeModes, ", "))
+	}
+	for _, column := range columns {
+		if colNames != nil && !containsString(colNames, column) {
+			return usageErrorf("unknown --url-decode-columns column %q", column)
+		}
+	}
+	return nil
+}
+
+// urlDecodeFields decodes the percent-encoded values of the data row with the given number in the columns given by
+// `conversionOptions.urlDecodeColumns`, in place, according to the reader's urlDecodeMode. Values without escapes
+// are left as they are.
+// Returns a *rowError for values with invalid escapes.
+func (r *csvRowReader) urlDecodeFields(rowNum int, rowFields []string) error {
+	unescape := url.QueryUnescape
+	if r.urlDecodeMode == "path" {
+		unescape = url.PathUnescape
+	}
+	for i, name := range r.colNames {
+		if !containsString(r.urlDecodeColumns, name) || i >= len(rowFields) {
+			continue
+		}
+		decoded, err := unescape(rowFields[i])
+		if err != nil {
+			return &rowError{rowNum, fmt.Errorf("column %q value %q is not URL-encoded: %v", name,
+				truncateText(rowFields[i], invalidValueLength), err)}
+		}
+		rowFields[i] = decoded
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCsv2JsonUrlDecodeColumns(t *testing.T) {
+	discardLogs(t)
+
+	for _, tt := range []struct {
+		testName   string
+		csv        string
+		mode       string
+		skipErrors bool
+		wantJson   string
+		wantErr    string
+	}{
+		{"Decodes escapes", "q,other\nhello%20world%21,a%20b\n", "", false,
+			`[{"q":"hello world!","other":"a%20b"}]`, ""},
+		{"Decodes + as a space by default", "q,other\na+b%2Bc,x\n", "", false, `[{"q":"a b+c","other":"x"}]`, ""},
+		{"Decodes + as a space in query mode", "q,other\na+b,x\n", "query", false, `[{"q":"a b","other":"x"}]`, ""},
+		{"Leaves + in path mode", "q,other\na+b%20c,x\n", "path", false, `[{"q":"a+b c","other":"x"}]`, ""},
+		{"Decoded values pass through", "q,other\nhello world,x\n", "", false, `[{"q":"hello world","other":"x"}]`,
+			""},
+		{"Invalid escapes are errors", "q,other\n100%zz,x\n", "", false, "",
+			`row 1: column "q" value "100%zz" is not URL-encoded: invalid URL escape "%zz"`},
+		{"Invalid escapes can be skipped", "q,other\n%zz,x\nok%21,y\n", "", true, `[{"q":"ok!","other":"y"}]`, ""},
+		{"Unknown column", "other\nx\n", "", false, "", `unknown --url-decode-columns column "q"`},
+		{"Unknown mode", "q\nx\n", "form", false, "", `unknown --url-decode-mode "form" (expected one of query, path)`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			jsonStream := bytes.NewBuffer([]byte{})
+
+			err := csv2Json(conversionOptions{
+				csvInputs:        []io.Reader{strings.NewReader(tt.csv)},
+				jsonOutput:       jsonStream,
+				urlDecodeColumns: []string{"q"},
+				urlDecodeMode:    tt.mode,
+				skipErrors:       tt.skipErrors,
+			})
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, jsonStream.String())
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
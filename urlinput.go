@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// urlInputOptions is used to configure fetching inputs which are given as http or https URLs.
+type urlInputOptions struct {
+	// timeout, when set, limits how long to wait for each response to begin. Reading the body is not limited, since
+	// it is streamed while it is converted.
+	timeout time.Duration
+	// headers are sent with each request, given as "Name: value" as for parseHeaders()
+	headers []string
+}
+
+// isInputUrl reports whether fileName is an http or https URL, which is fetched rather than opened as a file.
+func isInputUrl(fileName string) bool {
+	u, err := url.Parse(fileName)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// httpStatusError reports a response to the request for an input URL whose status was not 200 OK.
+type httpStatusError struct {
+	url    string
+	status string
+	code   int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("fetching %s: unexpected status %s", e.url, e.status)
+}
+
+// Is reports responses which say that the URL does not exist as os.ErrNotExist, like missing input files.
+func (e *httpStatusError) Is(target error) bool {
+	return target == os.ErrNotExist && (e.code == http.StatusNotFound || e.code == http.StatusGone)
+}
+
+// urlInput is the body of the response to the request for an input URL, which is named by its URL for inputName().
+type urlInput struct {
+	io.Reader
+	body io.Closer
+	url  string
+}
+
+func (u *urlInput) Name() string {
+	return u.url
+}
+
+func (u *urlInput) Close() error {
+	return u.body.Close()
+}
+
+// fetchCsvUrl requests rawUrl according to urlOpts, returning a *urlInput for streaming the response body, which is
+// decompressed when its Content-Encoding is gzip. Returns a *httpStatusError for any status but 200 OK.
+func fetchCsvUrl(rawUrl string, urlOpts urlInputOptions) (*urlInput, error) {
+	headers, err := parseHeaders(urlOpts.headers)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, rawUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = headers
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = urlOpts.timeout
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &httpStatusError{rawUrl, resp.Status, resp.StatusCode}
+	}
+	input := &urlInput{resp.Body, resp.Body, rawUrl}
+	// The transport only decompresses responses by itself when it asked for gzip, rather than a given header
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		zr, err := newGzipReader(resp.Body, rawUrl)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		input.Reader = zr
+	}
+	return input, nil
+}
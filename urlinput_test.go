@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsInputUrl(t *testing.T) {
+	for _, tt := range []struct {
+		fileName string
+		want     bool
+	}{
+		{"https://example.org/data.csv", true},
+		{"http://localhost:8080/data.csv?format=csv", true},
+		{"data.csv", false},
+		{"-", false},
+		{"/tmp/http:/data.csv", false},
+		{"ftp://example.org/data.csv", false},
+		{"http:data.csv", false},
+	} {
+		t.Run(tt.fileName, func(t *testing.T) {
+			assert.Equal(t, tt.want, isInputUrl(tt.fileName))
+		})
+	}
+}
+
+func TestGetCsvFilesUrl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/data.csv":
+			io.WriteString(w, "a,b\n3,4\n")
+		case "/private.csv":
+			if req.Header.Get("Authorization") != "Bearer secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			io.WriteString(w, "a,b\n5,6\n")
+		case "/encoded.csv":
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			io.WriteString(zw, "a,b\n7,8\n")
+			zw.Close()
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	t.Cleanup(server.Close)
+	fileName := filepath.Join(t.TempDir(), "local.csv")
+	require.NoError(t, ioutil.WriteFile(fileName, []byte("a,b\n1,2\n"), 0644))
+
+	for _, tt := range []struct {
+		testName     string
+		fileNames    []string
+		headers      []string
+		wantJson     string
+		wantErr      string
+		wantExitCode int
+	}{
+		{"Mixes files and URLs", []string{fileName, server.URL + "/data.csv"}, nil,
+			`[{"a": "1", "b": "2"}, {"a": "3", "b": "4"}]`, "", exitOK},
+		{"Sends headers", []string{server.URL + "/private.csv"}, []string{"Authorization: Bearer secret"},
+			`[{"a": "5", "b": "6"}]`, "", exitOK},
+		{"Decompresses a gzip Content-Encoding", []string{server.URL + "/encoded.csv"},
+			[]string{"Accept-Encoding: gzip"}, `[{"a": "7", "b": "8"}]`, "", exitOK},
+		{"Reports the status", []string{fileName, server.URL + "/private.csv"}, nil, "",
+			"fetching " + server.URL + "/private.csv: unexpected status 401 Unauthorized", exitFailure},
+		{"Reports missing URLs as not found", []string{server.URL + "/missing.csv"}, nil, "",
+			"fetching " + server.URL + "/missing.csv: unexpected status 404 Not Found", exitInputNotFound},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			files, err := getCsvFiles(tt.fileNames, "auto", urlInputOptions{headers: tt.headers})
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, tt.wantExitCode, exitCode(err))
+				return
+			}
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				closeCsvFiles(files)
+			})
+			assert.Equal(t, tt.fileNames[len(tt.fileNames)-1], inputName(files[len(files)-1], len(files)),
+				"URL inputs should be named by their URL")
+
+			jsonStream := bytes.NewBuffer([]byte{})
+			err = csv2Json(conversionOptions{csvInputs: files, jsonOutput: jsonStream, compression: "auto"})
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.wantJson, jsonStream.String())
+		})
+	}
+}
+
+func TestGetCsvFilesUrlStreams(t *testing.T) {
+	// The response is only finished once the first record has been converted, so it must not be buffered first.
+	// Its start is long enough to be sniffed.
+	converted := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "a\n"+strings.Repeat("1\n", sniffLength))
+		w.(http.Flusher).Flush()
+		select {
+		case <-converted:
+		case <-time.After(5 * time.Second):
+		}
+		io.WriteString(w, "2\n")
+	}))
+	t.Cleanup(server.Close)
+
+	files, err := getCsvFiles([]string{server.URL}, "auto", urlInputOptions{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		closeCsvFiles(files)
+	})
+	var values []string
+	started := time.Now()
+	err = eachBatch(conversionOptions{csvInputs: files}, 1, func(batch []record) error {
+		values = append(values, batch[0]["a"])
+		if len(values) == 1 {
+			close(converted)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, values, sniffLength+1)
+	assert.Equal(t, "2", values[len(values)-1])
+	assert.Less(t, int64(time.Since(started)), int64(5*time.Second), "The first record should not wait for the rest")
+}
+
+func TestGetCsvFilesUrlTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	_, err := getCsvFiles([]string{server.URL}, "auto", urlInputOptions{timeout: 20 * time.Millisecond})
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "timeout awaiting response headers"), err.Error())
+	assert.Equal(t, exitTimeout, exitCode(err))
+}
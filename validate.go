@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// invalidRowError reports a data row whose record was rejected by `conversionOptions.validate`.
+// Like CSV parsing errors, invalidRowErrors may be skipped instead of aborting, but skipped rows are counted apart.
+type invalidRowError struct {
+	row int
+	err error
+}
+
+func (e *invalidRowError) Error() string {
+	return fmt.Sprintf("row %d is invalid: %v", e.row, e.err)
+}
+
+func (e *invalidRowError) Unwrap() error {
+	return e.err
+}
+
+// constraintViolation reports a record which violates one of the constraints given by flags, such as --required.
+// Skipped rows are counted by constraint, which describes the flag and its value.
+type constraintViolation struct {
+	constraint string
+	problem    string
+}
+
+func (e *constraintViolation) Error() string {
+	return e.problem
+}
+
+// regexValidation requires the values of a column to match a pattern.
+type regexValidation struct {
+	column  string
+	pattern *regexp.Regexp
+}
+
+// parseRegexValidations compiles each of specs, given to --validate-regex as COLUMN=PATTERN. Since flaggy splits
+// values on commas, which patterns may contain, specs are first rejoined by joinRegexSpecs().
+func parseRegexValidations(specs []string) ([]regexValidation, error) {
+	specs = joinRegexSpecs(specs)
+	validations := make([]regexValidation, len(specs))
+	for i, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, usageErrorf("invalid --validate-regex %q (expected COLUMN=PATTERN)", spec)
+		}
+		pattern, err := regexp.Compile(parts[1])
+		if err != nil {
+			return nil, usageErrorf("invalid --validate-regex pattern for column %q: %v", parts[0], err)
+		}
+		validations[i] = regexValidation{parts[0], pattern}
+	}
+	return validations, nil
+}
+
+// joinRegexSpecs rejoins the COLUMN=PATTERN specs which were split on commas. Each piece begins a new spec when it
+// has a "=" preceded by a column name, which is taken to be anything without regular expression metacharacters, and
+// otherwise continues the previous one.
+func joinRegexSpecs(pieces []string) []string {
+	var specs []string
+	for _, piece := range pieces {
+		i := strings.IndexByte(piece, '=')
+		if len(specs) == 0 || (i > 0 && !strings.ContainsAny(piece[:i], `\^$.|?*+()[]{}`)) {
+			specs = append(specs, piece)
+		} else {
+			specs[len(specs)-1] += "," + piece
+		}
+	}
+	return specs
+}
+
+// validateRegexes returns a function for `conversionOptions.validate` which rejects records with a value that does not
+// match the pattern of each of validations for its column, so that several patterns for a column must all match.
+// A record without one of the columns causes a *usageError, since the column is not in the input.
+func validateRegexes(validations []regexValidation) func(rec record) error {
+	return func(rec record) error {
+		for _, v := range validations {
+			value, ok := rec[v.column]
+			if !ok {
+				return usageErrorf("unknown --validate-regex column %q", v.column)
+			} else if !v.pattern.MatchString(value) {
+				return &constraintViolation{"validate-regex " + v.column + "=" + v.pattern.String(), fmt.Sprintf(
+					"column %q value %q does not match %s", v.column, truncateText(value, invalidValueLength), v.pattern)}
+			}
+		}
+		return nil
+	}
+}
+
+// invalidValueLength limits how many bytes of an invalid value are included in errors.
+const invalidValueLength = 40
+
+// validateRequired returns a function for `conversionOptions.validate` which rejects records without a value for
+// each of columns, where values which are only whitespace count as empty.
+func validateRequired(columns []string) func(rec record) error {
+	return func(rec record) error {
+		for _, column := range columns {
+			if strings.TrimSpace(rec[column]) == "" {
+				return &constraintViolation{"required " + column, fmt.Sprintf("missing required field %q", column)}
+			}
+		}
+		return nil
+	}
+}
+
+// combineValidators returns a function for `conversionOptions.validate` which rejects records rejected by any of
+// validators, in order, or nil when there are none. Nil validators are ignored.
+func combineValidators(all ...func(rec record) error) func(rec record) error {
+	var validators []func(rec record) error
+	for _, validate := range all {
+		if validate != nil {
+			validators = append(validators, validate)
+		}
+	}
+	switch len(validators) {
+	case 0:
+		return nil
+	case 1:
+		return validators[0]
+	}
+	return func(rec record) error {
+		for _, validate := range validators {
+			if err := validate(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// rangeValidation requires the values of a column to be numbers within inclusive bounds, either of which may be open.
+type rangeValidation struct {
+	column   string
+	bounds   string
+	min, max *float64
+}
+
+// parseRangeValidations parses each of specs, given to --range as COLUMN=MIN..MAX, where MIN or MAX may be omitted.
+func parseRangeValidations(specs []string) ([]rangeValidation, error) {
+	validations := make([]rangeValidation, len(specs))
+	for i, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || !strings.Contains(parts[1], "..") {
+			return nil, usageErrorf("invalid --range %q (expected COLUMN=MIN..MAX)", spec)
+		}
+		v := rangeValidation{column: parts[0], bounds: parts[1]}
+		bounds := strings.SplitN(parts[1], "..", 2)
+		for j, bound := range []**float64{&v.min, &v.max} {
+			if bounds[j] == "" {
+				continue
+			}
+			n, err := strconv.ParseFloat(bounds[j], 64)
+			if err != nil {
+				return nil, usageErrorf("invalid --range bound %q for column %q", bounds[j], v.column)
+			}
+			*bound = &n
+		}
+		if v.min != nil && v.max != nil && *v.min > *v.max {
+			return nil, usageErrorf("invalid --range %q (minimum exceeds maximum)", spec)
+		}
+		validations[i] = v
+	}
+	return validations, nil
+}
+
+// validateRanges returns a function for `conversionOptions.validate` which rejects records with a value which is not
+// a number within the bounds of each of validations for its column. Values are parsed as by strconv.ParseFloat(),
+// ignoring surrounding whitespace. A record without one of the columns causes a *usageError.
+func validateRanges(validations []rangeValidation) func(rec record) error {
+	return func(rec record) error {
+		for _, v := range validations {
+			value, ok := rec[v.column]
+			if !ok {
+				return usageErrorf("unknown --range column %q", v.column)
+			}
+			n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			var problem string
+			switch {
+			case err != nil:
+				problem = "is not a number"
+			case v.min != nil && n < *v.min, v.max != nil && n > *v.max:
+				problem = "is not within " + v.bounds
+			default:
+				continue
+			}
+			return &constraintViolation{"range " + v.column + "=" + v.bounds, fmt.Sprintf("column %q value %q %s",
+				v.column, truncateText(value, invalidValueLength), problem)}
+		}
+		return nil
+	}
+}
+
+// enumValidation requires the values of a column to be one of a set of allowed values.
+type enumValidation struct {
+	column string
+	// allowed holds the allowed values, which are lower-cased when caseInsensitive is set
+	allowed         map[string]bool
+	caseInsensitive bool
+}
+
+// parseEnumValidations parses each of specs, given to --enum as COLUMN=VALUE,VALUE..., and each of fileSpecs, given
+// to --enum-file as COLUMN=FILE, where FILE has an allowed value on each line. Since flaggy splits values on commas,
+// specs are first rejoined by joinRegexSpecs(). The allowed values given for a column by either flag are combined.
+func parseEnumValidations(specs, fileSpecs []string, caseInsensitive bool) ([]enumValidation, error) {
+	var validations []enumValidation
+	byColumn := make(map[string]int)
+	allow := func(column string, values []string) {
+		i, ok := byColumn[column]
+		if !ok {
+			i = len(validations)
+			byColumn[column] = i
+			validations = append(validations, enumValidation{column, make(map[string]bool), caseInsensitive})
+		}
+		for _, value := range values {
+			if caseInsensitive {
+				value = strings.ToLower(value)
+			}
+			validations[i].allowed[value] = true
+		}
+	}
+
+	for _, spec := range joinRegexSpecs(specs) {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, usageErrorf("invalid --enum %q (expected COLUMN=VALUE,VALUE...)", spec)
+		}
+		allow(parts[0], strings.Split(parts[1], ","))
+	}
+	for _, spec := range fileSpecs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, usageErrorf("invalid --enum-file %q (expected COLUMN=FILE)", spec)
+		}
+		values, err := readEnumFile(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		allow(parts[0], values)
+	}
+	return validations, nil
+}
+
+// readEnumFile reads the allowed values in the named file, one per line, ignoring surrounding whitespace and blank
+// lines.
+func readEnumFile(fileName string) ([]string, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if value := strings.TrimSpace(line); value != "" {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return nil, usageErrorf("--enum-file %s has no values", fileName)
+	}
+	return values, nil
+}
+
+// validateEnums returns a function for `conversionOptions.validate` which rejects records with a value which is not
+// one of the allowed values of each of validations for its column. Violations are counted by value, so that the
+// summary of skipped rows shows which values were rejected, and how often. A record without one of the columns
+// causes a *usageError.
+func validateEnums(validations []enumValidation) func(rec record) error {
+	return func(rec record) error {
+		for _, v := range validations {
+			value, ok := rec[v.column]
+			if !ok {
+				return usageErrorf("unknown --enum column %q", v.column)
+			}
+			key := value
+			if v.caseInsensitive {
+				key = strings.ToLower(value)
+			}
+			if !v.allowed[key] {
+				value = truncateText(value, invalidValueLength)
+				return &constraintViolation{"enum " + v.column + "=" + value,
+					fmt.Sprintf("column %q value %q is not an allowed value", v.column, value)}
+			}
+		}
+		return nil
+	}
+}
+
+// dateValidation requires the non-empty values of a column to be dates or times in one of a list of layouts, as
+// accepted by time.Parse().
+type dateValidation struct {
+	column  string
+	layouts []string
+}
+
+// parseDateValidations parses each of specs, given to --validate-date as COLUMN=LAYOUT. Giving a column more than
+// once adds to its layouts, in order. Since flaggy splits values on commas, which layouts may contain, specs are first
+// rejoined by joinRegexSpecs().
+func parseDateValidations(specs []string) ([]dateValidation, error) {
+	var validations []dateValidation
+	byColumn := make(map[string]int)
+	for _, spec := range joinRegexSpecs(specs) {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, usageErrorf("invalid --validate-date %q (expected COLUMN=LAYOUT)", spec)
+		}
+		if i, ok := byColumn[parts[0]]; ok {
+			validations[i].layouts = append(validations[i].layouts, parts[1])
+			continue
+		}
+		byColumn[parts[0]] = len(validations)
+		validations = append(validations, dateValidation{parts[0], []string{parts[1]}})
+	}
+	return validations, nil
+}
+
+// validateDates returns a function for `conversionOptions.validate` which rejects records with a non-empty value
+// which does not parse in any of the layouts of each of validations for its column. Values are left unchanged.
+// Empty values are allowed, unless the column is also --required. A record without one of the columns causes a
+// *usageError.
+func validateDates(validations []dateValidation) func(rec record) error {
+	return func(rec record) error {
+		for _, v := range validations {
+			value, ok := rec[v.column]
+			if !ok {
+				return usageErrorf("unknown --validate-date column %q", v.column)
+			} else if value == "" || parsesAsDate(value, v.layouts) {
+				continue
+			}
+			return &constraintViolation{"validate-date " + v.column, fmt.Sprintf("column %q value %q is not a date in %s",
+				v.column, truncateText(value, invalidValueLength), strings.Join(quoteAll(v.layouts), " or "))}
+		}
+		return nil
+	}
+}
+
+// parsesAsDate reports whether value parses in any of layouts, trying each in order.
+func parsesAsDate(value string, layouts []string) bool {
+	for _, layout := range layouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteAll returns each of values, quoted as by strconv.Quote().
+func quoteAll(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+	return quoted
+}
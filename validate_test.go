@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCsv2JsonValidate(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+	// The validator sees values after transforms, so the defanged ="007" is valid
+	csvData := "id,qty\n\"=\"\"007\"\"\",1\n008,-2\n009,x,y\n010,3\n"
+	validate := func(rec record) error {
+		if !strings.HasPrefix(rec["id"], "0") {
+			return errors.New("id must have a leading zero")
+		} else if strings.HasPrefix(rec["qty"], "-") {
+			return errors.New("qty must not be negative")
+		}
+		return nil
+	}
+
+	for _, tt := range []struct {
+		testName        string
+		skipErrors      bool
+		wantJson        string
+		wantErr         string
+		wantRowsSkipped int
+		wantRowsInvalid int
+		wantLogs        string
+	}{
+		{"Aborts", false, "", "row 2 is invalid: qty must not be negative", 0, 0, ""},
+		{"Skips", true, `[{"id": "007", "qty": "1"}, {"id": "010", "qty": "3"}]`, "", 2, 1,
+			"row 2 is invalid: qty must not be negative\n" +
+				"record on line 4: wrong number of fields\n" +
+				"Skipped 1 lines (rows) due to parsing errors and 1 invalid rows\n"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			logs.Reset()
+			var buf bytes.Buffer
+			stats := &conversionStats{}
+			err := csv2Json(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(csvData)},
+				jsonOutput: &buf,
+				transforms: []valueTransform{defangFormula},
+				validate:   validate,
+				skipErrors: tt.skipErrors,
+				stats:      stats,
+			})
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, exitInvalidCsv, exitCode(err))
+			} else {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, buf.String())
+			}
+			assert.Equal(t, tt.wantRowsSkipped, stats.rowsSkipped)
+			assert.Equal(t, tt.wantRowsInvalid, stats.rowsInvalid, "Invalid rows should be counted apart")
+			assert.Equal(t, tt.wantLogs, logs.String())
+		})
+	}
+}
+
+func TestLogSkippedInvalidRows(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+
+	logSkippedRows(map[string]int{"invalid": 2})
+	assert.Equal(t, "Skipped 2 invalid rows\n", logs.String())
+}
+
+func TestValidateRegexes(t *testing.T) {
+	discardLogs(t)
+	// Both patterns for sku must match, so AB-0001 has too few letters and ABC-12345 is too long
+	csvData := "email,sku\na@x.com,ABC-1234\nnot-an-email,ABC-1234\nb@y.com,AB-0001\nc@z.com,ABC-12345\n"
+	validations, err := parseRegexValidations([]string{`email=^[^@]+@[^@]+$`, `sku=^[A-Z]{3}-\d{4}`, `sku=^.{1,8}$`})
+	require.NoError(t, err)
+	require.Len(t, validations, 3, "Commas within patterns should not split them")
+
+	for _, tt := range []struct {
+		testName        string
+		skipErrors      bool
+		wantJson        string
+		wantErr         string
+		wantRowsInvalid int
+	}{
+		{"Aborts", false, "", `row 2 is invalid: column "email" value "not-an-email" does not match ^[^@]+@[^@]+$`, 0},
+		{"Skips", true, `[{"email": "a@x.com", "sku": "ABC-1234"}]`, "", 3},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var buf bytes.Buffer
+			stats := &conversionStats{}
+			err := csv2Json(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(csvData)},
+				jsonOutput: &buf,
+				validate:   validateRegexes(validations),
+				skipErrors: tt.skipErrors,
+				stats:      stats,
+			})
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, buf.String())
+			}
+			assert.Equal(t, tt.wantRowsInvalid, stats.rowsInvalid)
+		})
+	}
+}
+
+func TestValidateRegexesErrors(t *testing.T) {
+	validations, err := parseRegexValidations([]string{"code=^[0-9]+$"})
+	require.NoError(t, err)
+	validate := validateRegexes(validations)
+
+	assert.NoError(t, validate(record{"code": "123"}))
+	assert.EqualError(t, validate(record{"code": strings.Repeat("x", 50)}),
+		`column "code" value "`+strings.Repeat("x", 40)+`..." does not match ^[0-9]+$`,
+		"Long values should be truncated")
+
+	err = csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader("id\n1\n")},
+		jsonOutput: ioutil.Discard,
+		validate:   validate,
+		skipErrors: true,
+	})
+	assert.EqualError(t, err, `unknown --validate-regex column "code"`)
+	assert.Equal(t, exitUsage, exitCode(err), "Unknown columns should not be skipped like invalid rows")
+
+	for _, specs := range [][]string{{"code"}, {"=^x$"}, {"code=("}} {
+		_, err := parseRegexValidations(specs)
+		assert.Equal(t, exitUsage, exitCode(err), "Specs %v should be invalid", specs)
+	}
+}
+
+func TestJoinRegexSpecs(t *testing.T) {
+	assert.Equal(t, []string{`sku=^[A-Z]{3,4}$`, "id=^[0-9]+$"},
+		joinRegexSpecs([]string{`sku=^[A-Z]{3`, `4}$`, "id=^[0-9]+$"}))
+	assert.Equal(t, []string{`a=^(b,c)=d$`}, joinRegexSpecs([]string{`a=^(b`, `c)=d$`}),
+		"Pieces whose = follows metacharacters should continue the previous spec")
+}
+
+func TestValidateRequired(t *testing.T) {
+	discardLogs(t)
+	// Data rows only, since column names are forced
+	csvData := "1,a@x.com,Alice\n,b@y.com,Bob\n3,  ,Carol\n4,d@z.com,\n"
+
+	for _, tt := range []struct {
+		testName   string
+		skipErrors bool
+		wantJson   string
+		wantErr    string
+		wantErrors []reportedError
+	}{
+		{"Aborts", false, "", `row 2 is invalid: missing required field "id"`, nil},
+		{"Skips", true, `[{"id": "1", "email": "a@x.com", "name": "Alice"}, {"id": "4", "email": "d@z.com", "name": ""}]`,
+			"", []reportedError{
+				{Line: 2, Row: 2, Message: `row 2 is invalid: missing required field "id"`},
+				{Line: 3, Row: 3, Message: `row 3 is invalid: missing required field "email"`},
+			}},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var buf bytes.Buffer
+			stats := &conversionStats{maxErrors: maxReportedErrors}
+			err := csv2Json(conversionOptions{
+				colNames:   []string{"id", "email", "name"},
+				csvInputs:  []io.Reader{strings.NewReader(csvData)},
+				jsonOutput: &buf,
+				validate:   validateRequired([]string{"id", "email"}),
+				skipErrors: tt.skipErrors,
+				stats:      stats,
+			})
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantJson, buf.String())
+			}
+			assert.Equal(t, tt.wantErrors, stats.errors, "Reported errors should name the missing field")
+			assert.Equal(t, len(tt.wantErrors), stats.rowsInvalid)
+		})
+	}
+
+	assert.EqualError(t, validateRequired([]string{"id"})(record{"name": "Alice"}), `missing required field "id"`,
+		"Missing fields should be rejected like empty ones")
+}
+
+func TestCombineValidators(t *testing.T) {
+	assert.Nil(t, combineValidators())
+	assert.Nil(t, combineValidators(nil))
+	validate := combineValidators(validateRequired([]string{"code"}), func(rec record) error {
+		return errors.New("always invalid")
+	})
+	assert.EqualError(t, validate(record{}), `missing required field "code"`, "Validators should apply in order")
+	assert.EqualError(t, validate(record{"code": "1"}), "always invalid")
+}
+
+func TestValidateRanges(t *testing.T) {
+	validations, err := parseRangeValidations([]string{"age=0..150", "price=0..", "discount=..0.5"})
+	require.NoError(t, err)
+	validate := validateRanges(validations)
+
+	for _, tt := range []struct {
+		testName string
+		rec      record
+		wantErr  string
+	}{
+		{"Within bounds", record{"age": "0", "price": "1e6", "discount": "-1"}, ""},
+		{"Inclusive maximum", record{"age": " 150 ", "price": "0", "discount": "0.5"}, ""},
+		{"Below minimum", record{"age": "-1", "price": "1", "discount": "0"},
+			`column "age" value "-1" is not within 0..150`},
+		{"Above maximum", record{"age": "151", "price": "1", "discount": "0"},
+			`column "age" value "151" is not within 0..150`},
+		{"Open maximum", record{"age": "1", "price": "-0.01", "discount": "0"},
+			`column "price" value "-0.01" is not within 0..`},
+		{"Open minimum", record{"age": "1", "price": "1", "discount": "0.6"},
+			`column "discount" value "0.6" is not within ..0.5`},
+		{"Not a number", record{"age": "old", "price": "1", "discount": "0"},
+			`column "age" value "old" is not a number`},
+		{"Empty", record{"age": "", "price": "1", "discount": "0"}, `column "age" value "" is not a number`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			err := validate(tt.rec)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+			var violation *constraintViolation
+			require.True(t, errors.As(err, &violation))
+			assert.Contains(t, []string{"range age=0..150", "range price=0..", "range discount=..0.5"},
+				violation.constraint)
+		})
+	}
+
+	err = validate(record{"price": "1", "discount": "0"})
+	assert.Equal(t, exitUsage, exitCode(err), "Unknown columns should be a usage error")
+	for _, spec := range []string{"age", "=0..1", "age=0-150", "age=a..1", "age=0..x", "age=2..1"} {
+		_, err := parseRangeValidations([]string{spec})
+		assert.Equal(t, exitUsage, exitCode(err), "Range %q should be invalid", spec)
+	}
+}
+
+func TestLogViolations(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+
+	validations, err := parseRangeValidations([]string{"age=0..150"})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	err = csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader("id,age\n1,30\n,200\n3,x\n,40\n")},
+		jsonOutput: &buf,
+		validate:   combineValidators(validateRequired([]string{"id"}), validateRanges(validations)),
+		skipErrors: true,
+	})
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"id": "1", "age": "30"}]`, buf.String())
+	assert.True(t, strings.HasSuffix(logs.String(),
+		"Skipped 3 invalid rows\nInvalid rows by constraint: range age=0..150 (1), required id (2)\n"),
+		"Unexpected logs %q", logs.String())
+}
+
+func TestValidateEnums(t *testing.T) {
+	statusFile := filepath.Join(t.TempDir(), "statuses.txt")
+	require.NoError(t, ioutil.WriteFile(statusFile, []byte("active\n  inactive \n\npending\n"), 0644))
+
+	for _, tt := range []struct {
+		testName        string
+		specs           []string
+		fileSpecs       []string
+		caseInsensitive bool
+		value           string
+		wantErr         string
+	}{
+		{"Allowed", []string{"status=active", "inactive", "pending"}, nil, false, "pending", ""},
+		{"Not allowed", []string{"status=active", "inactive"}, nil, false, "actve",
+			`column "status" value "actve" is not an allowed value`},
+		{"Case-sensitive", []string{"status=active"}, nil, false, "Active",
+			`column "status" value "Active" is not an allowed value`},
+		{"Case-insensitive", []string{"status=active"}, nil, true, "ACTIVE", ""},
+		{"Empty", []string{"status=active"}, nil, false, "", `column "status" value "" is not an allowed value`},
+		{"From file", nil, []string{"status=" + statusFile}, false, "inactive", ""},
+		{"Not in file", nil, []string{"status=" + statusFile}, false, "closed",
+			`column "status" value "closed" is not an allowed value`},
+		{"Combined", []string{"status=closed"}, []string{"status=" + statusFile}, false, "closed", ""},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			validations, err := parseEnumValidations(tt.specs, tt.fileSpecs, tt.caseInsensitive)
+			require.NoError(t, err)
+			err = validateEnums(validations)(record{"status": tt.value})
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+			var violation *constraintViolation
+			require.True(t, errors.As(err, &violation))
+			assert.Equal(t, "enum status="+tt.value, violation.constraint, "Violations should be counted by value")
+		})
+	}
+
+	validations, err := parseEnumValidations([]string{"status=active"}, nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, exitUsage, exitCode(validateEnums(validations)(record{"state": "active"})),
+		"Unknown columns should be a usage error")
+	for _, specs := range [][]string{{"status"}, {"=active"}} {
+		_, err := parseEnumValidations(specs, nil, false)
+		assert.Equal(t, exitUsage, exitCode(err), "Specs %v should be invalid", specs)
+	}
+	_, err = parseEnumValidations(nil, []string{"status=" + statusFile + ".missing"}, false)
+	assert.True(t, errors.Is(err, os.ErrNotExist), "Unexpected error %v", err)
+}
+
+func TestValidateDates(t *testing.T) {
+	validations, err := parseDateValidations([]string{"created_at=2006-01-02", "created_at=Jan 2", " 2006", "at=15:04"})
+	require.NoError(t, err)
+	require.Equal(t, []dateValidation{{"created_at", []string{"2006-01-02", "Jan 2, 2006"}}, {"at", []string{"15:04"}}},
+		validations, "Layouts split on commas should be rejoined")
+	validate := validateDates(validations)
+
+	for _, tt := range []struct {
+		testName string
+		rec      record
+		wantErr  string
+	}{
+		{"Valid", record{"created_at": "2024-02-29", "at": "23:59"}, ""},
+		{"Second layout", record{"created_at": "Feb 29, 2024", "at": "00:00"}, ""},
+		{"Empty", record{"created_at": "", "at": ""}, ""},
+		{"Invalid", record{"created_at": "2023-02-29", "at": "12:00"},
+			`column "created_at" value "2023-02-29" is not a date in "2006-01-02" or "Jan 2, 2006"`},
+		{"Whitespace", record{"created_at": "2024-01-01", "at": " 12:00"},
+			`column "at" value " 12:00" is not a date in "15:04"`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			err := validate(tt.rec)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	err = csv2Json(conversionOptions{
+		csvInputs:  []io.Reader{strings.NewReader("created_at,at\n2024-01-01,\n,10:00\n")},
+		jsonOutput: &buf,
+		validate:   combineValidators(validateRequired([]string{"created_at"}), validate),
+	})
+	assert.EqualError(t, err, `row 2 is invalid: missing required field "created_at"`,
+		"Empty values should only be rejected when required")
+
+	assert.Equal(t, exitUsage, exitCode(validate(record{"at": "10:00"})), "Unknown columns should be a usage error")
+	for _, spec := range []string{"created_at", "=2006", "created_at="} {
+		_, err := parseDateValidations([]string{spec})
+		assert.Equal(t, exitUsage, exitCode(err), "Spec %q should be invalid", spec)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// watchOptions is used to configure converting the CSV files which appear in a directory when calling watchDir().
+type watchOptions struct {
+	dir       string
+	outputDir string
+	// quiesce is how long a file must go without changing before it is converted
+	quiesce time.Duration
+	// onSuccess is what to do with each file once it is converted: "keep", "delete", or "move:" followed by the
+	// directory to move it to
+	onSuccess string
+}
+
+// watchDir converts each CSV file in `watchOpts.dir` which is there when it starts, and each one created thereafter,
+// until stop is closed, which also cancels any conversion in progress. Each file is converted once it has stopped
+// changing, as by convertWatchedFile(), and any failure is logged and leaves the file in place. Files are converted
+// one at a time, in the order they stop changing.
+// Returns any error from watching the directory.
+func watchDir(options conversionOptions, watchOpts watchOptions, stop <-chan struct{}) error {
+	if err := checkWatchOptions(watchOpts); err != nil {
+		return err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := watcher.Add(watchOpts.dir); err != nil {
+		return err
+	}
+	// Conversions are cancelled once stopped, rather than finishing a large file first
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// Each file is watched by a goroutine until it stops changing, and then sent to ready
+	ready := make(chan string)
+	pending := make(map[string]bool)
+	watch := func(fileName string) {
+		if pending[fileName] || !isWatchedFile(fileName) {
+			return
+		}
+		pending[fileName] = true
+		go func() {
+			if waitForQuiescence(fileName, watchOpts.quiesce, stop) {
+				select {
+				case ready <- fileName:
+				case <-stop:
+				}
+			}
+		}()
+	}
+
+	existing, err := ioutil.ReadDir(watchOpts.dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range existing {
+		watch(filepath.Join(watchOpts.dir, info.Name()))
+	}
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Write) != 0 {
+				watch(event.Name)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		case fileName := <-ready:
+			delete(pending, fileName)
+			if _, err := os.Stat(fileName); err != nil {
+				// The file was removed or renamed before it stopped changing
+				continue
+			}
+			if outputName, err := convertWatchedFile(ctx, options, watchOpts, fileName); ctx.Err() != nil {
+				// The file is left in place, to be converted once watching resumes
+				return nil
+			} else if err != nil {
+				logWatchedFile(fileName, "", err)
+			} else {
+				logWatchedFile(fileName, outputName, nil)
+			}
+		}
+	}
+}
+
+// interrupted returns a channel which is closed once the process receives an interrupt signal, so that watchDir()
+// can stop between conversions.
+func interrupted() <-chan struct{} {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	stop := make(chan struct{})
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		close(stop)
+	}()
+	return stop
+}
+
+// checkWatchOptions returns a *usageError describing the first of watchOpts which is invalid.
+func checkWatchOptions(watchOpts watchOptions) error {
+	switch {
+	case watchOpts.dir == "":
+		return usageErrorf("watch requires --dir")
+	case watchOpts.outputDir == "":
+		return usageErrorf("watch requires --output-dir")
+	case watchOpts.quiesce <= 0:
+		return usageErrorf("--quiesce must be positive")
+	}
+	_, _, err := parseOnSuccess(watchOpts.onSuccess)
+	return err
+}
+
+// parseOnSuccess parses the value of --on-success into its action, which is keep, delete, or move, and the directory
+// to move files to.
+func parseOnSuccess(onSuccess string) (action, dir string, err error) {
+	switch {
+	case onSuccess == "keep" || onSuccess == "delete":
+		return onSuccess, "", nil
+	case strings.HasPrefix(onSuccess, "move:") && len(onSuccess) > len("move:"):
+		return "move", onSuccess[len("move:"):], nil
+	}
+	return "", "", usageErrorf("invalid --on-success %q (expected keep, delete, or move:DIR)", onSuccess)
+}
+
+// isWatchedFile reports whether the named file is a CSV file which should be converted when it appears, rather than
+// a hidden or temporary file.
+func isWatchedFile(fileName string) bool {
+	base := filepath.Base(fileName)
+	return strings.EqualFold(filepath.Ext(base), ".csv") && !strings.HasPrefix(base, ".")
+}
+
+// waitForQuiescence polls the named file every interval until its size and modification time are unchanged since the
+// previous poll. Returns false if the file disappears or stop is closed first.
+func waitForQuiescence(fileName string, interval time.Duration, stop <-chan struct{}) bool {
+	var prev os.FileInfo
+	for {
+		info, err := os.Stat(fileName)
+		if err != nil {
+			return false
+		} else if prev != nil && info.Size() == prev.Size() && info.ModTime().Equal(prev.ModTime()) {
+			return true
+		}
+		prev = info
+
+		select {
+		case <-time.After(interval):
+		case <-stop:
+			return false
+		}
+	}
+}
+
+// watchedOutputName returns the name of the file in outputDir to which the named CSV file is converted, which has
+// the same base name with a .json extension, or .ndjson when records are emitted in batches, one per line.
+func watchedOutputName(fileName, outputDir string, batchSize int) string {
+	ext := ".json"
+	if batchSize > 0 {
+		ext = ".ndjson"
+	}
+	base := filepath.Base(fileName)
+	return filepath.Join(outputDir, strings.TrimSuffix(base, filepath.Ext(base))+ext)
+}
+
+// convertWatchedFile converts the named CSV file to JSON in `watchOpts.outputDir`, as named by watchedOutputName(),
+// and then keeps, deletes, or moves it according to `watchOpts.onSuccess`. The output file only replaces any earlier
+// one once conversion succeeds, so nothing is left behind by a failure, or once ctx is done, which stops conversion
+// with its error. A directory to move the file to is relative to the working directory, like every other path given.
+// Returns the name of the output file.
+func convertWatchedFile(ctx context.Context, options conversionOptions, watchOpts watchOptions,
+	fileName string) (string, error) {
+	action, moveDir, err := parseOnSuccess(watchOpts.onSuccess)
+	if err != nil {
+		return "", err
+	}
+	input, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer input.Close()
+
+	outputName := watchedOutputName(fileName, watchOpts.outputDir, options.batchSize)
+	if err := os.MkdirAll(watchOpts.outputDir, 0755); err != nil {
+		return "", &outputError{err}
+	}
+	output, err := createAtomicFile(outputName)
+	if err != nil {
+		return "", &outputError{err}
+	}
+
+	options.stats = &conversionStats{}
+	bw := bufio.NewWriter(output)
+	conv, err := newConverter(input, bw, withOptions(options))
+	if err == nil {
+		err = conv.run(ctx)
+	}
+	if err == nil {
+		if err = bw.Flush(); err != nil {
+			err = &outputError{err}
+		}
+	}
+	if err != nil {
+		output.discard()
+		return "", err
+	}
+	if err := output.commit(); err != nil {
+		return "", &outputError{err}
+	}
+
+	input.Close()
+	switch action {
+	case "delete":
+		err = os.Remove(fileName)
+	case "move":
+		if err = os.MkdirAll(moveDir, 0755); err == nil {
+			err = os.Rename(fileName, filepath.Join(moveDir, filepath.Base(fileName)))
+		}
+	}
+	return outputName, err
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConvertWatchedFile(t *testing.T) {
+	for _, tt := range []struct {
+		testName      string
+		onSuccess     string
+		csvData       string
+		wantOutput    string
+		wantErr       string
+		wantKept      bool
+		wantMovedFile string
+	}{
+		{"Keep", "keep", "a,b\n1,2\n", "[{\"a\":\"1\",\"b\":\"2\"}]\n", "", true, ""},
+		{"Delete", "delete", "a,b\n1,2\n", "[{\"a\":\"1\",\"b\":\"2\"}]\n", "", false, ""},
+		{"Move", "", "a,b\n1,2\n", "[{\"a\":\"1\",\"b\":\"2\"}]\n", "", false, "processed/people.csv"},
+		{"Failure", "delete", "a,b\n1,2,3\n", "", "record on line 2: wrong number of fields", true, ""},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			dir := t.TempDir()
+			inbox, outputDir := filepath.Join(dir, "inbox"), filepath.Join(dir, "done")
+			require.NoError(t, os.Mkdir(inbox, 0755))
+			fileName := filepath.Join(inbox, "people.csv")
+			require.NoError(t, ioutil.WriteFile(fileName, []byte(tt.csvData), 0644))
+			onSuccess := tt.onSuccess
+			if tt.wantMovedFile != "" {
+				// The directory to move to is within this test's directory
+				onSuccess = "move:" + filepath.Join(dir, "processed")
+			}
+
+			outputName, err := convertWatchedFile(context.Background(), conversionOptions{},
+				watchOptions{dir: inbox, outputDir: outputDir, quiesce: time.Second, onSuccess: onSuccess}, fileName)
+
+			_, statErr := os.Stat(fileName)
+			assert.Equal(t, tt.wantKept, statErr == nil, "Source file should only be kept as requested or on failure")
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Empty(t, sortedDirNames(t, outputDir), "Nothing should be left in the output directory")
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, filepath.Join(outputDir, "people.json"), outputName)
+			output, err := ioutil.ReadFile(outputName)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantOutput, string(output))
+			assert.Equal(t, []string{"people.json"}, sortedDirNames(t, outputDir))
+			if tt.wantMovedFile != "" {
+				assert.FileExists(t, filepath.Join(dir, tt.wantMovedFile))
+			}
+		})
+	}
+}
+
+func TestConvertWatchedFileCancelled(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "people.csv")
+	require.NoError(t, ioutil.WriteFile(fileName, []byte("a\n1\n"), 0644))
+	outputDir := filepath.Join(dir, "done")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := convertWatchedFile(ctx, conversionOptions{},
+		watchOptions{dir: dir, outputDir: outputDir, quiesce: time.Second, onSuccess: "delete"}, fileName)
+
+	assert.Equal(t, context.Canceled, err)
+	assert.FileExists(t, fileName, "A cancelled conversion should leave the file in place")
+	assert.Empty(t, sortedDirNames(t, outputDir), "Nothing should be left in the output directory")
+}
+
+// sortedDirNames returns the names of the entries of dir, which are sorted by ioutil.ReadDir().
+func sortedDirNames(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names
+}
+
+func TestWatchedOutputName(t *testing.T) {
+	assert.Equal(t, filepath.Join("done", "people.json"), watchedOutputName("inbox/people.csv", "done", 0))
+	assert.Equal(t, filepath.Join("done", "people.ndjson"), watchedOutputName("inbox/people.CSV", "done", 1),
+		"Batched output should be newline-delimited")
+}
+
+func TestIsWatchedFile(t *testing.T) {
+	for fileName, want := range map[string]bool{
+		"inbox/people.csv":      true,
+		"inbox/PEOPLE.CSV":      true,
+		"inbox/people.txt":      false,
+		"inbox/.people.csv":     false,
+		"inbox/people.csv.part": false,
+	} {
+		assert.Equal(t, want, isWatchedFile(fileName), fileName)
+	}
+}
+
+func TestParseOnSuccess(t *testing.T) {
+	for _, tt := range []struct {
+		onSuccess  string
+		wantAction string
+		wantDir    string
+		wantErr    bool
+	}{
+		{"keep", "keep", "", false},
+		{"delete", "delete", "", false},
+		{"move:processed/", "move", "processed/", false},
+		{"move:", "", "", true},
+		{"archive", "", "", true},
+	} {
+		t.Run(tt.onSuccess, func(t *testing.T) {
+			action, dir, err := parseOnSuccess(tt.onSuccess)
+			assert.Equal(t, tt.wantAction, action)
+			assert.Equal(t, tt.wantDir, dir)
+			if tt.wantErr {
+				assert.Equal(t, exitUsage, exitCode(err))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestWaitForQuiescence(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "people.csv")
+	require.NoError(t, ioutil.WriteFile(fileName, []byte("a\n"), 0644))
+
+	// Grow the file for a while, so that it is only quiet once this stops
+	grown := make(chan struct{})
+	go func() {
+		defer close(grown)
+		f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		for i := 0; i < 5; i++ {
+			f.WriteString("1\n")
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	assert.True(t, waitForQuiescence(fileName, 100*time.Millisecond, nil))
+	<-grown
+	info, err := os.Stat(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), info.Size(), "File should have stopped growing")
+
+	assert.False(t, waitForQuiescence(fileName+".missing", time.Millisecond, nil), "Missing file is never quiet")
+	stop := make(chan struct{})
+	close(stop)
+	assert.False(t, waitForQuiescence(fileName, time.Hour, stop), "Waiting should end once stopped")
+}
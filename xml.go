@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"unicode"
+)
+
+// xmlOptions is used to configure emitting records as XML when calling csv2Xml().
+type xmlOptions struct {
+	// root and row name the element which wraps every record, and the element of each record
+	root string
+	row  string
+	// attrNames emits each field as a field element whose name attribute is its column name, rather than as an
+	// element named by its column name, as by sanitizeXmlName()
+	attrNames bool
+}
+
+// xmlFieldElement is the element of each field when `xmlOptions.attrNames` is set.
+const xmlFieldElement = "field"
+
+// isXmlNameStart reports whether r can begin an element name written by csv2Xml().
+func isXmlNameStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+// isXmlNameRune reports whether r can follow the first rune of an element name written by csv2Xml().
+func isXmlNameRune(r rune) bool {
+	return isXmlNameStart(r) || r == '-' || r == '.' || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// sanitizeXmlName returns name as a valid XML element name, by replacing each rune which cannot appear in one with
+// an underscore, and prefixing one when it cannot begin with its first rune or begins with the reserved "xml".
+// Colons are replaced too, since they would make the name a namespace prefix. Empty names are a single underscore.
+func sanitizeXmlName(name string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		if isXmlNameRune(r) {
+			return r
+		}
+		return '_'
+	}, name)
+	if sanitized == "" || !isXmlNameStart([]rune(sanitized)[0]) ||
+		strings.HasPrefix(strings.ToLower(sanitized), "xml") {
+		sanitized = "_" + sanitized
+	}
+	return sanitized
+}
+
+// csv2Xml converts CSV data from `options.csvInputs` to XML, emitted to `options.jsonOutput`, in which an element
+// named `xmlOpts.root` holds an element named `xmlOpts.row` for each record. Each record's element holds an element
+// for each of its fields, in column order followed by any others in sorted order, whose text is its value.
+// Records are emitted as they are converted, and text is escaped by encoding/xml.
+// Returns a *usageError when `xmlOpts.root` or `xmlOpts.row` is not a valid element name, as by sanitizeXmlName(),
+// or any errors from reading CSV or writing XML.
+func csv2Xml(options conversionOptions, xmlOpts xmlOptions) error {
+	for _, element := range []struct {
+		flag string
+		name string
+	}{
+		{"--xml-root", xmlOpts.root},
+		{"--xml-row", xmlOpts.row},
+	} {
+		if sanitizeXmlName(element.name) != element.name {
+			return usageErrorf("%s %q is not a valid XML element name", element.flag, element.name)
+		}
+	}
+	reader, colNames, err := newCsvRowReader(options)
+	if err != nil {
+		return err
+	}
+	// Every column is sanitized once, in advance, since the same names recur in every record
+	elementNames := make(map[string]string, len(colNames))
+	for _, name := range colNames {
+		elementNames[name] = sanitizeXmlName(name)
+	}
+	order := newColumnOrder(colNames)
+
+	enc := xml.NewEncoder(options.jsonOutput)
+	root := xml.StartElement{Name: xml.Name{Local: xmlOpts.root}}
+	if err := enc.EncodeToken(root); err != nil {
+		return &outputError{err}
+	}
+	err = eachReaderBatch(options, reader, colNames, 1, func(batch []record) error {
+		for _, rec := range batch {
+			row := xml.StartElement{Name: xml.Name{Local: xmlOpts.row}}
+			if err := enc.EncodeToken(row); err != nil {
+				return &outputError{err}
+			}
+			for _, k := range order.keys(rec) {
+				var field xml.StartElement
+				if xmlOpts.attrNames {
+					field.Name.Local = xmlFieldElement
+					field.Attr = []xml.Attr{{Name: xml.Name{Local: "name"}, Value: k}}
+				} else if name, ok := elementNames[k]; ok {
+					field.Name.Local = name
+				} else {
+					field.Name.Local = sanitizeXmlName(k)
+				}
+				if err := enc.EncodeElement(rec[k], field); err != nil {
+					return &outputError{err}
+				}
+			}
+			if err := enc.EncodeToken(row.End()); err != nil {
+				return &outputError{err}
+			}
+			if err := enc.Flush(); err != nil {
+				return &outputError{err}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := enc.EncodeToken(root.End()); err != nil {
+		return &outputError{err}
+	}
+	if err := enc.Flush(); err != nil {
+		return &outputError{err}
+	}
+	if _, err := options.jsonOutput.Write([]byte("\n")); err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"github.com/integrii/flaggy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeXmlName(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want string
+	}{
+		{"name", "name"},
+		{"first name", "first_name"},
+		{"1st", "_1st"},
+		{"-x", "_-x"},
+		{"ns:tag", "ns_tag"},
+		{"a.b-c_d", "a.b-c_d"},
+		{"XMLData", "_XMLData"},
+		{"café", "café"},
+		{"", "_"},
+		{"<&>", "___"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sanitizeXmlName(tt.name))
+		})
+	}
+}
+
+func TestCsv2Xml(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		csvData  string
+		xmlOpts  xmlOptions
+		wantXml  string
+		wantErr  string
+	}{
+		{"Columns are elements in column order", "b,a\n1,2\n3,4\n", xmlOptions{root: "records", row: "record"},
+			"<records><record><b>1</b><a>2</a></record><record><b>3</b><a>4</a></record></records>\n", ""},
+		{"Special characters are escaped", "text\n\"<a href=\"\"x\"\">&amp;'</a>\"\n",
+			xmlOptions{root: "records", row: "record"},
+			"<records><record><text>&lt;a href=&#34;x&#34;&gt;&amp;amp;&#39;&lt;/a&gt;</text></record></records>\n", ""},
+		{"Empty values", "a,b\n,\n", xmlOptions{root: "records", row: "record"},
+			"<records><record><a></a><b></b></record></records>\n", ""},
+		{"Names are sanitized", "ns:id,first name,2nd\n1,ann,x\n", xmlOptions{root: "records", row: "record"},
+			"<records><record><ns_id>1</ns_id><first_name>ann</first_name><_2nd>x</_2nd></record></records>\n", ""},
+		{"Names are attributes", "ns:id,first name\n1,\"a\"\"b\"\n", xmlOptions{root: "rows", row: "row", attrNames: true},
+			`<rows><row><field name="ns:id">1</field><field name="first name">a&#34;b</field></row></rows>` + "\n",
+			""},
+		{"Empty input", "a,b\n", xmlOptions{root: "people", row: "person"}, "<people></people>\n", ""},
+		{"Invalid root", "a\n1\n", xmlOptions{root: "my root", row: "record"}, "",
+			`--xml-root "my root" is not a valid XML element name`},
+		{"Invalid row", "a\n1\n", xmlOptions{root: "records", row: "ns:record"}, "",
+			`--xml-row "ns:record" is not a valid XML element name`},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := csv2Xml(conversionOptions{
+				csvInputs:  []io.Reader{strings.NewReader(tt.csvData)},
+				jsonOutput: &buf,
+			}, tt.xmlOpts)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, exitUsage, exitCode(err))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantXml, buf.String())
+
+			dec := xml.NewDecoder(&buf)
+			for {
+				if _, err := dec.Token(); err == io.EOF {
+					break
+				} else {
+					require.NoError(t, err, "The output should be well-formed")
+				}
+			}
+		})
+	}
+}
+
+func TestCliXmlRejectsOrdering(t *testing.T) {
+	for _, tt := range []struct {
+		cliArgs []string
+		wantErr string
+	}{
+		{[]string{"--sort-by", "a"}, "--sort-by cannot be used when records are streamed"},
+		{[]string{"--reverse"}, "--reverse cannot be used when records are streamed or aggregated"},
+		{[]string{"--shuffle"}, "--shuffle cannot be used when records are streamed or aggregated"},
+	} {
+		t.Run(strings.Join(tt.cliArgs, " "), func(t *testing.T) {
+			dir := t.TempDir()
+			csvFileName := filepath.Join(dir, "in.csv")
+			require.NoError(t, ioutil.WriteFile(csvFileName, []byte("a\n1\n"), 0600))
+			outputFileName := filepath.Join(dir, "out.xml")
+			os.Args = append([]string{"csv2json", "--format", "xml", "-o", outputFileName, csvFileName}, tt.cliArgs...)
+			flaggy.ResetParser()
+
+			err := runCli()
+			assert.EqualError(t, err, tt.wantErr)
+			assert.Equal(t, exitUsage, exitCode(err))
+			assert.NoFileExists(t, outputFileName, "Nothing should be written")
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"gopkg.in/yaml.v3"
+	"io"
+	"sort"
+	"strings"
+)
+
+// yamlSequenceWriter writes values to w as the items of a YAML sequence one at a time, like a jsonArrayWriter.
+// The fields of each record are written in the order of colNames, rather than sorted like the keys of a JSON object,
+// followed by any others, such as those added by --row-number, in sorted order. Strings are quoted by the encoder
+// wherever they would otherwise be read as another type, such as yes and no, which YAML 1.1 reads as booleans.
+type yamlSequenceWriter struct {
+	w        io.Writer
+	colNames []string
+	// nestSeparator, when set, orders a field nested by nestValues() by its first column
+	nestSeparator string
+	numItems      int
+}
+
+// newYamlSequenceWriter returns a yamlSequenceWriter which writes to w, ordering the fields of records by colNames.
+func newYamlSequenceWriter(w io.Writer, colNames []string, nestSeparator string) *yamlSequenceWriter {
+	return &yamlSequenceWriter{w: w, colNames: colNames, nestSeparator: nestSeparator}
+}
+
+// write writes value as the next item of the sequence.
+// Returns an *outputError for any error encoding or writing it.
+func (s *yamlSequenceWriter) write(value interface{}) error {
+	var fields map[string]interface{}
+	switch value := value.(type) {
+	case record:
+		fields = make(map[string]interface{}, len(value))
+		for k, v := range value {
+			fields[k] = v
+		}
+	case map[string]interface{}:
+		fields = value
+	default:
+		data, err := encodeYaml([]interface{}{yamlValue(value)})
+		if err != nil {
+			return &outputError{err}
+		}
+		return s.writeItem(data)
+	}
+
+	if len(fields) == 0 {
+		return s.writeItem([]byte("- {}\n"))
+	}
+	var item bytes.Buffer
+	for i, key := range s.orderedKeys(fields) {
+		// Each field is encoded as a mapping of its own, whose lines are then indented under the item
+		data, err := encodeYaml(map[string]interface{}{key: yamlValue(fields[key])})
+		if err != nil {
+			return &outputError{err}
+		}
+		for j, line := range strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n") {
+			if i == 0 && j == 0 {
+				item.WriteString("- ")
+			} else {
+				item.WriteString("  ")
+			}
+			item.WriteString(line)
+		}
+		item.WriteByte('\n')
+	}
+	return s.writeItem(item.Bytes())
+}
+
+// writeItem writes the encoded item data, counting it.
+func (s *yamlSequenceWriter) writeItem(data []byte) error {
+	s.numItems++
+	if _, err := s.w.Write(data); err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
+
+// orderedKeys returns the keys of fields in the order in which write() writes them.
+func (s *yamlSequenceWriter) orderedKeys(fields map[string]interface{}) []string {
+	keys := make([]string, 0, len(fields))
+	ranks := make(map[string]int, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+		ranks[k] = len(s.colNames)
+		for i, name := range s.colNames {
+			if name == k || (s.nestSeparator != "" && strings.HasPrefix(name, k+s.nestSeparator)) {
+				ranks[k] = i
+				break
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if ranks[keys[i]] != ranks[keys[j]] {
+			return ranks[keys[i]] < ranks[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// close ends the sequence, which is written as an empty flow sequence when no items were written.
+// Returns an *outputError for any error writing it.
+func (s *yamlSequenceWriter) close() error {
+	if s.numItems > 0 {
+		return nil
+	}
+	if _, err := io.WriteString(s.w, "[]\n"); err != nil {
+		return &outputError{err}
+	}
+	return nil
+}
+
+// yamlValue returns v with each json.Number within it, as inferred by inferValue(), converted to an int64 or a
+// float64, which the encoder writes as a YAML number rather than a string.
+func yamlValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		} else if f, err := v.Float64(); err == nil {
+			return f
+		}
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for k, element := range v {
+			converted[k] = yamlValue(element)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, element := range v {
+			converted[i] = yamlValue(element)
+		}
+		return converted
+	}
+	return v
+}
+
+// encodeYaml encodes v as a YAML document, indenting by 2 spaces per level.
+func encodeYaml(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
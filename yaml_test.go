@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCsv2JsonYaml(t *testing.T) {
+	for _, tt := range []struct {
+		testName string
+		csvData  string
+		options  conversionOptions
+		wantYaml string
+	}{
+		{"Keys are in column order", "name,id,note\nann,1,\"two\nlines\"\n", conversionOptions{},
+			"- name: ann\n  id: \"1\"\n  note: |-\n    two\n    lines\n"},
+		{"Empty input", "name,id\n", conversionOptions{}, "[]\n"},
+		{"Extra fields follow the columns", "b,a\n1,2\n", conversionOptions{rowNumberField: "_row"},
+			"- b: \"1\"\n  a: \"2\"\n  _row: \"1\"\n"},
+		{"Nested fields are ordered by their first column", "z,a.y,a.x\n1,2,3\n",
+			conversionOptions{nestSeparator: "."}, "- z: \"1\"\n  a:\n    x: \"3\"\n    \"y\": \"2\"\n"},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.options.csvInputs = []io.Reader{strings.NewReader(tt.csvData)}
+			tt.options.jsonOutput = &buf
+			tt.options.yaml = true
+			require.NoError(t, csv2Json(tt.options))
+			assert.Equal(t, tt.wantYaml, buf.String())
+		})
+	}
+}
+
+func TestCsv2JsonYamlRoundTrips(t *testing.T) {
+	csvData := "name,answer,note,count,blank\n" +
+		"ann,yes,\"first line\nsecond line\n\",1,\n" +
+		"bob,no,: not a key,0x1F,~\n" +
+		"cy,on,\"- not an item, # nor a comment\",1e3,null\n" +
+		"dee,true,\"  padded \",007,\"'quoted'\"\n" +
+		"émile,Off,\"tab\tand \"\"quotes\"\"\",-.inf,日本語\n"
+
+	for _, tt := range []struct {
+		testName string
+		options  conversionOptions
+	}{
+		{"Strings", conversionOptions{}},
+		{"Typed", conversionOptions{inferTypes: true, nullValues: []string{""}}},
+		{"Sorted", conversionOptions{sortBy: "name:desc"}},
+	} {
+		t.Run(tt.testName, func(t *testing.T) {
+			var jsonOutput, yamlOutput bytes.Buffer
+			tt.options.csvInputs = []io.Reader{strings.NewReader(csvData)}
+			tt.options.jsonOutput = &jsonOutput
+			require.NoError(t, csv2Json(tt.options))
+
+			tt.options.csvInputs = []io.Reader{strings.NewReader(csvData)}
+			tt.options.jsonOutput = &yamlOutput
+			tt.options.yaml = true
+			require.NoError(t, csv2Json(tt.options))
+
+			var parsed []map[string]interface{}
+			require.NoError(t, yaml.Unmarshal(yamlOutput.Bytes(), &parsed), yamlOutput.String())
+			reencoded, err := json.Marshal(parsed)
+			require.NoError(t, err)
+			assert.JSONEq(t, jsonOutput.String(), string(reencoded), yamlOutput.String())
+		})
+	}
+}